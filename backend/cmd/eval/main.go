@@ -0,0 +1,146 @@
+// Command eval measures the extraction pipeline on stored submissions against a ground truth file.
+//
+//	go run ./cmd/eval -docs ./outputs -truth ./truth.json -provider openai
+//	go run ./cmd/eval -docs ./outputs -truth ./truth.json -cassette ./cassettes -cassette-mode replay -json
+//	go run ./cmd/eval -docs ./outputs -truth ./truth.json -prompts-dir ./prompts -prompt v2
+//	go run ./cmd/eval -docs ./outputs -truth ./truth.json -extractor rules
+//	go run ./cmd/eval -docs ./outputs -truth ./truth.json -ensemble gpt-4o-mini:0.2,gpt-4o-mini:0.8,gpt-4o:0.2
+//
+// API keys come from OPENAI_API_KEY or LLM_API_KEY. Logs go to stderr, the report to stdout.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"nodofinance/eval"
+	"nodofinance/llm"
+	"nodofinance/prompts"
+	"nodofinance/routes/app/submitter"
+	"nodofinance/utils/logger"
+
+	"go.uber.org/zap"
+)
+
+func main() {
+	docs := flag.String("docs", "", "directory of S3Document files (.json or .json.gz)")
+	truthPath := flag.String("truth", "", "ground truth file")
+	provider := flag.String("provider", "openai", "openai, compatible or fake")
+	baseURL := flag.String("base-url", "", "base URL of the compatible provider")
+	cassetteDir := flag.String("cassette", "", "cassette directory, to record or replay the calls")
+	cassetteMode := flag.String("cassette-mode", "replay", "record or replay")
+	cleanerModel := flag.String("cleaner-model", llm.MODEL_CLEANER, "cleaner model")
+	submitterModel := flag.String("submitter-model", llm.MODEL_SUBMITTER, "submitter model")
+	promptVersion := flag.String("prompt", prompts.DEFAULT_VERSION, "version of the prompt templates")
+	promptsDir := flag.String("prompts-dir", "", "directory of prompt template versions, besides the builtin ones")
+	extractor := flag.String("extractor", submitter.EXTRACTOR_LLM, "llm or rules")
+	ensembleSpec := flag.String("ensemble", "", "model:temperature,... of an ensemble extraction")
+	language := flag.String("language", "EN", "language of documents stored without one")
+	tolerance := flag.Float64("tolerance", 0.005, "relative error under which a value is correct")
+	parallel := flag.Int("parallel", 2, "documents extracted at the same time")
+	timeout := flag.Duration("timeout", 30*time.Minute, "timeout of the whole run")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	if *docs == "" || *truthPath == "" || (*extractor != submitter.EXTRACTOR_LLM && *extractor != submitter.EXTRACTOR_RULES) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	logger.Log, _ = zap.NewDevelopment()
+
+	library, err := prompts.Load(*promptsDir)
+	if err != nil {
+		fatal(err)
+	}
+	templates, err := library.Get(*promptVersion)
+	if err != nil {
+		fatal(err)
+	}
+
+	var ensemble []llm.EnsembleMember
+	if *ensembleSpec != "" {
+		if ensemble, err = llm.ParseEnsemble(*ensembleSpec); err != nil {
+			fatal(err)
+		}
+	}
+
+	llm.MODEL_CLEANER = *cleanerModel
+	llm.MODEL_SUBMITTER = *submitterModel
+
+	ai, err := newProvider(*provider, *baseURL, *cassetteDir, llm.CassetteMode(*cassetteMode))
+	if err != nil {
+		fatal(err)
+	}
+
+	cases, err := eval.LoadCases(*docs, *truthPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	report := eval.Run(ctx, ai, cases, eval.Options{
+		Tolerance: *tolerance,
+		Parallel:  *parallel,
+		Language:  *language,
+		Prompts:   templates,
+		Extractor: *extractor,
+		Ensemble:  ensemble,
+	})
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	} else {
+		err = report.WriteTable(os.Stdout)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func newProvider(name, baseURL, cassetteDir string, mode llm.CassetteMode) (llm.Provider, error) {
+	if cassetteDir != "" && mode == llm.ModeReplay {
+		return llm.NewCassette(nil, cassetteDir, llm.ModeReplay)
+	}
+
+	var inner llm.Provider
+	switch name {
+	case "openai":
+		apiKey := os.Getenv("OPENAI_API_KEY")
+		if apiKey == "" {
+			return nil, fmt.Errorf("missing OPENAI_API_KEY")
+		}
+		inner = llm.NewOpenAI(apiKey)
+	case "compatible":
+		if baseURL == "" {
+			return nil, fmt.Errorf("missing -base-url")
+		}
+		inner = llm.NewCompatible(baseURL, os.Getenv("LLM_API_KEY"))
+	case "fake":
+		inner = llm.NewScripted()
+	default:
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+
+	if cassetteDir != "" {
+		cassette, err := llm.NewCassette(inner, cassetteDir, mode)
+		if err != nil {
+			return nil, err
+		}
+		inner = cassette
+	}
+	return llm.NewResilient(inner, llm.RETRY_POLICY, &llm.Breaker{Threshold: llm.BREAKER_THRESHOLD, Cooldown: llm.BREAKER_COOLDOWN}), nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "eval:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,260 @@
+package eval
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"nodofinance/llm"
+	"nodofinance/prompts"
+	"nodofinance/routes/app/submitter"
+)
+
+/*
+	Offline evaluation of the extraction pipeline over stored submissions.
+	Every case is an S3Document (financial-docs-outputs, gzip or plain JSON) and the values it should
+	have produced. The cleaned chunks are taken back from the cleaner prompts and run again through
+	CallSubmitter (cleaner, submitter and postprocessor) with the given provider.
+
+	Ground truth file, keyed by document file name. Values are in FINANCE# units (already multiplied),
+	null means the filing has no value and fields left out are not scored:
+		{
+			"user_AAPL_2024-Y.json.gz": {"current_assets": 152987000000, "eps": 6.11, "revenue": null}
+		}
+*/
+
+// Truth maps a document file name to the expected value of each field
+type Truth map[string]map[string]*float64
+
+type Case struct {
+	Name     string
+	Document submitter.S3Document
+	Expected map[string]*float64
+}
+
+type Options struct {
+	// Relative error under which a value counts as correct
+	Tolerance float64
+	Parallel  int
+	// Language of documents stored without one
+	Language string
+	// Prompt templates of every case
+	Prompts *prompts.Set
+	// submitter.EXTRACTOR_RULES scores the rule based extractor instead, without LLM calls
+	Extractor string
+	// Submitter runs of an ensemble extraction, nil for a single run
+	Ensemble []llm.EnsembleMember
+}
+
+type CaseResult struct {
+	Name      string              `json:"name"`
+	Period    string              `json:"period"`
+	Error     string              `json:"error,omitempty"`
+	Predicted map[string]*float64 `json:"predicted,omitempty"`
+	Usage     llm.Usage           `json:"usage"`
+}
+
+// Report is the machine readable result of a run
+type Report struct {
+	CleanerModel   string               `json:"cleaner_model"`
+	SubmitterModel string               `json:"submitter_model"`
+	PromptVersion  string               `json:"prompt_version"`
+	Extractor      string               `json:"extractor"`
+	Ensemble       []llm.EnsembleMember `json:"ensemble,omitempty"`
+	Tolerance      float64              `json:"tolerance"`
+	Documents      int                  `json:"documents"`
+	Failed         int                  `json:"failed"`
+	Usage          llm.Usage            `json:"usage"`
+	Statements     []StatementStats     `json:"statements"`
+	Cases          []CaseResult         `json:"cases"`
+}
+
+var periodInName = regexp.MustCompile(`_(\d{4}-(?:Y|S[12]|Q[1-4]))\.json(?:\.gz)?$`)
+
+// LoadCases reads the truth file and the document of every entry in it
+func LoadCases(dir, truthPath string) ([]Case, error) {
+	data, err := os.ReadFile(truthPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading truth file: %w", err)
+	}
+
+	var truth Truth
+	if err := json.Unmarshal(data, &truth); err != nil {
+		return nil, fmt.Errorf("parsing truth file: %w", err)
+	}
+
+	names := make([]string, 0, len(truth))
+	for name := range truth {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cases := make([]Case, 0, len(names))
+	for _, name := range names {
+		doc, err := readDocument(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		if doc.Period == "" {
+			match := periodInName.FindStringSubmatch(name)
+			if match == nil {
+				return nil, fmt.Errorf("%s: document without period", name)
+			}
+			doc.Period = match[1]
+		}
+
+		cases = append(cases, Case{Name: name, Document: doc, Expected: truth[name]})
+	}
+
+	return cases, nil
+}
+
+func readDocument(path string) (submitter.S3Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return submitter.S3Document{}, err
+	}
+
+	if strings.HasSuffix(path, ".gz") {
+		gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return submitter.S3Document{}, err
+		}
+		if data, err = io.ReadAll(gzipReader); err != nil {
+			return submitter.S3Document{}, err
+		}
+	}
+
+	var doc submitter.S3Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return submitter.S3Document{}, err
+	}
+	return doc, nil
+}
+
+// Run extracts every case again and scores it against the truth
+func Run(ctx context.Context, ai llm.Provider, cases []Case, opts Options) Report {
+	results := make([]CaseResult, len(cases))
+
+	parallel := max(opts.Parallel, 1)
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+
+	for i, c := range cases {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = runCase(ctx, ai, c, opts)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{
+		CleanerModel:   llm.MODEL_CLEANER,
+		SubmitterModel: llm.MODEL_SUBMITTER,
+		PromptVersion:  opts.Prompts.Version,
+		Extractor:      opts.Extractor,
+		Ensemble:       opts.Ensemble,
+		Tolerance:      opts.Tolerance,
+		Documents:      len(cases),
+		Cases:          results,
+	}
+
+	for _, result := range results {
+		if result.Error != "" {
+			report.Failed++
+		}
+		report.Usage.PromptTokens += result.Usage.PromptTokens
+		report.Usage.CompletionTokens += result.Usage.CompletionTokens
+	}
+
+	report.Statements = score(cases, results, opts.Tolerance)
+	return report
+}
+
+func runCase(ctx context.Context, ai llm.Provider, c Case, opts Options) CaseResult {
+	doc := c.Document
+	result := CaseResult{Name: c.Name, Period: doc.Period}
+
+	language := doc.Language
+	if language == "" {
+		language = opts.Language
+	}
+
+	hits := submitter.Hits{
+		Balance:  doc.Balance.Metrics.First,
+		Income:   doc.Income.Metrics.First,
+		CashFlow: doc.CashFlow.Metrics.First,
+	}
+	units := submitter.UnitsFromClient{
+		Balance:  doc.Balance.Units,
+		Income:   doc.Income.Units,
+		CashFlow: doc.CashFlow.Units,
+	}
+
+	var res submitter.SubmitterRes
+	var err error
+	if opts.Extractor == submitter.EXTRACTOR_RULES {
+		res, err = submitter.ExtractWithRules(
+			cleanedContent(doc.Balance), cleanedContent(doc.Income), cleanedContent(doc.CashFlow),
+			doc.Period, language, hits,
+			units)
+	} else {
+		res, err = submitter.CallSubmitter(
+			ctx, ai,
+			cleanedContent(doc.Balance), cleanedContent(doc.Income), cleanedContent(doc.CashFlow),
+			doc.Period, language, hits,
+			units, submitter.Examples{}, opts.Prompts, opts.Ensemble, submitter.SubmitterRes{})
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	for _, r := range []submitter.AIresponse{res.Balance, res.Income, res.CashFlow} {
+		result.Usage.PromptTokens += r.PromptTokensCleaner + r.PromptTokensSubmitter
+		result.Usage.CompletionTokens += r.CompletionTokensCleaner + r.CompletionTokensSubmitter
+	}
+
+	result.Predicted, err = values(res.Periods[0].Result)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// cleanedContent is the text the cleaner got, the end of its prompt in older documents
+func cleanedContent(section submitter.S3StatementSection) string {
+	if section.CleanerContent != "" {
+		return section.CleanerContent
+	}
+	if _, content, found := strings.Cut(section.CleanerPrompt, "This is the text:\n"); found {
+		return content
+	}
+	return section.RawContent
+}
+
+func values(p submitter.Postprocessed) (map[string]*float64, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var v map[string]*float64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,176 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+
+	"nodofinance/routes/app/submitter"
+)
+
+// FieldStats scores one field over the cases that have it in the truth file.
+//   - ExactMatch: share of cases where the value (or its absence) is exactly the expected one
+//   - Precision: share of the values returned that are within Tolerance of the expected one
+//   - Relative errors: over cases with both values and a non-zero expected one
+type FieldStats struct {
+	Field               string  `json:"field"`
+	Cases               int     `json:"cases"`
+	ExactMatch          float64 `json:"exact_match"`
+	Predicted           int     `json:"predicted"`
+	Precision           float64 `json:"precision"`
+	MeanRelativeError   float64 `json:"mean_relative_error"`
+	MedianRelativeError float64 `json:"median_relative_error"`
+	MaxRelativeError    float64 `json:"max_relative_error"`
+}
+
+type StatementStats struct {
+	Statement         string       `json:"statement"`
+	ExactMatch        float64      `json:"exact_match"`
+	Precision         float64      `json:"precision"`
+	MeanRelativeError float64      `json:"mean_relative_error"`
+	Fields            []FieldStats `json:"fields"`
+}
+
+type counts struct {
+	cases, exact, predicted, correct int
+	relativeErrors                   []float64
+}
+
+func (c counts) add(o counts) counts {
+	c.cases += o.cases
+	c.exact += o.exact
+	c.predicted += o.predicted
+	c.correct += o.correct
+	c.relativeErrors = append(c.relativeErrors, o.relativeErrors...)
+	return c
+}
+
+func score(cases []Case, results []CaseResult, tolerance float64) []StatementStats {
+	var statements []StatementStats
+
+	for _, statement := range []string{"balance", "income", "cash_flow"} {
+		stats := StatementStats{Statement: statement}
+		var total counts
+
+		for _, field := range submitter.StatementFields[statement] {
+			var c counts
+			for i, tc := range cases {
+				expected, scored := tc.Expected[field]
+				if !scored {
+					continue
+				}
+
+				// a failed extraction returns nothing
+				predicted := results[i].Predicted[field]
+				c.cases++
+
+				if predicted != nil {
+					c.predicted++
+				}
+
+				switch {
+				case predicted == nil && expected == nil:
+					c.exact++
+				case predicted == nil || expected == nil:
+				default:
+					if *predicted == *expected {
+						c.exact++
+					}
+					relativeError := relativeError(*predicted, *expected)
+					if relativeError <= tolerance {
+						c.correct++
+					}
+					if *expected != 0 {
+						c.relativeErrors = append(c.relativeErrors, relativeError)
+					}
+				}
+			}
+
+			stats.Fields = append(stats.Fields, c.fieldStats(field))
+			total = total.add(c)
+		}
+
+		totalStats := total.fieldStats(statement)
+		stats.ExactMatch = totalStats.ExactMatch
+		stats.Precision = totalStats.Precision
+		stats.MeanRelativeError = totalStats.MeanRelativeError
+		statements = append(statements, stats)
+	}
+
+	return statements
+}
+
+func (c counts) fieldStats(field string) FieldStats {
+	stats := FieldStats{Field: field, Cases: c.cases, Predicted: c.predicted}
+	if c.cases > 0 {
+		stats.ExactMatch = float64(c.exact) / float64(c.cases)
+	}
+	if c.predicted > 0 {
+		stats.Precision = float64(c.correct) / float64(c.predicted)
+	}
+
+	if n := len(c.relativeErrors); n > 0 {
+		errors := append([]float64(nil), c.relativeErrors...)
+		sort.Float64s(errors)
+
+		sum := 0.0
+		for _, e := range errors {
+			sum += e
+		}
+		stats.MeanRelativeError = sum / float64(n)
+		stats.MaxRelativeError = errors[n-1]
+		if n%2 == 1 {
+			stats.MedianRelativeError = errors[n/2]
+		} else {
+			stats.MedianRelativeError = (errors[n/2-1] + errors[n/2]) / 2
+		}
+	}
+
+	return stats
+}
+
+func relativeError(predicted, expected float64) float64 {
+	if expected == 0 {
+		if predicted == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return math.Abs(predicted-expected) / math.Abs(expected)
+}
+
+// WriteTable prints the report for humans
+func (r Report) WriteTable(out io.Writer) error {
+	fmt.Fprintf(out, "extractor %s | models: cleaner %s, submitter %s | prompt %s | documents %d, failed %d | tokens %d in, %d out\n\n",
+		r.Extractor, r.CleanerModel, r.SubmitterModel, r.PromptVersion, r.Documents, r.Failed, r.Usage.PromptTokens, r.Usage.CompletionTokens)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "statement\tfield\tcases\texact\tprecision\tmean rel err\tmedian rel err\tmax rel err\t")
+
+	for _, statement := range r.Statements {
+		for _, f := range statement.Fields {
+			if f.Cases == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%.1f%%\t%.1f%%\t%.4f\t%.4f\t%.4f\t\n",
+				statement.Statement, f.Field, f.Cases, f.ExactMatch*100, f.Precision*100,
+				f.MeanRelativeError, f.MedianRelativeError, f.MaxRelativeError)
+		}
+		fmt.Fprintf(w, "%s\t(all)\t\t%.1f%%\t%.1f%%\t%.4f\t\t\t\n",
+			statement.Statement, statement.ExactMatch*100, statement.Precision*100, statement.MeanRelativeError)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for _, c := range r.Cases {
+		if c.Error != "" {
+			fmt.Fprintf(out, "\nfailed %s: %s", c.Name, c.Error)
+		}
+	}
+	fmt.Fprintln(out)
+	return nil
+}
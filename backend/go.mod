@@ -16,6 +16,7 @@ require (
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/stripe/stripe-go/v81 v81.4.0
 	go.uber.org/zap v1.27.0
+	golang.org/x/net v0.39.0
 )
 
 require (
@@ -42,5 +43,4 @@ require (
 	github.com/tidwall/pretty v1.2.1 // indirect
 	github.com/tidwall/sjson v1.2.5 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
-	golang.org/x/net v0.39.0 // indirect
 )
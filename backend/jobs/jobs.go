@@ -0,0 +1,292 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"nodofinance/utils/logger"
+
+	"github.com/patrickmn/go-cache"
+	"go.uber.org/zap"
+)
+
+type Status string
+
+const (
+	StatusQueued  Status = "queued"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+var ErrQueueFull = errors.New("job queue is full")
+
+// Error fails a job with the HTTP status code the status endpoints answer with
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string { return e.Message }
+
+type Event struct {
+	Stage  string `json:"stage"`
+	Status Status `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Code is the HTTP status of a failure returned as an Error
+	Code int   `json:"code,omitempty"`
+	Time int64 `json:"time"`
+}
+
+// Job is a unit of background work owned by a user.
+// Its events are kept so late subscribers can replay the progress.
+type Job struct {
+	ID       string
+	Username string
+
+	mu          sync.Mutex
+	status      Status
+	stage       string
+	err         string
+	code        int
+	result      any
+	events      []Event
+	subscribers map[chan Event]struct{}
+}
+
+type Snapshot struct {
+	ID     string  `json:"id"`
+	Status Status  `json:"status"`
+	Stage  string  `json:"stage"`
+	Error  string  `json:"error,omitempty"`
+	Code   int     `json:"code,omitempty"`
+	Result any     `json:"result,omitempty"`
+	Events []Event `json:"events"`
+}
+
+func (j *Job) Snapshot() Snapshot {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return Snapshot{
+		ID:     j.ID,
+		Status: j.status,
+		Stage:  j.stage,
+		Error:  j.err,
+		Code:   j.code,
+		Result: j.result,
+		Events: append([]Event(nil), j.events...),
+	}
+}
+
+// SetResult keeps the outcome of the job for the status endpoint
+func (j *Job) SetResult(result any) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.result = result
+}
+
+// Emit records a stage of a running job and notifies subscribers
+func (j *Job) Emit(stage string) {
+	j.publish(StatusRunning, stage, "", 0)
+}
+
+func (j *Job) publish(status Status, stage, errMessage string, code int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.status = status
+	j.stage = stage
+	j.err = errMessage
+	j.code = code
+
+	event := Event{Stage: stage, Status: status, Error: errMessage, Code: code, Time: time.Now().Unix()}
+	j.events = append(j.events, event)
+
+	final := status == StatusDone || status == StatusFailed
+	for subscriber := range j.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			// slow subscriber, it can still poll the status endpoint for the stages it missed
+			if !final {
+				continue
+			}
+			// but not for the outcome: an older stage makes room for it, and only publish sends
+			select {
+			case <-subscriber:
+			default:
+			}
+			subscriber <- event
+		}
+	}
+
+	if final {
+		for subscriber := range j.subscribers {
+			close(subscriber)
+		}
+		j.subscribers = nil
+	}
+}
+
+// Subscribe returns the events so far and a channel with the next ones.
+// The channel is closed when the job finishes; it is nil if it already has.
+func (j *Job) Subscribe() ([]Event, <-chan Event, func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	past := append([]Event(nil), j.events...)
+	if j.status == StatusDone || j.status == StatusFailed {
+		return past, nil, func() {}
+	}
+
+	subscriber := make(chan Event, 16)
+	if j.subscribers == nil {
+		j.subscribers = make(map[chan Event]struct{})
+	}
+	j.subscribers[subscriber] = struct{}{}
+
+	unsubscribe := func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+
+		if _, exists := j.subscribers[subscriber]; exists {
+			delete(j.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+
+	return past, subscriber, unsubscribe
+}
+
+// *
+// **
+// ***
+// ****
+// ***** CONTEXT
+type jobKey struct{}
+
+func withJob(ctx context.Context, j *Job) context.Context {
+	return context.WithValue(ctx, jobKey{}, j)
+}
+
+// Report emits a stage on the job running in ctx, if any
+func Report(ctx context.Context, stage string) {
+	if j, ok := ctx.Value(jobKey{}).(*Job); ok {
+		j.Emit(stage)
+	}
+}
+
+// SetResult keeps result on the job running in ctx, if any
+func SetResult(ctx context.Context, result any) {
+	if j, ok := ctx.Value(jobKey{}).(*Job); ok {
+		j.SetResult(result)
+	}
+}
+
+// *
+// **
+// ***
+// ****
+// ***** QUEUE
+// Run executes the job; the returned error message is shown to the user
+type Run func(ctx context.Context, j *Job) error
+
+type task struct {
+	job *Job
+	run Run
+}
+
+type Queue struct {
+	tasks   chan task
+	jobs    *cache.Cache
+	timeout time.Duration
+}
+
+// NewQueue starts workers goroutines consuming up to size pending jobs.
+// Each job runs under timeout and is kept for retention after it is created.
+func NewQueue(workers, size int, timeout, retention time.Duration) *Queue {
+	q := &Queue{
+		tasks:   make(chan task, size),
+		jobs:    cache.New(retention, 10*time.Minute),
+		timeout: timeout,
+	}
+
+	for range workers {
+		go q.work()
+	}
+
+	return q
+}
+
+func (q *Queue) Enqueue(username string, run Run) (*Job, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	j := &Job{ID: hex.EncodeToString(id), Username: username}
+	j.publish(StatusQueued, "queued", "", 0)
+
+	q.jobs.SetDefault(j.ID, j)
+
+	select {
+	case q.tasks <- task{job: j, run: run}:
+	default:
+		q.jobs.Delete(j.ID)
+		return nil, ErrQueueFull
+	}
+
+	return j, nil
+}
+
+// Get returns the job only to its owner
+func (q *Queue) Get(username, id string) (*Job, bool) {
+	value, exists := q.jobs.Get(id)
+	if !exists {
+		return nil, false
+	}
+
+	j := value.(*Job)
+	if j.Username != username {
+		return nil, false
+	}
+	return j, true
+}
+
+func (q *Queue) work() {
+	for t := range q.tasks {
+		q.execute(t)
+	}
+}
+
+func (q *Queue) execute(t task) {
+	ctx, cancel := context.WithTimeout(withJob(context.Background(), t.job), q.timeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Log.Error("Job panicked", zap.String("job", t.job.ID), zap.Any("panic", r))
+			t.job.publish(StatusFailed, "failed", "internal server error", 0)
+		}
+	}()
+
+	t.job.Emit("started")
+
+	if err := t.run(ctx, t.job); err != nil {
+		var jobErr *Error
+		code := 0
+		if errors.As(err, &jobErr) {
+			code = jobErr.Code
+		}
+		t.job.publish(StatusFailed, "failed", err.Error(), code)
+		return
+	}
+
+	t.job.publish(StatusDone, "done", "", 0)
+}
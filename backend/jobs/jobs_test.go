@@ -0,0 +1,42 @@
+package jobs
+
+import "testing"
+
+func TestPublishDeliversFinalEventToSlowSubscriber(t *testing.T) {
+	tests := []struct {
+		name   string
+		status Status
+	}{
+		{"done", StatusDone},
+		{"failed", StatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &Job{ID: "job"}
+			j.publish(StatusQueued, "queued", "", 0)
+
+			_, events, unsubscribe := j.Subscribe()
+			defer unsubscribe()
+
+			// more stages than the subscriber buffers, nobody reading
+			for range cap(events) + 4 {
+				j.Emit("stage")
+			}
+			j.publish(tt.status, string(tt.status), "", 0)
+
+			var last Event
+			received := 0
+			for event := range events {
+				last = event
+				received++
+			}
+			if received != cap(events) {
+				t.Errorf("received %d events, want %d", received, cap(events))
+			}
+			if last.Status != tt.status {
+				t.Errorf("last event status = %s, want %s", last.Status, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type CassetteMode string
+
+const (
+	ModeRecord CassetteMode = "record"
+	ModeReplay CassetteMode = "replay"
+)
+
+var ErrCassetteMiss = errors.New("no recorded response for prompt")
+
+var _ Provider = (*Cassette)(nil)
+
+// Cassette records every call of the wrapped provider to {dir}/{prompt hash}.json,
+// or replays those files without calling any provider. The hash covers the model,
+// messages, sampling parameters and schema, so a prompt change is a cache miss.
+type Cassette struct {
+	inner Provider
+	dir   string
+	mode  CassetteMode
+}
+
+// cassetteEntry is the file layout of one recorded call
+type cassetteEntry struct {
+	Request  Request  `json:"request"`
+	Schema   *Schema  `json:"schema,omitempty"`
+	Response Response `json:"response"`
+}
+
+// NewCassette wraps inner; inner can be nil in replay mode
+func NewCassette(inner Provider, dir string, mode CassetteMode) (*Cassette, error) {
+	switch mode {
+	case ModeRecord:
+		if inner == nil {
+			return nil, fmt.Errorf("record mode needs a provider")
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("creating cassette dir: %w", err)
+		}
+	case ModeReplay:
+	default:
+		return nil, fmt.Errorf("invalid cassette mode: %s", mode)
+	}
+
+	return &Cassette{inner: inner, dir: dir, mode: mode}, nil
+}
+
+func (c *Cassette) Chat(ctx context.Context, req Request) (Response, error) {
+	return c.call(ctx, req, nil, nil)
+}
+
+func (c *Cassette) ChatJSON(ctx context.Context, req Request, schema Schema) (Response, error) {
+	return c.call(ctx, req, &schema, nil)
+}
+
+// ChatStream records the whole completion, a replay streams it as one delta
+func (c *Cassette) ChatStream(ctx context.Context, req Request, onDelta OnDelta) (Response, error) {
+	return c.call(ctx, req, nil, onDelta)
+}
+
+func (c *Cassette) call(ctx context.Context, req Request, schema *Schema, onDelta OnDelta) (Response, error) {
+	key, err := PromptHash(req, schema)
+	if err != nil {
+		return Response{}, err
+	}
+	path := filepath.Join(c.dir, key+".json")
+
+	if c.mode == ModeReplay {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return Response{}, fmt.Errorf("%w: %s", ErrCassetteMiss, key)
+		}
+		if err != nil {
+			return Response{}, fmt.Errorf("reading cassette: %w", err)
+		}
+
+		var entry cassetteEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return Response{}, fmt.Errorf("decoding cassette %s: %w", key, err)
+		}
+		if onDelta != nil {
+			if err := onDelta(entry.Response.Content); err != nil {
+				return Response{}, err
+			}
+		}
+		return entry.Response, nil
+	}
+
+	var res Response
+	switch {
+	case onDelta != nil:
+		res, err = c.inner.ChatStream(ctx, req, onDelta)
+	case schema != nil:
+		res, err = c.inner.ChatJSON(ctx, req, *schema)
+	default:
+		res, err = c.inner.Chat(ctx, req)
+	}
+	if err != nil {
+		return Response{}, err
+	}
+
+	data, err := json.MarshalIndent(cassetteEntry{Request: req, Schema: schema, Response: res}, "", "  ")
+	if err != nil {
+		return Response{}, fmt.Errorf("encoding cassette: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return Response{}, fmt.Errorf("writing cassette: %w", err)
+	}
+
+	return res, nil
+}
+
+// PromptHash identifies a call by everything that is sent to the provider
+func PromptHash(req Request, schema *Schema) (string, error) {
+	data, err := json.Marshal(struct {
+		Request Request `json:"request"`
+		Schema  *Schema `json:"schema,omitempty"`
+	}{req, schema})
+	if err != nil {
+		return "", fmt.Errorf("hashing prompt: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
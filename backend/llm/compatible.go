@@ -0,0 +1,200 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var _ Provider = (*Compatible)(nil)
+
+// Compatible talks to any server exposing the OpenAI /chat/completions API (vLLM, Ollama, LiteLLM...)
+type Compatible struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+	// without a client timeout, long streams are bounded by the request context
+	streamClient *http.Client
+}
+
+func NewCompatible(baseURL, apiKey string) *Compatible {
+	return &Compatible{
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		apiKey:       apiKey,
+		client:       &http.Client{Timeout: 120 * time.Second},
+		streamClient: &http.Client{},
+	}
+}
+
+type compatibleRequest struct {
+	Model          string         `json:"model"`
+	Messages       []Message      `json:"messages"`
+	MaxTokens      int64          `json:"max_tokens,omitempty"`
+	Temperature    float64        `json:"temperature"`
+	ResponseFormat map[string]any `json:"response_format,omitempty"`
+	Stream         bool           `json:"stream,omitempty"`
+	StreamOptions  map[string]any `json:"stream_options,omitempty"`
+}
+
+type compatibleResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage Usage `json:"usage"`
+}
+
+type compatibleChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *Usage `json:"usage"`
+}
+
+func (p *Compatible) Chat(ctx context.Context, req Request) (Response, error) {
+	return p.complete(ctx, compatibleRequest{
+		Model:       req.Model,
+		Messages:    req.Messages,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+	})
+}
+
+func (p *Compatible) ChatJSON(ctx context.Context, req Request, schema Schema) (Response, error) {
+	return p.complete(ctx, compatibleRequest{
+		Model:       req.Model,
+		Messages:    req.Messages,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		ResponseFormat: map[string]any{
+			"type": "json_schema",
+			"json_schema": map[string]any{
+				"name":   schema.Name,
+				"schema": schema.Schema,
+				"strict": true,
+			},
+		},
+	})
+}
+
+// ChatStream reads the server-sent chunks of the completion, the last one has the usage
+func (p *Compatible) ChatStream(ctx context.Context, req Request, onDelta OnDelta) (Response, error) {
+	httpRes, err := p.post(ctx, p.streamClient, compatibleRequest{
+		Model:         req.Model,
+		Messages:      req.Messages,
+		MaxTokens:     req.MaxTokens,
+		Temperature:   req.Temperature,
+		Stream:        true,
+		StreamOptions: map[string]any{"include_usage": true},
+	})
+	if err != nil {
+		return Response{}, err
+	}
+	defer httpRes.Body.Close()
+
+	var content strings.Builder
+	var usage Usage
+
+	scanner := bufio.NewScanner(httpRes.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, found := strings.CutPrefix(scanner.Text(), "data:")
+		data = strings.TrimSpace(data)
+		if !found || data == "" {
+			continue
+		}
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk compatibleChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return Response{}, fmt.Errorf("decoding chunk: %w", err)
+		}
+		if chunk.Usage != nil {
+			usage = *chunk.Usage
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+
+		delta := chunk.Choices[0].Delta.Content
+		content.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			return Response{}, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Response{}, fmt.Errorf("reading stream: %w", err)
+	}
+
+	if content.Len() == 0 {
+		return Response{}, ErrNoChoices
+	}
+	return Response{Content: content.String(), Usage: usage}, nil
+}
+
+// post sends the request, an answer other than 200 is a StatusError
+func (p *Compatible) post(ctx context.Context, client *http.Client, body compatibleRequest) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/chat/completions", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	httpRes, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if httpRes.StatusCode != http.StatusOK {
+		defer httpRes.Body.Close()
+		resBody, _ := io.ReadAll(httpRes.Body)
+		return nil, newStatusError(httpRes.StatusCode, httpRes.Header, fmt.Errorf("provider returned status %d: %s", httpRes.StatusCode, resBody))
+	}
+	return httpRes, nil
+}
+
+func (p *Compatible) complete(ctx context.Context, body compatibleRequest) (Response, error) {
+	httpRes, err := p.post(ctx, p.client, body)
+	if err != nil {
+		return Response{}, err
+	}
+	defer httpRes.Body.Close()
+
+	resBody, err := io.ReadAll(httpRes.Body)
+	if err != nil {
+		return Response{}, fmt.Errorf("reading response: %w", err)
+	}
+
+	var chatCompletion compatibleResponse
+	if err := json.Unmarshal(resBody, &chatCompletion); err != nil {
+		return Response{}, fmt.Errorf("decoding response: %w", err)
+	}
+
+	if len(chatCompletion.Choices) == 0 {
+		return Response{}, ErrNoChoices
+	}
+
+	return Response{
+		Content: chatCompletion.Choices[0].Message.Content,
+		Usage:   chatCompletion.Usage,
+	}, nil
+}
@@ -0,0 +1,326 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type Priority int
+
+const (
+	// PriorityBulk is background work, e.g. submissions
+	PriorityBulk Priority = iota
+	// PriorityInteractive is a user waiting for the answer, e.g. the analyst
+	PriorityInteractive
+)
+
+type priorityKey struct{}
+
+// WithPriority marks the calls made with ctx, they are PriorityBulk by default
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityOf(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return PriorityBulk
+}
+
+var ErrBusy = errors.New("too many LLM calls waiting")
+
+// BusyError is a call rejected because the queue of the Limiter is full
+type BusyError struct {
+	RetryAfter time.Duration
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", ErrBusy, e.RetryAfter)
+}
+func (e *BusyError) Unwrap() error { return ErrBusy }
+
+type tokenUse struct {
+	at     time.Time
+	tokens int64
+}
+
+type waiter struct {
+	tokens int64
+	use    *tokenUse
+	// set instead of use when the waiter is evicted
+	err   error
+	ready chan struct{}
+}
+
+// Limiter bounds the calls in flight and the tokens of the last minute across the process.
+// Calls over the limits wait, interactive ones first, and are rejected when MaxWaiting are waiting.
+// A full queue takes an interactive call still: the newest bulk call waiting gives it its place.
+type Limiter struct {
+	MaxInFlight int
+	// Zero is no limit. A call is charged its estimate when it starts and its usage when it ends.
+	TokensPerMinute int64
+	MaxWaiting      int
+
+	mu       sync.Mutex
+	inFlight int
+	window   []*tokenUse
+	waiting  [PriorityInteractive + 1][]*waiter
+	timer    *time.Timer
+}
+
+// Acquire waits for a slot, the returned release takes the tokens the call used
+func (l *Limiter) Acquire(ctx context.Context, priority Priority, estimate int64) (func(Usage), error) {
+	l.mu.Lock()
+
+	if l.waitingCount() >= l.MaxWaiting && (priority == PriorityBulk || !l.evictBulk()) {
+		retryAfter := l.retryAfter()
+		l.mu.Unlock()
+		return nil, &BusyError{RetryAfter: retryAfter}
+	}
+
+	w := &waiter{tokens: estimate, ready: make(chan struct{})}
+	l.waiting[priority] = append(l.waiting[priority], w)
+	l.dispatch()
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		if w.err != nil {
+			return nil, w.err
+		}
+		return l.releaser(w.use), nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		select {
+		case <-w.ready:
+			if w.err != nil {
+				return nil, w.err
+			}
+			// granted meanwhile
+			l.release(w.use, Usage{})
+		default:
+			l.remove(priority, w)
+		}
+
+		// the attempt timed out in the queue, the provider is not to blame
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, &BusyError{RetryAfter: l.retryAfter()}
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Saturated tells if new bulk calls would be rejected, and when to try again
+func (l *Limiter) Saturated() (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.waitingCount() < l.MaxWaiting {
+		return 0, false
+	}
+	return l.retryAfter(), true
+}
+
+func (l *Limiter) releaser(use *tokenUse) func(Usage) {
+	var once sync.Once
+	return func(usage Usage) {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.release(use, usage)
+		})
+	}
+}
+
+func (l *Limiter) release(use *tokenUse, usage Usage) {
+	l.inFlight--
+	use.tokens = usage.PromptTokens + usage.CompletionTokens
+	l.dispatch()
+}
+
+// dispatch starts waiting calls while the limits allow, interactive first. Nothing starts
+// before the first waiting call of the highest priority, so bulk calls can't starve it.
+func (l *Limiter) dispatch() {
+	for p := len(l.waiting) - 1; p >= 0; p-- {
+		for len(l.waiting[p]) > 0 {
+			w := l.waiting[p][0]
+			if l.inFlight >= l.MaxInFlight {
+				return
+			}
+			if wait := l.tokenWait(w.tokens); wait > 0 {
+				l.wakeIn(wait)
+				return
+			}
+
+			l.waiting[p] = l.waiting[p][1:]
+			l.inFlight++
+			w.use = &tokenUse{at: time.Now(), tokens: w.tokens}
+			l.window = append(l.window, w.use)
+			close(w.ready)
+		}
+	}
+}
+
+// tokenWait is how long until tokens fit in the minute, zero if they do now.
+// A call bigger than the whole limit goes alone.
+func (l *Limiter) tokenWait(tokens int64) time.Duration {
+	l.prune()
+	if l.TokensPerMinute == 0 {
+		return 0
+	}
+
+	now := time.Now()
+	used := int64(0)
+	for _, use := range l.window {
+		used += use.tokens
+	}
+	if used+tokens <= l.TokensPerMinute || len(l.window) == 0 {
+		return 0
+	}
+
+	// until enough of the oldest uses leave the window
+	for _, use := range l.window {
+		used -= use.tokens
+		if used+tokens <= l.TokensPerMinute {
+			return use.at.Add(time.Minute).Sub(now)
+		}
+	}
+	return l.window[len(l.window)-1].at.Add(time.Minute).Sub(now)
+}
+
+// prune drops the uses older than a minute
+func (l *Limiter) prune() {
+	now := time.Now()
+	for len(l.window) > 0 && now.Sub(l.window[0].at) >= time.Minute {
+		l.window = l.window[1:]
+	}
+}
+
+func (l *Limiter) wakeIn(wait time.Duration) {
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	l.timer = time.AfterFunc(wait, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.dispatch()
+	})
+}
+
+// retryAfter guesses when a slot frees: about one call, or longer if the waiting calls are over the tokens
+func (l *Limiter) retryAfter() time.Duration {
+	wait := 5 * time.Second
+	for _, queue := range l.waiting {
+		if len(queue) > 0 {
+			wait = max(wait, l.tokenWait(queue[0].tokens))
+		}
+	}
+	return min(wait, time.Minute)
+}
+
+func (l *Limiter) waitingCount() int {
+	count := 0
+	for _, queue := range l.waiting {
+		count += len(queue)
+	}
+	return count
+}
+
+// evictBulk rejects the newest bulk call waiting, false if there is none
+func (l *Limiter) evictBulk() bool {
+	queue := l.waiting[PriorityBulk]
+	if len(queue) == 0 {
+		return false
+	}
+
+	w := queue[len(queue)-1]
+	l.waiting[PriorityBulk] = queue[:len(queue)-1]
+	w.err = &BusyError{RetryAfter: l.retryAfter()}
+	close(w.ready)
+	return true
+}
+
+func (l *Limiter) remove(priority Priority, w *waiter) {
+	queue := l.waiting[priority]
+	for i := range queue {
+		if queue[i] == w {
+			l.waiting[priority] = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	// the next one may fit now
+	l.dispatch()
+}
+
+var _ Provider = (*Limited)(nil)
+
+// Limited makes the calls of the wrapped provider go through a Limiter
+type Limited struct {
+	inner   Provider
+	limiter *Limiter
+}
+
+func NewLimited(inner Provider, limiter *Limiter) *Limited {
+	return &Limited{inner: inner, limiter: limiter}
+}
+
+func (p *Limited) Chat(ctx context.Context, req Request) (Response, error) {
+	release, err := p.limiter.Acquire(ctx, priorityOf(ctx), estimateTokens(req))
+	if err != nil {
+		return Response{}, err
+	}
+
+	res, err := p.inner.Chat(ctx, req)
+	release(res.Usage)
+	return res, err
+}
+
+func (p *Limited) ChatJSON(ctx context.Context, req Request, schema Schema) (Response, error) {
+	release, err := p.limiter.Acquire(ctx, priorityOf(ctx), estimateTokens(req))
+	if err != nil {
+		return Response{}, err
+	}
+
+	res, err := p.inner.ChatJSON(ctx, req, schema)
+	release(res.Usage)
+	return res, err
+}
+
+func (p *Limited) ChatStream(ctx context.Context, req Request, onDelta OnDelta) (Response, error) {
+	release, err := p.limiter.Acquire(ctx, priorityOf(ctx), estimateTokens(req))
+	if err != nil {
+		return Response{}, err
+	}
+
+	res, err := p.inner.ChatStream(ctx, req, onDelta)
+	release(res.Usage)
+	return res, err
+}
+
+func (p *Limited) Saturated() (time.Duration, bool) {
+	return p.limiter.Saturated()
+}
+
+// estimateTokens is what rate limits count before the call: the prompt, about 4 characters
+// per token, and the completion it may take
+func estimateTokens(req Request) int64 {
+	chars := 0
+	for _, m := range req.Messages {
+		chars += len(m.Content)
+	}
+	return int64(chars/4) + req.MaxTokens
+}
+
+// Saturated tells if p rejects new calls, and when to try again
+func Saturated(p Provider) (time.Duration, bool) {
+	if checker, ok := p.(interface{ Saturated() (time.Duration, bool) }); ok {
+		return checker.Saturated()
+	}
+	return 0, false
+}
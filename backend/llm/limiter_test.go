@@ -0,0 +1,208 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitQueued waits until n calls are waiting in l
+func waitQueued(t *testing.T, l *Limiter, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		l.mu.Lock()
+		count := l.waitingCount()
+		l.mu.Unlock()
+		if count == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d calls waiting, want %d", count, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimiterInteractiveFirst(t *testing.T) {
+	l := &Limiter{MaxInFlight: 1, MaxWaiting: 10}
+	ctx := context.Background()
+
+	release, err := l.Acquire(ctx, PriorityBulk, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan Priority, 2)
+	acquire := func(p Priority) {
+		release, err := l.Acquire(ctx, p, 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		order <- p
+		release(Usage{})
+	}
+
+	// the bulk call waits longer
+	go acquire(PriorityBulk)
+	waitQueued(t, l, 1)
+	go acquire(PriorityInteractive)
+	waitQueued(t, l, 2)
+
+	release(Usage{})
+	for _, want := range []Priority{PriorityInteractive, PriorityBulk} {
+		if got := <-order; got != want {
+			t.Errorf("started %v, want %v", got, want)
+		}
+	}
+}
+
+func TestLimiterMaxWaiting(t *testing.T) {
+	l := &Limiter{MaxInFlight: 1, MaxWaiting: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release, err := l.Acquire(ctx, PriorityBulk, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release(Usage{})
+
+	if _, saturated := l.Saturated(); saturated {
+		t.Error("Saturated() with no call waiting")
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := l.Acquire(ctx, PriorityBulk, 0)
+		done <- err
+	}()
+	waitQueued(t, l, 1)
+
+	if _, saturated := l.Saturated(); !saturated {
+		t.Error("Saturated() = false with MaxWaiting calls waiting")
+	}
+	var busyErr *BusyError
+	if _, err := l.Acquire(ctx, PriorityBulk, 0); !errors.As(err, &busyErr) || busyErr.RetryAfter <= 0 {
+		t.Errorf("Acquire() error = %v, want a BusyError with a wait", err)
+	}
+
+	// a cancelled waiter leaves the queue
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Acquire() error = %v, want context.Canceled", err)
+	}
+	waitQueued(t, l, 0)
+}
+
+func TestLimiterInteractiveEvictsBulk(t *testing.T) {
+	l := &Limiter{MaxInFlight: 1, MaxWaiting: 2}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release, err := l.Acquire(ctx, PriorityBulk, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release(Usage{})
+
+	acquire := func(p Priority) chan error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := l.Acquire(ctx, p, 0)
+			done <- err
+		}()
+		return done
+	}
+
+	oldest := acquire(PriorityBulk)
+	waitQueued(t, l, 1)
+	newest := acquire(PriorityBulk)
+	waitQueued(t, l, 2)
+
+	// the newest bulk call gives its place, the oldest keeps waiting
+	interactive := acquire(PriorityInteractive)
+	var busyErr *BusyError
+	if err := <-newest; !errors.As(err, &busyErr) || busyErr.RetryAfter <= 0 {
+		t.Errorf("evicted Acquire() error = %v, want a BusyError with a wait", err)
+	}
+	waitQueued(t, l, 2)
+	select {
+	case err := <-oldest:
+		t.Errorf("oldest bulk Acquire() = %v, want it still waiting", err)
+	default:
+	}
+
+	second := acquire(PriorityInteractive)
+	if err := <-oldest; !errors.As(err, &busyErr) {
+		t.Errorf("evicted Acquire() error = %v, want a BusyError", err)
+	}
+	waitQueued(t, l, 2)
+
+	// no bulk call left to evict
+	if _, err := l.Acquire(ctx, PriorityInteractive, 0); !errors.As(err, &busyErr) {
+		t.Errorf("Acquire() error = %v, want a BusyError with only interactive calls waiting", err)
+	}
+
+	cancel()
+	for _, done := range []chan error{interactive, second} {
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("Acquire() error = %v, want context.Canceled", err)
+		}
+	}
+	waitQueued(t, l, 0)
+}
+
+func TestLimiterTokensPerMinute(t *testing.T) {
+	l := &Limiter{MaxInFlight: 10, TokensPerMinute: 100, MaxWaiting: 10}
+	ctx := context.Background()
+
+	tokenWait := func(tokens int64) time.Duration {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.tokenWait(tokens)
+	}
+
+	if wait := tokenWait(500); wait != 0 {
+		t.Errorf("tokenWait() = %s on an empty window, a call over the limit goes alone", wait)
+	}
+
+	// the estimate is charged when the call starts
+	release, err := l.Acquire(ctx, PriorityBulk, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait := tokenWait(40); wait != 0 {
+		t.Errorf("tokenWait(40) = %s, want 0", wait)
+	}
+	if wait := tokenWait(50); wait <= 0 || wait > time.Minute {
+		t.Errorf("tokenWait(50) = %s, want until the estimate leaves the window", wait)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	var busyErr *BusyError
+	if _, err := l.Acquire(timeoutCtx, PriorityBulk, 50); !errors.As(err, &busyErr) {
+		t.Errorf("Acquire() error = %v, want a BusyError when timed out in the queue", err)
+	}
+
+	// then the usage replaces it
+	release(Usage{PromptTokens: 15, CompletionTokens: 5})
+	if wait := tokenWait(80); wait != 0 {
+		t.Errorf("tokenWait(80) = %s after the usage, want 0", wait)
+	}
+	if wait := tokenWait(81); wait <= 0 {
+		t.Errorf("tokenWait(81) = %s, want a wait", wait)
+	}
+
+	// uses older than a minute don't count
+	l.mu.Lock()
+	l.window[0].at = time.Now().Add(-time.Minute)
+	l.mu.Unlock()
+	if wait := tokenWait(100); wait != 0 {
+		t.Errorf("tokenWait(100) = %s with an old use, want 0", wait)
+	}
+}
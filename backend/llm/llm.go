@@ -0,0 +1,284 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"nodofinance/utils/env"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Role string
+
+const (
+	RoleSystem    Role = "system"
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+type Message struct {
+	Role    Role   `json:"role"`
+	Content string `json:"content"`
+}
+
+func SystemMessage(content string) Message {
+	return Message{Role: RoleSystem, Content: content}
+}
+
+func UserMessage(content string) Message {
+	return Message{Role: RoleUser, Content: content}
+}
+
+func AssistantMessage(content string) Message {
+	return Message{Role: RoleAssistant, Content: content}
+}
+
+type Request struct {
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	MaxTokens   int64     `json:"max_tokens"`
+	Temperature float64   `json:"temperature"`
+	// Timeout of each attempt, see Resilient
+	Timeout time.Duration `json:"-"`
+}
+
+// Schema constrains a completion to a strict JSON schema
+type Schema struct {
+	Name   string `json:"name"`
+	Schema any    `json:"schema"`
+}
+
+type Usage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+}
+
+type Response struct {
+	Content string `json:"content"`
+	Usage   Usage  `json:"usage"`
+}
+
+// OnDelta gets the pieces of a streamed completion as they arrive, an error stops the stream
+type OnDelta func(delta string) error
+
+// Provider is a chat completion backend
+type Provider interface {
+	Chat(ctx context.Context, req Request) (Response, error)
+	ChatJSON(ctx context.Context, req Request, schema Schema) (Response, error)
+	// ChatStream is Chat passing the completion to onDelta while it is generated
+	ChatStream(ctx context.Context, req Request, onDelta OnDelta) (Response, error)
+}
+
+// *
+// **
+// ***
+// ****
+// ***** CONFIG
+// Models per pipeline stage, overridable through LLM_MODEL_* parameters
+var (
+	MODEL_CLEANER       = "gpt-4o-mini"
+	MODEL_SUBMITTER     = "gpt-4o-mini"
+	MODEL_ANALYST_SMALL = "gpt-4o-mini"
+	MODEL_ANALYST_BIG   = "gpt-4o"
+)
+
+// Timeout of one attempt of each stage, overridable through LLM_TIMEOUT_* parameters (e.g. "90s")
+var (
+	TIMEOUT_CLEANER   = 60 * time.Second
+	TIMEOUT_SUBMITTER = 60 * time.Second
+	TIMEOUT_ANALYST   = 3 * time.Minute
+)
+
+// Retries and breaker of the provider built by NewFromEnv
+var (
+	RETRY_POLICY = RetryPolicy{Attempts: 3, BaseDelay: time.Second, MaxDelay: 20 * time.Second}
+
+	BREAKER_THRESHOLD = 5
+	BREAKER_COOLDOWN  = 30 * time.Second
+)
+
+// Process wide limits of the provider built by NewFromEnv, overridable through LLM_MAX_IN_FLIGHT,
+// LLM_TOKENS_PER_MINUTE (0 is no limit) and LLM_MAX_WAITING parameters
+var (
+	MAX_IN_FLIGHT     = 8
+	TOKENS_PER_MINUTE = int64(0)
+	MAX_WAITING       = 64
+)
+
+// EnsembleMember is one submitter run of an ensemble extraction
+type EnsembleMember struct {
+	Model       string  `json:"model"`
+	Temperature float64 `json:"temperature"`
+}
+
+const MAX_ENSEMBLE_MEMBERS = 5
+
+// Submitter runs of an ensemble extraction, overridable through LLM_ENSEMBLE_SUBMITTERS ("model:temperature,...").
+// By default the submitter model twice, at a low and a high temperature, and the big analyst model.
+var ENSEMBLE_SUBMITTERS = defaultEnsemble()
+
+func defaultEnsemble() []EnsembleMember {
+	return []EnsembleMember{{MODEL_SUBMITTER, 0.2}, {MODEL_SUBMITTER, 0.8}, {MODEL_ANALYST_BIG, 0.2}}
+}
+
+func init() {
+	env.RegisterValidator(validateVar)
+}
+
+func validateVar() error {
+	if model, exists := env.Get("LLM_MODEL_CLEANER"); exists {
+		MODEL_CLEANER = model
+	}
+	if model, exists := env.Get("LLM_MODEL_SUBMITTER"); exists {
+		MODEL_SUBMITTER = model
+	}
+	if model, exists := env.Get("LLM_MODEL_ANALYST_SMALL"); exists {
+		MODEL_ANALYST_SMALL = model
+	}
+	if model, exists := env.Get("LLM_MODEL_ANALYST_BIG"); exists {
+		MODEL_ANALYST_BIG = model
+	}
+
+	if MODEL_CLEANER == "" || MODEL_SUBMITTER == "" || MODEL_ANALYST_SMALL == "" || MODEL_ANALYST_BIG == "" {
+		return fmt.Errorf("invalid value for LLM_MODEL_*: model names cannot be empty")
+	}
+
+	timeouts := map[string]*time.Duration{
+		"LLM_TIMEOUT_CLEANER":   &TIMEOUT_CLEANER,
+		"LLM_TIMEOUT_SUBMITTER": &TIMEOUT_SUBMITTER,
+		"LLM_TIMEOUT_ANALYST":   &TIMEOUT_ANALYST,
+	}
+	for name, timeout := range timeouts {
+		value, exists := env.Get(name)
+		if !exists {
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return fmt.Errorf("invalid value for %s: %s", name, value)
+		}
+		*timeout = parsed
+	}
+
+	limits := map[string]*int{
+		"LLM_MAX_IN_FLIGHT": &MAX_IN_FLIGHT,
+		"LLM_MAX_WAITING":   &MAX_WAITING,
+	}
+	for name, limit := range limits {
+		value, exists := env.Get(name)
+		if !exists {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return fmt.Errorf("invalid value for %s: %s", name, value)
+		}
+		*limit = parsed
+	}
+
+	if value, exists := env.Get("LLM_TOKENS_PER_MINUTE"); exists {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			return fmt.Errorf("invalid value for LLM_TOKENS_PER_MINUTE: %s", value)
+		}
+		TOKENS_PER_MINUTE = parsed
+	}
+
+	ENSEMBLE_SUBMITTERS = defaultEnsemble()
+	if spec, exists := env.Get("LLM_ENSEMBLE_SUBMITTERS"); exists {
+		members, err := ParseEnsemble(spec)
+		if err != nil {
+			return fmt.Errorf("invalid value for LLM_ENSEMBLE_SUBMITTERS: %w", err)
+		}
+		ENSEMBLE_SUBMITTERS = members
+	}
+
+	return nil
+}
+
+// ParseEnsemble parses "model:temperature,..." with 2 to MAX_ENSEMBLE_MEMBERS members
+func ParseEnsemble(spec string) ([]EnsembleMember, error) {
+	var members []EnsembleMember
+
+	for _, part := range strings.Split(spec, ",") {
+		model, temperatureStr, found := strings.Cut(strings.TrimSpace(part), ":")
+		if !found || model == "" {
+			return nil, fmt.Errorf("invalid member %q, expected model:temperature", part)
+		}
+
+		temperature, err := strconv.ParseFloat(temperatureStr, 64)
+		if err != nil || temperature < 0 || temperature > 2 {
+			return nil, fmt.Errorf("invalid temperature in member %q", part)
+		}
+
+		members = append(members, EnsembleMember{model, temperature})
+	}
+
+	if len(members) < 2 || len(members) > MAX_ENSEMBLE_MEMBERS {
+		return nil, fmt.Errorf("%d members, expected 2 to %d", len(members), MAX_ENSEMBLE_MEMBERS)
+	}
+	return members, nil
+}
+
+// NewFromEnv builds the provider selected by LLM_PROVIDER (openai, compatible or fake),
+// wrapped in a Cassette when LLM_CASSETTE_MODE is record or replay, then in a Limited and a Resilient,
+// so every attempt waits for its own slot
+func NewFromEnv() (Provider, error) {
+	p, err := newCassetteFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	limited := NewLimited(p, &Limiter{MaxInFlight: MAX_IN_FLIGHT, TokensPerMinute: TOKENS_PER_MINUTE, MaxWaiting: MAX_WAITING})
+	return NewResilient(limited, RETRY_POLICY, &Breaker{Threshold: BREAKER_THRESHOLD, Cooldown: BREAKER_COOLDOWN}), nil
+}
+
+func newCassetteFromEnv() (Provider, error) {
+	mode, exists := env.Get("LLM_CASSETTE_MODE")
+	if !exists || mode == "" {
+		return newProvider()
+	}
+
+	dir, exists := env.Get("LLM_CASSETTE_DIR")
+	if !exists || dir == "" {
+		dir = "cassettes"
+	}
+
+	if CassetteMode(mode) == ModeReplay {
+		return NewCassette(nil, dir, ModeReplay)
+	}
+
+	inner, err := newProvider()
+	if err != nil {
+		return nil, err
+	}
+	return NewCassette(inner, dir, CassetteMode(mode))
+}
+
+func newProvider() (Provider, error) {
+	provider, exists := env.Get("LLM_PROVIDER")
+	if !exists {
+		provider = "openai"
+	}
+
+	switch provider {
+	case "openai":
+		apiKey, exists := env.Get("OPENAI_API_KEY")
+		if !exists {
+			return nil, fmt.Errorf("missing required environment variable: OPENAI_API_KEY")
+		}
+		return NewOpenAI(apiKey), nil
+	case "compatible":
+		baseURL, exists := env.Get("LLM_BASE_URL")
+		if !exists {
+			return nil, fmt.Errorf("missing required environment variable: LLM_BASE_URL")
+		}
+		apiKey, _ := env.Get("LLM_API_KEY")
+		return NewCompatible(baseURL, apiKey), nil
+	case "fake":
+		return NewScripted(), nil
+	default:
+		return nil, fmt.Errorf("invalid value for LLM_PROVIDER: %s", provider)
+	}
+}
@@ -0,0 +1,125 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+var ErrNoChoices = errors.New("provider returned no choices")
+
+var _ Provider = (*OpenAI)(nil)
+
+type OpenAI struct {
+	client openai.Client
+}
+
+func NewOpenAI(apiKey string) *OpenAI {
+	// retries are up to Resilient
+	return &OpenAI{client: openai.NewClient(option.WithAPIKey(apiKey), option.WithMaxRetries(0))}
+}
+
+func (p *OpenAI) Chat(ctx context.Context, req Request) (Response, error) {
+	return p.complete(ctx, p.params(req))
+}
+
+func (p *OpenAI) ChatJSON(ctx context.Context, req Request, schema Schema) (Response, error) {
+	params := p.params(req)
+	params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
+		OfJSONSchema: &openai.ResponseFormatJSONSchemaParam{
+			JSONSchema: openai.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:   schema.Name,
+				Schema: schema.Schema,
+				Strict: openai.Bool(true),
+			},
+		},
+	}
+	return p.complete(ctx, params)
+}
+
+func (p *OpenAI) ChatStream(ctx context.Context, req Request, onDelta OnDelta) (Response, error) {
+	params := p.params(req)
+	params.StreamOptions = openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)}
+
+	stream := p.client.Chat.Completions.NewStreaming(ctx, params)
+	defer stream.Close()
+
+	var content strings.Builder
+	var usage Usage
+	for stream.Next() {
+		chunk := stream.Current()
+		// the last chunk has the usage and no choices
+		if chunk.Usage.TotalTokens > 0 {
+			usage = Usage{PromptTokens: chunk.Usage.PromptTokens, CompletionTokens: chunk.Usage.CompletionTokens}
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+
+		delta := chunk.Choices[0].Delta.Content
+		content.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			return Response{}, err
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return Response{}, wrapOpenAIError(err)
+	}
+
+	if content.Len() == 0 {
+		return Response{}, ErrNoChoices
+	}
+	return Response{Content: content.String(), Usage: usage}, nil
+}
+
+func (p *OpenAI) params(req Request) openai.ChatCompletionNewParams {
+	messages := make([]openai.ChatCompletionMessageParamUnion, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		switch m.Role {
+		case RoleSystem:
+			messages = append(messages, openai.SystemMessage(m.Content))
+		case RoleAssistant:
+			messages = append(messages, openai.AssistantMessage(m.Content))
+		default:
+			messages = append(messages, openai.UserMessage(m.Content))
+		}
+	}
+
+	return openai.ChatCompletionNewParams{
+		Messages:    messages,
+		Model:       req.Model,
+		MaxTokens:   openai.Int(req.MaxTokens),
+		Temperature: openai.Float(req.Temperature),
+	}
+}
+
+func (p *OpenAI) complete(ctx context.Context, params openai.ChatCompletionNewParams) (Response, error) {
+	chatCompletion, err := p.client.Chat.Completions.New(ctx, params)
+	if err != nil {
+		return Response{}, wrapOpenAIError(err)
+	}
+
+	if len(chatCompletion.Choices) == 0 {
+		return Response{}, ErrNoChoices
+	}
+
+	return Response{
+		Content: chatCompletion.Choices[0].Message.Content,
+		Usage: Usage{
+			PromptTokens:     chatCompletion.Usage.PromptTokens,
+			CompletionTokens: chatCompletion.Usage.CompletionTokens,
+		},
+	}, nil
+}
+
+// wrapOpenAIError keeps the status of API errors for Classify
+func wrapOpenAIError(err error) error {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) && apiErr.Response != nil {
+		return newStatusError(apiErr.StatusCode, apiErr.Response.Header, err)
+	}
+	return err
+}
@@ -0,0 +1,326 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"nodofinance/utils/logger"
+
+	"go.uber.org/zap"
+)
+
+type ErrorKind string
+
+const (
+	KindRateLimit     ErrorKind = "rate_limit"
+	KindTimeout       ErrorKind = "timeout"
+	KindServer        ErrorKind = "server"
+	KindInvalidOutput ErrorKind = "invalid_output"
+	KindUnavailable   ErrorKind = "unavailable"
+	KindBusy          ErrorKind = "busy"
+	KindOther         ErrorKind = "other"
+)
+
+var (
+	ErrInvalidOutput = errors.New("provider returned invalid JSON")
+	ErrUnavailable   = errors.New("LLM provider is unavailable, try again later")
+)
+
+// StatusError is a non 2xx answer of the provider
+type StatusError struct {
+	StatusCode int
+	// RetryAfter is the wait the provider asked for, zero if it didn't
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *StatusError) Error() string { return e.Err.Error() }
+func (e *StatusError) Unwrap() error { return e.Err }
+
+func newStatusError(statusCode int, header http.Header, err error) *StatusError {
+	e := &StatusError{StatusCode: statusCode, Err: err}
+	if seconds, parseErr := strconv.Atoi(header.Get("Retry-After")); parseErr == nil && seconds > 0 {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return e
+}
+
+// CallError is a call that failed for good, after its retries
+type CallError struct {
+	Kind     ErrorKind
+	Attempts int
+	Err      error
+}
+
+func (e *CallError) Error() string {
+	return fmt.Sprintf("%s after %d attempts: %v", e.Kind, e.Attempts, e.Err)
+}
+func (e *CallError) Unwrap() error { return e.Err }
+
+// Classify tells what went wrong in a provider call
+func Classify(err error) ErrorKind {
+	var callErr *CallError
+	var statusErr *StatusError
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &callErr):
+		return callErr.Kind
+	case errors.Is(err, ErrUnavailable):
+		return KindUnavailable
+	case errors.Is(err, ErrBusy):
+		return KindBusy
+	case errors.Is(err, ErrInvalidOutput), errors.Is(err, ErrNoChoices):
+		return KindInvalidOutput
+	case errors.Is(err, context.DeadlineExceeded):
+		return KindTimeout
+	case errors.As(err, &statusErr):
+		switch {
+		case statusErr.StatusCode == http.StatusTooManyRequests:
+			return KindRateLimit
+		case statusErr.StatusCode == http.StatusRequestTimeout:
+			return KindTimeout
+		case statusErr.StatusCode >= 500:
+			return KindServer
+		}
+		return KindOther
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return KindTimeout
+		}
+		return KindServer
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return KindServer
+	}
+	return KindOther
+}
+
+// Retryable tells if another attempt may succeed. A busy Limiter is only waited for in the background,
+// interactive callers answer 429 right away.
+func (k ErrorKind) Retryable(priority Priority) bool {
+	if k == KindBusy {
+		return priority == PriorityBulk
+	}
+	return k == KindRateLimit || k == KindTimeout || k == KindServer || k == KindInvalidOutput
+}
+
+// unhealthy kinds count towards opening the breaker, the others are about the request
+func (k ErrorKind) unhealthy() bool {
+	return k == KindTimeout || k == KindServer
+}
+
+// RetryPolicy is a bounded exponential backoff with full jitter
+type RetryPolicy struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+func (p RetryPolicy) delay(attempt int, err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return min(statusErr.RetryAfter, p.MaxDelay)
+	}
+	var busyErr *BusyError
+	if errors.As(err, &busyErr) {
+		return min(busyErr.RetryAfter, p.MaxDelay)
+	}
+
+	backoff := min(p.BaseDelay<<(attempt-1), p.MaxDelay)
+	return time.Duration(rand.Int64N(int64(backoff) + 1))
+}
+
+// Breaker opens after Threshold unhealthy failures in a row and fails every call for Cooldown.
+// Then one call goes through: an answer of the provider closes the breaker, an unhealthy failure
+// opens it again and a call that never reached the provider (busy, cancelled) lets the next one probe.
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.Threshold {
+		return true
+	}
+	if b.probing || time.Since(b.openedAt) < b.Cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// Open tells if calls fail fast
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.Threshold && (b.probing || time.Since(b.openedAt) < b.Cooldown)
+}
+
+// record takes the outcome of a call that was allowed, nil for a success
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasProbing := b.probing
+	b.probing = false
+
+	switch {
+	case err == nil:
+		b.failures = 0
+	case Classify(err).unhealthy():
+		b.failures++
+		if b.failures >= b.Threshold {
+			if !wasProbing {
+				logger.Log.Warn("LLM circuit breaker opened", zap.Int("failures", b.failures), zap.Duration("cooldown", b.Cooldown))
+			}
+			b.openedAt = time.Now()
+		}
+	case wasProbing && answered(err):
+		// the provider answered, even if the request was wrong
+		b.failures = 0
+	}
+}
+
+// abandon ends a call that was allowed without an outcome, a probe leaves the breaker half-open
+func (b *Breaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// answered tells if err came from a response of the provider, not from before reaching it
+func answered(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) || errors.Is(err, ErrInvalidOutput) || errors.Is(err, ErrNoChoices)
+}
+
+var _ Provider = (*Resilient)(nil)
+
+// Resilient retries the calls of the wrapped provider on transient errors, limits each attempt to
+// Request.Timeout and fails fast with ErrUnavailable while its breaker is open.
+// Tokens of answers thrown away for invalid JSON are added to the usage of the final one,
+// or returned with the error when no attempt succeeds, so they are billed either way.
+type Resilient struct {
+	inner   Provider
+	policy  RetryPolicy
+	breaker *Breaker
+}
+
+func NewResilient(inner Provider, policy RetryPolicy, breaker *Breaker) *Resilient {
+	return &Resilient{inner: inner, policy: policy, breaker: breaker}
+}
+
+func (r *Resilient) Chat(ctx context.Context, req Request) (Response, error) {
+	return r.call(ctx, req, nil, func(ctx context.Context) (Response, error) {
+		return r.inner.Chat(ctx, req)
+	})
+}
+
+func (r *Resilient) ChatJSON(ctx context.Context, req Request, schema Schema) (Response, error) {
+	return r.call(ctx, req, nil, func(ctx context.Context) (Response, error) {
+		res, err := r.inner.ChatJSON(ctx, req, schema)
+		if err == nil && !json.Valid([]byte(res.Content)) {
+			return res, ErrInvalidOutput
+		}
+		return res, err
+	})
+}
+
+// ChatStream is only retried until the first delta, the caller already has it
+func (r *Resilient) ChatStream(ctx context.Context, req Request, onDelta OnDelta) (Response, error) {
+	started := false
+	return r.call(ctx, req, func() bool { return !started }, func(ctx context.Context) (Response, error) {
+		return r.inner.ChatStream(ctx, req, func(delta string) error {
+			started = true
+			return onDelta(delta)
+		})
+	})
+}
+
+// Available tells if calls go through, false while the breaker is open
+func (r *Resilient) Available() bool {
+	return !r.breaker.Open()
+}
+
+// call makes the attempts of fn, canRetry (nil is always) can stop them
+func (r *Resilient) call(ctx context.Context, req Request, canRetry func() bool, fn func(ctx context.Context) (Response, error)) (Response, error) {
+	var wasted Usage
+
+	for attempt := 1; ; attempt++ {
+		if !r.breaker.allow() {
+			return Response{Usage: wasted}, &CallError{Kind: KindUnavailable, Attempts: attempt - 1, Err: ErrUnavailable}
+		}
+
+		callCtx, cancel := ctx, context.CancelFunc(func() {})
+		if req.Timeout > 0 {
+			callCtx, cancel = context.WithTimeout(ctx, req.Timeout)
+		}
+		res, err := fn(callCtx)
+		cancel()
+
+		if err == nil {
+			r.breaker.record(nil)
+			res.Usage.PromptTokens += wasted.PromptTokens
+			res.Usage.CompletionTokens += wasted.CompletionTokens
+			return res, nil
+		}
+
+		kind := Classify(err)
+		if kind == KindInvalidOutput {
+			wasted.PromptTokens += res.Usage.PromptTokens
+			wasted.CompletionTokens += res.Usage.CompletionTokens
+		}
+
+		// the caller gave up, not the provider
+		if ctx.Err() != nil {
+			r.breaker.abandon()
+			return Response{Usage: wasted}, ctx.Err()
+		}
+
+		r.breaker.record(err)
+		if !kind.Retryable(priorityOf(ctx)) || attempt >= r.policy.Attempts || (canRetry != nil && !canRetry()) {
+			return Response{Usage: wasted}, &CallError{Kind: kind, Attempts: attempt, Err: err}
+		}
+
+		delay := r.policy.delay(attempt, err)
+		logger.Log.Warn("Retrying LLM call", zap.Error(err), zap.String("kind", string(kind)), zap.String("model", req.Model), zap.Int("attempt", attempt), zap.Duration("delay", delay))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return Response{Usage: wasted}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Saturated is the one of the wrapped provider
+func (r *Resilient) Saturated() (time.Duration, bool) {
+	return Saturated(r.inner)
+}
+
+// Available tells if p takes calls, providers without a breaker always do
+func Available(p Provider) bool {
+	if checker, ok := p.(interface{ Available() bool }); ok {
+		return checker.Available()
+	}
+	return true
+}
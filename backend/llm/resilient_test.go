@@ -0,0 +1,194 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"nodofinance/utils/logger"
+
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+var errServer = &StatusError{StatusCode: http.StatusBadGateway, Err: errors.New("bad gateway")}
+
+// openBreaker is a breaker past its threshold whose cooldown is over
+func openBreaker(t *testing.T) *Breaker {
+	t.Helper()
+
+	b := &Breaker{Threshold: 2, Cooldown: time.Hour}
+	for range b.Threshold {
+		if !b.allow() {
+			t.Fatal("closed breaker rejected a call")
+		}
+		b.record(errServer)
+	}
+	if !b.Open() || b.allow() {
+		t.Fatal("breaker not open after Threshold unhealthy failures")
+	}
+
+	b.openedAt = time.Now().Add(-b.Cooldown)
+	return b
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	tests := []struct {
+		name string
+		errs []error
+		open bool
+	}{
+		{name: "unhealthy failures in a row", errs: []error{errServer, context.DeadlineExceeded}, open: true},
+		{name: "a success in between", errs: []error{errServer, nil, errServer}, open: false},
+		{name: "request errors don't count", errs: []error{errServer, &StatusError{StatusCode: http.StatusBadRequest, Err: errors.New("bad request")}}, open: false},
+		{name: "busy doesn't count", errs: []error{errServer, &BusyError{}}, open: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Breaker{Threshold: 2, Cooldown: time.Hour}
+			for _, err := range tt.errs {
+				b.allow()
+				b.record(err)
+			}
+			if got := b.Open(); got != tt.open {
+				t.Errorf("Open() = %v, want %v", got, tt.open)
+			}
+		})
+	}
+}
+
+func TestBreakerProbe(t *testing.T) {
+	tests := []struct {
+		name string
+		// err is the outcome of the probe, abandon ends it without one
+		err     error
+		abandon bool
+		// closed is a breaker letting every call through, otherwise halfOpen is one letting the next probe
+		closed   bool
+		halfOpen bool
+	}{
+		{name: "success closes", err: nil, closed: true},
+		{name: "request error is an answer", err: &StatusError{StatusCode: http.StatusBadRequest, Err: errors.New("bad request")}, closed: true},
+		{name: "invalid output is an answer", err: ErrInvalidOutput, closed: true},
+		{name: "unhealthy failure reopens", err: errServer},
+		{name: "busy never reached the provider", err: &BusyError{RetryAfter: time.Second}, halfOpen: true},
+		{name: "cancelled caller", abandon: true, halfOpen: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := openBreaker(t)
+
+			if !b.allow() {
+				t.Fatal("cooldown over, probe rejected")
+			}
+			if b.allow() {
+				t.Fatal("second call allowed during the probe")
+			}
+			if !b.Open() {
+				t.Fatal("Open() = false during the probe")
+			}
+
+			if tt.abandon {
+				b.abandon()
+			} else {
+				b.record(tt.err)
+			}
+
+			switch {
+			case tt.closed:
+				if b.Open() || b.failures != 0 {
+					t.Errorf("breaker not closed, failures = %d", b.failures)
+				}
+			case tt.halfOpen:
+				if b.failures != b.Threshold {
+					t.Errorf("failures = %d, want %d", b.failures, b.Threshold)
+				}
+				if !b.allow() {
+					t.Error("next call can't probe")
+				}
+			default:
+				if !b.Open() || b.allow() {
+					t.Error("breaker not open again for the cooldown")
+				}
+			}
+		})
+	}
+}
+
+func TestResilientCancelledProbeKeepsBreaker(t *testing.T) {
+	b := openBreaker(t)
+	ai := NewScripted()
+	r := NewResilient(ai, RetryPolicy{Attempts: 3}, b)
+
+	// the caller gives up during the probe
+	ctx, cancel := context.WithCancel(context.Background())
+	ai.Handler = func(req Request, schema *Schema) (Response, error) {
+		cancel()
+		return Response{}, ctx.Err()
+	}
+	if _, err := r.Chat(ctx, Request{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Chat() error = %v, want context.Canceled", err)
+	}
+	if b.failures != b.Threshold {
+		t.Errorf("failures = %d, want %d", b.failures, b.Threshold)
+	}
+
+	// the next call probes and closes it
+	ai.Handler = nil
+	if _, err := r.Chat(context.Background(), Request{}); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if b.Open() || b.failures != 0 {
+		t.Errorf("breaker not closed, failures = %d", b.failures)
+	}
+}
+
+func TestResilientFailureKeepsWastedUsage(t *testing.T) {
+	invalid := Response{Content: "not json", Usage: Usage{PromptTokens: 10, CompletionTokens: 1}}
+
+	tests := []struct {
+		name      string
+		cancelAt  int // the call that gives up, 0 for none
+		wantErr   error
+		wantUsage Usage
+	}{
+		{"every attempt invalid", 0, ErrInvalidOutput, Usage{PromptTokens: 30, CompletionTokens: 3}},
+		{"caller gives up", 2, context.Canceled, Usage{PromptTokens: 10, CompletionTokens: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			calls := 0
+			ai := NewScripted()
+			ai.Handler = func(req Request, schema *Schema) (Response, error) {
+				calls++
+				if calls == tt.cancelAt {
+					cancel()
+					return Response{}, ctx.Err()
+				}
+				return invalid, nil
+			}
+			r := NewResilient(ai, RetryPolicy{Attempts: 3}, &Breaker{Threshold: 10, Cooldown: time.Hour})
+
+			res, err := r.ChatJSON(ctx, Request{}, Schema{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ChatJSON() error = %v, want %v", err, tt.wantErr)
+			}
+			if res.Usage != tt.wantUsage {
+				t.Errorf("usage = %+v, want %+v", res.Usage, tt.wantUsage)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var _ Provider = (*Scripted)(nil)
+
+// Scripted is a deterministic provider for tests and offline runs. Responses are
+// served from Handler if set, then from the queue, then a stable default:
+// a null-filled object for schema calls and a fixed text for chat calls.
+type Scripted struct {
+	Handler func(req Request, schema *Schema) (Response, error)
+
+	mu        sync.Mutex
+	responses []Response
+	calls     []Request
+}
+
+func NewScripted(responses ...Response) *Scripted {
+	return &Scripted{responses: responses}
+}
+
+// Push queues responses, served in FIFO order
+func (p *Scripted) Push(responses ...Response) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.responses = append(p.responses, responses...)
+}
+
+// Calls returns the requests received so far
+func (p *Scripted) Calls() []Request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Request(nil), p.calls...)
+}
+
+func (p *Scripted) Chat(ctx context.Context, req Request) (Response, error) {
+	return p.next(ctx, req, nil)
+}
+
+func (p *Scripted) ChatJSON(ctx context.Context, req Request, schema Schema) (Response, error) {
+	return p.next(ctx, req, &schema)
+}
+
+// ChatStream passes the scripted response to onDelta a few words at a time
+func (p *Scripted) ChatStream(ctx context.Context, req Request, onDelta OnDelta) (Response, error) {
+	res, err := p.next(ctx, req, nil)
+	if err != nil {
+		return Response{}, err
+	}
+
+	words := strings.SplitAfter(res.Content, " ")
+	for i := 0; i < len(words); i += 8 {
+		if err := onDelta(strings.Join(words[i:min(i+8, len(words))], "")); err != nil {
+			return Response{}, err
+		}
+	}
+	return res, nil
+}
+
+func (p *Scripted) next(ctx context.Context, req Request, schema *Schema) (Response, error) {
+	if err := ctx.Err(); err != nil {
+		return Response{}, err
+	}
+
+	p.mu.Lock()
+	p.calls = append(p.calls, req)
+	handler := p.Handler
+	var queued *Response
+	if handler == nil && len(p.responses) > 0 {
+		queued = &p.responses[0]
+		p.responses = p.responses[1:]
+	}
+	p.mu.Unlock()
+
+	if handler != nil {
+		return handler(req, schema)
+	}
+	if queued != nil {
+		return *queued, nil
+	}
+
+	content := "Scripted response."
+	if schema != nil {
+		nullContent, err := nullObject(schema.Schema)
+		if err != nil {
+			return Response{}, err
+		}
+		content = nullContent
+	}
+
+	return Response{Content: content, Usage: estimateUsage(req, content)}, nil
+}
+
+// nullObject answers a schema with every top-level property set to null
+func nullObject(schema any) (string, error) {
+	raw, err := json.Marshal(schema)
+	if err != nil {
+		return "", fmt.Errorf("marshaling schema: %w", err)
+	}
+
+	var parsed struct {
+		Properties map[string]any `json:"properties"`
+	}
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return "", fmt.Errorf("parsing schema: %w", err)
+	}
+
+	doc := make(map[string]any, len(parsed.Properties))
+	for field := range parsed.Properties {
+		doc[field] = nil
+	}
+
+	content, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// estimateUsage counts ~4 characters per token
+func estimateUsage(req Request, content string) Usage {
+	promptChars := 0
+	for _, m := range req.Messages {
+		promptChars += len(m.Content)
+	}
+	return Usage{
+		PromptTokens:     int64(promptChars/4 + 1),
+		CompletionTokens: int64(len(content)/4 + 1),
+	}
+}
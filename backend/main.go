@@ -15,16 +15,18 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"github.com/joho/godotenv"
-	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/option"
 	"github.com/patrickmn/go-cache"
 	"github.com/stripe/stripe-go/v81"
 	"go.uber.org/zap"
 
+	"nodofinance/jobs"
+	"nodofinance/llm"
 	"nodofinance/middleware"
+	"nodofinance/routes/admin"
 	"nodofinance/routes/app"
 	"nodofinance/routes/auth"
 	"nodofinance/routes/payments"
+	"nodofinance/store"
 	"nodofinance/utils/env"
 	"nodofinance/utils/jwt"
 	"nodofinance/utils/logger"
@@ -58,30 +60,40 @@ func main() {
 	// jwt validation uses its own cache
 	logger.Log.Info("Cache initialized")
 
-	ctx := context.Background()
-	sdkConfig, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		logger.Log.Fatal("Unable to load SDK config")
+	if err := store.LoadMemoryStore(); err != nil {
+		logger.Log.Fatal("Store validation failed", zap.Error(err))
 	}
 
-	ssm := ssm.NewFromConfig(sdkConfig)
-	c := cognitoidentityprovider.NewFromConfig(sdkConfig)
-	s := s3.NewFromConfig(sdkConfig)
-	d := dynamodb.NewFromConfig(sdkConfig)
-	logger.Log.Info("AWS SDK initialized")
-
-	/*
-		nodofinance_table:
-			- PK: username
-			- SK: composite_sk:
-				* TICKER#{ticker} -> attributes: last_update, currency, analysis
-				* FINANCE#{ticker}#{reverse_year}#{period_order} -> attributes: financial data fields
-				* METADATA -> attributes: stripe_id, expires_date, ctokens
-	*/
-
-	err = env.LoadEnv(ssm, "/")
-	if err != nil {
-		logger.Log.Fatal("Failed to load environment variables", zap.Error(err))
+	// A memory store runs without AWS: variables come from .env, tokens are not verified against Cognito,
+	// auth and payments are not served and submitted documents are not kept in S3
+	var st store.Store
+	var c *cognitoidentityprovider.Client
+	var s *s3.Client
+	if store.MEMORY_STORE {
+		env.LoadLocal()
+		st = store.NewMemory()
+		jwt.UNSIGNED_TOKENS = true
+		logger.Log.Warn("Using in-memory store without AWS, data will not persist and tokens are not verified")
+	} else {
+		ctx := context.Background()
+		sdkConfig, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			logger.Log.Fatal("Unable to load SDK config")
+		}
+
+		ssm := ssm.NewFromConfig(sdkConfig)
+		c = cognitoidentityprovider.NewFromConfig(sdkConfig)
+		s = s3.NewFromConfig(sdkConfig)
+		d := dynamodb.NewFromConfig(sdkConfig)
+		logger.Log.Info("AWS SDK initialized")
+
+		err = env.LoadEnv(ssm, "/")
+		if err != nil {
+			logger.Log.Fatal("Failed to load environment variables", zap.Error(err))
+		}
+
+		// nodofinance_table layout: see store/store.go
+		st = store.NewDynamo(d)
 	}
 	logger.Log.Info("Environment variables loaded successfully")
 
@@ -89,11 +101,20 @@ func main() {
 		logger.Log.Fatal("Environment validation failed", zap.Error(err))
 	}
 
-	err = jwt.LoadJWKS()
-	if err != nil {
-		logger.Log.Fatal("Failed to load JWKS", zap.Error(err))
+	if !store.MEMORY_STORE {
+		err = jwt.LoadJWKS()
+		if err != nil {
+			logger.Log.Fatal("Failed to load JWKS", zap.Error(err))
+		}
+		logger.Log.Info("JWKS loaded successfully")
+
+		stripeKey, ok := env.Get("STRIPE_SK")
+		if !ok {
+			logger.Log.Fatal("STRIPE_SK not found in environment variables")
+		}
+		stripe.Key = stripeKey
+		logger.Log.Info("Stripe initialized successfully")
 	}
-	logger.Log.Info("JWKS loaded successfully")
 
 	err = static.CacheFrontend(execDir)
 	if err != nil {
@@ -101,19 +122,18 @@ func main() {
 	}
 	logger.Log.Info("Frontend files cached successfully")
 
-	stripeKey, ok := env.Get("STRIPE_SK")
-	if !ok {
-		logger.Log.Fatal("STRIPE_SK not found in environment variables")
-	}
-	stripe.Key = stripeKey
-	logger.Log.Info("Stripe initialized successfully")
-
-	openAIkey, ok := env.Get("OPENAI_API_KEY")
-	if !ok {
-		logger.Log.Fatal("OPENAI_API_KEY not found in environment variables")
+	ai, err := llm.NewFromEnv()
+	if err != nil {
+		logger.Log.Fatal("Failed to initialize LLM provider", zap.Error(err))
 	}
-	ai := openai.NewClient(
-		option.WithAPIKey(openAIkey),
+	logger.Log.Info("LLM provider initialized successfully")
+
+	// Submissions run in the background, outside the request WriteTimeout
+	submitQueue := jobs.NewQueue(
+		4,             // workers
+		64,            // pending jobs
+		5*time.Minute, // timeout per job
+		time.Hour,     // job status retention
 	)
 
 	mux := http.NewServeMux()
@@ -136,7 +156,7 @@ func main() {
 	// ***** AUTH
 	mux.HandleFunc("/api/auth/token", middleware.Filter(
 		func(w http.ResponseWriter, r *http.Request) {
-			auth.Token(w, r, c, d)
+			auth.Token(w, r, c, st)
 		},
 		middleware.FilterConfig{
 			AllowedMethods:  []string{"POST"},
@@ -160,7 +180,7 @@ func main() {
 
 	mux.HandleFunc("/api/auth/signin", middleware.Filter(
 		func(w http.ResponseWriter, r *http.Request) {
-			auth.SignIn(w, r, c, d)
+			auth.SignIn(w, r, c, st)
 		},
 		middleware.FilterConfig{
 			AllowedMethods:  []string{"POST"},
@@ -237,7 +257,7 @@ func main() {
 	// ***** PAYMENTS
 	mux.HandleFunc("/api/auth/payments/create-checkout-session", middleware.Filter(
 		func(w http.ResponseWriter, r *http.Request) {
-			payments.Checkout(w, r, d, dataCache)
+			payments.Checkout(w, r, st, dataCache)
 		},
 		middleware.FilterConfig{
 			AllowedMethods:  []string{"POST"},
@@ -249,7 +269,7 @@ func main() {
 
 	mux.HandleFunc("/api/auth/payments/webhooks", middleware.Filter(
 		func(w http.ResponseWriter, r *http.Request) {
-			payments.Webhooks(w, r, c, d)
+			payments.Webhooks(w, r, c, st)
 		},
 		middleware.FilterConfig{
 			AllowedMethods:  []string{"POST"},
@@ -261,7 +281,7 @@ func main() {
 
 	mux.HandleFunc("/api/auth/payments/create-portal-session", middleware.Filter(
 		func(w http.ResponseWriter, r *http.Request) {
-			payments.Portal(w, r, d)
+			payments.Portal(w, r, st)
 		},
 		middleware.FilterConfig{
 			AllowedMethods:  []string{"POST"},
@@ -273,7 +293,7 @@ func main() {
 
 	mux.HandleFunc("/api/auth/payments/refresh-status", middleware.Filter(
 		func(w http.ResponseWriter, r *http.Request) {
-			payments.Refresh(w, r, c, d, dataCache)
+			payments.Refresh(w, r, c, st, dataCache)
 		},
 		middleware.FilterConfig{
 			AllowedMethods:  []string{"POST"},
@@ -290,7 +310,7 @@ func main() {
 	// ***** APP
 	mux.HandleFunc("/api/app/mount", middleware.Filter(
 		func(w http.ResponseWriter, r *http.Request) {
-			app.MountPortfolio(w, r, d, dataCache)
+			app.MountPortfolio(w, r, st, dataCache)
 		},
 		middleware.FilterConfig{
 			AllowedMethods:  []string{"GET"},
@@ -314,7 +334,31 @@ func main() {
 
 	mux.HandleFunc("/api/app/submit", middleware.Filter(
 		func(w http.ResponseWriter, r *http.Request) {
-			app.Submit(w, r, d, s, ai, dataCache, devMode)
+			app.Submit(w, r, st, s, ai, dataCache, submitQueue, devMode)
+		},
+		middleware.FilterConfig{
+			AllowedMethods:  []string{"POST"},
+			DevMode:         devMode,
+			ValidateRequest: true,
+			CheckPremium:    true,
+		},
+	))
+
+	mux.HandleFunc("/api/app/submit-raw", middleware.Filter(
+		func(w http.ResponseWriter, r *http.Request) {
+			app.Submit(w, r, st, s, ai, dataCache, submitQueue, devMode)
+		},
+		middleware.FilterConfig{
+			AllowedMethods:  []string{"POST"},
+			DevMode:         devMode,
+			ValidateRequest: true,
+			CheckPremium:    true,
+		},
+	))
+
+	mux.HandleFunc("/api/app/submit-xbrl", middleware.Filter(
+		func(w http.ResponseWriter, r *http.Request) {
+			app.SubmitXBRL(w, r, st, dataCache)
 		},
 		middleware.FilterConfig{
 			AllowedMethods:  []string{"POST"},
@@ -324,9 +368,81 @@ func main() {
 		},
 	))
 
+	mux.HandleFunc("/api/app/import-edgar", middleware.Filter(
+		func(w http.ResponseWriter, r *http.Request) {
+			app.ImportEdgar(w, r, st, dataCache)
+		},
+		middleware.FilterConfig{
+			AllowedMethods:  []string{"POST"},
+			DevMode:         devMode,
+			ValidateRequest: true,
+			CheckPremium:    true,
+		},
+	))
+
+	mux.HandleFunc("/api/app/submit-status", middleware.Filter(
+		func(w http.ResponseWriter, r *http.Request) {
+			app.SubmitStatus(w, r, submitQueue)
+		},
+		middleware.FilterConfig{
+			AllowedMethods:  []string{"GET"},
+			DevMode:         devMode,
+			ValidateRequest: true,
+			CheckPremium:    true,
+		},
+	))
+
+	mux.HandleFunc("/api/app/submit-events", middleware.Filter(
+		func(w http.ResponseWriter, r *http.Request) {
+			app.SubmitEvents(w, r, submitQueue)
+		},
+		middleware.FilterConfig{
+			AllowedMethods:  []string{"GET"},
+			DevMode:         devMode,
+			ValidateRequest: true,
+			CheckPremium:    true,
+		},
+	))
+
 	mux.HandleFunc("/api/app/mount-ticker", middleware.Filter(
 		func(w http.ResponseWriter, r *http.Request) {
-			app.MountTicker(w, r, d)
+			app.MountTicker(w, r, st)
+		},
+		middleware.FilterConfig{
+			AllowedMethods:  []string{"GET"},
+			DevMode:         devMode,
+			ValidateRequest: true,
+			CheckPremium:    true,
+		},
+	))
+
+	mux.HandleFunc("/api/app/draft", middleware.Filter(
+		func(w http.ResponseWriter, r *http.Request) {
+			app.GetDraft(w, r, st)
+		},
+		middleware.FilterConfig{
+			AllowedMethods:  []string{"GET"},
+			DevMode:         devMode,
+			ValidateRequest: true,
+			CheckPremium:    true,
+		},
+	))
+
+	mux.HandleFunc("/api/app/approve-draft", middleware.Filter(
+		func(w http.ResponseWriter, r *http.Request) {
+			app.ApproveDraft(w, r, st, s, dataCache)
+		},
+		middleware.FilterConfig{
+			AllowedMethods:  []string{"POST"},
+			DevMode:         devMode,
+			ValidateRequest: true,
+			CheckPremium:    true,
+		},
+	))
+
+	mux.HandleFunc("/api/app/provenance", middleware.Filter(
+		func(w http.ResponseWriter, r *http.Request) {
+			app.Provenance(w, r, st)
 		},
 		middleware.FilterConfig{
 			AllowedMethods:  []string{"GET"},
@@ -338,7 +454,19 @@ func main() {
 
 	mux.HandleFunc("/api/app/analyst", middleware.Filter(
 		func(w http.ResponseWriter, r *http.Request) {
-			app.Analyst(w, r, d, ai)
+			app.Analyst(w, r, st, ai)
+		},
+		middleware.FilterConfig{
+			AllowedMethods:  []string{"POST"},
+			DevMode:         devMode,
+			ValidateRequest: true,
+			CheckPremium:    true,
+		},
+	))
+
+	mux.HandleFunc("/api/app/analyst/stream", middleware.Filter(
+		func(w http.ResponseWriter, r *http.Request) {
+			app.AnalystStream(w, r, st, ai)
 		},
 		middleware.FilterConfig{
 			AllowedMethods:  []string{"POST"},
@@ -348,9 +476,93 @@ func main() {
 		},
 	))
 
+	mux.HandleFunc("/api/app/compare", middleware.Filter(
+		func(w http.ResponseWriter, r *http.Request) {
+			app.Compare(w, r, st, ai)
+		},
+		middleware.FilterConfig{
+			AllowedMethods:  []string{"POST"},
+			DevMode:         devMode,
+			ValidateRequest: true,
+			CheckPremium:    true,
+		},
+	))
+
+	mux.HandleFunc("/api/app/analyses", middleware.Filter(
+		func(w http.ResponseWriter, r *http.Request) {
+			app.Analyses(w, r, st)
+		},
+		middleware.FilterConfig{
+			AllowedMethods:  []string{"GET"},
+			DevMode:         devMode,
+			ValidateRequest: true,
+			CheckPremium:    true,
+		},
+	))
+
+	mux.HandleFunc("/api/app/analysis", middleware.Filter(
+		func(w http.ResponseWriter, r *http.Request) {
+			app.GetAnalysis(w, r, st)
+		},
+		middleware.FilterConfig{
+			AllowedMethods:  []string{"GET"},
+			DevMode:         devMode,
+			ValidateRequest: true,
+			CheckPremium:    true,
+		},
+	))
+
+	mux.HandleFunc("/api/app/analysis-diff", middleware.Filter(
+		func(w http.ResponseWriter, r *http.Request) {
+			app.DiffAnalyses(w, r, st)
+		},
+		middleware.FilterConfig{
+			AllowedMethods:  []string{"GET"},
+			DevMode:         devMode,
+			ValidateRequest: true,
+			CheckPremium:    true,
+		},
+	))
+
+	mux.HandleFunc("/api/app/chat", middleware.Filter(
+		func(w http.ResponseWriter, r *http.Request) {
+			app.Chat(w, r, st, ai)
+		},
+		middleware.FilterConfig{
+			AllowedMethods:  []string{"POST"},
+			DevMode:         devMode,
+			ValidateRequest: true,
+			CheckPremium:    true,
+		},
+	))
+
+	mux.HandleFunc("/api/app/chat-export", middleware.Filter(
+		func(w http.ResponseWriter, r *http.Request) {
+			app.ChatExport(w, r, st)
+		},
+		middleware.FilterConfig{
+			AllowedMethods:  []string{"GET"},
+			DevMode:         devMode,
+			ValidateRequest: true,
+			CheckPremium:    true,
+		},
+	))
+
+	mux.HandleFunc("/api/app/chat-clear", middleware.Filter(
+		func(w http.ResponseWriter, r *http.Request) {
+			app.ChatClear(w, r, st)
+		},
+		middleware.FilterConfig{
+			AllowedMethods:  []string{"DELETE"},
+			DevMode:         devMode,
+			ValidateRequest: true,
+			CheckPremium:    true,
+		},
+	))
+
 	mux.HandleFunc("/api/app/edit", middleware.Filter(
 		func(w http.ResponseWriter, r *http.Request) {
-			app.Edit(w, r, d)
+			app.Edit(w, r, st, s)
 		},
 		middleware.FilterConfig{
 			AllowedMethods:  []string{"PATCH"},
@@ -362,7 +574,7 @@ func main() {
 
 	mux.HandleFunc("/api/app/delete-ticker", middleware.Filter(
 		func(w http.ResponseWriter, r *http.Request) {
-			app.Delete(w, r, d, dataCache)
+			app.Delete(w, r, st, dataCache)
 		},
 		middleware.FilterConfig{
 			AllowedMethods:  []string{"DELETE"},
@@ -372,6 +584,23 @@ func main() {
 		},
 	))
 
+	// *
+	// **
+	// ***
+	// ****
+	// ***** ADMIN
+	mux.HandleFunc("/api/admin/extractions", middleware.Filter(
+		func(w http.ResponseWriter, r *http.Request) {
+			admin.PurgeExtractions(w, r, st)
+		},
+		middleware.FilterConfig{
+			AllowedMethods:  []string{"DELETE"},
+			DevMode:         devMode,
+			ValidateRequest: true,
+			CheckPremium:    false,
+		},
+	))
+
 	// *
 	// **
 	// ***
@@ -379,16 +608,26 @@ func main() {
 	// ***** STATIC
 	mux.HandleFunc("/", static.Handler(devMode, []string{"GET", "HEAD"}))
 
+	// Raw, XBRL and EDGAR filings are read on the server, so their routes take bigger bodies
+	handler := http.NewServeMux()
+	handler.Handle("/", http.MaxBytesHandler(mux, 100*1024))                         // 100KB limit for request body
+	handler.Handle("/api/app/submit-raw", http.MaxBytesHandler(mux, 10*1024*1024))   // 10MB limit for raw documents
+	handler.Handle("/api/app/submit-xbrl", http.MaxBytesHandler(mux, 10*1024*1024))  // 10MB limit for XBRL documents
+	handler.Handle("/api/app/import-edgar", http.MaxBytesHandler(mux, 30*1024*1024)) // 30MB limit for companyfacts files
+	if store.MEMORY_STORE {
+		handler.Handle("/api/auth/", http.NotFoundHandler()) // Cognito and Stripe
+	}
+
 	port := 80
 
 	server := &http.Server{
 		Addr:              fmt.Sprintf(":%d", port),
-		Handler:           http.MaxBytesHandler(mux, 100*1024), // 100KB limit for request body
-		ReadHeaderTimeout: 3 * time.Second,                     // Timeout for reading headers
-		ReadTimeout:       20 * time.Second,                    // Timeout for reading the request body
-		WriteTimeout:      60 * time.Second,                    // Timeout for writing the response
-		IdleTimeout:       60 * time.Second,                    // Timeout for idle connections (keep-alive)
-		MaxHeaderBytes:    32 * 1024,                           // 32KB limit for headers
+		Handler:           handler,
+		ReadHeaderTimeout: 3 * time.Second,  // Timeout for reading headers
+		ReadTimeout:       20 * time.Second, // Timeout for reading the request body
+		WriteTimeout:      60 * time.Second, // Timeout for writing the response
+		IdleTimeout:       60 * time.Second, // Timeout for idle connections (keep-alive)
+		MaxHeaderBytes:    32 * 1024,        // 32KB limit for headers
 	}
 
 	logger.Log.Info("Server starting on", zap.Int("port", port))
@@ -0,0 +1,717 @@
+package preprocess
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	rowSplitPattern     = regexp.MustCompile(`(-?\(?\d[\d,.]*\)?)(\s+)(-?\(?\d[\d,.]*\)?)(\s+)([a-zA-Z][\w\s]*)`)
+	digitPattern        = regexp.MustCompile(`\d`)
+	letterPattern       = regexp.MustCompile(`[a-zA-Z]`)
+	specialCharsPattern = regexp.MustCompile(`^[(),\-.'0-9\s]*$`)
+	onlyNumbersPattern  = regexp.MustCompile(`^[\s\d(),\-.']*$`)
+	tablePattern        = regexp.MustCompile(`(?i)table:|end of table`)
+	datePattern         = regexp.MustCompile(`\b(\d{1,2})/(\d{1,2})/(\d{2,4})\b`)
+	yearPattern         = regexp.MustCompile(`\b(\d{4})\b`)
+	decimalRestPattern  = regexp.MustCompile(`^\s+\d+[.,]\d+`)
+	negativeRestPattern = regexp.MustCompile(`^\s+\(-?\d+[.,]\d+\)`)
+	accountingPattern   = regexp.MustCompile(`\(\s*(\d+(?:\.\d+)?)\s*\)`)
+	decimalCommaPattern = regexp.MustCompile(`(\d),(\d+)`)
+
+	millionsEN = regexp.MustCompile(`(?i)\bin\s+millions?\b|\bmillions?\s+of\s+(?:dollars|pounds|euros)\b`)
+	thousandEN = regexp.MustCompile(`(?i)\bin\s+thousands?\b|\bthousands?\s+of\s+(?:dollars|pounds|euros)\b`)
+	millionsES = regexp.MustCompile(`(?i)\ben\s+millones\b|\bmillones\s+de\s+(?:euros|dolares|dólares|libras)\b`)
+	thousandES = regexp.MustCompile(`(?i)\ben\s+miles\b|\bmiles\s+de\s+(?:euros|dolares|dólares|libras)\b`)
+
+	removeMillionsEN  = regexp.MustCompile(`(?i)\bin\s+millions?\s+of\b|\bin\s+millions?,\b|\bin\s+millions?\b|\bmillions?\s+of\b|\bmillions?\.\b|\bmillions?,\b|\bmillions?\b`)
+	removeThousandsEN = regexp.MustCompile(`(?i)\bin\s+thousands?\s+of\b|\bin\s+thousands?,\b|\bin\s+thousands?\b|\bthousands?\s+of\b|\bthousands?\.\b|\bthousands?,\b|\bthousands?\b`)
+	removePerShareEN  = regexp.MustCompile(`(?i)\bexcept\s+share\s+and\s+per\s+share\b|\bexcept\s+per\s+share\b|\bexcept\s+share\b`)
+	removeMillionsES  = regexp.MustCompile(`(?i)\ben\s+millones\s+de\b|\ben\s+millones,\b|\bmillones\s+de\b|\bmillones\.\b|\bmillones,\b|\bmillones\b`)
+	removeThousandsES = regexp.MustCompile(`(?i)\ben\s+miles\s+de\b|\ben\s+miles,\b|\bmiles\s+de\b|\bmiles\.\b|\bmiles,\b|\bmiles\b`)
+)
+
+// CleanChunk strips a statement chunk down to its labelled figures and detects
+// its units (1000, 1000000 or 0 if unknown). period is the full period, e.g. 2024-Y.
+func CleanChunk(target, chunk, language, period string) (string, int64) {
+	filterWords := getFilterWords(language, target)
+
+	// *
+	// **
+	// ***
+	// ****
+	// ***** NORMALIZE
+	// two numbers followed by a word signal a new row glued to the previous one
+	var normalizedLines []string
+	for _, line := range strings.Split(chunk, "\n") {
+		if strings.TrimSpace(line) == "" {
+			normalizedLines = append(normalizedLines, line)
+			continue
+		}
+
+		match := rowSplitPattern.FindStringSubmatchIndex(line)
+		if match != nil {
+			// split after the second number
+			splitPosition := match[7]
+			normalizedLines = append(normalizedLines, line[:splitPosition], line[splitPosition:])
+		} else {
+			normalizedLines = append(normalizedLines, line)
+		}
+	}
+
+	var sb strings.Builder
+	for _, line := range normalizedLines {
+		sb.WriteString(line + "\n")
+	}
+	processedText := sb.String()
+
+	// *
+	// **
+	// ***
+	// ****
+	// *****  FILTER WORDS
+	processedText = filterLines(processedText, filterWords)
+
+	// *
+	// **
+	// ***
+	// ****
+	// ***** ORPHANS
+	processedText = joinOrphans(processedText)
+
+	// *
+	// **
+	// ***
+	// ****
+	// ***** DATES
+	if strings.HasSuffix(period, "-Y") || strings.HasSuffix(period, "S2") || strings.HasSuffix(period, "Q4") {
+		processedText = shiftJanuaryDates(processedText, language)
+	}
+
+	// *
+	// **
+	// ***
+	// ****
+	// ***** CURRENCIES & INTEGERS
+	lines := strings.Split(processedText, "\n")
+	sb.Reset()
+	for _, line := range lines {
+		processedLine := stripCurrenciesAndIntegers([]rune(line))
+
+		// Handle Spanish language case
+		if language == "ES" {
+			processedLine = strings.ReplaceAll(processedLine, ".", "")
+		}
+
+		sb.WriteString(processedLine + "\n")
+	}
+	processedText = sb.String()
+
+	// *
+	// **
+	// ***
+	// ****
+	// ***** UNITS
+	hasMillionsFirst, hasThousandsFirst := detectUnits(processedText, language)
+
+	// *
+	// **
+	// ***
+	// ****
+	// ***** (+), THOUSANDS SEP, €$£, TEXT-ONLY CHUNKS...
+	lines = strings.Split(processedText, "\n")
+	var linesToKeep []string
+	for _, line := range lines {
+		processedLine := stripPlusParentheses([]rune(line))
+
+		// Remove all thousands separators
+		if language == "ES" {
+			processedLine = strings.ReplaceAll(processedLine, ".", "")
+		} else {
+			processedLine = strings.ReplaceAll(processedLine, ",", "")
+		}
+
+		// Remove €, $, £
+		processedLine = strings.NewReplacer("€", "", "$", "", "£", "").Replace(processedLine)
+
+		// Only add lines that have fewer than 180 characters
+		if len([]rune(processedLine)) < 180 {
+			linesToKeep = append(linesToKeep, processedLine)
+		}
+	}
+
+	specialKeywords := []string{"miles", "millones", "thousands", "millions"}
+	containsSpecialKeywords := func(line string) bool {
+		lowerLine := strings.ToLower(line)
+		return slices.ContainsFunc(specialKeywords, func(keyword string) bool {
+			return strings.Contains(lowerLine, keyword)
+		})
+	}
+
+	nextNonEmpty := func(from int) int {
+		for from < len(linesToKeep) && strings.TrimSpace(linesToKeep[from]) == "" {
+			from++
+		}
+		return from
+	}
+
+	sb.Reset()
+	for i, line := range linesToKeep {
+		if tablePattern.MatchString(line) {
+			continue
+		}
+
+		nextIndex := nextNonEmpty(i + 1)
+		nextNextIndex := nextNonEmpty(nextIndex + 1)
+
+		// Only add this line if it or any of the next two non-empty lines contain numbers
+		if digitPattern.MatchString(line) ||
+			(nextIndex < len(linesToKeep) && digitPattern.MatchString(linesToKeep[nextIndex])) ||
+			(nextNextIndex < len(linesToKeep) && digitPattern.MatchString(linesToKeep[nextNextIndex])) ||
+			containsSpecialKeywords(line) {
+			sb.WriteString(line + "\n")
+		}
+	}
+	processedText = sb.String()
+
+	// *
+	// **
+	// ***
+	// ****
+	// ***** POSTPROCESS UNITS
+	hasMillionsFinal, hasThousandsFinal := detectUnits(processedText, language)
+
+	switch language {
+	case "EN":
+		processedText = removeMillionsEN.ReplaceAllString(processedText, "")
+		processedText = removeThousandsEN.ReplaceAllString(processedText, "")
+		processedText = removePerShareEN.ReplaceAllString(processedText, "")
+	case "ES":
+		processedText = removeMillionsES.ReplaceAllString(processedText, "")
+		processedText = removeThousandsES.ReplaceAllString(processedText, "")
+
+		// Handle decimal points for Spanish
+		processedText = decimalCommaPattern.ReplaceAllString(processedText, "${1}.${2}")
+	}
+
+	var units int64
+	switch {
+	case hasMillionsFinal:
+		units = 1000000
+	case hasThousandsFinal:
+		units = 1000
+	case hasMillionsFirst:
+		units = 1000000
+	case hasThousandsFirst:
+		units = 1000
+	}
+
+	// Convert accounting notation (parentheses) to minus sign
+	processedText = accountingPattern.ReplaceAllString(processedText, "-${1}")
+
+	return processedText, units
+}
+
+func detectUnits(text, language string) (bool, bool) {
+	switch language {
+	case "EN":
+		return millionsEN.MatchString(text), thousandEN.MatchString(text)
+	case "ES":
+		return millionsES.MatchString(text), thousandES.MatchString(text)
+	default:
+		return false, false
+	}
+}
+
+func filterLines(text string, filterWords []string) string {
+	lines := strings.Split(text, "\n")
+	linesToRemove := make(map[int]bool)
+
+	for i, line := range lines {
+		if linesToRemove[i] {
+			continue
+		}
+
+		lowerLine := strings.ToLower(line)
+		containedIn := func(word string) bool { return strings.Contains(lowerLine, word) }
+
+		if slices.ContainsFunc(goodWords, containedIn) {
+			continue
+		}
+
+		hasFilterWord := slices.ContainsFunc(filterWords, containedIn)
+		hasNumber := digitPattern.MatchString(line)
+
+		if hasFilterWord && hasNumber {
+			linesToRemove[i] = true
+			continue
+		}
+
+		// label alone, its figures on the next line
+		if hasFilterWord && i+1 < len(lines) && specialCharsPattern.MatchString(lines[i+1]) {
+			linesToRemove[i] = true
+			linesToRemove[i+1] = true
+		}
+	}
+
+	return joinKept(lines, linesToRemove)
+}
+
+func joinOrphans(text string) string {
+	lines := strings.Split(text, "\n")
+	linesToRemove := make(map[int]bool)
+	orphans := []string{
+		"non-current assets",
+		"non current assets",
+		"non-current liabilities",
+		"non current liabilities",
+		"current assets",
+		"current liabilities",
+	}
+
+	hasOnlyNumbersAndSpecialChars := func(line string) bool {
+		trimmed := strings.TrimSpace(line)
+		return onlyNumbersPattern.MatchString(trimmed) && len(trimmed) > 0
+	}
+	hasLettersAndNumbers := func(line string) bool {
+		return letterPattern.MatchString(line) && digitPattern.MatchString(line)
+	}
+
+	for i := range lines {
+		currentLine := lines[i]
+		lowerLine := strings.ToLower(currentLine)
+
+		if !slices.ContainsFunc(orphans, func(phrase string) bool { return strings.Contains(lowerLine, phrase) }) {
+			continue
+		}
+
+		// Case 1: current line already has numbers
+		if digitPattern.MatchString(currentLine) {
+			continue
+		}
+
+		// Case 2: next line has only numbers
+		if i+1 < len(lines) && hasOnlyNumbersAndSpecialChars(lines[i+1]) {
+			continue
+		}
+
+		// Case 3: orphaned numbers in the next 5 lines
+		for j := 2; j <= 5; j++ {
+			if i+j >= len(lines) {
+				break
+			}
+
+			potentialOrphanLine := lines[i+j]
+			lineAbove := lines[i+j-1]
+
+			if hasOnlyNumbersAndSpecialChars(potentialOrphanLine) && hasLettersAndNumbers(lineAbove) {
+				lines[i] = currentLine + " " + strings.TrimSpace(potentialOrphanLine)
+				linesToRemove[i+j] = true
+				break
+			}
+		}
+	}
+
+	return joinKept(lines, linesToRemove)
+}
+
+func joinKept(lines []string, linesToRemove map[int]bool) string {
+	kept := make([]string, 0, len(lines))
+	for i, line := range lines {
+		if !linesToRemove[i] {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// shiftJanuaryDates moves dates of fiscal years closing in January back to the
+// year they report, then blanks the January mentions
+func shiftJanuaryDates(text, language string) string {
+	lines := strings.Split(text, "\n")
+	month := "january"
+	if language == "ES" {
+		month = "enero"
+	}
+
+	var modifiedText strings.Builder
+	for i := 0; i < len(lines); i++ {
+		// Combine current line with next line if available
+		combinedLines := lines[i]
+		if i+1 < len(lines) {
+			combinedLines += "\n" + lines[i+1]
+		}
+
+		if strings.Contains(strings.ToLower(combinedLines), month) {
+			modifiedText.WriteString(processJanuarySection(lines, i, language))
+
+			// Skip processed lines (up to 2)
+			i += min(2, len(lines)-i-1)
+		} else {
+			modifiedText.WriteString(processDates(lines[i], language, false) + "\n")
+		}
+	}
+
+	modified := modifiedText.String()
+	var januaryRemoved strings.Builder
+	for currPos := 0; currPos < len(modified); {
+		if foundJanuary, skipLen := hasJanuary(modified[currPos:], language); foundJanuary {
+			januaryRemoved.WriteString(strings.Repeat(" ", skipLen))
+			currPos += skipLen
+		} else {
+			januaryRemoved.WriteByte(modified[currPos])
+			currPos++
+		}
+	}
+
+	return januaryRemoved.String()
+}
+
+func processDates(line, language string, isJanuary bool) string {
+	if len([]rune(line)) < 6 {
+		return line
+	}
+
+	result := line
+
+	// Process full dates, last first so earlier positions stay valid
+	dateMatches := datePattern.FindAllStringSubmatchIndex(line, -1)
+	for k := len(dateMatches) - 1; k >= 0; k-- {
+		match := dateMatches[k]
+		yearStr := line[match[6]:match[7]]
+
+		firstNum, _ := strconv.Atoi(line[match[2]:match[3]])
+		secondNum, _ := strconv.Atoi(line[match[4]:match[5]])
+		year, _ := strconv.Atoi(yearStr)
+
+		// Handle two-digit years
+		if len(yearStr) == 2 {
+			if year < 50 {
+				year += 2000
+			} else {
+				year += 1900
+			}
+		}
+
+		replace := false
+		if language == "ES" {
+			if firstNum < 1 || firstNum > 31 || secondNum < 1 || secondNum > 12 {
+				continue
+			}
+			replace = secondNum == 1
+		} else {
+			if firstNum < 1 || firstNum > 31 || secondNum < 1 || secondNum > 31 {
+				continue
+			}
+			replace = (firstNum == 1 && secondNum > 12) || (firstNum > 12 && secondNum == 1)
+		}
+
+		if replace {
+			result = result[:match[0]] + strconv.Itoa(year-1) + result[match[1]:]
+		}
+	}
+
+	// Process standalone years for January
+	if isJanuary {
+		yearMatches := yearPattern.FindAllStringSubmatchIndex(result, -1)
+		for k := len(yearMatches) - 1; k >= 0; k-- {
+			match := yearMatches[k]
+			year, _ := strconv.Atoi(result[match[2]:match[3]])
+			result = result[:match[0]] + strconv.Itoa(year-1) + result[match[1]:]
+		}
+	}
+
+	return result
+}
+
+func processJanuarySection(lines []string, currentIndex int, language string) string {
+	for currentIndex < len(lines) && strings.TrimSpace(lines[currentIndex]) == "" {
+		currentIndex++
+	}
+
+	if currentIndex >= len(lines) {
+		return ""
+	}
+
+	var combinedText strings.Builder
+	for offset := 0; offset < 3 && currentIndex+offset < len(lines); offset++ {
+		combinedText.WriteString(lines[currentIndex+offset] + "\n")
+	}
+
+	return processDates(combinedText.String(), language, true)
+}
+
+func hasJanuary(text, language string) (bool, int) {
+	if len(text) == 0 {
+		return false, 0
+	}
+
+	month := "january"
+	if language == "ES" {
+		month = "enero"
+	}
+
+	// Check for complete patterns, case insensitive
+	for _, pattern := range []string{month + " 31,", month + " 31", month} {
+		if len(text) >= len(pattern) && strings.EqualFold(text[:len(pattern)], pattern) {
+			return true, len(pattern)
+		}
+	}
+
+	// Check for split pattern (month on one line, day on another)
+	searchLength := min(len(text), len(month)+5)
+	if !strings.Contains(strings.ToLower(text[:searchLength]), month) {
+		return false, 0
+	}
+
+	pos := 0
+	for {
+		newlinePos := strings.IndexByte(text[pos:], '\n')
+		if newlinePos == -1 {
+			break
+		}
+		newlinePos += pos
+
+		// Check if we've gone too far from the start
+		if newlinePos > 500 {
+			break
+		}
+
+		// Skip whitespace after the newline
+		checkPos := newlinePos + 1
+		for checkPos < len(text) && isSpace(text[checkPos]) {
+			checkPos++
+		}
+
+		// Check for "31" pattern
+		if checkPos+1 < len(text) && text[checkPos] == '3' && text[checkPos+1] == '1' &&
+			(checkPos+2 == len(text) || isSpace(text[checkPos+2]) || strings.IndexByte(".,;:?!", text[checkPos+2]) != -1) {
+			return true, checkPos + 2
+		}
+
+		pos = newlinePos + 1
+	}
+
+	return false, 0
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// *
+// **
+// ***
+// ****
+// ***** INTEGERS & CURRENCIES HELPERS
+// stripCurrenciesAndIntegers normalizes unit markers and blanks note references,
+// footnote marks and years that head figure columns
+func stripCurrenciesAndIntegers(line []rune) string {
+	var processedLine strings.Builder
+	isDigit := func(i int) bool { return i < len(line) && isASCIIDigit(line[i]) }
+	isWhite := func(i int) bool { return i < len(line) && unicode.IsSpace(line[i]) }
+	substring := func(start, end int) string { return string(line[min(start, len(line)):min(end, len(line))]) }
+
+	for curr := 0; curr < len(line); {
+		// Check for currency symbols
+		if found, length, kind := checkCurrencyMillionsOrUds(line[curr:]); found {
+			switch kind {
+			case "units":
+				processedLine.WriteString("units")
+			case "millions":
+				processedLine.WriteString("in millions")
+			case "thousands":
+				processedLine.WriteString("in thousands")
+			}
+			curr += length
+			continue
+		}
+
+		// Check for integers surrounded by parentheses
+		if line[curr] == '(' && curr+1 < len(line) {
+			numStart := curr + 1
+			numEnd := numStart
+			for isDigit(numEnd) {
+				numEnd++
+			}
+
+			if numEnd < len(line) && line[numEnd] == ')' {
+				numLength := numEnd - numStart
+				if numLength <= 2 {
+					nextFiveChars := substring(numEnd+1, numEnd+6)
+					if nextFiveChars != " week" && nextFiveChars != " mont" {
+						processedLine.WriteString(strings.Repeat(" ", numLength+2))
+						curr = numEnd + 1
+						continue
+					}
+				}
+			}
+		}
+
+		// Check for integers surrounded by spaces
+		if isDigit(curr) && (curr == 0 || isWhite(curr-1)) {
+			numStart := curr
+			numEnd := numStart
+			hasDelimiters := false
+
+			for numEnd < len(line) {
+				if isDigit(numEnd) {
+					numEnd++
+				} else if line[numEnd] == '.' || line[numEnd] == ',' {
+					hasDelimiters = true
+					numEnd++
+				} else {
+					break
+				}
+			}
+
+			if isWhite(numEnd) {
+				numLength := numEnd - numStart
+
+				if numLength <= 2 {
+					nextFiveChars := substring(numEnd, numEnd+5)
+					if nextFiveChars != " week" && nextFiveChars != " mont" {
+						processedLine.WriteString(strings.Repeat(" ", numLength))
+						curr = numEnd
+						continue
+					}
+				} else if numLength == 4 && !hasDelimiters && isYearHeader(line, numEnd) {
+					processedLine.WriteString(strings.Repeat(" ", numLength))
+					curr = numEnd
+					continue
+				}
+			}
+		}
+
+		processedLine.WriteRune(line[curr])
+		curr++
+	}
+
+	return processedLine.String()
+}
+
+// isYearHeader tells if the 4 digits ending at numEnd are followed by a figure
+func isYearHeader(line []rune, numEnd int) bool {
+	restOfLine := string(line[numEnd:])
+	if decimalRestPattern.MatchString(restOfLine) || negativeRestPattern.MatchString(restOfLine) {
+		return true
+	}
+
+	la := numEnd
+	for la < len(line) && unicode.IsSpace(line[la]) {
+		la++
+	}
+	if la >= len(line) {
+		return false
+	}
+
+	if line[la] == '(' {
+		bracketIndex := la + 1
+		for bracketIndex < len(line) && line[bracketIndex] != ')' {
+			bracketIndex++
+		}
+		return bracketIndex < len(line) && digitPattern.MatchString(string(line[la+1:bracketIndex]))
+	}
+
+	if isASCIIDigit(line[la]) {
+		for laEnd := la; laEnd < len(line); laEnd++ {
+			if line[laEnd] == '.' || line[laEnd] == ',' {
+				return true
+			}
+			if !isASCIIDigit(line[laEnd]) {
+				break
+			}
+		}
+	}
+
+	return false
+}
+
+func isASCIIDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func checkCurrencyMillionsOrUds(remaining []rune) (bool, int, string) {
+	currencySymbols := []rune{'$', '£', '€'}
+
+	// Check for "Uds." (case insensitive)
+	if len(remaining) >= 4 && strings.EqualFold(string(remaining[:3]), "uds") && remaining[3] == '.' {
+		return true, 4, "units"
+	}
+
+	if len(remaining) == 0 || !slices.Contains(currencySymbols, remaining[0]) {
+		return false, 0, ""
+	}
+
+	// Currency symbol followed by million marker
+	if len(remaining) >= 2 && (remaining[1] == 'm' || remaining[1] == 'M') {
+		return true, 2, "millions"
+	}
+
+	// Currency symbol followed by whitespace and then million/thousand words
+	pos := 1
+	for pos < len(remaining) && unicode.IsSpace(remaining[pos]) {
+		pos++
+	}
+
+	hasWord := func(word string) bool {
+		end := pos + len([]rune(word))
+		return end <= len(remaining) && strings.ToLower(string(remaining[pos:end])) == word
+	}
+
+	for _, word := range []string{"million", "millions", "millón", "millon", "millones"} {
+		if hasWord(word) {
+			return true, pos + len([]rune(word)), "millions"
+		}
+	}
+
+	for _, word := range []string{"thousand", "thousands", "mil", "miles"} {
+		if hasWord(word) {
+			return true, pos + len([]rune(word)), "thousands"
+		}
+	}
+
+	return false, 0, ""
+}
+
+// stripPlusParentheses replaces parenthetical expressions containing a '+' by a space
+func stripPlusParentheses(line []rune) string {
+	var processedLine strings.Builder
+
+	for curr := 0; curr < len(line); {
+		if line[curr] == '(' {
+			closeParenPos := -1
+			hasPlus := false
+
+			// Find matching closing parenthesis
+			depth := 1
+			for i := curr + 1; i < len(line) && depth > 0; i++ {
+				switch line[i] {
+				case '(':
+					depth++
+				case ')':
+					depth--
+					if depth == 0 {
+						closeParenPos = i
+					}
+				case '+':
+					hasPlus = true
+				}
+			}
+
+			if closeParenPos != -1 && hasPlus {
+				processedLine.WriteString(" ")
+				curr = closeParenPos + 1
+				continue
+			}
+		}
+
+		processedLine.WriteRune(line[curr])
+		curr++
+	}
+
+	return processedLine.String()
+}
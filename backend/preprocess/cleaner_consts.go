@@ -0,0 +1,412 @@
+package preprocess
+
+var goodWords = []string{
+	"bill",
+	"mil",
+	"thou",
+	"ended",
+}
+
+var commonFilterWordsEN = []string{
+	"deferred",
+	"goodwill",
+	"payable",
+	"tax",
+	"accrued",
+	"lease",
+	"senior",
+	"receivable",
+	"prepaid",
+	"property",
+	"intangible",
+	"estate",
+	"deficit",
+	"additional",
+	"equipment",
+	"subscription",
+	"service",
+	"interest",
+	"marketing",
+	"research",
+	"develop",
+	"general",
+	"administrative",
+	"provision",
+	"adjust",
+	"depreciation",
+	"compensation",
+	"amortiz",
+	"unrealized",
+	"exchange",
+	"foreign",
+	"marketable",
+	"securit",
+	"proceed",
+	"purchase",
+	"repay",
+	"acquire",
+	"currency",
+	"cost",
+	"expense",
+	"change",
+	"kept",
+	"retain",
+	"contract",
+	"insurance",
+	"transfer",
+	"reclassif",
+	"margin",
+	"instrument",
+	"right",
+	"fixed",
+	"inventor",
+	"dividend",
+	"start",
+	"transfer",
+	"payment",
+	"%",
+	"distribution",
+	"employ",
+	"environment",
+	"government",
+	"coverage",
+	"writing",
+	"premium",
+	"reserve",
+	"loan",
+	"subsidy",
+	"donation",
+	"legacy",
+	"disposal",
+	"negotiable",
+	"required",
+	"emission",
+	"www.",
+	"http",
+	"secretary",
+	"president",
+	"person",
+	"supplier",
+	"working",
+	"collateral",
+	"retirement",
+	"exploration",
+	"polic",
+	"expenditure",
+	"signed",
+	"venture",
+	"held",
+	"associate",
+	"restricted",
+	"deriva",
+	"quantitative",
+	"qualitative",
+	"supplement",
+	"system",
+	"cancel",
+	"subsidiar",
+	"maturity",
+	"portion",
+	"other asset",
+	"other liabilit",
+	"commitment",
+	"contingen",
+	"fulfil",
+	"technolog",
+	"infraestruct",
+	"product",
+	"category",
+	"segment",
+	"licens",
+	"deposit",
+	"impairment",
+	"transport",
+	"legal",
+	"pension",
+	"translat",
+	"remunerat",
+	"director",
+	"board",
+	"reclasifica",
+	"interim",
+	"accompan",
+	"parties",
+	"table:",
+	"end of table",
+	"automo",
+	"storage",
+	"address",
+}
+
+var balanceSpecificEN = []string{
+	"invest",
+	"financ",
+	"income",
+	"revenue",
+	"flow",
+	"earning",
+	"loss",
+	"attribut",
+	"issued",
+	"commercial",
+	"called-up",
+	"ebit",
+	"weight",
+	"finan",
+	"reclassif",
+	"common",
+}
+
+var incomeSpecificEN = []string{
+	"invest",
+	"financ",
+	"liabilit",
+	"asset",
+	"equivalent",
+	"balance",
+	"flow",
+	"suppl",
+	"fuel",
+}
+
+var cashFlowSpecificEN = []string{
+	"liabilit",
+	"asset",
+	"equity",
+	"stock",
+	"share",
+	"equivalent",
+	"income",
+	"revenue",
+	"balance",
+	"earning",
+	"loss",
+	"attribut",
+	"collection",
+	"funds",
+	"other",
+	"increase",
+	"decrease",
+	"equivalen",
+	"acquisition",
+	"movement",
+	"reconcil",
+	"fee",
+	"redemption",
+	"transaction",
+	"issu",
+	"increase",
+	"decrease",
+	"proceed",
+	"payment",
+	"dividend",
+	"treasury",
+	"subscribed",
+}
+
+var commonFilterWordsES = []string{
+	"diferido",
+	"comercio",
+	"pagable",
+	"impuesto",
+	"acreedor",
+	"deudor",
+	"intangible",
+	"deficit",
+	"adicional",
+	"equipo",
+	"suscrip",
+	"servicio",
+	"inter",
+	"marketing",
+	"investiga",
+	"desarrollo",
+	"general",
+	"administr",
+	"provisi",
+	"ajuste",
+	"deprecia",
+	"compensa",
+	"amortiz",
+	"divisa",
+	"libera",
+	"gasto",
+	"coste",
+	"adquis",
+	"reembolso",
+	"cambio",
+	"mantenido",
+	"retenido",
+	"otra",
+	"inmobiliar",
+	"contrato",
+	"seguro",
+	"transfer",
+	"reclasif",
+	"impositiv",
+	"valoraci",
+	"conversi",
+	"participa",
+	"margen",
+	"instrumento",
+	"tenedor",
+	"derecho",
+	"inmoviliza",
+	"existencia",
+	"inventario",
+	"arrenda",
+	"dividendo",
+	"circula",
+	"inicio",
+	"traspaso",
+	"distribuci",
+	"combinaci",
+	"alta",
+	"pago",
+	"saldo",
+	"%",
+	"reparto",
+	"nuestro",
+	"medioambiente",
+	"gobierno",
+	"cobertura",
+	"periodificaci",
+	"escritura",
+	"prima",
+	"reserva",
+	"subvenci",
+	"donaci",
+	"subsidio",
+	"legado",
+	"enajena",
+	"desinversi",
+	"descubierto",
+	"negociable",
+	"emisi",
+	"asociad",
+	"www.",
+	"http",
+	"(+34",
+	"madrid",
+	"vocal",
+	"secretar",
+	"consejer",
+	"president",
+	"persona",
+	"proveedor",
+	"exigid",
+	"aumento",
+	"reducci",
+	"combustible",
+	"suscrito",
+	"perpetua",
+	"S.A.",
+	"derrama",
+	"asegurad",
+	"asimetr",
+	"subordinad",
+	"riesgo",
+	"reclasifica",
+	"designa",
+	"hiper",
+	"table:",
+	"end of table",
+}
+
+var balanceSpecificES = []string{
+	"invers",
+	"financ",
+	"ingreso",
+	"beneficio",
+	"flujo",
+	"flow",
+	"resultado",
+	"atribui",
+	"multigrupo",
+	"fiscal",
+	"cartera",
+	"cobra",
+	"paga",
+	"ebit",
+	"socio",
+	"variaci",
+	"deriva",
+}
+
+var incomeSpecificES = []string{
+	"invers",
+	"financ",
+	"pasivo",
+	"activo",
+	"balance",
+	"flujo",
+	"flow",
+	"equivalen",
+	"prestaciones",
+}
+
+var cashFlowSpecificES = []string{
+	"pasivo",
+	"activo",
+	"patrimonio",
+	"equivalen",
+	"ingreso",
+	"beneficio",
+	"balance",
+	"resultado",
+	"atribui",
+	"otro",
+	"banco",
+	"variac",
+	"unidad",
+	"cobro",
+	"fiscal",
+	"riesgo",
+	"acci",
+	"prop",
+	"socio",
+	"propietar",
+	"cartera",
+	"cobra",
+	"paga",
+	"partida",
+}
+
+var (
+	filterWordsBalanceEN  = concat(commonFilterWordsEN, balanceSpecificEN)
+	filterWordsIncomeEN   = concat(commonFilterWordsEN, incomeSpecificEN)
+	filterWordsCashFlowEN = concat(commonFilterWordsEN, cashFlowSpecificEN)
+	filterWordsBalanceES  = concat(commonFilterWordsES, balanceSpecificES)
+	filterWordsIncomeES   = concat(commonFilterWordsES, incomeSpecificES)
+	filterWordsCashFlowES = concat(commonFilterWordsES, cashFlowSpecificES)
+)
+
+func concat(slices ...[]string) []string {
+	result := []string{}
+	for _, s := range slices {
+		result = append(result, s...)
+	}
+	return result
+}
+
+func getFilterWords(language, target string) []string {
+	if language == "ES" {
+		switch target {
+		case "balance":
+			return filterWordsBalanceES
+		case "income":
+			return filterWordsIncomeES
+		default:
+			return filterWordsCashFlowES
+		}
+	}
+
+	switch target {
+	case "balance":
+		return filterWordsBalanceEN
+	case "income":
+		return filterWordsIncomeEN
+	default:
+		return filterWordsCashFlowEN
+	}
+}
@@ -0,0 +1,242 @@
+package preprocess
+
+import (
+	"io"
+	"mime/quotedprintable"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	boundaryPattern   = regexp.MustCompile(`(?i)boundary="([^"]+)"`)
+	numericEntity     = regexp.MustCompile(`&#(\d+);`)
+	hexEntity         = regexp.MustCompile(`&#[xX]([0-9a-fA-F]+);`)
+	countNumbersRegex = regexp.MustCompile(`-?(\d+(\.\d*)?|\.\d+)`)
+)
+
+// IsHTML tells if a raw document should go through HTMLToText first
+func IsHTML(content string) bool {
+	searchArea := strings.ToLower(content[:min(len(content), 8192)])
+	return isMime(content) ||
+		strings.Contains(searchArea, "<html") ||
+		strings.Contains(searchArea, "<body") ||
+		strings.Contains(searchArea, "<table")
+}
+
+// HTMLToText extracts the readable text of an HTML (or MIME .mht) filing,
+// flattening tables to one row per line
+func HTMLToText(text string) string {
+	if text == "" {
+		return ""
+	}
+
+	if isMime(text) {
+		return processContent(extractHTML(text))
+	}
+
+	return processContent(text)
+}
+
+func isMime(content string) bool {
+	searchArea := strings.ToLower(content[:min(len(content), 8192)])
+	return strings.Contains(searchArea, "mime-version: 1.0") &&
+		strings.Contains(searchArea, "content-type: multipart/")
+}
+
+// Extract HTML from multipart MIME message
+func extractHTML(content string) string {
+	boundaryMatch := boundaryPattern.FindStringSubmatch(content)
+	if boundaryMatch == nil {
+		return ""
+	}
+
+	var result strings.Builder
+	for _, part := range strings.Split(content, "--"+boundaryMatch[1]) {
+		lowerPart := strings.ToLower(part)
+		if !strings.Contains(lowerPart, "content-type: text/html") {
+			continue
+		}
+
+		// Extract content after headers
+		bodyStart := 0
+		if i := strings.Index(part, "\r\n\r\n"); i != -1 {
+			bodyStart = i + 4
+		} else if i := strings.Index(part, "\n\n"); i != -1 {
+			bodyStart = i + 2
+		}
+		body := part[bodyStart:]
+
+		if strings.Contains(lowerPart, "content-transfer-encoding: quoted-printable") {
+			decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(body)))
+			if err == nil {
+				body = string(decoded)
+			}
+		}
+
+		result.WriteString(body + "\n")
+	}
+
+	return result.String()
+}
+
+// Process HTML content to extract readable text
+func processContent(content string) string {
+	if content == "" {
+		return ""
+	}
+
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return ""
+	}
+
+	var sb strings.Builder
+	extractFormattedText(&sb, doc, false)
+
+	return postprocessing(sb.String())
+}
+
+var skippableTags = map[string]bool{
+	"img": true, "meta": true, "button": true, "input": true, "svg": true,
+	"noscript": true, "iframe": true, "link": true, "head": true, "nav": true,
+	"header": true, "footer": true, "object": true, "embed": true, "canvas": true,
+	"map": true, "area": true, "param": true, "video": true, "audio": true,
+	"track": true, "source": true, "select": true, "base": true, "br": true,
+	"col": true, "hr": true, "wbr": true, "script": true, "style": true,
+}
+
+// Extract text with special handling for tables
+func extractFormattedText(sb *strings.Builder, node *html.Node, inTable bool) {
+	switch node.Type {
+	case html.ElementNode:
+		tagName := strings.ToLower(node.Data)
+		if skippableTags[tagName] {
+			return
+		}
+
+		if tagName == "table" {
+			inTable = true
+			sb.WriteString("\n\nTable: ")
+		}
+
+		if inTable {
+			switch tagName {
+			case "tr":
+				sb.WriteString("\n")
+			case "td", "th":
+				sb.WriteString("  ")
+			}
+		}
+
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			extractFormattedText(sb, child, inTable)
+		}
+
+		if tagName == "table" {
+			sb.WriteString("\nEnd of table\n\n")
+		}
+	case html.TextNode:
+		if text := strings.TrimSpace(node.Data); text != "" {
+			sb.WriteString(text + " ")
+		}
+	default:
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			extractFormattedText(sb, child, inTable)
+		}
+	}
+}
+
+// Process leftover (double encoded) HTML entities and clean the output
+func postprocessing(input string) string {
+	if input == "" {
+		return ""
+	}
+
+	output := strings.NewReplacer(
+		"&quot;", `"`,
+		"&apos;", "'",
+		"&amp;", "&",
+		"&lt;", "<",
+		"&gt;", ">",
+		"&#160;", " ",
+		"&nbsp;nbsp;", " ",
+		"&nbsp;&nbsp;", " ",
+		"&nbsp;", " ",
+		"&#8217;", "'",
+	).Replace(input)
+
+	decode := func(base int) func(string) string {
+		return func(entity string) string {
+			digits := strings.TrimRight(strings.TrimLeft(entity, "&#xX"), ";")
+			code, err := strconv.ParseInt(digits, base, 32)
+			if err != nil {
+				return entity
+			}
+			return string(rune(code))
+		}
+	}
+	output = numericEntity.ReplaceAllStringFunc(output, decode(10))
+	output = hexEntity.ReplaceAllStringFunc(output, decode(16))
+
+	return cleanOutput(output)
+}
+
+// cleanOutput drops tables without figures and collapses runs of newlines
+func cleanOutput(input string) string {
+	const tableStart = "Table:"
+	const tableEnd = "End of table"
+
+	var output strings.Builder
+	consecutiveNewlines := 0
+
+	appendCleanedSection := func(section string) {
+		for _, c := range section {
+			if c == '\n' {
+				if consecutiveNewlines < 2 {
+					output.WriteRune('\n')
+					consecutiveNewlines++
+				}
+			} else {
+				output.WriteRune(c)
+				consecutiveNewlines = 0
+			}
+		}
+	}
+
+	pos, lastPos := 0, 0
+	for {
+		start := strings.Index(input[pos:], tableStart)
+		if start == -1 {
+			break
+		}
+		pos += start
+
+		// Add content before the table
+		appendCleanedSection(input[lastPos:pos])
+
+		end := strings.Index(input[pos:], tableEnd)
+		if end == -1 {
+			lastPos = pos
+			break
+		}
+		endPos := pos + end + len(tableEnd)
+
+		tableSection := input[pos:endPos]
+		if len(countNumbersRegex.FindAllString(tableSection, -1)) > 1 {
+			appendCleanedSection(tableSection)
+		}
+
+		pos = endPos
+		lastPos = pos
+	}
+
+	// Add remaining content
+	if lastPos < len(input) {
+		appendCleanedSection(input[lastPos:])
+	}
+
+	return output.String()
+}
@@ -0,0 +1,234 @@
+package preprocess
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Port of frontend/src/utils/preprocess. Keep both sides in sync:
+// the server recomputes what the browser sends.
+
+type Metrics struct {
+	FirstUniqueHits  int `json:"first_unique_hits"`
+	SecondUniqueHits int `json:"second_unique_hits"`
+	ThirdUniqueHits  int `json:"third_unique_hits"`
+	FourthUniqueHits int `json:"fourth_unique_hits"`
+	FifthUniqueHits  int `json:"fifth_unique_hits"`
+}
+
+type ChunkResult struct {
+	Chunk      string   `json:"chunk,omitempty"`
+	Cleaned    string   `json:"cleaned,omitempty"`
+	Units      int64    `json:"units,omitempty"`
+	Metrics    Metrics  `json:"metrics"`
+	Indicators []string `json:"indicators"`
+}
+
+type Output struct {
+	BalanceResult  ChunkResult `json:"balance_result"`
+	IncomeResult   ChunkResult `json:"income_result"`
+	CashFlowResult ChunkResult `json:"cash_flow_result"`
+	Language       string      `json:"language"`
+}
+
+// Preprocess finds the balance, income and cash flow sections of a document
+// and cleans them. period is the full period, e.g. 2024-Q4.
+func Preprocess(content, period string) Output {
+	lowerContent, lowerOffsets := normalizeText(content)
+
+	// try EN
+	language := "EN"
+	balance := findChunk(content, lowerContent, lowerOffsets, Indicators("balance", language))
+	income := findChunk(content, lowerContent, lowerOffsets, Indicators("income", language))
+	cashFlow := findChunk(content, lowerContent, lowerOffsets, Indicators("cash_flow", language))
+
+	// not enough hits -> try ES
+	if balance.Metrics.FirstUniqueHits < 15 ||
+		income.Metrics.FirstUniqueHits < 15 ||
+		cashFlow.Metrics.FirstUniqueHits < 15 {
+		language = "ES"
+		balance = findChunk(content, lowerContent, lowerOffsets, Indicators("balance", language))
+		income = findChunk(content, lowerContent, lowerOffsets, Indicators("income", language))
+		cashFlow = findChunk(content, lowerContent, lowerOffsets, Indicators("cash_flow", language))
+	}
+
+	// clean
+	balance.Cleaned, balance.Units = CleanChunk("balance", balance.Chunk, language, period)
+	income.Cleaned, income.Units = CleanChunk("income", income.Chunk, language, period)
+	cashFlow.Cleaned, cashFlow.Units = CleanChunk("cash_flow", cashFlow.Chunk, language, period)
+
+	return Output{
+		BalanceResult:  balance,
+		IncomeResult:   income,
+		CashFlowResult: cashFlow,
+		Language:       language,
+	}
+}
+
+// Indicators returns the section keywords of a target (balance, income, cash_flow)
+func Indicators(target, language string) []string {
+	if language == "ES" {
+		switch target {
+		case "balance":
+			return balanceIndicatorsES
+		case "income":
+			return incomeIndicatorsES
+		default:
+			return cashFlowIndicatorsES
+		}
+	}
+
+	switch target {
+	case "balance":
+		return balanceIndicatorsEN
+	case "income":
+		return incomeIndicatorsEN
+	default:
+		return cashFlowIndicatorsEN
+	}
+}
+
+// UniqueHits counts the distinct indicators of a target present anywhere in text.
+// A chunk always scores at least the first_unique_hits of its best window.
+func UniqueHits(text, target, language string) (int, []string) {
+	lowerText, _ := normalizeText(text)
+
+	found := []string{}
+	for _, indicator := range Indicators(target, language) {
+		if strings.Contains(lowerText, indicator) {
+			found = append(found, indicator)
+		}
+	}
+
+	return len(found), found
+}
+
+// normalizeText lowercases and strips accents like the browser: toLowerCase, NFD and the
+// combining marks U+0300 to U+036F removed. offsets are the byte offsets of its UTF-16 units.
+func normalizeText(content string) (string, []int) {
+	var b strings.Builder
+	b.Grow(len(content))
+
+	for _, r := range content {
+		r = StripAccent(unicode.ToLower(r))
+		if r >= 0x300 && r <= 0x36f {
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	lowerContent := b.String()
+	return lowerContent, utf16Offsets(lowerContent)
+}
+
+// utf16Offsets maps every UTF-16 unit of s, and its end, to a byte offset of s. Both units of a
+// surrogate pair map to the start of the character: a cut through it keeps the character at the
+// start and drops it at the end, where the browser leaves half of it.
+func utf16Offsets(s string) []int {
+	offsets := make([]int, 0, len(s)+1)
+	for i, r := range s {
+		for range utf16.RuneLen(r) {
+			offsets = append(offsets, i)
+		}
+	}
+	return append(offsets, len(s))
+}
+
+// StripAccent folds the precomposed latin letters that NFD would decompose, for the lowercase
+// ES and EN labels. Other letters keep their accents, the browser strips them too.
+func StripAccent(r rune) rune {
+	if r < utf8.RuneSelf {
+		return r
+	}
+
+	switch r {
+	case 'á', 'à', 'â', 'ä', 'ã', 'å':
+		return 'a'
+	case 'é', 'è', 'ê', 'ë':
+		return 'e'
+	case 'í', 'ì', 'î', 'ï':
+		return 'i'
+	case 'ó', 'ò', 'ô', 'ö', 'õ':
+		return 'o'
+	case 'ú', 'ù', 'û', 'ü':
+		return 'u'
+	case 'ñ':
+		return 'n'
+	case 'ç':
+		return 'c'
+	case 'ý', 'ÿ':
+		return 'y'
+	default:
+		return r
+	}
+}
+
+// findChunk slides the windows over the normalized content and cuts the chunk of the best one from
+// content. Positions are UTF-16 units and the stride is fractional, as in the browser, which also
+// takes the position on the normalized content as the one on content.
+func findChunk(content, lowerContent string, lowerOffsets []int, indicators []string) ChunkResult {
+	counts := [5]int{} // first, second, third, fourth, fifth
+	bestStart := 0.0
+	var bestIndicators []string
+
+	contentLength := float64(len(lowerOffsets) - 1)
+
+	for i := 0.0; i < contentLength; i += OVERLAP_STRIDE {
+		windowEnd := min(i+WINDOW_SIZE, contentLength)
+		window := lowerContent[lowerOffsets[int(i)]:lowerOffsets[int(windowEnd)]]
+
+		// Check each indicator against the current window
+		var foundIndicators []string
+		for _, indicator := range indicators {
+			if strings.Contains(window, indicator) {
+				foundIndicators = append(foundIndicators, indicator)
+			}
+		}
+
+		count := len(foundIndicators)
+
+		// Update top 5 counts
+		switch {
+		case count > counts[0]:
+			counts = [5]int{count, counts[0], counts[1], counts[2], counts[3]}
+			bestStart = i
+			bestIndicators = foundIndicators
+		case count > counts[1]:
+			counts = [5]int{counts[0], count, counts[1], counts[2], counts[3]}
+		case count > counts[2]:
+			counts = [5]int{counts[0], counts[1], count, counts[2], counts[3]}
+		case count > counts[3]:
+			counts = [5]int{counts[0], counts[1], counts[2], count, counts[3]}
+		case count > counts[4]:
+			counts[4] = count
+		}
+	}
+
+	// Calculate chunk boundaries on the original content
+	offsets := utf16Offsets(content)
+	units := float64(len(offsets) - 1)
+
+	chunkStart := 0.0
+	if bestStart > BUFFER_SIZE {
+		chunkStart = min(bestStart-BUFFER_SIZE, units)
+	}
+	chunkEnd := chunkStart + min(OUTPUT_CHUNK_SIZE, units-chunkStart)
+
+	if bestIndicators == nil {
+		bestIndicators = []string{}
+	}
+
+	return ChunkResult{
+		Chunk: content[offsets[int(chunkStart)]:offsets[int(chunkEnd)]],
+		Metrics: Metrics{
+			FirstUniqueHits:  counts[0],
+			SecondUniqueHits: counts[1],
+			ThirdUniqueHits:  counts[2],
+			FourthUniqueHits: counts[3],
+			FifthUniqueHits:  counts[4],
+		},
+		Indicators: bestIndicators,
+	}
+}
@@ -0,0 +1,104 @@
+package preprocess
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The goldens are the output of the browser preprocessor on the same filings, see testdata/golden.mjs
+func TestPreprocessMatchesBrowser(t *testing.T) {
+	filings, err := filepath.Glob("testdata/filing_*.txt")
+	if err != nil || len(filings) == 0 {
+		t.Fatalf("no filings in testdata: %v", err)
+	}
+
+	for _, filing := range filings {
+		t.Run(filepath.Base(filing), func(t *testing.T) {
+			content, err := os.ReadFile(filing)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden, err := os.ReadFile(strings.TrimSuffix(filing, ".txt") + ".golden.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want Output
+			if err := json.Unmarshal(golden, &want); err != nil {
+				t.Fatalf("decoding golden: %v", err)
+			}
+
+			got := Preprocess(string(content), "2024-Y")
+			if got.Language != want.Language {
+				t.Errorf("Language = %s, want %s", got.Language, want.Language)
+			}
+
+			sections := []struct {
+				name      string
+				got, want ChunkResult
+			}{
+				{"balance", got.BalanceResult, want.BalanceResult},
+				{"income", got.IncomeResult, want.IncomeResult},
+				{"cash_flow", got.CashFlowResult, want.CashFlowResult},
+			}
+			for _, section := range sections {
+				if section.got.Metrics != section.want.Metrics {
+					t.Errorf("%s Metrics = %+v, want %+v", section.name, section.got.Metrics, section.want.Metrics)
+				}
+				if strings.Join(section.got.Indicators, "|") != strings.Join(section.want.Indicators, "|") {
+					t.Errorf("%s Indicators = %q, want %q", section.name, section.got.Indicators, section.want.Indicators)
+				}
+				if section.got.Chunk != section.want.Chunk {
+					t.Errorf("%s Chunk differs: %d chars from %q, want %d from %q", section.name,
+						len([]rune(section.got.Chunk)), prefix(section.got.Chunk), len([]rune(section.want.Chunk)), prefix(section.want.Chunk))
+				}
+				if section.got.Cleaned != section.want.Cleaned {
+					t.Errorf("%s Cleaned = %q, want %q", section.name, section.got.Cleaned, section.want.Cleaned)
+				}
+				if section.got.Units != section.want.Units {
+					t.Errorf("%s Units = %d, want %d", section.name, section.got.Units, section.want.Units)
+				}
+			}
+		})
+	}
+}
+
+// prefix is the start of a chunk, for messages
+func prefix(chunk string) string {
+	runes := []rune(chunk)
+	return string(runes[:min(len(runes), 40)])
+}
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		// UTF-16 units of the result
+		wantUnits int
+	}{
+		{"ascii", "Total Assets", "total assets", 12},
+		{"precomposed accents", "Situación FINANCIERA Año", "situacion financiera ano", 24},
+		{"combining marks", "Situacio\u0301n", "situacion", 9},
+		{"letters outside the table", "Ærø", "ærø", 3},
+		{"surrogate pair", "a😀b", "a😀b", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, offsets := normalizeText(tt.content)
+			if got != tt.want {
+				t.Errorf("normalizeText() = %q, want %q", got, tt.want)
+			}
+			if units := len(offsets) - 1; units != tt.wantUnits {
+				t.Errorf("normalizeText() units = %d, want %d", units, tt.wantUnits)
+			}
+			if offsets[len(offsets)-1] != len(got) {
+				t.Errorf("normalizeText() end offset = %d, want %d", offsets[len(offsets)-1], len(got))
+			}
+		})
+	}
+}
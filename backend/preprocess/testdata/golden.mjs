@@ -0,0 +1,14 @@
+// Writes the output of the browser preprocessor for every filing_*.txt to <name>.golden.json,
+// the Go port must produce the same. Run from backend/preprocess after changing either side:
+//   node testdata/golden.mjs
+import { readdirSync, readFileSync, writeFileSync } from 'node:fs'
+import { Preprocessor } from '../../../frontend/src/utils/preprocess/preprocessor.js'
+
+const dir = new URL('.', import.meta.url)
+const preprocessor = new Preprocessor()
+
+for (const name of readdirSync(dir).filter((f) => /^filing_.*\.txt$/.test(f))) {
+  const content = readFileSync(new URL(name, dir), 'utf8')
+  const output = preprocessor.preprocess(content, '2024-Y')
+  writeFileSync(new URL(name.replace(/\.txt$/, '.golden.json'), dir), JSON.stringify(output, null, 2) + '\n')
+}
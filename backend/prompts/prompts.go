@@ -0,0 +1,245 @@
+package prompts
+
+import (
+	"embed"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"nodofinance/utils/env"
+)
+
+/*
+	Prompt templates of the LLM calls in text/template syntax, one directory per version:
+		templates/v1/cleaner_system.tmpl
+		templates/v1/cleaner.tmpl
+		...
+	Every version defines all the Names. The builtin versions are embedded, PROMPTS_DIR adds versions
+	from disk (a directory with the name of a builtin version replaces it), so prompts change without a rebuild.
+	A trailing newline at the end of a file is dropped.
+
+	PROMPT_ROLLOUT splits the calls between versions by percentage, e.g. "v1:90,v2:10".
+	The same key always gets the same version. Without it every call uses DEFAULT_VERSION.
+*/
+
+const (
+	CleanerSystem   = "cleaner_system"
+	Cleaner         = "cleaner"
+	SubmitterSystem = "submitter_system"
+	Submitter       = "submitter"
+	AnalystSystem   = "analyst_system"
+	Analyst         = "analyst"
+	Insights        = "insights"
+	ChatSystem      = "chat_system"
+	Compare         = "compare"
+)
+
+var Names = []string{CleanerSystem, Cleaner, SubmitterSystem, Submitter, AnalystSystem, Analyst, Insights, ChatSystem, Compare}
+
+const DEFAULT_VERSION = "v1"
+
+//go:embed templates
+var builtin embed.FS
+
+var funcs = template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+	// value prints an optional number, null if missing
+	"value": func(v *float64) string {
+		if v == nil {
+			return "null"
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	},
+}
+
+// Set is one version of every template
+type Set struct {
+	Version   string
+	templates map[string]*template.Template
+}
+
+func (s *Set) Render(name string, data any) (string, error) {
+	t, exists := s.templates[name]
+	if !exists {
+		return "", fmt.Errorf("prompt %s not found in version %s", name, s.Version)
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("rendering prompt %s of version %s: %w", name, s.Version, err)
+	}
+	return b.String(), nil
+}
+
+type share struct {
+	version string
+	percent int
+}
+
+// Library holds the loaded versions and how calls are split between them
+type Library struct {
+	sets    map[string]*Set
+	rollout []share
+}
+
+// Load reads the builtin versions, then the ones in dir if it is not empty
+func Load(dir string) (*Library, error) {
+	l := &Library{sets: make(map[string]*Set)}
+
+	templates, err := fs.Sub(builtin, "templates")
+	if err != nil {
+		return nil, err
+	}
+	if err := l.load(templates); err != nil {
+		return nil, fmt.Errorf("builtin prompts: %w", err)
+	}
+
+	if dir != "" {
+		if err := l.load(os.DirFS(dir)); err != nil {
+			return nil, fmt.Errorf("prompts in %s: %w", dir, err)
+		}
+	}
+
+	if _, exists := l.sets[DEFAULT_VERSION]; !exists {
+		return nil, fmt.Errorf("missing prompt version %s", DEFAULT_VERSION)
+	}
+	return l, nil
+}
+
+func (l *Library) load(fsys fs.FS) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		version := entry.Name()
+		set := &Set{Version: version, templates: make(map[string]*template.Template, len(Names))}
+		for _, name := range Names {
+			data, err := fs.ReadFile(fsys, version+"/"+name+".tmpl")
+			if err != nil {
+				return fmt.Errorf("version %s: %w", version, err)
+			}
+
+			text := strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+			t, err := template.New(name).Funcs(funcs).Parse(text)
+			if err != nil {
+				return fmt.Errorf("version %s: %w", version, err)
+			}
+			set.templates[name] = t
+		}
+		l.sets[version] = set
+	}
+
+	return nil
+}
+
+func (l *Library) Get(version string) (*Set, error) {
+	set, exists := l.sets[version]
+	if !exists {
+		return nil, fmt.Errorf("unknown prompt version %q, available: %s", version, strings.Join(l.Versions(), ", "))
+	}
+	return set, nil
+}
+
+func (l *Library) Versions() []string {
+	versions := make([]string, 0, len(l.sets))
+	for version := range l.sets {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// SetRollout parses "version:percent,..." with percents adding up to 100
+func (l *Library) SetRollout(spec string) error {
+	var rollout []share
+	total := 0
+
+	for _, part := range strings.Split(spec, ",") {
+		version, percentStr, found := strings.Cut(strings.TrimSpace(part), ":")
+		if !found {
+			return fmt.Errorf("invalid rollout %q, expected version:percent", part)
+		}
+
+		percent, err := strconv.Atoi(percentStr)
+		if err != nil || percent < 0 || percent > 100 {
+			return fmt.Errorf("invalid percent in rollout %q", part)
+		}
+		if _, err := l.Get(version); err != nil {
+			return err
+		}
+
+		rollout = append(rollout, share{version, percent})
+		total += percent
+	}
+
+	if total != 100 {
+		return fmt.Errorf("rollout percents add up to %d, not 100", total)
+	}
+
+	l.rollout = rollout
+	return nil
+}
+
+// Assign picks the version of a key, e.g. a submission, from a hash of it
+func (l *Library) Assign(key string) *Set {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	bucket := int(h.Sum32() % 100)
+
+	for _, s := range l.rollout {
+		if bucket < s.percent {
+			return l.sets[s.version]
+		}
+		bucket -= s.percent
+	}
+	return l.sets[DEFAULT_VERSION]
+}
+
+// *
+// **
+// ***
+// ****
+// ***** CONFIG
+var library *Library
+
+func init() {
+	var err error
+	if library, err = Load(""); err != nil {
+		panic(err)
+	}
+
+	env.RegisterValidator(validateVar)
+}
+
+func validateVar() error {
+	dir, _ := env.Get("PROMPTS_DIR")
+	l, err := Load(dir)
+	if err != nil {
+		return fmt.Errorf("invalid value for PROMPTS_DIR: %w", err)
+	}
+
+	if rollout, exists := env.Get("PROMPT_ROLLOUT"); exists {
+		if err := l.SetRollout(rollout); err != nil {
+			return fmt.Errorf("invalid value for PROMPT_ROLLOUT: %w", err)
+		}
+	}
+
+	library = l
+	return nil
+}
+
+// Assign picks the version of a key from the configured library
+func Assign(key string) *Set {
+	return library.Assign(key)
+}
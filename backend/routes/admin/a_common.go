@@ -0,0 +1,42 @@
+package admin
+
+import (
+	"net/http"
+	"slices"
+
+	"nodofinance/utils/jwt"
+	"nodofinance/utils/logger"
+
+	"go.uber.org/zap"
+)
+
+// Members of this Cognito group can use the admin routes
+const ADMIN_GROUP = "admin"
+
+// adminUsername returns the user of a request validated by the middleware, if it is an admin
+func adminUsername(r *http.Request) (string, bool) {
+	idTokenCookie, errIT := r.Cookie("nodo_id_token")
+	if errIT != nil {
+		return "", false
+	}
+
+	idClaims, err := jwt.GetTokenClaims(idTokenCookie.Value)
+	if err != nil {
+		logger.Log.Error("Failed to get token claims", zap.Error(err))
+		return "", false
+	}
+
+	username, exists := idClaims["cognito:username"].(string)
+	if !exists {
+		logger.Log.Error("Failed to get username from token claims")
+		return "", false
+	}
+
+	groups, _ := idClaims["cognito:groups"].([]any)
+	if !slices.Contains(groups, any(ADMIN_GROUP)) {
+		logger.Log.Warn("Admin route called by a non admin user", zap.String("username", username))
+		return "", false
+	}
+
+	return username, true
+}
@@ -0,0 +1,51 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"nodofinance/store"
+	"nodofinance/utils/logger"
+	"nodofinance/utils/sanitize"
+
+	"go.uber.org/zap"
+)
+
+// Res
+type PurgeExtractionsRes struct {
+	Deleted int `json:"deleted"`
+}
+
+// PurgeExtractions empties the extraction cache, or only the entries of ?prompt_version
+func PurgeExtractions(w http.ResponseWriter, r *http.Request, st store.Store) {
+	ctx := r.Context()
+
+	username, isAdmin := adminUsername(r)
+	if !isAdmin {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	promptVersion := sanitize.Trim(r.URL.Query().Get("prompt_version"), "")
+	if promptVersion != "" && !sanitize.PromptVersion(promptVersion) {
+		logger.Log.Error("Invalid prompt version", zap.String("prompt_version", promptVersion))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := st.PurgeExtractions(ctx, promptVersion)
+	if err != nil {
+		logger.Log.Error("Failed to purge extractions", zap.Error(err), zap.Int("deleted", deleted))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.Info("Admin purged extraction cache", zap.String("username", username), zap.String("prompt_version", promptVersion), zap.Int("deleted", deleted))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(PurgeExtractionsRes{Deleted: deleted}); err != nil {
+		logger.Log.Error("Error encoding response", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
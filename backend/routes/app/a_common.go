@@ -2,17 +2,15 @@ package app
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net/http"
+	"nodofinance/llm"
+	"nodofinance/store"
 	"nodofinance/utils/env"
 	"strconv"
-	"strings"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"time"
 )
 
 var (
@@ -23,6 +21,19 @@ var (
 	OUTPUT_RATE_SMALL float64
 	INPUT_RATE_BIG    float64
 	OUTPUT_RATE_BIG   float64
+	DRAFT_TTL         time.Duration
+	// Zero turns the extraction cache off
+	EXTRACTION_CACHE_TTL time.Duration
+	// Share of unmatched figures over which an analysis is regenerated, then rejected
+	ANALYST_MAX_ERROR_RATE float64
+	ANALYST_REGENERATIONS  int
+)
+
+const (
+	defaultDraftTTL             = 72 * time.Hour
+	defaultExtractionCacheTTL   = 30 * 24 * time.Hour
+	defaultAnalystMaxErrorRate  = 0.3
+	defaultAnalystRegenerations = 1
 )
 
 func init() {
@@ -102,9 +113,61 @@ func validateVar() error {
 	}
 	OUTPUT_RATE_BIG = parsedOutputRateBig
 
+	// Optional, e.g. "48h"
+	DRAFT_TTL = defaultDraftTTL
+	if draftTTLStr, exists := env.Get("DRAFT_TTL"); exists {
+		parsedDraftTTL, err := time.ParseDuration(draftTTLStr)
+		if err != nil || parsedDraftTTL <= 0 {
+			return fmt.Errorf("invalid value for DRAFT_TTL: %s", draftTTLStr)
+		}
+		DRAFT_TTL = parsedDraftTTL
+	}
+
+	// Optional, e.g. "168h" or "0" to turn the cache off
+	EXTRACTION_CACHE_TTL = defaultExtractionCacheTTL
+	if cacheTTLStr, exists := env.Get("EXTRACTION_CACHE_TTL"); exists {
+		parsedCacheTTL, err := time.ParseDuration(cacheTTLStr)
+		if err != nil || parsedCacheTTL < 0 {
+			return fmt.Errorf("invalid value for EXTRACTION_CACHE_TTL: %s", cacheTTLStr)
+		}
+		EXTRACTION_CACHE_TTL = parsedCacheTTL
+	}
+
+	// Optional, e.g. "0.2" or "1" to never reject
+	ANALYST_MAX_ERROR_RATE = defaultAnalystMaxErrorRate
+	if errorRateStr, exists := env.Get("ANALYST_MAX_ERROR_RATE"); exists {
+		parsedErrorRate, err := strconv.ParseFloat(errorRateStr, 64)
+		if err != nil || parsedErrorRate < 0 || parsedErrorRate > 1 {
+			return fmt.Errorf("invalid value for ANALYST_MAX_ERROR_RATE: %s", errorRateStr)
+		}
+		ANALYST_MAX_ERROR_RATE = parsedErrorRate
+	}
+
+	// Optional, 0 rejects without regenerating
+	ANALYST_REGENERATIONS = defaultAnalystRegenerations
+	if regenerationsStr, exists := env.Get("ANALYST_REGENERATIONS"); exists {
+		parsedRegenerations, err := strconv.Atoi(regenerationsStr)
+		if err != nil || parsedRegenerations < 0 || parsedRegenerations > 3 {
+			return fmt.Errorf("invalid value for ANALYST_REGENERATIONS: %s", regenerationsStr)
+		}
+		ANALYST_REGENERATIONS = parsedRegenerations
+	}
+
 	return nil
 }
 
+// modelRates are the input and output rates per million tokens of a model. Models without rates of
+// their own, e.g. in LLM_ENSEMBLE_SUBMITTERS, are billed at the highest ones.
+func modelRates(model string) (float64, float64) {
+	switch model {
+	case llm.MODEL_ANALYST_BIG:
+		return INPUT_RATE_BIG, OUTPUT_RATE_BIG
+	case llm.MODEL_CLEANER, llm.MODEL_SUBMITTER, llm.MODEL_ANALYST_SMALL:
+		return INPUT_RATE_SMALL, OUTPUT_RATE_SMALL
+	}
+	return max(INPUT_RATE_SMALL, INPUT_RATE_BIG), max(OUTPUT_RATE_SMALL, OUTPUT_RATE_BIG)
+}
+
 type LimitType int
 
 const (
@@ -119,84 +182,35 @@ type ConsumptionLimitResult struct {
 	LimitReached LimitType
 }
 
-func checkConsumptionLimits(ctx context.Context, d *dynamodb.Client, username, ticker string) (ConsumptionLimitResult, error) {
+func checkConsumptionLimits(ctx context.Context, st store.Store, username, ticker string) (ConsumptionLimitResult, error) {
 	// Check periods count for this ticker
-	queryPeriodsInput := &dynamodb.QueryInput{
-		TableName:              aws.String("nodofinance_table"),
-		KeyConditionExpression: aws.String("#pk = :username AND begins_with(#sk, :finance_prefix)"),
-		ExpressionAttributeNames: map[string]string{
-			"#pk": "username",
-			"#sk": "composite_sk",
-		},
-		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
-			":username":       &dynamoTypes.AttributeValueMemberS{Value: username},
-			":finance_prefix": &dynamoTypes.AttributeValueMemberS{Value: fmt.Sprintf("FINANCE#%s#", ticker)},
-		},
-		Select: dynamoTypes.SelectCount,
-	}
-
-	periodsResult, err := d.Query(ctx, queryPeriodsInput)
+	periodsCount, err := st.CountFinancePeriods(ctx, username, ticker)
 	if err != nil {
 		return ConsumptionLimitResult{}, fmt.Errorf("checking periods count: %w", err)
 	}
 
-	periodsCount := int(periodsResult.Count)
 	if periodsCount >= int(MAX_PERIODS) {
 		return ConsumptionLimitResult{false, LimitTypePeriods}, nil
 	}
 
 	// If no records found for this ticker, check total unique tickers
 	if periodsCount == 0 {
-		queryTickersInput := &dynamodb.QueryInput{
-			TableName:              aws.String("nodofinance_table"),
-			KeyConditionExpression: aws.String("#pk = :username AND begins_with(#sk, :ticker_prefix)"),
-			ExpressionAttributeNames: map[string]string{
-				"#pk": "username",
-				"#sk": "composite_sk",
-			},
-			ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
-				":username":      &dynamoTypes.AttributeValueMemberS{Value: username},
-				":ticker_prefix": &dynamoTypes.AttributeValueMemberS{Value: "TICKER#"},
-			},
-			Select: dynamoTypes.SelectCount,
-		}
-
-		tickersResult, err := d.Query(ctx, queryTickersInput)
+		tickersCount, err := st.CountTickers(ctx, username)
 		if err != nil {
 			return ConsumptionLimitResult{}, fmt.Errorf("checking tickers count: %w", err)
 		}
 
-		tickersCount := int(tickersResult.Count)
 		if tickersCount >= int(MAX_TICKERS) {
 			return ConsumptionLimitResult{false, LimitTypeTickers}, nil
 		}
 	}
 
-	getUserInput := &dynamodb.GetItemInput{
-		TableName: aws.String("nodofinance_table"),
-		Key: map[string]dynamoTypes.AttributeValue{
-			"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-			"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: "METADATA"},
-		},
-		ProjectionExpression: aws.String("ctokens"),
-	}
-
-	result, err := d.GetItem(ctx, getUserInput)
-	if err != nil {
-		return ConsumptionLimitResult{}, fmt.Errorf("checking token consumption: %w", err)
-	}
-
-	if result.Item == nil {
+	user, err := st.GetMetadata(ctx, username)
+	if errors.Is(err, store.ErrNotFound) {
 		return ConsumptionLimitResult{false, LimitTypeTokens}, nil
 	}
-
-	var user struct {
-		CTokens *int64 `dynamodbav:"ctokens,omitempty"`
-	}
-
-	err = attributevalue.UnmarshalMap(result.Item, &user)
 	if err != nil {
-		return ConsumptionLimitResult{}, fmt.Errorf("unmarshaling user data: %w", err)
+		return ConsumptionLimitResult{}, fmt.Errorf("checking token consumption: %w", err)
 	}
 
 	if user.CTokens == nil || *user.CTokens >= int64(MAX_TOKENS) {
@@ -206,167 +220,23 @@ func checkConsumptionLimits(ctx context.Context, d *dynamodb.Client, username, t
 	return ConsumptionLimitResult{true, LimitTypeNone}, nil
 }
 
-func getPeriodOrder(period string) (int, error) {
-	switch period {
-	case "Y":
-		return 1, nil
-	case "S2":
-		return 2, nil
-	case "S1":
-		return 3, nil
-	case "Q4":
-		return 4, nil
-	case "Q3":
-		return 5, nil
-	case "Q2":
-		return 6, nil
-	case "Q1":
-		return 7, nil
-	default:
-		return 0, fmt.Errorf("invalid period: %s", period)
-	}
-}
-
-// Convert period order back to period string
-func getPeriodFromOrder(order int) string {
-	switch order {
-	case 1:
-		return "Y"
-	case 2:
-		return "S2"
-	case 3:
-		return "S1"
-	case 4:
-		return "Q4"
-	case 5:
-		return "Q3"
-	case 6:
-		return "Q2"
-	case 7:
-		return "Q1"
-	default:
-		return ""
-	}
-}
-
-func buildFinanceSortKey(ticker string, year int, period string) (string, error) {
-	reverseYear := 9999 - year
-
-	periodOrder, err := getPeriodOrder(period)
-	if err != nil {
-		return "", err
-	}
-
-	return fmt.Sprintf("FINANCE#%s#%04d#%02d", ticker, reverseYear, periodOrder), nil
-}
-
-// Helper function to extract float values from DynamoDB attributes
-func getDynamoDBFloatValue(item map[string]dynamoTypes.AttributeValue, key string) *float64 {
-	if item == nil {
-		return nil
-	}
-	if attr, exists := item[key]; exists {
-		if n, ok := attr.(*dynamoTypes.AttributeValueMemberN); ok {
-			if val, err := strconv.ParseFloat(n.Value, 64); err == nil {
-				return &val
-			}
-		}
-	}
-	return nil
-}
-
-// Helper function to format *int64 for DynamoDB
-func formatInt64Ptr(val *int64) string {
-	if val == nil {
-		return ""
-	}
-	return strconv.FormatInt(*val, 10)
-}
-
-// Helper function to format *float64 for DynamoDB
-func formatFloat64Ptr(val *float64) string {
-	if val == nil {
-		return ""
-	}
-	return fmt.Sprintf("%.4f", *val)
-}
-
-// Extract year and period from FINANCE sort key
-func extractFromFinanceSK(sortKey string) (int, string, error) {
-	// FINANCE#AAPL#7977#04 -> year=2022, period=Q4
-	parts := strings.Split(sortKey, "#")
-	if len(parts) != 4 {
-		return 0, "", fmt.Errorf("invalid finance sort key format")
-	}
-
-	reverseYear, err := strconv.Atoi(parts[2])
-	if err != nil {
-		return 0, "", fmt.Errorf("invalid reverse year: %w", err)
-	}
-
-	periodOrder, err := strconv.Atoi(parts[3])
-	if err != nil {
-		return 0, "", fmt.Errorf("invalid period order: %w", err)
-	}
-
-	year := 9999 - reverseYear
-	period := getPeriodFromOrder(periodOrder)
-
-	return year, period, nil
-}
-
-// Encode LastEvaluatedKey as base64 cursor
-func encodeCursor(lastEvaluatedKey map[string]dynamoTypes.AttributeValue) string {
-	// Convert to simple map for JSON encoding
-	cursorData := make(map[string]interface{})
-
-	for key, value := range lastEvaluatedKey {
-		switch v := value.(type) {
-		case *dynamoTypes.AttributeValueMemberS:
-			cursorData[key] = map[string]string{"S": v.Value}
-		case *dynamoTypes.AttributeValueMemberN:
-			cursorData[key] = map[string]string{"N": v.Value}
-		}
-	}
-
-	jsonData, err := json.Marshal(cursorData)
-	if err != nil {
-		return ""
-	}
-
-	return base64.URLEncoding.EncodeToString(jsonData)
+// writeBusy answers 429 when the LLM calls waiting are too many
+func writeBusy(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "Too many requests in progress, try again later", http.StatusTooManyRequests)
 }
 
-// Parse base64 cursor back to ExclusiveStartKey
-func parseCursor(cursor string) (map[string]dynamoTypes.AttributeValue, error) {
-	jsonData, err := base64.URLEncoding.DecodeString(cursor)
+// Split a "YYYY-P" period into year and period type
+func splitPeriod(fullPeriod string) (int, string, error) {
+	year, err := strconv.Atoi(fullPeriod[:4])
 	if err != nil {
-		return nil, fmt.Errorf("invalid cursor encoding: %w", err)
-	}
-
-	var cursorData map[string]any
-	if err := json.Unmarshal(jsonData, &cursorData); err != nil {
-		return nil, fmt.Errorf("invalid cursor format: %w", err)
+		return 0, "", err
 	}
 
-	exclusiveStartKey := make(map[string]dynamoTypes.AttributeValue)
-
-	for key, value := range cursorData {
-		valueMap, ok := value.(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		if s, exists := valueMap["S"]; exists {
-			if str, ok := s.(string); ok {
-				exclusiveStartKey[key] = &dynamoTypes.AttributeValueMemberS{Value: str}
-			}
-		} else if n, exists := valueMap["N"]; exists {
-			if str, ok := n.(string); ok {
-				exclusiveStartKey[key] = &dynamoTypes.AttributeValueMemberN{Value: str}
-			}
-		}
+	periodType := fullPeriod[5:]
+	if _, err := store.PeriodOrder(periodType); err != nil {
+		return 0, "", err
 	}
 
-	return exclusiveStartKey, nil
+	return year, periodType, nil
 }
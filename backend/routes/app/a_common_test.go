@@ -0,0 +1,229 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"nodofinance/store"
+	"nodofinance/utils/logger"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+const testUser = "test-user"
+
+// setLimits sets the consumption limits for the test
+func setLimits(t *testing.T, tokens, periods, tickers int64) {
+	t.Helper()
+
+	saved := []int64{MAX_TOKENS, MAX_PERIODS, MAX_TICKERS}
+	t.Cleanup(func() {
+		MAX_TOKENS, MAX_PERIODS, MAX_TICKERS = saved[0], saved[1], saved[2]
+	})
+	MAX_TOKENS, MAX_PERIODS, MAX_TICKERS = tokens, periods, tickers
+}
+
+// newMemoryStore is a store with the METADATA of testUser, ctokens consumed
+func newMemoryStore(t *testing.T, ctokens int64) *store.Memory {
+	t.Helper()
+
+	st := store.NewMemory()
+	if err := st.PutMetadata(context.Background(), store.Metadata{Username: testUser, CTokens: &ctokens}); err != nil {
+		t.Fatalf("PutMetadata() = %v", err)
+	}
+	return st
+}
+
+// putPeriod stores a period of ticker with its revenue
+func putPeriod(t *testing.T, st store.Store, ticker string, year int, period string, revenue int64) {
+	t.Helper()
+
+	f := store.Finance{Ticker: ticker, Year: year, Period: period, Revenue: &revenue}
+	if err := st.PutFinancePeriod(context.Background(), testUser, f, "USD", 0); err != nil {
+		t.Fatalf("PutFinancePeriod() = %v", err)
+	}
+}
+
+// deref is the value of an optional field, or nil, for messages
+func deref[T any](value *T) any {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
+
+// newRequest is a request of username with its id token cookie. The claims are not verified, the token is unsigned.
+func newRequest(t *testing.T, method, target, body, username string) *http.Request {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"cognito:username": username}).
+		SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("SignedString() = %v", err)
+	}
+
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.AddCookie(&http.Cookie{Name: "nodo_id_token", Value: token})
+	return r
+}
+
+// fakeS3 keeps the objects put to it and serves them back, for the S3 client of newS3Client
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		f.objects[r.URL.Path] = body
+	case http.MethodGet:
+		body, exists := f.objects[r.URL.Path]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// object returns the body put under key of S3_OUTPUTS_BUCKET
+func (f *fakeS3) object(key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, exists := f.objects["/"+S3_OUTPUTS_BUCKET+"/"+key]
+	return body, exists
+}
+
+// newS3Client is a client of a fakeS3 server, closed with the test
+func newS3Client(t *testing.T) (*s3.Client, *fakeS3) {
+	t.Helper()
+
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	return client, fake
+}
+
+func TestCheckConsumptionLimits(t *testing.T) {
+	setLimits(t, 1000, 2, 2)
+
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T) store.Store
+		ticker string
+		want   ConsumptionLimitResult
+	}{
+		{
+			name:   "new ticker with room",
+			setup:  func(t *testing.T) store.Store { return newMemoryStore(t, 0) },
+			ticker: "AAPL",
+			want:   ConsumptionLimitResult{true, LimitTypeNone},
+		},
+		{
+			name: "periods of the ticker used up",
+			setup: func(t *testing.T) store.Store {
+				st := newMemoryStore(t, 0)
+				putPeriod(t, st, "AAPL", 2023, "Y", 100)
+				putPeriod(t, st, "AAPL", 2024, "Y", 110)
+				return st
+			},
+			ticker: "AAPL",
+			want:   ConsumptionLimitResult{false, LimitTypePeriods},
+		},
+		{
+			name: "tickers used up, new ticker",
+			setup: func(t *testing.T) store.Store {
+				st := newMemoryStore(t, 0)
+				putPeriod(t, st, "AAPL", 2024, "Y", 100)
+				putPeriod(t, st, "MSFT", 2024, "Y", 100)
+				return st
+			},
+			ticker: "GOOG",
+			want:   ConsumptionLimitResult{false, LimitTypeTickers},
+		},
+		{
+			name: "tickers used up, stored ticker",
+			setup: func(t *testing.T) store.Store {
+				st := newMemoryStore(t, 0)
+				putPeriod(t, st, "AAPL", 2024, "Y", 100)
+				putPeriod(t, st, "MSFT", 2024, "Y", 100)
+				return st
+			},
+			ticker: "MSFT",
+			want:   ConsumptionLimitResult{true, LimitTypeNone},
+		},
+		{
+			name:   "tokens under the limit",
+			setup:  func(t *testing.T) store.Store { return newMemoryStore(t, 999) },
+			ticker: "AAPL",
+			want:   ConsumptionLimitResult{true, LimitTypeNone},
+		},
+		{
+			name:   "tokens at the limit",
+			setup:  func(t *testing.T) store.Store { return newMemoryStore(t, 1000) },
+			ticker: "AAPL",
+			want:   ConsumptionLimitResult{false, LimitTypeTokens},
+		},
+		{
+			name: "tokens never counted",
+			setup: func(t *testing.T) store.Store {
+				st := store.NewMemory()
+				st.PutMetadata(context.Background(), store.Metadata{Username: testUser})
+				return st
+			},
+			ticker: "AAPL",
+			want:   ConsumptionLimitResult{false, LimitTypeTokens},
+		},
+		{
+			name:   "user without metadata",
+			setup:  func(t *testing.T) store.Store { return store.NewMemory() },
+			ticker: "AAPL",
+			want:   ConsumptionLimitResult{false, LimitTypeTokens},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkConsumptionLimits(context.Background(), tt.setup(t), testUser, tt.ticker)
+			if err != nil {
+				t.Fatalf("checkConsumptionLimits() = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("checkConsumptionLimits() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"nodofinance/store"
+	"nodofinance/utils/jwt"
+	"nodofinance/utils/logger"
+	"nodofinance/utils/sanitize"
+
+	"go.uber.org/zap"
+)
+
+/*
+	History of the analyst reports of a ticker, every /api/app/analyst call keeps one (see store.Analysis).
+	Reports are compared by section: the text under each markdown heading, matched by heading.
+*/
+
+const ANALYSES_PAGE_SIZE = 10
+
+// Leading numbering of a heading, e.g. "2." or "A.", left out when matching sections
+var headingNumbering = regexp.MustCompile(`^([0-9]+|[A-Za-z])[.)]\s+`)
+
+// Res
+type AnalysisSummary struct {
+	ID            string          `json:"id"`
+	CreatedAt     int64           `json:"created_at"`
+	Model         string          `json:"model"`
+	PromptVersion string          `json:"prompt_version"`
+	Tokens        int64           `json:"tokens"`
+	FinancesHash  string          `json:"finances_hash"`
+	Insights      *store.Insights `json:"insights,omitempty"`
+}
+
+type AnalysesRes struct {
+	Analyses []AnalysisSummary `json:"analyses"`
+	Cursor   string            `json:"cursor,omitempty"`
+}
+
+type AnalysisRes struct {
+	AnalysisSummary
+	Content      string              `json:"content"`
+	Verification *store.Verification `json:"verification,omitempty"`
+}
+
+const (
+	SECTION_ADDED     = "added"
+	SECTION_REMOVED   = "removed"
+	SECTION_CHANGED   = "changed"
+	SECTION_UNCHANGED = "unchanged"
+)
+
+type SectionDiff struct {
+	Heading string `json:"heading"`
+	Status  string `json:"status"`
+	// Text of the section in each version, left out when unchanged
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+type AnalysisDiffRes struct {
+	From AnalysisSummary `json:"from"`
+	To   AnalysisSummary `json:"to"`
+	// SameFinances tells if both reports were written from the same data
+	SameFinances bool          `json:"same_finances"`
+	Sections     []SectionDiff `json:"sections"`
+}
+
+// Analyses lists the analyses of a ticker, newest first, without their content
+func Analyses(w http.ResponseWriter, r *http.Request, st store.Store) {
+	ctx := r.Context()
+
+	username, ticker, ok := analysisParams(w, r)
+	if !ok {
+		return
+	}
+
+	cursor := sanitize.Trim(r.URL.Query().Get("cursor"), "")
+	if cursor != "" && !sanitize.Cursor(cursor) {
+		logger.Log.Error("Invalid cursor")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	analyses, nextCursor, err := st.PageAnalyses(ctx, username, ticker, cursor, ANALYSES_PAGE_SIZE)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		logger.Log.Warn("Invalid cursor", zap.Error(err), zap.String("username", username))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.Log.Error("Failed to page analyses", zap.Error(err), zap.String("username", username), zap.String("ticker", ticker))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := AnalysesRes{Analyses: make([]AnalysisSummary, 0, len(analyses)), Cursor: nextCursor}
+	for _, a := range analyses {
+		response.Analyses = append(response.Analyses, summarizeAnalysis(a))
+	}
+
+	writeAnalysisJSON(w, response)
+}
+
+// GetAnalysis returns one analysis of the history
+func GetAnalysis(w http.ResponseWriter, r *http.Request, st store.Store) {
+	username, ticker, ok := analysisParams(w, r)
+	if !ok {
+		return
+	}
+
+	a, ok := getAnalysisParam(w, r, st, username, ticker, "id")
+	if !ok {
+		return
+	}
+
+	writeAnalysisJSON(w, AnalysisRes{AnalysisSummary: summarizeAnalysis(a), Content: a.Content, Verification: a.Verification})
+}
+
+// DiffAnalyses compares two analyses of a ticker section by section
+func DiffAnalyses(w http.ResponseWriter, r *http.Request, st store.Store) {
+	username, ticker, ok := analysisParams(w, r)
+	if !ok {
+		return
+	}
+
+	from, ok := getAnalysisParam(w, r, st, username, ticker, "from")
+	if !ok {
+		return
+	}
+
+	to, ok := getAnalysisParam(w, r, st, username, ticker, "to")
+	if !ok {
+		return
+	}
+
+	writeAnalysisJSON(w, AnalysisDiffRes{
+		From:         summarizeAnalysis(from),
+		To:           summarizeAnalysis(to),
+		SameFinances: from.FinancesHash == to.FinancesHash,
+		Sections:     diffSections(from.Content, to.Content),
+	})
+}
+
+// analysisParams reads the user and the ticker, writing the error if they are missing or invalid
+func analysisParams(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	ticker := sanitize.Trim(r.URL.Query().Get("ticker"), "u")
+	if !sanitize.Ticker(ticker) {
+		logger.Log.Error("Invalid ticker", zap.String("ticker", ticker))
+		w.WriteHeader(http.StatusBadRequest)
+		return "", "", false
+	}
+
+	idTokenCookie, errIT := r.Cookie("nodo_id_token")
+	if errIT != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return "", "", false
+	}
+
+	idClaims, err := jwt.GetTokenClaims(idTokenCookie.Value)
+	if err != nil {
+		logger.Log.Error("Failed to get token claims", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return "", "", false
+	}
+
+	username, exists := idClaims["cognito:username"].(string)
+	if !exists {
+		logger.Log.Error("Failed to get username from token claims")
+		w.WriteHeader(http.StatusUnauthorized)
+		return "", "", false
+	}
+
+	return username, ticker, true
+}
+
+func getAnalysisParam(w http.ResponseWriter, r *http.Request, st store.Store, username, ticker, param string) (store.Analysis, bool) {
+	id := sanitize.Trim(r.URL.Query().Get(param), "")
+	if !sanitize.AnalysisID(id) {
+		logger.Log.Error("Invalid analysis id", zap.String("param", param), zap.String("id", id))
+		w.WriteHeader(http.StatusBadRequest)
+		return store.Analysis{}, false
+	}
+
+	a, err := st.GetAnalysis(r.Context(), username, ticker, id)
+	if errors.Is(err, store.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return store.Analysis{}, false
+	}
+	if err != nil {
+		logger.Log.Error("Failed to get analysis", zap.Error(err), zap.String("username", username), zap.String("ticker", ticker))
+		w.WriteHeader(http.StatusInternalServerError)
+		return store.Analysis{}, false
+	}
+
+	return a, true
+}
+
+func summarizeAnalysis(a store.Analysis) AnalysisSummary {
+	return AnalysisSummary{
+		ID:            a.ID,
+		CreatedAt:     a.CreatedAt,
+		Model:         a.Model,
+		PromptVersion: a.PromptVersion,
+		Tokens:        a.Tokens,
+		FinancesHash:  a.FinancesHash,
+		Insights:      a.Insights,
+	}
+}
+
+func writeAnalysisJSON(w http.ResponseWriter, response any) {
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		logger.Log.Error("Failed to marshal response", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+type section struct {
+	heading string
+	key     string
+	text    string
+}
+
+// splitSections cuts a markdown report at its headings. Text before the first one is a section
+// without heading.
+func splitSections(content string) []section {
+	var sections []section
+	current := section{}
+
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			if current.heading != "" || strings.TrimSpace(current.text) != "" {
+				sections = append(sections, current)
+			}
+			heading := strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+			current = section{heading: heading, key: sectionKey(heading)}
+			continue
+		}
+		current.text += line + "\n"
+	}
+	if current.heading != "" || strings.TrimSpace(current.text) != "" {
+		sections = append(sections, current)
+	}
+
+	for i := range sections {
+		sections[i].text = strings.TrimSpace(sections[i].text)
+	}
+	return sections
+}
+
+// sectionKey matches headings across versions: without numbering, emphasis or case
+func sectionKey(heading string) string {
+	key := strings.Trim(heading, "*_ ")
+	key = headingNumbering.ReplaceAllString(key, "")
+	return strings.ToLower(strings.Join(strings.Fields(strings.Trim(key, "*_:")), " "))
+}
+
+// diffSections lists the sections of to in order, then the ones only from has. Repeated headings
+// are matched in order.
+func diffSections(from, to string) []SectionDiff {
+	fromSections := splitSections(from)
+	used := make([]bool, len(fromSections))
+
+	diffs := []SectionDiff{}
+	for _, s := range splitSections(to) {
+		match := -1
+		for i, f := range fromSections {
+			if !used[i] && f.key == s.key {
+				match = i
+				break
+			}
+		}
+
+		if match == -1 {
+			diffs = append(diffs, SectionDiff{Heading: s.heading, Status: SECTION_ADDED, To: s.text})
+			continue
+		}
+
+		used[match] = true
+		if fromSections[match].text == s.text {
+			diffs = append(diffs, SectionDiff{Heading: s.heading, Status: SECTION_UNCHANGED})
+			continue
+		}
+		diffs = append(diffs, SectionDiff{Heading: s.heading, Status: SECTION_CHANGED, From: fromSections[match].text, To: s.text})
+	}
+
+	for i, f := range fromSections {
+		if !used[i] {
+			diffs = append(diffs, SectionDiff{Heading: f.heading, Status: SECTION_REMOVED, From: f.text})
+		}
+	}
+
+	return diffs
+}
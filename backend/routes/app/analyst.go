@@ -2,26 +2,23 @@ package app
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math"
 	"net/http"
-	"strconv"
-
-	"strings"
+	"time"
 
+	"nodofinance/llm"
+	"nodofinance/prompts"
+	"nodofinance/store"
 	"nodofinance/utils/jwt"
 	"nodofinance/utils/logger"
 	"nodofinance/utils/sanitize"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
-	"github.com/openai/openai-go"
-
 	"go.uber.org/zap"
 )
 
@@ -31,37 +28,190 @@ type AnalystReq struct {
 }
 
 type AnalystRes struct {
-	AnalystMessage string `json:"analyst_message"`
+	AnalystMessage string          `json:"analyst_message"`
+	Insights       *store.Insights `json:"insights,omitempty"`
+	// AnalysisID is the version of the report in the history
+	AnalysisID string `json:"analysis_id"`
+	// Verification of the figures of the report against the finances
+	Verification *store.Verification `json:"verification,omitempty"`
+}
+
+// analysisRequest is what the analyst prompt is built from
+type analysisRequest struct {
+	username  string
+	ticker    string
+	currency  string
+	finances  string
+	rows      int
+	templates *prompts.Set
 }
 
-func Analyst(w http.ResponseWriter, r *http.Request, d *dynamodb.Client, ai openai.Client) {
+var errAnalysisTicker = errors.New("ticker record not found")
+
+func Analyst(w http.ResponseWriter, r *http.Request, st store.Store, ai llm.Provider) {
+	ctx := r.Context()
+
+	req, ok := prepareAnalysis(w, r, st, nil)
+	if !ok {
+		return
+	}
+
+	// the user waits for the analysis, it goes before queued submissions
+	openAIResponse, err := generateAnalysis(llm.WithPriority(ctx, llm.PriorityInteractive), ai, req, nil, nil)
+	if err != nil {
+		billFailedAnalysis(ctx, st, req, openAIResponse)
+		writeAnalystError(w, req, err)
+		return
+	}
+
+	analysis, err := saveAnalysis(ctx, st, req, openAIResponse)
+	if err != nil {
+		logger.Log.Error("Failed to save analysis", zap.Error(err), zap.String("username", req.username), zap.String("ticker", req.ticker))
+		if errors.Is(err, errAnalysisTicker) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := AnalystRes{
+		AnalystMessage: analysis.Content,
+		Insights:       analysis.Insights,
+		AnalysisID:     analysis.ID,
+		Verification:   analysis.Verification,
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		logger.Log.Error("Failed to marshal response", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// AnalystStream is Analyst relaying the completion as server-sent events: "delta" events with the
+// text as it comes, then "done" with the AnalystRes or "error". A report that fails verification is
+// followed by a "retry" event with its verification and the deltas of the next one. The analysis is
+// only saved once the completion ends, a client that disconnects cancels the call. The tokens of a
+// failed or rejected analysis are billed anyway.
+func AnalystStream(w http.ResponseWriter, r *http.Request, st store.Store, ai llm.Provider) {
+	ctx := r.Context()
+
+	req, ok := prepareAnalysis(w, r, st, nil)
+	if !ok {
+		return
+	}
+
+	// the stream outlives the server WriteTimeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		logger.Log.Error("Failed to clear write deadline", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeEvent := func(event string, payload any) error {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	// headers go with the first delta, errors before it keep their status code
+	started := false
+	onDelta := func(delta string) error {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Connection", "keep-alive")
+			w.WriteHeader(http.StatusOK)
+		}
+		return writeEvent("delta", map[string]string{"content": delta})
+	}
+
+	// the client drops the text it got so far
+	onRetry := func(verification store.Verification) {
+		if started {
+			writeEvent("retry", verification)
+		}
+	}
+
+	openAIResponse, err := generateAnalysis(llm.WithPriority(ctx, llm.PriorityInteractive), ai, req, onDelta, onRetry)
+	if err != nil {
+		// the client may be gone, the tokens were spent anyway
+		billFailedAnalysis(context.WithoutCancel(ctx), st, req, openAIResponse)
+	}
+	if ctx.Err() != nil {
+		logger.Log.Info("Analyst stream cancelled by client", zap.String("username", req.username), zap.String("ticker", req.ticker))
+		return
+	}
+	if err != nil && !started {
+		writeAnalystError(w, req, err)
+		return
+	}
+	if errors.Is(err, errAnalysisRejected) {
+		writeEvent("error", map[string]any{"error": errAnalysisRejectedMessage, "verification": openAIResponse.Verification})
+		return
+	}
+	if err != nil {
+		logger.Log.Error("Analyst stream failed", zap.Error(err), zap.String("username", req.username), zap.String("ticker", req.ticker))
+		writeEvent("error", map[string]string{"error": "analysis failed, try again"})
+		return
+	}
+
+	analysis, err := saveAnalysis(ctx, st, req, openAIResponse)
+	if err != nil {
+		logger.Log.Error("Failed to save analysis", zap.Error(err), zap.String("username", req.username), zap.String("ticker", req.ticker))
+		writeEvent("error", map[string]string{"error": "analysis could not be saved, try again"})
+		return
+	}
+
+	if err := writeEvent("done", AnalystRes{AnalystMessage: analysis.Content, Insights: analysis.Insights, AnalysisID: analysis.ID, Verification: analysis.Verification}); err != nil {
+		logger.Log.Warn("Failed to write analyst done event", zap.Error(err), zap.String("username", req.username))
+	}
+}
+
+// prepareAnalysis reads and checks the request, the user and their finances. It writes the error and
+// returns false if the analysis can't go on. extra, if not nil, is decoded from the body too, for
+// requests with more fields than AnalystReq.
+func prepareAnalysis(w http.ResponseWriter, r *http.Request, st store.Store, extra any) (analysisRequest, bool) {
 	ctx := r.Context()
 
 	idTokenCookie, errIT := r.Cookie("nodo_id_token")
 	if errIT != nil {
 		w.WriteHeader(http.StatusUnauthorized)
-		return
+		return analysisRequest{}, false
 	}
 
 	idClaims, err := jwt.GetTokenClaims(idTokenCookie.Value)
 	if err != nil {
 		logger.Log.Error("Failed to get token claims", zap.Error(err))
 		w.WriteHeader(http.StatusUnauthorized)
-		return
+		return analysisRequest{}, false
 	}
 
 	username, exists := idClaims["cognito:username"].(string)
 	if !exists {
 		logger.Log.Error("Failed to get username from token claims")
 		w.WriteHeader(http.StatusUnauthorized)
-		return
+		return analysisRequest{}, false
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		logger.Log.Error("Failed to read request body", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return analysisRequest{}, false
 	}
 	defer r.Body.Close()
 
@@ -69,7 +219,15 @@ func Analyst(w http.ResponseWriter, r *http.Request, d *dynamodb.Client, ai open
 	if err := json.Unmarshal(body, &req); err != nil {
 		logger.Log.Error("Failed to unmarshal request body", zap.Error(err))
 		w.WriteHeader(http.StatusBadRequest)
-		return
+		return analysisRequest{}, false
+	}
+
+	if extra != nil {
+		if err := json.Unmarshal(body, extra); err != nil {
+			logger.Log.Error("Failed to unmarshal request body", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return analysisRequest{}, false
+		}
 	}
 
 	ticker := req.Ticker
@@ -81,46 +239,27 @@ func Analyst(w http.ResponseWriter, r *http.Request, d *dynamodb.Client, ai open
 	if !sanitize.Ticker(ticker) || !sanitize.Currency(currency) {
 		logger.Log.Error("Invalid ticker or currency", zap.String("ticker", ticker), zap.String("currency", currency))
 		w.WriteHeader(http.StatusBadRequest)
-		return
+		return analysisRequest{}, false
 	}
 
 	// Get user tokens
-	getUserResult, err := d.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String("nodofinance_table"),
-		Key: map[string]dynamoTypes.AttributeValue{
-			"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-			"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: "METADATA"},
-		},
-		ProjectionExpression: aws.String("ctokens"),
-	})
-
-	if err != nil {
-		logger.Log.Error("Failed to get user metadata", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	if getUserResult.Item == nil {
+	userMetadata, err := st.GetMetadata(ctx, username)
+	if errors.Is(err, store.ErrNotFound) {
 		logger.Log.Error("No rows found for user", zap.String("username", username))
 		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-
-	var userMetadata struct {
-		CTokens *int64 `dynamodbav:"ctokens,omitempty"`
+		return analysisRequest{}, false
 	}
 
-	err = attributevalue.UnmarshalMap(getUserResult.Item, &userMetadata)
 	if err != nil {
-		logger.Log.Error("Failed to unmarshal user metadata", zap.Error(err))
+		logger.Log.Error("Failed to get user metadata", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return analysisRequest{}, false
 	}
 
 	if userMetadata.CTokens == nil {
 		logger.Log.Warn("CTokens is nil for user", zap.String("username", username))
 		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return analysisRequest{}, false
 	}
 
 	// Check token limit (identical logic)
@@ -132,131 +271,117 @@ func Analyst(w http.ResponseWriter, r *http.Request, d *dynamodb.Client, ai open
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		w.WriteHeader(http.StatusForbidden)
 		w.Write([]byte(limitMessage))
-		return
+		return analysisRequest{}, false
 	}
 
-	mergedFinances, rowsCount, err := GetFinances(ctx, d, username, ticker)
+	mergedFinances, rowsCount, err := GetFinances(ctx, st, username, ticker)
+	if errors.Is(err, store.ErrNotFound) {
+		logger.Log.Error("No finances found", zap.String("username", username), zap.String("ticker", ticker))
+		w.WriteHeader(http.StatusNotFound)
+		return analysisRequest{}, false
+	}
 	if err != nil {
 		logger.Log.Error("Failed to get finances", zap.Error(err), zap.String("username", username), zap.String("ticker", ticker))
 		w.WriteHeader(http.StatusInternalServerError)
+		return analysisRequest{}, false
+	}
+
+	templates := prompts.Assign(username + "#" + ticker)
+
+	return analysisRequest{
+		username:  username,
+		ticker:    ticker,
+		currency:  currency,
+		finances:  mergedFinances,
+		rows:      rowsCount,
+		templates: templates,
+	}, true
+}
+
+func writeAnalystError(w http.ResponseWriter, req analysisRequest, err error) {
+	if llm.Classify(err) == llm.KindUnavailable {
+		logger.Log.Warn("LLM provider unavailable", zap.String("username", req.username), zap.String("ticker", req.ticker))
+		http.Error(w, llm.ErrUnavailable.Error(), http.StatusServiceUnavailable)
 		return
 	}
 
-	if mergedFinances == "" {
-		logger.Log.Error("No finances found", zap.String("username", username), zap.String("ticker", ticker))
-		w.WriteHeader(http.StatusNotFound)
+	var busyErr *llm.BusyError
+	if errors.As(err, &busyErr) {
+		logger.Log.Warn("LLM calls saturated", zap.String("username", req.username), zap.String("ticker", req.ticker), zap.Duration("retry_after", busyErr.RetryAfter))
+		writeBusy(w, busyErr.RetryAfter)
 		return
 	}
 
-	openAIResponse, err := callOpenAI(ctx, ai, ticker, mergedFinances, currency, rowsCount)
-	if openAIResponse == (OpenAIResponse{}) {
-		logger.Log.Error("Failed to call OpenAI", zap.String("username", username), zap.String("ticker", ticker))
-		w.WriteHeader(http.StatusInternalServerError)
+	if errors.Is(err, errAnalysisRejected) {
+		http.Error(w, errAnalysisRejectedMessage, http.StatusBadGateway)
 		return
 	}
 
-	if err != nil {
-		logger.Log.Error("Failed to call OpenAI", zap.Error(err), zap.String("username", username), zap.String("ticker", ticker))
-		w.WriteHeader(http.StatusInternalServerError)
+	logger.Log.Error("Failed to call OpenAI", zap.Error(err), zap.String("username", req.username), zap.String("ticker", req.ticker))
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+// billFailedAnalysis bills the tokens of an analysis that failed or was rejected, otherwise every
+// "try again" would be a free regeneration. Failures are only logged.
+func billFailedAnalysis(ctx context.Context, st store.Store, req analysisRequest, openAIResponse OpenAIResponse) {
+	tokens := analystTokens(req.rows > 2, openAIResponse.PromptTokens, openAIResponse.CompletionTokens)
+	if tokens == 0 {
 		return
 	}
+	if err := st.AddTokens(ctx, req.username, tokens); err != nil {
+		logger.Log.Error("Failed to bill failed analysis", zap.Error(err), zap.String("username", req.username), zap.String("ticker", req.ticker), zap.Int64("tokens", tokens))
+	}
+}
 
+// saveAnalysis stores the completion, truncated, on the ticker and in its history, and bills it
+func saveAnalysis(ctx context.Context, st store.Store, req analysisRequest, openAIResponse OpenAIResponse) (store.Analysis, error) {
 	if len(openAIResponse.FinalContent) < 100 {
-		logger.Log.Error("OpenAI response too short", zap.String("username", username), zap.String("ticker", ticker))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return store.Analysis{}, fmt.Errorf("response too short: %d characters", len(openAIResponse.FinalContent))
 	}
 
 	if len(openAIResponse.FinalContent) > 8000 {
 		openAIResponse.FinalContent = openAIResponse.FinalContent[:8000] + "..."
 	}
 
-	var totalTokens int64
-	if rowsCount > 2 {
-		totalTokens = int64(
-			float64(openAIResponse.PromptTokens)*(INPUT_RATE_BIG/INPUT_RATE_SMALL) +
-				float64(openAIResponse.CompletionTokens)*(OUTPUT_RATE_BIG/INPUT_RATE_SMALL))
-	} else {
-		totalTokens = int64(
-			float64(openAIResponse.PromptTokens) +
-				float64(openAIResponse.CompletionTokens)*(OUTPUT_RATE_SMALL/INPUT_RATE_SMALL))
-	}
+	totalTokens := analystTokens(req.rows > 2, openAIResponse.PromptTokens, openAIResponse.CompletionTokens)
 
-	// Update user tokens (atomic increment)
-	_, err = d.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName: aws.String("nodofinance_table"),
-		Key: map[string]dynamoTypes.AttributeValue{
-			"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-			"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: "METADATA"},
-		},
-		UpdateExpression: aws.String("ADD ctokens :tokens"),
-		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
-			":tokens": &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(totalTokens, 10)},
-		},
-		ConditionExpression: aws.String("attribute_exists(username) AND attribute_exists(composite_sk)"),
-	})
+	financesHash := sha256.Sum256([]byte(req.finances))
 
-	if err != nil {
-		// Check if it's a condition check failure (user doesn't exist)
-		var conditionCheckFailed *dynamoTypes.ConditionalCheckFailedException
-		if errors.As(err, &conditionCheckFailed) {
-			logger.Log.Error("User metadata not found for token update", zap.String("username", username))
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		logger.Log.Error("Failed to update user tokens", zap.Error(err), zap.String("username", username))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	// Direct update using known sort key pattern
-	_, err = d.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName: aws.String("nodofinance_table"),
-		Key: map[string]dynamoTypes.AttributeValue{
-			"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-			"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: fmt.Sprintf("TICKER#%s", ticker)},
-		},
-		UpdateExpression: aws.String("SET analysis = :analysis"),
-		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
-			":analysis": &dynamoTypes.AttributeValueMemberS{Value: openAIResponse.FinalContent},
-		},
-		ConditionExpression: aws.String("attribute_exists(username) AND attribute_exists(composite_sk)"),
+	// Ticker, history and tokens in one transaction
+	analysis, err := st.PutAnalysis(ctx, req.username, store.Analysis{
+		Ticker:           req.ticker,
+		Content:          openAIResponse.FinalContent,
+		Insights:         openAIResponse.Insights,
+		Verification:     openAIResponse.Verification,
+		Model:            openAIResponse.Model,
+		PromptVersion:    req.templates.Version,
+		PromptTokens:     openAIResponse.PromptTokens,
+		CompletionTokens: openAIResponse.CompletionTokens,
+		Tokens:           totalTokens,
+		FinancesHash:     hex.EncodeToString(financesHash[:]),
 	})
-
 	if err != nil {
-		// Check if it's a condition check failure (record doesn't exist)
-		var conditionCheckFailed *dynamoTypes.ConditionalCheckFailedException
-		if errors.As(err, &conditionCheckFailed) {
-			logger.Log.Error("Ticker record not found for analysis update",
-				zap.String("username", username),
-				zap.String("ticker", ticker))
-			w.WriteHeader(http.StatusNotFound) // More appropriate status
-			return
+		if errors.Is(err, store.ErrNotFound) {
+			return store.Analysis{}, errAnalysisTicker
 		}
-
-		logger.Log.Error("Failed to update analysis",
-			zap.Error(err),
-			zap.String("username", username),
-			zap.String("ticker", ticker))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	response := AnalystRes{
-		AnalystMessage: openAIResponse.FinalContent,
+		return store.Analysis{}, fmt.Errorf("putting analysis: %w", err)
 	}
 
-	jsonResponse, err := json.Marshal(response)
-	if err != nil {
-		logger.Log.Error("Failed to marshal response", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
+	logger.Log.Info("Analysis updated", zap.String("username", req.username), zap.String("ticker", req.ticker), zap.String("analysis_id", analysis.ID), zap.String("prompt_version", req.templates.Version))
+	return analysis, nil
+}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	w.Write(jsonResponse)
+// analystTokens is the usage of the big or small analyst model in tokens of the small model's input,
+// what users are billed
+func analystTokens(big bool, promptTokens, completionTokens int64) int64 {
+	if big {
+		return int64(
+			float64(promptTokens)*(INPUT_RATE_BIG/INPUT_RATE_SMALL) +
+				float64(completionTokens)*(OUTPUT_RATE_BIG/INPUT_RATE_SMALL))
+	}
+	return int64(
+		float64(promptTokens) +
+			float64(completionTokens)*(OUTPUT_RATE_SMALL/INPUT_RATE_SMALL))
 }
 
 // *
@@ -264,30 +389,19 @@ func Analyst(w http.ResponseWriter, r *http.Request, d *dynamodb.Client, ai open
 // ***
 // ****
 // ***** CONSTRUCT FINANCIAL DATA
-func GetFinances(ctx context.Context, d *dynamodb.Client, username, ticker string) (string, int, error) {
-	// Query DynamoDB for all financial records for this user+ticker
-	result, err := d.Query(ctx, &dynamodb.QueryInput{
-		TableName:              aws.String("nodofinance_table"),
-		KeyConditionExpression: aws.String("username = :pk AND begins_with(composite_sk, :sk_prefix)"),
-		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
-			":pk":        &dynamoTypes.AttributeValueMemberS{Value: username},
-			":sk_prefix": &dynamoTypes.AttributeValueMemberS{Value: fmt.Sprintf("FINANCE#%s#", ticker)},
-		},
-		ScanIndexForward: aws.Bool(false), // DESC order (newest first, matches reverse_year design)
-	})
-
+// GetFinances preprocesses the periods of a ticker for the analyst, store.ErrNotFound if it has none
+func GetFinances(ctx context.Context, st store.Store, username, ticker string) (string, int, error) {
+	// All financial records for this user+ticker
+	records, err := st.ListFinancePeriods(ctx, username, ticker)
 	if err != nil {
-		return "", 0, fmt.Errorf("database query failed: %w", err)
+		return "", 0, err
 	}
 
-	// Convert DynamoDB items to FinanceMap format (same as SQL version)
-	finances, err := DynamoItemsToFinanceMaps(result.Items)
-	if err != nil {
-		return "", 0, fmt.Errorf("failed to convert items to finance maps: %w", err)
-	}
+	// Convert records to FinanceMap format (same as SQL version)
+	finances := FinancesToFinanceMaps(records)
 
 	if len(finances) == 0 {
-		return "", 0, fmt.Errorf("no finance records found")
+		return "", 0, fmt.Errorf("no finance records found: %w", store.ErrNotFound)
 	}
 
 	// Use existing preprocessing logic (no changes needed)
@@ -299,47 +413,46 @@ func GetFinances(ctx context.Context, d *dynamodb.Client, username, ticker strin
 	return mergedFinances, rowsCount, nil
 }
 
-// DynamoItemsToFinanceMaps converts DynamoDB items to FinanceMap (equivalent to RowsToFinanceMaps)
-func DynamoItemsToFinanceMaps(items []map[string]dynamoTypes.AttributeValue) ([]FinanceMap, error) {
-	result := make([]FinanceMap, 0, len(items))
-
-	for _, item := range items {
-		entry := make(FinanceMap)
-
-		// Convert each DynamoDB attribute to the expected format
-		for key, attr := range item {
-			switch a := attr.(type) {
-			case *dynamoTypes.AttributeValueMemberS:
-				entry[key] = a.Value
-			case *dynamoTypes.AttributeValueMemberN:
-				// Parse number based on field type
-				if key == "eps" {
-					// EPS should be float64
-					if val, err := strconv.ParseFloat(a.Value, 64); err == nil {
-						entry[key] = val
-					} else {
-						entry[key] = nil
-					}
-				} else {
-					// Other financial fields should be int64
-					if val, err := strconv.ParseInt(a.Value, 10, 64); err == nil {
-						entry[key] = val
-					} else {
-						entry[key] = nil
-					}
-				}
-			case *dynamoTypes.AttributeValueMemberNULL:
+// FinancesToFinanceMaps converts stored periods to FinanceMap (equivalent to RowsToFinanceMaps)
+func FinancesToFinanceMaps(records []store.Finance) []FinanceMap {
+	result := make([]FinanceMap, 0, len(records))
+
+	for _, f := range records {
+		entry := FinanceMap{
+			"year":        int64(f.Year),
+			"period_type": f.Period,
+		}
+
+		// Financial fields are int64, EPS is float64, missing values are nil
+		setInt := func(key string, val *int64) {
+			if val != nil {
+				entry[key] = *val
+			} else {
 				entry[key] = nil
-			default:
-				// Skip other types (like PK, SK which aren't used in calculations)
-				continue
 			}
 		}
 
+		setInt("current_assets", f.CurrentAssets)
+		setInt("non_current_assets", f.NonCurrentAssets)
+		setInt("cash_and_equivalents", f.Cash)
+		setInt("current_liabilities", f.CurrentLiabilities)
+		setInt("non_current_liabilities", f.NonCurrentLiabilities)
+		setInt("revenue", f.Revenue)
+		setInt("net_income", f.NetIncome)
+		setInt("cash_flow_from_operations", f.CashFlowFromOperations)
+		setInt("cash_flow_from_investing", f.CashFlowFromInvesting)
+		setInt("cash_flow_from_financing", f.CashFlowFromFinancing)
+
+		if f.EPS != nil {
+			entry["eps"] = *f.EPS
+		} else {
+			entry["eps"] = nil
+		}
+
 		result = append(result, entry)
 	}
 
-	return result, nil
+	return result
 }
 
 // PreprocessFinancesFromMaps - identical logic to PreprocessFinances but works with FinanceMap slice
@@ -572,93 +685,35 @@ func SafeDoubleOperation(row FinanceMap, fields []string, operation func([]float
 // ***
 // ****
 // ***** PROMPT
-func promptEngineer(mergedFinances, ticker, currency string, rows int) string {
-	var builder strings.Builder
-	var currencyStr string
+// Template data, see the prompts package
+type analystData struct {
+	Ticker string
+	// Empty when the currency is not one the prompt names
+	Currency string
+	Finances string
+	// More than two periods get the analysis with trends and projections
+	Detailed bool
+}
 
-	if currency == "EUR" || currency == "USD" || currency == "GBP" {
-		currencyStr = fmt.Sprintf("  expressed in %s", currency)
-	} else {
-		currencyStr = ". Currency of the data is not specified."
+// promptEngineer renders the analyst prompt name, prompts.Analyst or prompts.Insights
+func promptEngineer(templates *prompts.Set, name, mergedFinances, ticker, currency string, rows int) (string, error) {
+	data := analystData{
+		Ticker:   ticker,
+		Finances: mergedFinances,
+		Detailed: rows > 2,
 	}
 
-	// common for both cases
-	fmt.Fprintf(&builder, "Financial analysis of %s%s:\n", ticker, currencyStr)
-	fmt.Fprintf(&builder, "%s\n\n", mergedFinances)
-	builder.WriteString("Periods: annual (YYYY-Y), quarterly (YYYY-Q1/Q2/Q3/Q4) or semi-annual (YYYY-S1/S2).\n")
-	builder.WriteString("Mention material limitations in the data if detected.\n")
-	builder.WriteString("Format:\n")
-	builder.WriteString("* Professional markdown\n")
+	data.Currency = promptCurrency(currency)
 
-	if rows > 2 {
-		builder.WriteString("* Highlight material variations\n")
-		builder.WriteString("Methodology:\n")
-		builder.WriteString("* Trend analysis\n")
-		builder.WriteString("* KPI evolution\n")
-		builder.WriteString("* Seasonal factors\n")
-		builder.WriteString("* Fundamental drivers\n")
-		builder.WriteString("Analytical focus on:\n")
-		builder.WriteString("* Critical trends\n")
-		builder.WriteString("* Strategic implications\n")
-		builder.WriteString("* Well-founded perspectives\n")
-		builder.WriteString("* Future EPS potential\n")
-		builder.WriteString("Financial analysis structure:\n")
-		builder.WriteString("1. Executive Summary\n")
-		builder.WriteString("   - Main Conclusions\n")
-		builder.WriteString("   - Critical Trends\n")
-		builder.WriteString("   - Perspectives\n")
-		builder.WriteString("2. Fundamental Analysis\n")
-		builder.WriteString("   A. Profitability\n")
-		builder.WriteString("      - Revenue\n")
-		builder.WriteString("      - Margins\n")
-		builder.WriteString("      - Quality of Results\n")
-		builder.WriteString("   B. Financial Position\n")
-		builder.WriteString("      - Capital Structure\n")
-		builder.WriteString("      - Solvency and Liquidity\n")
-		builder.WriteString("      - Operational Efficiency\n")
-		builder.WriteString("   C. Cash Generation\n")
-		builder.WriteString("      - Operating Cash Flow\n")
-		builder.WriteString("      - Investment/Financing Policy\n")
-		builder.WriteString("      - Cash Model Sustainability\n")
-		builder.WriteString("3. Projections\n")
-		builder.WriteString("   A. Operating KPIs\n")
-		builder.WriteString("      - Revenue Growth\n")
-		builder.WriteString("      - Margins and Profitability\n")
-		builder.WriteString("      - Cash Generation\n")
-		builder.WriteString("   B. Scenarios (including projected EPS)\n")
-		builder.WriteString("      - Base Case\n")
-		builder.WriteString("      - Bull Case\n")
-		builder.WriteString("      - Bear Case\n")
-		builder.WriteString("4. Risks\n")
-		builder.WriteString("   - Operational\n")
-		builder.WriteString("   - Financial\n")
-		builder.WriteString("   - Structural")
-	} else {
-		builder.WriteString("Financial analysis structure:\n")
-		builder.WriteString("1. Executive Summary\n")
-		builder.WriteString("   - Main Conclusions\n")
-		builder.WriteString("   - Key Indicators\n")
-		builder.WriteString("   - Financial position\n")
-		builder.WriteString("2. Fundamental Analysis\n")
-		builder.WriteString("   A. Profitability\n")
-		builder.WriteString("      - Revenue\n")
-		builder.WriteString("      - Margins\n")
-		builder.WriteString("      - Quality of Results\n")
-		builder.WriteString("   B. Financial Position\n")
-		builder.WriteString("      - Capital Structure\n")
-		builder.WriteString("      - Solvency and Liquidity\n")
-		builder.WriteString("      - Operational Efficiency\n")
-		builder.WriteString("   C. Cash Generation\n")
-		builder.WriteString("      - Operating Cash Flow\n")
-		builder.WriteString("      - Investment/Financing Policy\n")
-		builder.WriteString("      - Cash Model Sustainability\n")
-		builder.WriteString("3. Considerations\n")
-		builder.WriteString("   - Main Risks\n")
-		builder.WriteString("   - Key Factors\n")
-		builder.WriteString("   - Analysis Limitations")
-	}
-
-	return builder.String()
+	return templates.Render(name, data)
+}
+
+// promptCurrency is the currency the prompts name, empty for the others
+func promptCurrency(currency string) string {
+	if currency == "EUR" || currency == "USD" || currency == "GBP" {
+		return currency
+	}
+	return ""
 }
 
 // *
@@ -670,48 +725,174 @@ type OpenAIResponse struct {
 	PromptTokens     int64  `json:"prompt_tokens"`
 	CompletionTokens int64  `json:"completion_tokens"`
 	FinalContent     string `json:"final_content"`
+	Model            string `json:"model"`
+	// Insights is nil if its call failed, the report is kept anyway
+	Insights     *store.Insights     `json:"insights,omitempty"`
+	Verification *store.Verification `json:"verification,omitempty"`
 }
 
-func callOpenAI(ctx context.Context, ai openai.Client, ticker, mergedFinances, currency string, rows int) (OpenAIResponse, error) {
-	model := openai.ChatModelGPT4oMini
+// callOpenAI asks the analyst model for the report, onDelta streams it if not nil, and for its
+// insights at the same time. Tokens of both calls are added up.
+func callOpenAI(ctx context.Context, ai llm.Provider, templates *prompts.Set, ticker, mergedFinances, currency string, rows int, onDelta llm.OnDelta) (OpenAIResponse, error) {
+	model := llm.MODEL_ANALYST_SMALL
 	if rows > 2 {
-		model = openai.ChatModelGPT4o
+		model = llm.MODEL_ANALYST_BIG
 	}
 
 	openAIResponse := OpenAIResponse{}
 
-	systemContent := "You are a senior equity research analyst at " +
-		"a large investment bank. Your specialty is fundamental analysis " +
-		"and company valuation. You must maintain a professional but " +
-		"direct tone, emphasizing the critical points that affect the investment thesis. " +
-		"Your recommendations must be backed by quantitative data."
+	systemContent, err := templates.Render(prompts.AnalystSystem, analystData{Ticker: ticker})
+	if err != nil {
+		return openAIResponse, err
+	}
+
+	userPrompt, err := promptEngineer(templates, prompts.Analyst, mergedFinances, ticker, currency, rows)
+	if err != nil {
+		return openAIResponse, err
+	}
+
+	insightsPrompt, err := promptEngineer(templates, prompts.Insights, mergedFinances, ticker, currency, rows)
+	if err != nil {
+		return openAIResponse, err
+	}
+
+	insightsCtx, cancelInsights := context.WithCancel(ctx)
+	defer cancelInsights()
 
-	userPrompt := promptEngineer(mergedFinances, ticker, currency, rows)
+	insightsDone := make(chan insightsResult, 1)
+	go func() {
+		insightsDone <- callInsights(insightsCtx, ai, model, systemContent, insightsPrompt)
+	}()
 
-	chatCompletion, err := ai.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.SystemMessage(systemContent),
-			openai.UserMessage(userPrompt),
+	req := llm.Request{
+		Model: model,
+		Messages: []llm.Message{
+			llm.SystemMessage(systemContent),
+			llm.UserMessage(userPrompt),
 		},
-		Model:       model,
-		MaxTokens:   openai.Int(10000),
-		Temperature: openai.Float(0.4),
-	})
+		MaxTokens:   10000,
+		Temperature: 0.4,
+		Timeout:     llm.TIMEOUT_ANALYST,
+	}
 
+	var chatCompletion llm.Response
+	if onDelta != nil {
+		chatCompletion, err = ai.ChatStream(ctx, req, onDelta)
+	} else {
+		chatCompletion, err = ai.Chat(ctx, req)
+	}
+
+	// a failed call has the usage of the attempts it threw away
+	openAIResponse.PromptTokens = chatCompletion.Usage.PromptTokens
+	openAIResponse.CompletionTokens = chatCompletion.Usage.CompletionTokens
 	if err != nil {
-		logger.Log.Error("Failed to call OpenAI API", zap.Error(err))
+		logger.Log.Error("Failed to call analyst model", zap.Error(err))
+		cancelInsights()
+		insights := <-insightsDone
+		openAIResponse.PromptTokens += insights.usage.PromptTokens
+		openAIResponse.CompletionTokens += insights.usage.CompletionTokens
 		return openAIResponse, err
 	}
 
-	if len(chatCompletion.Choices) > 0 {
-		openAIResponse.FinalContent = chatCompletion.Choices[0].Message.Content
-		openAIResponse.PromptTokens = chatCompletion.Usage.PromptTokens
-		openAIResponse.CompletionTokens = chatCompletion.Usage.CompletionTokens
+	openAIResponse.FinalContent = chatCompletion.Content
+	openAIResponse.Model = model
+
+	insights := <-insightsDone
+	openAIResponse.PromptTokens += insights.usage.PromptTokens
+	openAIResponse.CompletionTokens += insights.usage.CompletionTokens
+	if insights.err != nil {
+		logger.Log.Warn("Failed to get analyst insights", zap.Error(insights.err), zap.String("ticker", ticker))
 	} else {
-		logger.Log.Error("OpenAI API returned no choices", zap.Any("response", chatCompletion))
-		return openAIResponse, fmt.Errorf("invalid response format from OpenAI API")
+		openAIResponse.Insights = insights.insights
 	}
 
 	return openAIResponse, nil
 
 }
+
+var insightsSchema = llm.Schema{
+	Name: "analyst_insights",
+	Schema: map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"score": map[string]any{"type": "number"},
+			"pillars": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"profitability":   map[string]any{"type": "number"},
+					"solvency":        map[string]any{"type": "number"},
+					"liquidity":       map[string]any{"type": "number"},
+					"cash_generation": map[string]any{"type": "number"},
+				},
+				"required":             []string{"profitability", "solvency", "liquidity", "cash_generation"},
+				"additionalProperties": false,
+			},
+			"projected_eps": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"base": map[string]any{"type": []string{"number", "null"}},
+					"bull": map[string]any{"type": []string{"number", "null"}},
+					"bear": map[string]any{"type": []string{"number", "null"}},
+				},
+				"required":             []string{"base", "bull", "bear"},
+				"additionalProperties": false,
+			},
+			"risks": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"category":    map[string]any{"type": "string", "enum": []string{"operational", "financial", "structural"}},
+						"description": map[string]any{"type": "string"},
+					},
+					"required":             []string{"category", "description"},
+					"additionalProperties": false,
+				},
+			},
+		},
+		"required":             []string{"score", "pillars", "projected_eps", "risks"},
+		"additionalProperties": false,
+	},
+}
+
+type insightsResult struct {
+	insights *store.Insights
+	usage    llm.Usage
+	err      error
+}
+
+func callInsights(ctx context.Context, ai llm.Provider, model, systemContent, insightsPrompt string) insightsResult {
+	res, err := ai.ChatJSON(ctx, llm.Request{
+		Model: model,
+		Messages: []llm.Message{
+			llm.SystemMessage(systemContent),
+			llm.UserMessage(insightsPrompt),
+		},
+		MaxTokens:   1000,
+		Temperature: 0.2,
+		Timeout:     llm.TIMEOUT_ANALYST,
+	}, insightsSchema)
+	if err != nil {
+		return insightsResult{usage: res.Usage, err: err}
+	}
+
+	var insights store.Insights
+	if err := json.Unmarshal([]byte(res.Content), &insights); err != nil {
+		return insightsResult{usage: res.Usage, err: fmt.Errorf("parsing insights: %w", err)}
+	}
+
+	// the schema can't bound numbers
+	clampScore := func(score *float64) {
+		*score = math.Round(math.Min(math.Max(*score, 0), 10)*10) / 10
+	}
+	clampScore(&insights.Score)
+	clampScore(&insights.Pillars.Profitability)
+	clampScore(&insights.Pillars.Solvency)
+	clampScore(&insights.Pillars.Liquidity)
+	clampScore(&insights.Pillars.CashGeneration)
+	if len(insights.Risks) > 6 {
+		insights.Risks = insights.Risks[:6]
+	}
+
+	return insightsResult{insights: &insights, usage: res.Usage}
+}
@@ -0,0 +1,237 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"nodofinance/llm"
+	"nodofinance/prompts"
+	"nodofinance/store"
+	"nodofinance/utils/logger"
+
+	"go.uber.org/zap"
+)
+
+/*
+	Follow-up questions about a ticker. The model gets the merged finances and the stored analysis in
+	the system prompt, then the last messages of the chat (CHAT# items) within CHAT_CONTEXT_MESSAGES
+	and CHAT_CONTEXT_CHARS, then the question. Each turn is billed like the analyst with the small model.
+*/
+
+const (
+	CHAT_MAX_QUESTION     = 1000 // characters
+	CHAT_CONTEXT_MESSAGES = 10
+	CHAT_CONTEXT_CHARS    = 12000
+	// A longer chat has to be cleared first
+	MAX_CHAT_MESSAGES = 200
+)
+
+type ChatReq struct {
+	Ticker   string `json:"ticker"`
+	Currency string `json:"currency"`
+	Question string `json:"question"`
+}
+
+type ChatRes struct {
+	Answer string `json:"answer"`
+	Tokens int64  `json:"tokens"`
+}
+
+type ChatMessageRes struct {
+	Role      string `json:"role"`
+	Content   string `json:"content"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type ChatExportRes struct {
+	Ticker   string           `json:"ticker"`
+	Messages []ChatMessageRes `json:"messages"`
+}
+
+type chatData struct {
+	Ticker   string
+	Currency string
+	Finances string
+	Analysis string
+}
+
+// Chat answers a question about the finances and the analysis of a ticker
+func Chat(w http.ResponseWriter, r *http.Request, st store.Store, ai llm.Provider) {
+	ctx := r.Context()
+
+	var body ChatReq
+	req, ok := prepareAnalysis(w, r, st, &body)
+	if !ok {
+		return
+	}
+
+	question := strings.TrimSpace(body.Question)
+	if question == "" || utf8.RuneCountInString(question) > CHAT_MAX_QUESTION {
+		logger.Log.Error("Invalid chat question", zap.String("username", req.username), zap.Int("length", len(question)))
+		http.Error(w, fmt.Sprintf("Questions take 1 to %d characters", CHAT_MAX_QUESTION), http.StatusBadRequest)
+		return
+	}
+
+	tickerRecord, err := st.GetTicker(ctx, req.username, req.ticker)
+	if errors.Is(err, store.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Log.Error("Failed to get ticker", zap.Error(err), zap.String("username", req.username), zap.String("ticker", req.ticker))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	history, err := st.ListChat(ctx, req.username, req.ticker)
+	if err != nil {
+		logger.Log.Error("Failed to list chat", zap.Error(err), zap.String("username", req.username), zap.String("ticker", req.ticker))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if len(history) >= MAX_CHAT_MESSAGES {
+		logger.Log.Warn("Chat is full", zap.String("username", req.username), zap.String("ticker", req.ticker))
+		http.Error(w, "The conversation is too long, export and clear it to continue", http.StatusConflict)
+		return
+	}
+
+	systemContent, err := req.templates.Render(prompts.ChatSystem, chatData{
+		Ticker:   req.ticker,
+		Currency: promptCurrency(req.currency),
+		Finances: req.finances,
+		Analysis: tickerRecord.Analysis,
+	})
+	if err != nil {
+		logger.Log.Error("Failed to render chat prompt", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	messages := []llm.Message{llm.SystemMessage(systemContent)}
+	for _, m := range chatContext(history) {
+		if m.Role == store.ChatRoleAssistant {
+			messages = append(messages, llm.AssistantMessage(m.Content))
+		} else {
+			messages = append(messages, llm.UserMessage(m.Content))
+		}
+	}
+	messages = append(messages, llm.UserMessage(question))
+
+	// the user waits for the answer, it goes before queued submissions
+	completion, err := ai.Chat(llm.WithPriority(ctx, llm.PriorityInteractive), llm.Request{
+		Model:       llm.MODEL_ANALYST_SMALL,
+		Messages:    messages,
+		MaxTokens:   1500,
+		Temperature: 0.4,
+		Timeout:     llm.TIMEOUT_ANALYST,
+	})
+	if err != nil {
+		writeAnalystError(w, req, err)
+		return
+	}
+
+	tokens := int64(
+		float64(completion.Usage.PromptTokens) +
+			float64(completion.Usage.CompletionTokens)*(OUTPUT_RATE_SMALL/INPUT_RATE_SMALL))
+
+	err = st.PutChatTurn(ctx, req.username, req.ticker,
+		store.ChatMessage{Role: store.ChatRoleUser, Content: question},
+		store.ChatMessage{Role: store.ChatRoleAssistant, Content: completion.Content, Tokens: tokens},
+	)
+	if err != nil {
+		logger.Log.Error("Failed to put chat turn", zap.Error(err), zap.String("username", req.username), zap.String("ticker", req.ticker))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeAnalysisJSON(w, ChatRes{Answer: completion.Content, Tokens: tokens})
+}
+
+// ChatExport returns the whole chat of a ticker, as JSON or with format=markdown as a file
+func ChatExport(w http.ResponseWriter, r *http.Request, st store.Store) {
+	username, ticker, ok := analysisParams(w, r)
+	if !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "markdown" {
+		logger.Log.Error("Invalid chat export format", zap.String("format", format))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	history, err := st.ListChat(r.Context(), username, ticker)
+	if err != nil {
+		logger.Log.Error("Failed to list chat", zap.Error(err), zap.String("username", username), zap.String("ticker", ticker))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if format == "markdown" {
+		var b strings.Builder
+		fmt.Fprintf(&b, "# %s\n", ticker)
+		for _, m := range history {
+			speaker := "You"
+			if m.Role == store.ChatRoleAssistant {
+				speaker = "Analyst"
+			}
+			fmt.Fprintf(&b, "\n**%s** (%s):\n\n%s\n", speaker, time.Unix(m.CreatedAt, 0).UTC().Format(time.RFC3339), m.Content)
+		}
+
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-chat.md"`, ticker))
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(b.String()))
+		return
+	}
+
+	response := ChatExportRes{Ticker: ticker, Messages: make([]ChatMessageRes, 0, len(history))}
+	for _, m := range history {
+		response.Messages = append(response.Messages, ChatMessageRes{Role: m.Role, Content: m.Content, CreatedAt: m.CreatedAt})
+	}
+
+	writeAnalysisJSON(w, response)
+}
+
+// ChatClear deletes the chat of a ticker
+func ChatClear(w http.ResponseWriter, r *http.Request, st store.Store) {
+	username, ticker, ok := analysisParams(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := st.DeleteChat(r.Context(), username, ticker)
+	if err != nil {
+		logger.Log.Error("Failed to delete chat", zap.Error(err), zap.String("username", username), zap.String("ticker", ticker), zap.Int("deleted", deleted))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.Info("Chat cleared", zap.String("username", username), zap.String("ticker", ticker), zap.Int("deleted", deleted))
+	w.WriteHeader(http.StatusOK)
+}
+
+// chatContext is the end of the chat the model sees: the last CHAT_CONTEXT_MESSAGES that fit in
+// CHAT_CONTEXT_CHARS, starting with a question
+func chatContext(history []store.ChatMessage) []store.ChatMessage {
+	start := len(history)
+	chars := 0
+	for start > 0 && len(history)-start < CHAT_CONTEXT_MESSAGES {
+		chars += len(history[start-1].Content)
+		if chars > CHAT_CONTEXT_CHARS {
+			break
+		}
+		start--
+	}
+
+	for start < len(history) && history[start].Role != store.ChatRoleUser {
+		start++
+	}
+	return history[start:]
+}
@@ -0,0 +1,417 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"nodofinance/llm"
+	"nodofinance/prompts"
+	"nodofinance/store"
+	"nodofinance/utils/jwt"
+	"nodofinance/utils/logger"
+	"nodofinance/utils/sanitize"
+
+	"go.uber.org/zap"
+)
+
+/*
+	Comparative report of 2 to 5 tickers of the portfolio. The finances of each ticker are preprocessed
+	as for the analyst (see GetFinances), then aligned on the periods they all have, keeping their
+	normalized ratios and the median of each ratio across the companies. The completion budget, the
+	cost estimated before the call and the tokens billed grow with the number of companies.
+	Comparisons are billed but not stored.
+*/
+
+const (
+	COMPARE_MIN_TICKERS = 2
+	COMPARE_MAX_TICKERS = 5
+	// Most recent common periods sent to the model
+	COMPARE_MAX_PERIODS = 6
+	// Completion budget of each company in the report
+	COMPARE_TOKENS_PER_TICKER = 2000
+)
+
+// Fields of each company and period in the comparison, amounts only give the scale
+var compareFields = []string{
+	"revenue", "net_income", "total_assets", "eps",
+	"net_margin", "roa", "roe", "solvency_ratio", "debt_ratio", "liquidity_ratio",
+	"working_capital_over_non_current_liabilities",
+}
+
+// Ratios with a peer median, computed or from compareFields
+var compareRatios = []string{
+	"revenue_growth", "operating_cash_flow_margin",
+	"net_margin", "roa", "roe", "solvency_ratio", "debt_ratio", "liquidity_ratio",
+	"working_capital_over_non_current_liabilities",
+}
+
+var errNoCommonPeriods = errors.New("no common periods")
+
+type CompareReq struct {
+	Tickers []string `json:"tickers"`
+}
+
+type CompareRes struct {
+	AnalystMessage string   `json:"analyst_message"`
+	Tickers        []string `json:"tickers"`
+	// Periods the comparison covers, oldest first
+	Periods []string `json:"periods"`
+	Tokens  int64    `json:"tokens"`
+}
+
+type comparePayload struct {
+	Periods []string `json:"periods"`
+	// ticker, then period, then field
+	Companies  map[string]map[string]map[string]*float64 `json:"companies"`
+	PeerMedian map[string]map[string]*float64            `json:"peer_median"`
+}
+
+type compareData struct {
+	Tickers []string
+	// Empty when the companies report in different currencies or one the prompt doesn't name
+	Currency        string
+	MixedCurrencies bool
+	Finances        string
+	Detailed        bool
+}
+
+// Compare writes a relative-strength report of several tickers of the user
+func Compare(w http.ResponseWriter, r *http.Request, st store.Store, ai llm.Provider) {
+	ctx := r.Context()
+
+	idTokenCookie, errIT := r.Cookie("nodo_id_token")
+	if errIT != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	idClaims, err := jwt.GetTokenClaims(idTokenCookie.Value)
+	if err != nil {
+		logger.Log.Error("Failed to get token claims", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	username, exists := idClaims["cognito:username"].(string)
+	if !exists {
+		logger.Log.Error("Failed to get username from token claims")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Log.Error("Failed to read request body", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+
+	var req CompareReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		logger.Log.Error("Failed to unmarshal request body", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	tickers := make([]string, 0, len(req.Tickers))
+	seen := make(map[string]bool)
+	for _, ticker := range req.Tickers {
+		ticker = sanitize.Trim(ticker, "u")
+		if !sanitize.Ticker(ticker) {
+			logger.Log.Error("Invalid ticker", zap.String("ticker", ticker))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !seen[ticker] {
+			seen[ticker] = true
+			tickers = append(tickers, ticker)
+		}
+	}
+
+	if len(tickers) < COMPARE_MIN_TICKERS || len(tickers) > COMPARE_MAX_TICKERS {
+		logger.Log.Error("Invalid number of tickers to compare", zap.String("username", username), zap.Int("tickers", len(tickers)))
+		http.Error(w, fmt.Sprintf("Compare %d to %d different tickers", COMPARE_MIN_TICKERS, COMPARE_MAX_TICKERS), http.StatusBadRequest)
+		return
+	}
+
+	userMetadata, err := st.GetMetadata(ctx, username)
+	if errors.Is(err, store.ErrNotFound) {
+		logger.Log.Error("No rows found for user", zap.String("username", username))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		logger.Log.Error("Failed to get user metadata", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if userMetadata.CTokens == nil {
+		logger.Log.Warn("CTokens is nil for user", zap.String("username", username))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Tickers must be in the portfolio
+	finances := make(map[string]string, len(tickers))
+	currencies := make(map[string]bool)
+	for _, ticker := range tickers {
+		tickerRecord, err := st.GetTicker(ctx, username, ticker)
+		if errors.Is(err, store.ErrNotFound) {
+			logger.Log.Error("Ticker not in portfolio", zap.String("username", username), zap.String("ticker", ticker))
+			http.Error(w, fmt.Sprintf("%s is not in your portfolio", ticker), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Log.Error("Failed to get ticker", zap.Error(err), zap.String("username", username), zap.String("ticker", ticker))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		currencies[tickerRecord.Currency] = true
+
+		mergedFinances, _, err := GetFinances(ctx, st, username, ticker)
+		if errors.Is(err, store.ErrNotFound) {
+			logger.Log.Error("Ticker without periods", zap.String("username", username), zap.String("ticker", ticker))
+			http.Error(w, fmt.Sprintf("%s has no periods to compare", ticker), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Log.Error("Failed to get finances", zap.Error(err), zap.String("username", username), zap.String("ticker", ticker))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		finances[ticker] = mergedFinances
+	}
+
+	payload, err := buildComparePayload(finances)
+	if errors.Is(err, errNoCommonPeriods) {
+		logger.Log.Warn("No common periods to compare", zap.String("username", username), zap.Strings("tickers", tickers))
+		http.Error(w, "The companies have no period in common", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		logger.Log.Error("Failed to build comparison", zap.Error(err), zap.String("username", username), zap.Strings("tickers", tickers))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		logger.Log.Error("Failed to marshal comparison", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data := compareData{
+		Tickers:  tickers,
+		Finances: string(payloadJSON),
+		Detailed: len(payload.Periods) > 2,
+	}
+	if len(currencies) == 1 {
+		for currency := range currencies {
+			data.Currency = promptCurrency(currency)
+		}
+	} else {
+		data.MixedCurrencies = true
+	}
+
+	templates := prompts.Assign(username + "#compare")
+
+	systemContent, err := templates.Render(prompts.AnalystSystem, analystData{})
+	if err != nil {
+		logger.Log.Error("Failed to render analyst system prompt", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	userPrompt, err := templates.Render(prompts.Compare, data)
+	if err != nil {
+		logger.Log.Error("Failed to render compare prompt", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	model := llm.MODEL_ANALYST_SMALL
+	if data.Detailed {
+		model = llm.MODEL_ANALYST_BIG
+	}
+
+	llmReq := llm.Request{
+		Model: model,
+		Messages: []llm.Message{
+			llm.SystemMessage(systemContent),
+			llm.UserMessage(userPrompt),
+		},
+		MaxTokens:   int64(COMPARE_TOKENS_PER_TICKER * len(tickers)),
+		Temperature: 0.4,
+		Timeout:     llm.TIMEOUT_ANALYST,
+	}
+
+	// The whole comparison has to fit in what is left of the month
+	estimate := estimateCompareTokens(llmReq, data.Detailed)
+	if *userMetadata.CTokens+estimate > MAX_TOKENS {
+		logger.Log.Warn("cTokens limit", zap.String("username", username), zap.Int64("cTokens", *userMetadata.CTokens), zap.Int64("estimate", estimate))
+
+		limitMessage := fmt.Sprintf("This comparison takes up to %d tokens and you have %d left. Current limit: %d tokens per month.",
+			estimate, max(MAX_TOKENS-*userMetadata.CTokens, 0), MAX_TOKENS)
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(limitMessage))
+		return
+	}
+
+	// the user waits for the report, it goes before queued submissions
+	completion, err := ai.Chat(llm.WithPriority(ctx, llm.PriorityInteractive), llmReq)
+	if err != nil {
+		writeAnalystError(w, analysisRequest{username: username, ticker: strings.Join(tickers, ",")}, err)
+		return
+	}
+
+	if len(completion.Content) < 100 {
+		logger.Log.Error("Comparison too short", zap.String("username", username), zap.Int("length", len(completion.Content)))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	tokens := analystTokens(data.Detailed, completion.Usage.PromptTokens, completion.Usage.CompletionTokens)
+	if err := st.AddTokens(ctx, username, tokens); err != nil {
+		logger.Log.Error("Failed to add tokens", zap.Error(err), zap.String("username", username), zap.Int64("tokens", tokens))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.Info("Comparison done", zap.String("username", username), zap.Strings("tickers", tickers), zap.Int64("tokens", tokens), zap.String("prompt_version", templates.Version))
+
+	writeAnalysisJSON(w, CompareRes{
+		AnalystMessage: completion.Content,
+		Tickers:        tickers,
+		Periods:        payload.Periods,
+		Tokens:         tokens,
+	})
+}
+
+// estimateCompareTokens bills the prompt, about 4 characters per token, and the whole completion
+// budget, which grows with the number of companies
+func estimateCompareTokens(req llm.Request, big bool) int64 {
+	chars := 0
+	for _, m := range req.Messages {
+		chars += len(m.Content)
+	}
+	return analystTokens(big, int64(chars/4), req.MaxTokens)
+}
+
+// buildComparePayload aligns the preprocessed finances of each ticker on their common periods, the
+// most recent COMPARE_MAX_PERIODS
+func buildComparePayload(finances map[string]string) (comparePayload, error) {
+	periodsByTicker := make(map[string]map[string]map[string]*float64, len(finances))
+	common := make(map[string]int)
+	for ticker, mergedFinances := range finances {
+		var periods map[string]map[string]*float64
+		if err := json.Unmarshal([]byte(mergedFinances), &periods); err != nil {
+			return comparePayload{}, fmt.Errorf("parsing finances of %s: %w", ticker, err)
+		}
+		periodsByTicker[ticker] = periods
+		for key := range periods {
+			common[key]++
+		}
+	}
+
+	var keys []string
+	for key, count := range common {
+		if count == len(finances) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return comparePayload{}, errNoCommonPeriods
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return periodBefore(keys[i], keys[j]) })
+	if len(keys) > COMPARE_MAX_PERIODS {
+		keys = keys[len(keys)-COMPARE_MAX_PERIODS:]
+	}
+
+	payload := comparePayload{
+		Periods:    keys,
+		Companies:  make(map[string]map[string]map[string]*float64, len(finances)),
+		PeerMedian: make(map[string]map[string]*float64, len(keys)),
+	}
+
+	for ticker, periods := range periodsByTicker {
+		company := make(map[string]map[string]*float64, len(keys))
+		for _, key := range keys {
+			company[key] = normalizePeriod(periods, key)
+		}
+		payload.Companies[ticker] = company
+	}
+
+	for _, key := range keys {
+		medians := make(map[string]*float64, len(compareRatios))
+		for _, field := range compareRatios {
+			var values []float64
+			for _, company := range payload.Companies {
+				if value := company[key][field]; value != nil {
+					values = append(values, *value)
+				}
+			}
+			medians[field] = median(values)
+		}
+		payload.PeerMedian[key] = medians
+	}
+
+	return payload, nil
+}
+
+// normalizePeriod keeps the compareFields of a period and adds the ratios that don't depend on the
+// size of the company. Growth is against the same period of the previous year, if the company has it.
+func normalizePeriod(periods map[string]map[string]*float64, key string) map[string]*float64 {
+	values := periods[key]
+	normalized := make(map[string]*float64, len(compareFields)+2)
+	for _, field := range compareFields {
+		normalized[field] = values[field]
+	}
+
+	ratio := func(numerator, denominator *float64) *float64 {
+		if numerator == nil || denominator == nil || *denominator == 0 {
+			return nil
+		}
+		result := RoundTo2Decimals(*numerator / *denominator)
+		return &result
+	}
+
+	normalized["operating_cash_flow_margin"] = ratio(values["cash_flow_from_operations"], values["revenue"])
+
+	normalized["revenue_growth"] = nil
+	if year, period, err := splitPeriod(key); err == nil {
+		if previous, exists := periods[fmt.Sprintf("%d-%s", year-1, period)]; exists {
+			revenue, before := values["revenue"], previous["revenue"]
+			if revenue != nil && before != nil && *before != 0 {
+				growth := RoundTo2Decimals((*revenue - *before) / *before)
+				normalized["revenue_growth"] = &growth
+			}
+		}
+	}
+
+	return normalized
+}
+
+// median of the values, nil if there are none
+func median(values []float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Float64s(values)
+	middle := len(values) / 2
+	result := values[middle]
+	if len(values)%2 == 0 {
+		result = RoundTo2Decimals((values[middle-1] + values[middle]) / 2)
+	}
+	return &result
+}
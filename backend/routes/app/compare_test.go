@@ -0,0 +1,161 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"nodofinance/store"
+)
+
+func TestBuildComparePayload(t *testing.T) {
+	number := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name        string
+		finances    map[string]string
+		wantErr     error
+		wantPeriods []string
+		// ticker, then period, then field, of the values to check
+		wantCompanies map[string]map[string]map[string]*float64
+		wantMedian    map[string]map[string]*float64
+	}{
+		{
+			name: "ratios and growth of the common period",
+			finances: map[string]string{
+				"AAA": `{"2023-Y": {"revenue": 100, "cash_flow_from_operations": 20, "net_margin": 0.1},
+				         "2024-Y": {"revenue": 120, "cash_flow_from_operations": 30, "net_margin": 0.12}}`,
+				"BBB": `{"2024-Q4": {"revenue": 60},
+				         "2024-Y": {"revenue": 200, "cash_flow_from_operations": 50, "net_margin": 0.2}}`,
+			},
+			wantPeriods: []string{"2024-Y"},
+			wantCompanies: map[string]map[string]map[string]*float64{
+				// growth against a period that is not common
+				"AAA": {"2024-Y": {"revenue": number(120), "operating_cash_flow_margin": number(0.25), "revenue_growth": number(0.2)}},
+				"BBB": {"2024-Y": {"revenue": number(200), "operating_cash_flow_margin": number(0.25), "revenue_growth": nil}},
+			},
+			wantMedian: map[string]map[string]*float64{
+				"2024-Y": {"operating_cash_flow_margin": number(0.25), "revenue_growth": number(0.2), "net_margin": number(0.16), "roe": nil},
+			},
+		},
+		{
+			name: "growth from zero revenue and margin without revenue",
+			finances: map[string]string{
+				"AAA": `{"2023-Y": {"revenue": 0}, "2024-Y": {"revenue": 50, "cash_flow_from_operations": 10}}`,
+				"BBB": `{"2023-Y": {"revenue": 80}, "2024-Y": {"revenue": 100, "cash_flow_from_operations": 10}}`,
+				"CCC": `{"2023-Y": {"revenue": 50}, "2024-Y": {"revenue": null, "cash_flow_from_operations": 10}}`,
+			},
+			wantPeriods: []string{"2023-Y", "2024-Y"},
+			wantCompanies: map[string]map[string]map[string]*float64{
+				"AAA": {"2024-Y": {"revenue_growth": nil, "operating_cash_flow_margin": number(0.2)}},
+				"BBB": {"2024-Y": {"revenue_growth": number(0.25), "operating_cash_flow_margin": number(0.1)}},
+				"CCC": {"2024-Y": {"revenue_growth": nil, "operating_cash_flow_margin": nil}},
+			},
+			wantMedian: map[string]map[string]*float64{
+				"2023-Y": {"revenue_growth": nil},
+				"2024-Y": {"revenue_growth": number(0.25), "operating_cash_flow_margin": number(0.15)},
+			},
+		},
+		{
+			name: "most recent periods, oldest first",
+			finances: map[string]string{
+				"AAA": `{"2018-Y": {}, "2019-Y": {}, "2020-Y": {}, "2021-Y": {}, "2022-Y": {}, "2023-Y": {}, "2024-Q1": {}, "2024-Y": {}}`,
+				"BBB": `{"2018-Y": {}, "2019-Y": {}, "2020-Y": {}, "2021-Y": {}, "2022-Y": {}, "2023-Y": {}, "2024-Q1": {}, "2024-Y": {}}`,
+			},
+			wantPeriods: []string{"2020-Y", "2021-Y", "2022-Y", "2023-Y", "2024-Q1", "2024-Y"},
+		},
+		{
+			name: "no common period",
+			finances: map[string]string{
+				"AAA": `{"2023-Y": {"revenue": 100}}`,
+				"BBB": `{"2024-Y": {"revenue": 100}}`,
+			},
+			wantErr: errNoCommonPeriods,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := buildComparePayload(tt.finances)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("buildComparePayload() = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(payload.Periods, tt.wantPeriods) {
+				t.Errorf("periods = %v, want %v", payload.Periods, tt.wantPeriods)
+			}
+			for ticker, periods := range tt.wantCompanies {
+				for period, fields := range periods {
+					for field, want := range fields {
+						if got := payload.Companies[ticker][period][field]; !reflect.DeepEqual(got, want) {
+							t.Errorf("%s %s %s = %v, want %v", ticker, period, field, deref(got), deref(want))
+						}
+					}
+				}
+			}
+			for period, fields := range tt.wantMedian {
+				for field, want := range fields {
+					if got := payload.PeerMedian[period][field]; !reflect.DeepEqual(got, want) {
+						t.Errorf("median %s %s = %v, want %v", period, field, deref(got), deref(want))
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("invalid finances", func(t *testing.T) {
+		if _, err := buildComparePayload(map[string]string{"AAA": "not json"}); err == nil {
+			t.Error("buildComparePayload() = nil, want an error")
+		}
+	})
+}
+
+// periodlessStore has the TICKER# of a ticker without its periods, like a delete half seen
+type periodlessStore struct {
+	*store.Memory
+	ticker string
+}
+
+func (s *periodlessStore) ListFinancePeriods(ctx context.Context, username, ticker string) ([]store.Finance, error) {
+	if ticker == s.ticker {
+		return []store.Finance{}, nil
+	}
+	return s.Memory.ListFinancePeriods(ctx, username, ticker)
+}
+
+func TestCompareErrors(t *testing.T) {
+	setLimits(t, 1000, 5, 5)
+	st := &periodlessStore{Memory: newMemoryStore(t, 0), ticker: "MSFT"}
+	putPeriod(t, st, "AAPL", 2024, "Y", 100)
+	putPeriod(t, st, "MSFT", 2024, "Y", 100)
+	putPeriod(t, st, "GOOG", 2023, "Y", 100)
+
+	tests := []struct {
+		name    string
+		tickers string
+		want    int
+	}{
+		{"one ticker", `["AAPL"]`, http.StatusBadRequest},
+		{"ticker not in the portfolio", `["AAPL", "AMZN"]`, http.StatusNotFound},
+		{"ticker without periods", `["AAPL", "MSFT"]`, http.StatusNotFound},
+		{"no common period", `["AAPL", "GOOG"]`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			body := fmt.Sprintf(`{"tickers": %s}`, tt.tickers)
+			Compare(w, newRequest(t, http.MethodPost, "/compare", body, testUser), st, nil)
+			if w.Code != tt.want {
+				t.Errorf("Compare() status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
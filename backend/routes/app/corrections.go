@@ -0,0 +1,194 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"nodofinance/routes/app/submitter"
+	"nodofinance/store"
+	"nodofinance/utils/logger"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// Chunks are cut when stored, the prompt cuts them again
+const maxCorrectionChunkLen = 4000
+
+// loadExamples returns the latest corrections of the user for every statement in the language, the
+// filings of other users never reach their prompts. Without them the submitter still works, so
+// failures are only logged.
+func loadExamples(ctx context.Context, st store.Store, username, language string) submitter.Examples {
+	load := func(target string) []store.Correction {
+		corrections, err := st.ListCorrections(ctx, username, language, target, submitter.MAX_EXAMPLES)
+		if err != nil {
+			logger.Log.Warn("Failed to list corrections", zap.Error(err), zap.String("language", language), zap.String("target", target))
+			return nil
+		}
+		return corrections
+	}
+
+	return submitter.Examples{
+		Balance:  load("balance"),
+		Income:   load("income"),
+		CashFlow: load("cash_flow"),
+	}
+}
+
+// recordCorrections keeps the fields the user changed in an extracted period, one correction per statement,
+// with the statement text of the submission. Only the first edit is recorded, later ones don't correct
+// the model anymore. Failures are only logged.
+func recordCorrections(ctx context.Context, st store.Store, s *s3.Client, username string, before, after store.Finance) {
+	if before.SourceDocument == "" || before.Edited {
+		return
+	}
+
+	beforeValues := financeValues(before)
+	afterValues := financeValues(after)
+
+	changed := make(map[string][]store.CorrectedField)
+	for target, fields := range submitter.StatementFields {
+		for _, field := range fields {
+			if equalValues(beforeValues[field], afterValues[field]) {
+				continue
+			}
+			changed[target] = append(changed[target], store.CorrectedField{
+				Field:  field,
+				Label:  before.Provenance[field].Label,
+				Before: beforeValues[field],
+				After:  afterValues[field],
+			})
+		}
+	}
+
+	if len(changed) == 0 {
+		return
+	}
+
+	doc, language, err := getS3Document(ctx, s, before.SourceDocument)
+	if err != nil {
+		logger.Log.Warn("Failed to get submission of corrected period", zap.Error(err), zap.String("S3 file", before.SourceDocument))
+		return
+	}
+
+	for target, fields := range changed {
+		chunk := statementChunk(doc, target)
+		if chunk == "" {
+			continue
+		}
+
+		err := st.PutCorrection(ctx, username, store.Correction{
+			Language:  language,
+			Target:    target,
+			CreatedAt: time.Now().Unix(),
+			Period:    fmt.Sprintf("%d-%s", before.Year, before.Period),
+			Chunk:     chunk,
+			Fields:    fields,
+		})
+		if err != nil {
+			logger.Log.Warn("Failed to put correction", zap.Error(err), zap.String("target", target))
+		}
+	}
+}
+
+func getS3Document(ctx context.Context, s *s3.Client, key string) (submitter.S3Document, string, error) {
+	out, err := s.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(S3_OUTPUTS_BUCKET),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return submitter.S3Document{}, "", err
+	}
+	defer out.Body.Close()
+
+	compressed, err := io.ReadAll(out.Body)
+	if err != nil {
+		return submitter.S3Document{}, "", err
+	}
+
+	// the SDK may have decoded the gzip content encoding already
+	data := compressed
+	if gzipReader, err := gzip.NewReader(bytes.NewReader(compressed)); err == nil {
+		data, err = io.ReadAll(gzipReader)
+		if err != nil {
+			return submitter.S3Document{}, "", err
+		}
+	}
+
+	var doc submitter.S3Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return submitter.S3Document{}, "", err
+	}
+
+	language := doc.Language
+	if language == "" {
+		language = out.Metadata["language"]
+	}
+	return doc, language, nil
+}
+
+// statementChunk returns the cleaned text the submitter read, or the raw chunk if the prompt is missing
+func statementChunk(doc submitter.S3Document, target string) string {
+	var section submitter.S3StatementSection
+	switch target {
+	case "balance":
+		section = doc.Balance
+	case "income":
+		section = doc.Income
+	default:
+		section = doc.CashFlow
+	}
+
+	chunk := section.SubmitterContent
+	if chunk == "" {
+		// documents stored before the prompt texts were kept
+		chunk = section.RawContent
+		if _, text, found := strings.Cut(section.SubmitterPrompt, "\nThis is the text:\n"); found {
+			chunk = text
+		}
+	}
+
+	chunk = strings.TrimSpace(chunk)
+	if runes := []rune(chunk); len(runes) > maxCorrectionChunkLen {
+		chunk = string(runes[:maxCorrectionChunkLen])
+	}
+	return chunk
+}
+
+func financeValues(f store.Finance) map[string]*float64 {
+	toFloat := func(v *int64) *float64 {
+		if v == nil {
+			return nil
+		}
+		value := float64(*v)
+		return &value
+	}
+
+	return map[string]*float64{
+		"cash_and_equivalents":      toFloat(f.Cash),
+		"current_assets":            toFloat(f.CurrentAssets),
+		"non_current_assets":        toFloat(f.NonCurrentAssets),
+		"current_liabilities":       toFloat(f.CurrentLiabilities),
+		"non_current_liabilities":   toFloat(f.NonCurrentLiabilities),
+		"revenue":                   toFloat(f.Revenue),
+		"net_income":                toFloat(f.NetIncome),
+		"eps":                       f.EPS,
+		"cash_flow_from_operations": toFloat(f.CashFlowFromOperations),
+		"cash_flow_from_investing":  toFloat(f.CashFlowFromInvesting),
+		"cash_flow_from_financing":  toFloat(f.CashFlowFromFinancing),
+	}
+}
+
+func equalValues(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"nodofinance/store"
+
+	"github.com/patrickmn/go-cache"
+)
+
+func TestDelete(t *testing.T) {
+	st := newMemoryStore(t, 0)
+	putPeriod(t, st, "AAPL", 2023, "Y", 100)
+	putPeriod(t, st, "AAPL", 2024, "Y", 110)
+	dataCache := cache.New(cache.NoExpiration, 0)
+
+	del := func(target string) int {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		Delete(w, newRequest(t, http.MethodDelete, target, "", testUser), st, dataCache)
+		return w.Code
+	}
+
+	dataCache.Set("tickers_"+testUser, []string{"AAPL"}, cache.NoExpiration)
+	if code := del("/delete?ticker=AAPL&period=2024-Y"); code != http.StatusOK {
+		t.Fatalf("Delete() status = %d, want %d", code, http.StatusOK)
+	}
+	if _, found := dataCache.Get("tickers_" + testUser); found {
+		t.Error("tickers of the user still cached after a delete")
+	}
+	if _, err := st.GetTicker(context.Background(), testUser, "AAPL"); err != nil {
+		t.Errorf("GetTicker() with a period left = %v, want the ticker", err)
+	}
+
+	if code := del("/delete?ticker=AAPL&period=2024-Y"); code != http.StatusNotFound {
+		t.Errorf("Delete() of a deleted period status = %d, want %d", code, http.StatusNotFound)
+	}
+	if code := del("/delete?ticker=AAPL&period=2024-Q9"); code != http.StatusBadRequest {
+		t.Errorf("Delete() of an invalid period status = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// the last period takes the ticker with it
+	if code := del("/delete?ticker=AAPL&period=2023-Y"); code != http.StatusOK {
+		t.Fatalf("Delete() of the last period status = %d, want %d", code, http.StatusOK)
+	}
+	if _, err := st.GetTicker(context.Background(), testUser, "AAPL"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetTicker() without periods = %v, want %v", err, store.ErrNotFound)
+	}
+}
@@ -0,0 +1,360 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"nodofinance/routes/app/submitter"
+	"nodofinance/store"
+	"nodofinance/utils/jwt"
+	"nodofinance/utils/logger"
+	"nodofinance/utils/sanitize"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/patrickmn/go-cache"
+	"go.uber.org/zap"
+)
+
+// Req
+type ApproveDraftReq struct {
+	Ticker string `json:"ticker"`
+	Period string `json:"period"`
+	// Period -> financial data, same shape as new_financial_data of Edit. Corrected periods are stored as edited
+	Corrections map[string]map[string]any `json:"corrections,omitempty"`
+}
+
+// Res
+type DraftRes struct {
+	Ticker    string           `json:"ticker"`
+	Period    string           `json:"period"`
+	Currency  string           `json:"currency"`
+	ExpiresAt int64            `json:"expires_at"`
+	Periods   []DraftPeriodRes `json:"periods"`
+}
+
+type DraftPeriodRes struct {
+	Period        string                  `json:"period"`
+	FinancialData submitter.Postprocessed `json:"financial_data"`
+	LowConfidence []string                `json:"low_confidence"`
+	Anomalies     []store.Anomaly         `json:"anomalies"`
+	Provenance    map[string]store.Source `json:"provenance"`
+}
+
+type ApproveDraftRes struct {
+	Ticker   string   `json:"ticker"`
+	Approved []string `json:"approved"`
+	// Comparatives edited or left out by MAX_PERIODS since the draft was made
+	Skipped []string `json:"skipped"`
+}
+
+// GetDraft returns a draft waiting for review, with the anomalies of every period
+func GetDraft(w http.ResponseWriter, r *http.Request, st store.Store) {
+	ctx := r.Context()
+
+	ticker := sanitize.Trim(r.URL.Query().Get("ticker"), "u")
+	fullPeriod := sanitize.Trim(r.URL.Query().Get("period"), "u")
+
+	if ticker == "" || fullPeriod == "" {
+		logger.Log.Error("Ticker or period is empty")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !sanitize.Ticker(ticker) || !sanitize.Period(fullPeriod) {
+		logger.Log.Error("Invalid ticker or period", zap.String("ticker", ticker), zap.String("period", fullPeriod))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	idTokenCookie, errIT := r.Cookie("nodo_id_token")
+	if errIT != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	idClaims, err := jwt.GetTokenClaims(idTokenCookie.Value)
+	if err != nil {
+		logger.Log.Error("Failed to get token claims", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	username, exists := idClaims["cognito:username"].(string)
+	if !exists {
+		logger.Log.Error("Failed to get username from token claims")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	d, err := st.GetDraft(ctx, username, ticker, fullPeriod)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		logger.Log.Error("Failed to get draft", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp := DraftRes{
+		Ticker:    d.Ticker,
+		Period:    d.Period,
+		Currency:  d.Currency,
+		ExpiresAt: d.ExpiresAt,
+		Periods:   make([]DraftPeriodRes, 0, len(d.Periods)),
+	}
+	for _, p := range d.Periods {
+		period := DraftPeriodRes{
+			Period:        fmt.Sprintf("%d-%s", p.Year, p.Period),
+			FinancialData: financeToPostprocessed(p.Finance),
+			LowConfidence: p.Finance.LowConfidence,
+			Anomalies:     p.Anomalies,
+			Provenance:    p.Finance.Provenance,
+		}
+		if period.LowConfidence == nil {
+			period.LowConfidence = []string{}
+		}
+		if period.Anomalies == nil {
+			period.Anomalies = []store.Anomaly{}
+		}
+		resp.Periods = append(resp.Periods, period)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Log.Error("Error encoding response", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// ApproveDraft promotes a draft, with the user's corrections, into FINANCE# items and deletes it.
+// Tokens were billed when the draft was made.
+func ApproveDraft(w http.ResponseWriter, r *http.Request, st store.Store, s *s3.Client, dataCache *cache.Cache) {
+	ctx := r.Context()
+
+	idTokenCookie, errIT := r.Cookie("nodo_id_token")
+	if errIT != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	idClaims, err := jwt.GetTokenClaims(idTokenCookie.Value)
+	if err != nil {
+		logger.Log.Error("Failed to get token claims", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	username, exists := idClaims["cognito:username"].(string)
+	if !exists {
+		logger.Log.Error("Failed to get username from token claims")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// 1. Request body
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Log.Error("Failed to read request body", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+
+	var req ApproveDraftReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		logger.Log.Error("Failed to unmarshal request body", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ticker := sanitize.Trim(req.Ticker, "u")
+	fullPeriod := sanitize.Trim(req.Period, "u")
+
+	if !sanitize.Ticker(ticker) || !sanitize.Period(fullPeriod) {
+		logger.Log.Error("Invalid ticker or period", zap.String("ticker", ticker), zap.String("period", fullPeriod))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for period, financialData := range req.Corrections {
+		if financialData == nil || !sanitize.FinancialData(financialData) {
+			logger.Log.Error("Invalid correction", zap.String("period", period), zap.Any("financial_data", financialData))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 2. Draft
+	d, err := st.GetDraft(ctx, username, ticker, fullPeriod)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		logger.Log.Error("Failed to get draft", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	drafted := make(map[string]bool, len(d.Periods))
+	comparatives := make([]string, 0, len(d.Periods))
+	for _, p := range d.Periods {
+		period := fmt.Sprintf("%d-%s", p.Year, p.Period)
+		drafted[period] = true
+		if period != d.Period {
+			comparatives = append(comparatives, period)
+		}
+	}
+
+	for period := range req.Corrections {
+		if !drafted[sanitize.Trim(period, "u")] {
+			logger.Log.Error("Correction of a period not in the draft", zap.String("period", period))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 3. Consumption limits, the periods may have changed since the draft was made
+	existing, err := st.ListFinancePeriods(ctx, username, ticker)
+	if err != nil {
+		logger.Log.Error("Failed to list finance periods", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if len(existing) == 0 {
+		tickersCount, err := st.CountTickers(ctx, username)
+		if err != nil {
+			logger.Log.Error("Failed to count tickers", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if tickersCount >= int(MAX_TICKERS) {
+			http.Error(w, fmt.Sprintf("Max %d tickers", MAX_TICKERS), http.StatusForbidden)
+			return
+		}
+	}
+
+	requestedStored := false
+	for _, f := range existing {
+		if fmt.Sprintf("%d-%s", f.Year, f.Period) == d.Period {
+			requestedStored = true
+		}
+	}
+	if !requestedStored && len(existing) >= int(MAX_PERIODS) {
+		http.Error(w, fmt.Sprintf("Max %d periods per ticker", MAX_PERIODS), http.StatusForbidden)
+		return
+	}
+
+	storable, err := storablePeriods(ctx, st, username, ticker, d.Period, comparatives)
+	if err != nil {
+		logger.Log.Error("Failed to check stored periods", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// 4. Corrections
+	resp := ApproveDraftRes{Ticker: ticker, Approved: []string{}, Skipped: []string{}}
+	corrections := make(map[string]map[string]any, len(req.Corrections))
+	for period, financialData := range req.Corrections {
+		corrections[sanitize.Trim(period, "u")] = financialData
+	}
+
+	var finances []store.Finance
+	var reviewed [][2]store.Finance // before, after
+	for _, p := range d.Periods {
+		period := fmt.Sprintf("%d-%s", p.Year, p.Period)
+		if period != d.Period && !storable[period] {
+			resp.Skipped = append(resp.Skipped, period)
+			continue
+		}
+
+		f := p.Finance
+		if financialData, corrected := corrections[period]; corrected {
+			// reviewed by the user, like an Edit
+			f = financeFromFinancialData(ticker, p.Year, p.Period, financialData)
+			f.Edited = true
+			f.Provenance = p.Finance.Provenance
+			f.SourceDocument = p.Finance.SourceDocument
+			f.PromptVersion = p.Finance.PromptVersion
+			reviewed = append(reviewed, [2]store.Finance{p.Finance, f})
+		}
+
+		finances = append(finances, f)
+		resp.Approved = append(resp.Approved, period)
+	}
+
+	// 5. DynamoDB
+	err = st.PromoteDraft(ctx, username, d, finances)
+	if err != nil {
+		if errors.Is(err, store.ErrConditionFailed) {
+			logger.Log.Warn("Period edited meanwhile", zap.String("ticker", ticker))
+			http.Error(w, "A period was edited meanwhile, try again", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			logger.Log.Warn("Draft expired or already approved", zap.String("ticker", ticker), zap.String("period", fullPeriod))
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		logger.Log.Error("Transaction failed", zap.Error(err), zap.String("username", username), zap.String("ticker", ticker), zap.String("period", fullPeriod))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	cacheKey := "tickers_" + username
+	dataCache.Delete(cacheKey)
+
+	for _, pair := range reviewed {
+		recordCorrections(ctx, st, s, username, pair[0], pair[1])
+	}
+
+	logger.Log.Info("User approved draft", zap.String("username", username), zap.String("ticker", ticker), zap.String("period", fullPeriod), zap.Int("corrections", len(corrections)))
+
+	// 6. Response
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Log.Error("Error encoding response", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// newDraft keeps the periods of a submission, with their validation warnings, for DRAFT_TTL
+func newDraft(ticker, period, currency string, finances []store.Finance, validations []submitter.Validation) store.Draft {
+	now := time.Now()
+
+	d := store.Draft{
+		Ticker:    ticker,
+		Period:    period,
+		Currency:  currency,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(DRAFT_TTL).Unix(),
+		Periods:   make([]store.DraftPeriod, 0, len(finances)),
+	}
+
+	for i, f := range finances {
+		var anomalies []store.Anomaly
+		for _, warning := range validations[i].Warnings {
+			anomalies = append(anomalies, store.Anomaly{Code: warning.Code, Fields: warning.Fields, Message: warning.Message})
+		}
+
+		d.Periods = append(d.Periods, store.DraftPeriod{
+			Finance:   f,
+			Year:      f.Year,
+			Period:    f.Period,
+			Anomalies: anomalies,
+		})
+	}
+
+	return d
+}
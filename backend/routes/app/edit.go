@@ -3,18 +3,15 @@ package app
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
+	"nodofinance/store"
 	"nodofinance/utils/jwt"
 	"nodofinance/utils/logger"
 	"nodofinance/utils/sanitize"
 	"strconv"
-	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"go.uber.org/zap"
 )
 
@@ -24,7 +21,7 @@ type EditReq struct {
 	NewFinancialData map[string]any `json:"new_financial_data"`
 }
 
-func Edit(w http.ResponseWriter, r *http.Request, d *dynamodb.Client) {
+func Edit(w http.ResponseWriter, r *http.Request, st store.Store, s *s3.Client) {
 	ctx := r.Context()
 
 	idTokenCookie, errIT := r.Cookie("nodo_id_token")
@@ -75,13 +72,12 @@ func Edit(w http.ResponseWriter, r *http.Request, d *dynamodb.Client) {
 		return
 	}
 
-	year, err := strconv.Atoi(fullPeriod[:4])
+	year, periodType, err := splitPeriod(fullPeriod)
 	if err != nil {
-		logger.Log.Error("Failed to parse year from period", zap.Error(err))
+		logger.Log.Error("Failed to parse period", zap.Error(err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	periodType := fullPeriod[5:]
 
 	if financialData == nil {
 		logger.Log.Error("Financial data is nil")
@@ -95,6 +91,36 @@ func Edit(w http.ResponseWriter, r *http.Request, d *dynamodb.Client) {
 		return
 	}
 
+	updated := financeFromFinancialData(ticker, year, periodType, financialData)
+
+	// Extracted values, to learn from the correction
+	before, errBefore := st.GetFinancePeriod(ctx, username, ticker, year, periodType)
+
+	err = st.UpdateFinancePeriod(ctx, username, updated)
+	if err != nil {
+		// Check if it's a condition check failure (record doesn't exist)
+		if errors.Is(err, store.ErrNotFound) {
+			logger.Log.Warn("No rows affected, possibly invalid ticker or period", zap.String("ticker", ticker), zap.String("period", fullPeriod))
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		logger.Log.Error("Failed to update financial data", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if errBefore == nil {
+		recordCorrections(ctx, st, s, username, before, updated)
+	}
+
+	logger.Log.Info("User edited result", zap.String("username", username), zap.String("ticker", ticker), zap.String("period", fullPeriod))
+	w.WriteHeader(http.StatusOK)
+}
+
+// financeFromFinancialData converts sanitized financial data from the client into a period.
+// Fields missing or not parseable are nil.
+func financeFromFinancialData(ticker string, year int, periodType string, financialData map[string]any) store.Finance {
 	// Process financial data
 	newFinancialDataInt := make(map[string]*int64)
 	var eps *float64
@@ -164,97 +190,21 @@ func Edit(w http.ResponseWriter, r *http.Request, d *dynamodb.Client) {
 		}
 	}
 
-	financeSK, err := buildFinanceSortKey(ticker, year, periodType)
-	if err != nil {
-		logger.Log.Error("Failed to build finance sort key", zap.Error(err))
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	var setExpressions []string
-	var removeExpressions []string
-	expressionAttributeValues := make(map[string]dynamoTypes.AttributeValue)
-
-	// Helper function to add update expression
-	addUpdateExpression := func(field string, value interface{}) {
-		if value == nil {
-			// Remove attribute if nil
-			removeExpressions = append(removeExpressions, field)
-		} else {
-			placeholder := fmt.Sprintf(":val_%s", field)
-			setExpressions = append(setExpressions, fmt.Sprintf("%s = %s", field, placeholder))
-			switch v := value.(type) {
-			case *int64:
-				if v != nil {
-					expressionAttributeValues[placeholder] = &dynamoTypes.AttributeValueMemberN{
-						Value: strconv.FormatInt(*v, 10),
-					}
-				}
-			case *float64:
-				if v != nil {
-					expressionAttributeValues[placeholder] = &dynamoTypes.AttributeValueMemberN{
-						Value: fmt.Sprintf("%.4f", *v),
-					}
-				}
-			}
-		}
-	}
-
-	// Add all financial fields
-	addUpdateExpression("current_assets", newFinancialDataInt["current_assets"])
-	addUpdateExpression("non_current_assets", newFinancialDataInt["non_current_assets"])
-	addUpdateExpression("eps", eps)
-	addUpdateExpression("cash_and_equivalents", newFinancialDataInt["cash_and_equivalents"])
-	addUpdateExpression("cash_flow_from_financing", newFinancialDataInt["cash_flow_from_financing"])
-	addUpdateExpression("cash_flow_from_investing", newFinancialDataInt["cash_flow_from_investing"])
-	addUpdateExpression("cash_flow_from_operations", newFinancialDataInt["cash_flow_from_operations"])
-	addUpdateExpression("revenue", newFinancialDataInt["revenue"])
-	addUpdateExpression("current_liabilities", newFinancialDataInt["current_liabilities"])
-	addUpdateExpression("non_current_liabilities", newFinancialDataInt["non_current_liabilities"])
-	addUpdateExpression("net_income", newFinancialDataInt["net_income"])
-
-	// Build proper DynamoDB UpdateExpression syntax
-	var updateParts []string
-	if len(setExpressions) > 0 {
-		updateParts = append(updateParts, "SET "+strings.Join(setExpressions, ", "))
-	}
-	if len(removeExpressions) > 0 {
-		updateParts = append(updateParts, "REMOVE "+strings.Join(removeExpressions, ", "))
-	}
-	updateExpression := strings.Join(updateParts, " ")
-
-	// Execute DynamoDB UpdateItem
-	updateInput := &dynamodb.UpdateItemInput{
-		TableName: aws.String("nodofinance_table"),
-		Key: map[string]dynamoTypes.AttributeValue{
-			"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-			"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: financeSK},
-		},
-		UpdateExpression:    aws.String(updateExpression),
-		ConditionExpression: aws.String("attribute_exists(composite_sk) AND attribute_exists(username)"),
-		ReturnValues:        dynamoTypes.ReturnValueNone,
-	}
-
-	// Only add ExpressionAttributeValues if we have any
-	if len(expressionAttributeValues) > 0 {
-		updateInput.ExpressionAttributeValues = expressionAttributeValues
-	}
-
-	_, err = d.UpdateItem(ctx, updateInput)
-	if err != nil {
-		// Check if it's a condition check failure (record doesn't exist)
-		var conditionCheckFailed *dynamoTypes.ConditionalCheckFailedException
-		if errors.As(err, &conditionCheckFailed) {
-			logger.Log.Warn("No rows affected, possibly invalid ticker or period", zap.String("ticker", ticker), zap.String("period", fullPeriod))
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-
-		logger.Log.Error("Failed to update financial data", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	// Replace all financial fields, missing ones are removed
+	return store.Finance{
+		Ticker:                 ticker,
+		Year:                   year,
+		Period:                 periodType,
+		CurrentAssets:          newFinancialDataInt["current_assets"],
+		NonCurrentAssets:       newFinancialDataInt["non_current_assets"],
+		EPS:                    eps,
+		Cash:                   newFinancialDataInt["cash_and_equivalents"],
+		CashFlowFromFinancing:  newFinancialDataInt["cash_flow_from_financing"],
+		CashFlowFromInvesting:  newFinancialDataInt["cash_flow_from_investing"],
+		CashFlowFromOperations: newFinancialDataInt["cash_flow_from_operations"],
+		Revenue:                newFinancialDataInt["revenue"],
+		CurrentLiabilities:     newFinancialDataInt["current_liabilities"],
+		NonCurrentLiabilities:  newFinancialDataInt["non_current_liabilities"],
+		NetIncome:              newFinancialDataInt["net_income"],
 	}
-
-	logger.Log.Info("User edited result", zap.String("username", username), zap.String("ticker", ticker), zap.String("period", fullPeriod))
-	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// editBody is an edit of every field, empty but for values
+func editBody(t *testing.T, ticker, period string, values map[string]any) string {
+	t.Helper()
+
+	financialData := map[string]any{
+		"current_assets": "", "non_current_assets": "", "cash_and_equivalents": "",
+		"current_liabilities": "", "non_current_liabilities": "", "revenue": "", "net_income": "", "eps": "",
+		"cash_flow_from_operations": "", "cash_flow_from_investing": "", "cash_flow_from_financing": "",
+	}
+	for field, value := range values {
+		financialData[field] = value
+	}
+
+	body, err := json.Marshal(EditReq{Ticker: ticker, Period: period, NewFinancialData: financialData})
+	if err != nil {
+		t.Fatalf("json.Marshal() = %v", err)
+	}
+	return string(body)
+}
+
+func TestEdit(t *testing.T) {
+	st := newMemoryStore(t, 0)
+	putPeriod(t, st, "AAPL", 2024, "Y", 110)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"stored period", editBody(t, "AAPL", "2024-Y", map[string]any{"revenue": "120", "net_income": 15, "eps": "1.5"}), http.StatusOK},
+		{"period not stored", editBody(t, "AAPL", "2023-Y", map[string]any{"revenue": "120"}), http.StatusNotFound},
+		{"ticker not stored", editBody(t, "MSFT", "2024-Y", map[string]any{"revenue": "120"}), http.StatusNotFound},
+		{"invalid period", editBody(t, "AAPL", "2024-X", map[string]any{"revenue": "120"}), http.StatusBadRequest},
+		{"some fields only", `{"ticker":"AAPL","period":"2024-Y","new_financial_data":{"revenue":"130"}}`, http.StatusBadRequest},
+		{"without financial data", `{"ticker":"AAPL","period":"2024-Y"}`, http.StatusBadRequest},
+		{"malformed body", `{"ticker":`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Edit(w, newRequest(t, http.MethodPost, "/edit", tt.body, testUser), st, nil)
+			if w.Code != tt.want {
+				t.Errorf("Edit() status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+
+	edited, err := st.GetFinancePeriod(context.Background(), testUser, "AAPL", 2024, "Y")
+	if err != nil {
+		t.Fatalf("GetFinancePeriod() = %v", err)
+	}
+	if !edited.Edited {
+		t.Error("edited period not marked as edited")
+	}
+	if edited.Revenue == nil || *edited.Revenue != 120 || edited.NetIncome == nil || *edited.NetIncome != 15 || edited.EPS == nil || *edited.EPS != 1.5 {
+		t.Errorf("edited period = revenue %v, net income %v, eps %v, want 120, 15, 1.5", edited.Revenue, edited.NetIncome, edited.EPS)
+	}
+	if edited.Cash != nil {
+		t.Errorf("field missing in the edit = %d, want it removed", *edited.Cash)
+	}
+}
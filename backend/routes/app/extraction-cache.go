@@ -0,0 +1,134 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"nodofinance/llm"
+	"nodofinance/prompts"
+	"nodofinance/routes/app/submitter"
+	"nodofinance/store"
+	"nodofinance/utils/logger"
+
+	"go.uber.org/zap"
+)
+
+// extractionHash covers everything the pipeline reads, so submissions with the same hash
+// get the same extraction. The few-shot examples are part of it, a correction made since
+// the last upload of the document has to reach the prompts.
+// The user is part of it: results carry their prompts, examples and tokens, they are never
+// reused across users.
+func extractionHash(sub submission, hits submitter.Hits, templates *prompts.Set, examples submitter.Examples) (string, error) {
+	h := sha256.New()
+
+	examplesData, err := json.Marshal(examples)
+	if err != nil {
+		return "", err
+	}
+	examplesDigest := sha256.Sum256(examplesData)
+
+	parts := []string{
+		sub.username,
+		templates.Version,
+		llm.MODEL_CLEANER,
+		llm.MODEL_SUBMITTER,
+		sub.period,
+		sub.language,
+		fmt.Sprintf("%d/%d/%d", sub.units.Balance, sub.units.Income, sub.units.CashFlow),
+		fmt.Sprintf("%d/%d/%d", hits.Balance, hits.Income, hits.CashFlow),
+		sub.balanceCleaned,
+		sub.incomeCleaned,
+		sub.cashFlowCleaned,
+		hex.EncodeToString(examplesDigest[:]),
+	}
+	for _, member := range sub.ensemble {
+		parts = append(parts, fmt.Sprintf("%s@%g", member.Model, member.Temperature))
+	}
+	for _, part := range parts {
+		// length prefixed, so parts can't run into each other
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// getCachedExtraction returns the result of an earlier submission with the same hash.
+// Failures are only logged, the pipeline runs again.
+func getCachedExtraction(ctx context.Context, st store.Store, hash string) (submitter.SubmitterRes, bool) {
+	if EXTRACTION_CACHE_TTL == 0 {
+		return submitter.SubmitterRes{}, false
+	}
+
+	e, err := st.GetExtraction(ctx, hash)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			logger.Log.Warn("Failed to get cached extraction", zap.Error(err), zap.String("hash", hash))
+		}
+		return submitter.SubmitterRes{}, false
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(e.Result))
+	if err != nil {
+		logger.Log.Warn("Failed to read cached extraction", zap.Error(err), zap.String("hash", hash))
+		return submitter.SubmitterRes{}, false
+	}
+
+	data, err := io.ReadAll(gzipReader)
+	if err != nil {
+		logger.Log.Warn("Failed to read cached extraction", zap.Error(err), zap.String("hash", hash))
+		return submitter.SubmitterRes{}, false
+	}
+
+	var res submitter.SubmitterRes
+	if err := json.Unmarshal(data, &res); err != nil || len(res.Periods) == 0 {
+		logger.Log.Warn("Invalid cached extraction", zap.Error(err), zap.String("hash", hash))
+		return submitter.SubmitterRes{}, false
+	}
+
+	return res, true
+}
+
+// putCachedExtraction keeps a result for EXTRACTION_CACHE_TTL. Failures are only logged.
+func putCachedExtraction(ctx context.Context, st store.Store, hash, promptVersion string, res submitter.SubmitterRes) {
+	if EXTRACTION_CACHE_TTL == 0 {
+		return
+	}
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		logger.Log.Warn("Failed to marshal extraction", zap.Error(err))
+		return
+	}
+
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	if _, err := gzipWriter.Write(data); err != nil {
+		logger.Log.Warn("Failed to compress extraction", zap.Error(err))
+		return
+	}
+	if err := gzipWriter.Close(); err != nil {
+		logger.Log.Warn("Failed to compress extraction", zap.Error(err))
+		return
+	}
+
+	now := time.Now()
+	err = st.PutExtraction(ctx, store.Extraction{
+		Hash:          hash,
+		PromptVersion: promptVersion,
+		CreatedAt:     now.Unix(),
+		ExpiresAt:     now.Add(EXTRACTION_CACHE_TTL).Unix(),
+		Result:        compressed.Bytes(),
+	})
+	if err != nil {
+		// e.g. over the 400KB item limit of DynamoDB
+		logger.Log.Warn("Failed to cache extraction", zap.Error(err), zap.String("hash", hash), zap.Int("bytes", compressed.Len()))
+	}
+}
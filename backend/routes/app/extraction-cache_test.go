@@ -0,0 +1,48 @@
+package app
+
+import (
+	"testing"
+
+	"nodofinance/prompts"
+	"nodofinance/routes/app/submitter"
+	"nodofinance/store"
+)
+
+func TestExtractionHash(t *testing.T) {
+	sub := submission{username: testUser, period: "2024-Y", language: "EN", balanceCleaned: "Total current assets 27,800"}
+	hits := submitter.Hits{Balance: 3}
+	templates := &prompts.Set{Version: "v1"}
+	correction := store.Correction{CreatedAt: 1, Period: "2024-Y", Chunk: "Total current assets 27,800",
+		Fields: []store.CorrectedField{{Field: "current_assets"}}}
+
+	base, err := extractionHash(sub, hits, templates, submitter.Examples{})
+	if err != nil {
+		t.Fatalf("extractionHash() = %v", err)
+	}
+
+	otherUser := sub
+	otherUser.username = "other"
+
+	tests := []struct {
+		name     string
+		sub      submission
+		examples submitter.Examples
+		wantSame bool
+	}{
+		{"same document", sub, submitter.Examples{}, true},
+		{"other user", otherUser, submitter.Examples{}, false},
+		{"correction since the last upload", sub, submitter.Examples{Balance: []store.Correction{correction}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := extractionHash(tt.sub, hits, templates, tt.examples)
+			if err != nil {
+				t.Fatalf("extractionHash() = %v", err)
+			}
+			if (hash == base) != tt.wantSame {
+				t.Errorf("extractionHash() equal to the first upload = %t, want %t", hash == base, tt.wantSame)
+			}
+		})
+	}
+}
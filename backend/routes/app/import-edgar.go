@@ -0,0 +1,267 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+
+	"nodofinance/store"
+	"nodofinance/utils/jwt"
+	"nodofinance/utils/logger"
+	"nodofinance/utils/sanitize"
+	"nodofinance/xbrl"
+
+	"github.com/patrickmn/go-cache"
+	"go.uber.org/zap"
+)
+
+// Req
+type ImportEdgarReq struct {
+	Ticker string `json:"ticker"`
+	// EDGAR companyfacts file, as downloaded
+	CompanyFacts json.RawMessage `json:"company_facts"`
+}
+
+// Res
+type ImportEdgarRes struct {
+	Ticker   string   `json:"ticker"`
+	Currency string   `json:"currency"`
+	Imported []string `json:"imported"`
+	// Periods of the file left out, with the reason
+	Skipped []SkippedPeriod `json:"skipped"`
+}
+
+type SkippedPeriod struct {
+	Period string `json:"period"`
+	Reason string `json:"reason"`
+}
+
+// Reasons a period of the file is not imported
+const (
+	SKIP_REASON_EDITED      = "edited"
+	SKIP_REASON_MAX_PERIODS = "max_periods"
+	SKIP_REASON_TRANSACTION = "max_transaction_periods"
+	SKIP_REASON_CURRENCY    = "currency"
+	SKIP_REASON_NO_DATA     = "no_data"
+)
+
+// ImportEdgar backfills the 10-K and 10-Q periods of a companyfacts file in one transaction.
+// Existing periods are updated unless the user edited them, new ones are added newest first
+// while MAX_PERIODS allows. No tokens are billed.
+// When nothing is left to import, the skipped periods are answered without writing.
+func ImportEdgar(w http.ResponseWriter, r *http.Request, st store.Store, dataCache *cache.Cache) {
+	ctx := r.Context()
+
+	idTokenCookie, errIT := r.Cookie("nodo_id_token")
+	if errIT != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	idClaims, err := jwt.GetTokenClaims(idTokenCookie.Value)
+	if err != nil {
+		logger.Log.Error("Failed to get token claims", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	username, exists := idClaims["cognito:username"].(string)
+	if !exists {
+		logger.Log.Error("Failed to get username from token claims")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// 1. Request body
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Log.Error("Failed to read request body", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+
+	var req ImportEdgarReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		logger.Log.Error("Failed to unmarshal request body", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ticker := sanitize.Trim(req.Ticker, "u")
+	if !sanitize.Ticker(ticker) {
+		logger.Log.Error("Invalid ticker", zap.String("ticker", ticker))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// 2. Filings
+	filings, err := xbrl.ParseCompanyFacts(req.CompanyFacts)
+	if err != nil {
+		logger.Log.Error("Failed to parse companyfacts", zap.Error(err))
+		http.Error(w, "nodo error: Invalid file. Is it an EDGAR companyfacts file?", http.StatusBadRequest)
+		return
+	}
+
+	// 3. Consumption limits, tokens are not consumed here
+	existing, err := st.ListFinancePeriods(ctx, username, ticker)
+	if err != nil {
+		logger.Log.Error("Failed to list finance periods", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if len(existing) == 0 {
+		tickersCount, err := st.CountTickers(ctx, username)
+		if err != nil {
+			logger.Log.Error("Failed to count tickers", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if tickersCount >= int(MAX_TICKERS) {
+			http.Error(w, fmt.Sprintf("Max %d tickers", MAX_TICKERS), http.StatusForbidden)
+			return
+		}
+	}
+
+	// The currency of a stored ticker is kept, a new ticker takes the one of the newest filing
+	currency := ""
+	if len(existing) > 0 {
+		t, err := st.GetTicker(ctx, username, ticker)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			logger.Log.Error("Failed to get ticker", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if sanitize.Currency(t.Currency) && t.Currency != "ND" {
+			currency = t.Currency
+		}
+	}
+	if currency == "" {
+		currency = filings[0].Document.Currency()
+	}
+	if !sanitize.Currency(currency) {
+		currency = "ND"
+	}
+
+	stored := make(map[string]bool, len(existing))
+	edited := make(map[string]bool, len(existing))
+	for _, f := range existing {
+		stored[fmt.Sprintf("%d-%s", f.Year, f.Period)] = true
+		edited[fmt.Sprintf("%d-%s", f.Year, f.Period)] = f.Edited
+	}
+	freeSlots := int(MAX_PERIODS) - len(existing)
+
+	// 4. Postprocessor, same constraints and fallbacks as the model output
+	resp := ImportEdgarRes{
+		Ticker:   ticker,
+		Currency: currency,
+		Imported: []string{},
+		Skipped:  []SkippedPeriod{},
+	}
+	var finances []store.Finance
+	skip := func(period, reason string) {
+		resp.Skipped = append(resp.Skipped, SkippedPeriod{Period: period, Reason: reason})
+	}
+
+	for _, filing := range filings {
+		period := filing.Period.String()
+
+		if edited[period] {
+			skip(period, SKIP_REASON_EDITED)
+			continue
+		}
+
+		documentCurrency := filing.Document.Currency()
+		if currency != "ND" && documentCurrency != currency {
+			skip(period, SKIP_REASON_CURRENCY)
+			continue
+		}
+
+		postprocessedResult, err := postprocessXBRL(filing.Document.Statements(filing.Period, documentCurrency))
+		if err != nil {
+			logger.Log.Error("Failed to postprocess financial data", zap.Error(err), zap.String("accession", filing.Accession))
+			skip(period, SKIP_REASON_NO_DATA)
+			continue
+		}
+
+		if reflect.ValueOf(postprocessedResult).IsZero() {
+			skip(period, SKIP_REASON_NO_DATA)
+			continue
+		}
+
+		if !stored[period] && freeSlots <= 0 {
+			skip(period, SKIP_REASON_MAX_PERIODS)
+			continue
+		}
+		if len(finances) >= store.MaxTransactionPeriods {
+			skip(period, SKIP_REASON_TRANSACTION)
+			continue
+		}
+
+		if !stored[period] {
+			freeSlots--
+		}
+		finances = append(finances, postprocessedToFinance(ticker, filing.Period.Year, filing.Period.Type, postprocessedResult))
+		resp.Imported = append(resp.Imported, period)
+	}
+
+	if len(finances) == 0 {
+		logger.Log.Info("No periods to import", zap.String("ticker", ticker), zap.Int("skipped", len(resp.Skipped)))
+
+		noData := true
+		for _, s := range resp.Skipped {
+			if s.Reason == SKIP_REASON_MAX_PERIODS {
+				http.Error(w, fmt.Sprintf("Max %d periods per ticker", MAX_PERIODS), http.StatusForbidden)
+				return
+			}
+			noData = noData && s.Reason == SKIP_REASON_NO_DATA
+		}
+		if noData {
+			http.Error(w, "nodo error: No 10-K or 10-Q financial data found.", http.StatusBadRequest)
+			return
+		}
+
+		// Edited or in another currency, nothing to write
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logger.Log.Error("Error encoding response", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// 5. DynamoDB
+	err = st.PutFinancePeriods(ctx, username, ticker, finances, currency, 0)
+	if err != nil {
+		if errors.Is(err, store.ErrConditionFailed) {
+			logger.Log.Warn("Period edited meanwhile", zap.String("ticker", ticker))
+			http.Error(w, "A period was edited meanwhile, try again", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			logger.Log.Error("User does not exist", zap.String("username", username))
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		logger.Log.Error("Transaction failed", zap.Error(err), zap.String("username", username), zap.String("ticker", ticker), zap.Int("periods", len(finances)))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	cacheKey := "tickers_" + username
+	dataCache.Delete(cacheKey)
+
+	// 6. Response
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Log.Error("Error encoding response", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,206 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"nodofinance/store"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// edgarFiling is a 10-K of a companyfacts file, with its balance and income in unit
+type edgarFiling struct {
+	year    int
+	unit    string
+	assets  float64
+	revenue float64
+}
+
+// companyFactsBody is the request of ImportEdgar for ticker with the 10-Ks of filings
+func companyFactsBody(t *testing.T, ticker string, filings ...edgarFiling) string {
+	t.Helper()
+
+	type fact struct {
+		Start string  `json:"start,omitempty"`
+		End   string  `json:"end"`
+		Val   float64 `json:"val"`
+		Accn  string  `json:"accn"`
+		FY    int     `json:"fy"`
+		FP    string  `json:"fp"`
+		Form  string  `json:"form"`
+		Filed string  `json:"filed"`
+	}
+
+	assets := make(map[string][]fact)
+	revenues := make(map[string][]fact)
+	for _, f := range filings {
+		accn := fmt.Sprintf("0000320193-%d-000001", f.year-2000)
+		end := fmt.Sprintf("%d-12-31", f.year)
+		filed := fmt.Sprintf("%d-02-15", f.year+1)
+
+		assets[f.unit] = append(assets[f.unit], fact{End: end, Val: f.assets, Accn: accn, FY: f.year, FP: "FY", Form: "10-K", Filed: filed})
+		revenues[f.unit] = append(revenues[f.unit], fact{Start: fmt.Sprintf("%d-01-01", f.year), End: end, Val: f.revenue, Accn: accn, FY: f.year, FP: "FY", Form: "10-K", Filed: filed})
+	}
+
+	companyFacts, err := json.Marshal(map[string]any{
+		"facts": map[string]any{
+			"us-gaap": map[string]any{
+				"Assets":   map[string]any{"units": assets},
+				"Revenues": map[string]any{"units": revenues},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Marshal() = %v", err)
+	}
+
+	body, err := json.Marshal(ImportEdgarReq{Ticker: ticker, CompanyFacts: companyFacts})
+	if err != nil {
+		t.Fatalf("Marshal() = %v", err)
+	}
+	return string(body)
+}
+
+func TestImportEdgar(t *testing.T) {
+	setLimits(t, 1000, 3, 2)
+
+	filings := []edgarFiling{
+		{year: 2024, unit: "USD", assets: 300e6, revenue: 120e6},
+		{year: 2023, unit: "USD", assets: 280e6, revenue: 100e6},
+	}
+
+	tests := []struct {
+		name         string
+		setup        func(t *testing.T, st *store.Memory)
+		filings      []edgarFiling
+		wantStatus   int
+		wantImported []string
+		wantSkipped  []SkippedPeriod
+		wantCurrency string
+	}{
+		{
+			name:         "new ticker",
+			filings:      filings,
+			wantStatus:   http.StatusOK,
+			wantImported: []string{"2024-Y", "2023-Y"},
+			wantSkipped:  []SkippedPeriod{},
+			wantCurrency: "USD",
+		},
+		{
+			name: "edited period",
+			setup: func(t *testing.T, st *store.Memory) {
+				revenue := int64(90)
+				f := store.Finance{Ticker: "AAPL", Year: 2023, Period: "Y", Revenue: &revenue, Edited: true}
+				if err := st.PutFinancePeriod(context.Background(), testUser, f, "USD", 0); err != nil {
+					t.Fatalf("PutFinancePeriod() = %v", err)
+				}
+			},
+			filings:      filings,
+			wantStatus:   http.StatusOK,
+			wantImported: []string{"2024-Y"},
+			wantSkipped:  []SkippedPeriod{{"2023-Y", SKIP_REASON_EDITED}},
+			wantCurrency: "USD",
+		},
+		{
+			name:         "filing in another currency than the newest",
+			filings:      []edgarFiling{filings[0], {year: 2023, unit: "EUR", assets: 250e6, revenue: 90e6}},
+			wantStatus:   http.StatusOK,
+			wantImported: []string{"2024-Y"},
+			wantSkipped:  []SkippedPeriod{{"2023-Y", SKIP_REASON_CURRENCY}},
+			wantCurrency: "USD",
+		},
+		{
+			name: "stored ticker keeps its currency",
+			setup: func(t *testing.T, st *store.Memory) {
+				putPeriod(t, st, "AAPL", 2022, "Y", 80)
+			},
+			filings:      []edgarFiling{{year: 2024, unit: "EUR", assets: 300e6, revenue: 120e6}, filings[1]},
+			wantStatus:   http.StatusOK,
+			wantImported: []string{"2023-Y"},
+			wantSkipped:  []SkippedPeriod{{"2024-Y", SKIP_REASON_CURRENCY}},
+			wantCurrency: "USD",
+		},
+		{
+			name: "every period edited",
+			setup: func(t *testing.T, st *store.Memory) {
+				for _, year := range []int{2023, 2024} {
+					revenue := int64(90)
+					f := store.Finance{Ticker: "AAPL", Year: year, Period: "Y", Revenue: &revenue, Edited: true}
+					if err := st.PutFinancePeriod(context.Background(), testUser, f, "USD", 0); err != nil {
+						t.Fatalf("PutFinancePeriod() = %v", err)
+					}
+				}
+			},
+			filings:      filings,
+			wantStatus:   http.StatusOK,
+			wantImported: []string{},
+			wantSkipped:  []SkippedPeriod{{"2024-Y", SKIP_REASON_EDITED}, {"2023-Y", SKIP_REASON_EDITED}},
+			wantCurrency: "USD",
+		},
+		{
+			name: "periods of the ticker used up",
+			setup: func(t *testing.T, st *store.Memory) {
+				putPeriod(t, st, "AAPL", 2020, "Y", 60)
+				putPeriod(t, st, "AAPL", 2021, "Y", 70)
+				putPeriod(t, st, "AAPL", 2022, "Y", 80)
+			},
+			filings:    filings,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "tickers used up",
+			setup: func(t *testing.T, st *store.Memory) {
+				putPeriod(t, st, "MSFT", 2024, "Y", 100)
+				putPeriod(t, st, "GOOG", 2024, "Y", 100)
+			},
+			filings:    filings,
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newMemoryStore(t, 0)
+			if tt.setup != nil {
+				tt.setup(t, st)
+			}
+
+			w := httptest.NewRecorder()
+			r := newRequest(t, http.MethodPost, "/import-edgar", companyFactsBody(t, "AAPL", tt.filings...), testUser)
+			ImportEdgar(w, r, st, cache.New(0, 0))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("ImportEdgar() status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var res ImportEdgarRes
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if !reflect.DeepEqual(res.Imported, tt.wantImported) {
+				t.Errorf("imported = %v, want %v", res.Imported, tt.wantImported)
+			}
+			if !reflect.DeepEqual(res.Skipped, tt.wantSkipped) {
+				t.Errorf("skipped = %v, want %v", res.Skipped, tt.wantSkipped)
+			}
+
+			// the currency answered is the one stored, untouched when nothing is written
+			tk, err := st.GetTicker(context.Background(), testUser, "AAPL")
+			if err != nil {
+				t.Fatalf("GetTicker() = %v", err)
+			}
+			if res.Currency != tt.wantCurrency || tk.Currency != tt.wantCurrency {
+				t.Errorf("currency = %q, stored %q, want %q", res.Currency, tk.Currency, tt.wantCurrency)
+			}
+		})
+	}
+}
@@ -2,16 +2,15 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
+	"nodofinance/store"
 	"nodofinance/utils/jwt"
 	"nodofinance/utils/logger"
 	"nodofinance/utils/sanitize"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"go.uber.org/zap"
 )
 
@@ -41,14 +40,19 @@ type FinancialData struct {
 }
 
 type Response struct {
-	Currency      string        `json:"currency,omitempty"`
-	Analysis      string        `json:"analysis,omitempty"`
-	Period        string        `json:"period,omitempty"`
-	FinancialData FinancialData `json:"financial_data,omitempty"`
-	Cursor        string        `json:"cursor,omitempty"`
+	Currency string `json:"currency,omitempty"`
+	Analysis string `json:"analysis,omitempty"`
+	// Insights is the structured part of the analysis, for charts
+	Insights      *store.Insights `json:"insights,omitempty"`
+	Period        string          `json:"period,omitempty"`
+	FinancialData FinancialData   `json:"financial_data,omitempty"`
+	Cursor        string          `json:"cursor,omitempty"`
+	// Fields scored below submitter.LOW_CONFIDENCE and why, until the user edits the period
+	LowConfidence []string `json:"low_confidence,omitempty"`
+	Warnings      []string `json:"warnings,omitempty"`
 }
 
-func MountTicker(w http.ResponseWriter, r *http.Request, d *dynamodb.Client) {
+func MountTicker(w http.ResponseWriter, r *http.Request, st store.Store) {
 	ctx := r.Context()
 
 	ticker := sanitize.Trim(r.URL.Query().Get("ticker"), "u")
@@ -96,143 +100,64 @@ func MountTicker(w http.ResponseWriter, r *http.Request, d *dynamodb.Client) {
 		return
 	}
 
-	// Build query for financial data
-	var queryInput *dynamodb.QueryInput
-
-	if !rWithCursor {
-		queryInput = &dynamodb.QueryInput{
-			TableName:              aws.String("nodofinance_table"),
-			KeyConditionExpression: aws.String("username = :username AND begins_with(composite_sk, :sk_prefix)"),
-			ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
-				":username":  &dynamoTypes.AttributeValueMemberS{Value: username},
-				":sk_prefix": &dynamoTypes.AttributeValueMemberS{Value: fmt.Sprintf("FINANCE#%s#", ticker)},
-			},
-			Limit: aws.Int32(1), // Get current + next for pagination
-		}
-	} else {
-		exclusiveStartKey, err := parseCursor(cursor)
-		if err != nil {
-			logger.Log.Error("Invalid cursor", zap.Error(err))
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
-		queryInput = &dynamodb.QueryInput{
-			TableName:              aws.String("nodofinance_table"),
-			KeyConditionExpression: aws.String("username = :username AND begins_with(composite_sk, :sk_prefix)"),
-			ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
-				":username":  &dynamoTypes.AttributeValueMemberS{Value: username},
-				":sk_prefix": &dynamoTypes.AttributeValueMemberS{Value: fmt.Sprintf("FINANCE#%s#", ticker)},
-			},
-			ExclusiveStartKey: exclusiveStartKey,
-			Limit:             aws.Int32(1),
-		}
+	// Current period + cursor of the next one
+	records, nextCursor, err := st.PageFinancePeriods(ctx, username, ticker, cursor, 1)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		logger.Log.Warn("Invalid cursor", zap.Error(err), zap.String("username", username))
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
-	result, err := d.Query(ctx, queryInput)
 	if err != nil {
 		logger.Log.Error("Error querying financial data", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	if len(result.Items) == 0 {
+	if len(records) == 0 {
 		logger.Log.Error("Empty result set mounting ticker", zap.String("ticker", ticker), zap.String("username", username))
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
 	// Process current record
-	currentRecord := result.Items[0]
-
-	compositeSKAttr, exists := currentRecord["composite_sk"]
-	if !exists {
-		logger.Log.Error("Missing composite_sk in financial record")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	compositeSKMember, ok := compositeSKAttr.(*dynamoTypes.AttributeValueMemberS)
-	if !ok {
-		logger.Log.Error("Invalid composite_sk type in financial record")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	year, periodType, err := extractFromFinanceSK(compositeSKMember.Value)
-	if err != nil {
-		logger.Log.Error("Failed to parse financial sort key", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
+	currentRecord := records[0]
 
 	var response Response
 
-	response.Period = fmt.Sprintf("%d-%s", year, periodType)
-
-	if result.LastEvaluatedKey != nil {
-		nextCursor := encodeCursor(result.LastEvaluatedKey)
-		if nextCursor == "" {
-			logger.Log.Error("Failed to encode next cursor")
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		response.Cursor = nextCursor
-	}
+	response.Period = fmt.Sprintf("%d-%s", currentRecord.Year, currentRecord.Period)
+	response.Cursor = nextCursor
+	response.LowConfidence = currentRecord.LowConfidence
+	response.Warnings = currentRecord.Warnings
 
 	// Get previous year data
-	var prevYearRecord map[string]dynamoTypes.AttributeValue
-	if year > 1 {
-		prevYearSK, err := buildFinanceSortKey(ticker, year-1, periodType)
+	var prevYearRecord *store.Finance
+	if currentRecord.Year > 1 {
+		prevYearResult, err := st.GetFinancePeriod(ctx, username, ticker, currentRecord.Year-1, currentRecord.Period)
 		if err == nil {
-			prevYearResult, err := d.GetItem(ctx, &dynamodb.GetItemInput{
-				TableName: aws.String("nodofinance_table"),
-				Key: map[string]dynamoTypes.AttributeValue{
-					"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-					"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: prevYearSK},
-				},
-			})
-			if err == nil && len(prevYearResult.Item) > 0 {
-				prevYearRecord = prevYearResult.Item
-			}
+			prevYearRecord = &prevYearResult
 		}
 	}
 
 	// Get ticker info (currency, analysis) when not using cursor
 	if !rWithCursor {
 
-		tickerResult, err := d.GetItem(ctx, &dynamodb.GetItemInput{
-			TableName: aws.String("nodofinance_table"),
-			Key: map[string]dynamoTypes.AttributeValue{
-				"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-				"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: fmt.Sprintf("TICKER#%s", ticker)},
-			},
-			ProjectionExpression: aws.String("currency, analysis"),
-		})
-
-		if err != nil {
-			logger.Log.Error("Error getting ticker metadata", zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		if len(tickerResult.Item) == 0 {
+		tickerResult, err := st.GetTicker(ctx, username, ticker)
+		if errors.Is(err, store.ErrNotFound) {
 			logger.Log.Error("No ticker metadata found", zap.String("ticker", ticker), zap.String("username", username))
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-		if currencyAttr, exists := tickerResult.Item["currency"]; exists {
-			if currencyStr, ok := currencyAttr.(*dynamoTypes.AttributeValueMemberS); ok {
-				response.Currency = currencyStr.Value
-			}
+		if err != nil {
+			logger.Log.Error("Error getting ticker metadata", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
 
-		if analysisAttr, exists := tickerResult.Item["analysis"]; exists {
-			if analysisStr, ok := analysisAttr.(*dynamoTypes.AttributeValueMemberS); ok {
-				response.Analysis = analysisStr.Value
-			}
-		}
+		response.Currency = tickerResult.Currency
+		response.Analysis = tickerResult.Analysis
+		response.Insights = tickerResult.Insights
 	}
 
 	financialData := buildFinancialData(currentRecord, prevYearRecord)
@@ -248,16 +173,7 @@ func MountTicker(w http.ResponseWriter, r *http.Request, d *dynamodb.Client) {
 }
 
 // Helper function to build financial data struct
-func buildFinancialData(currentRecord, prevYearRecord map[string]dynamoTypes.AttributeValue) FinancialData {
-	// Helper functions for pointer conversion
-	setInt64Ptr := func(source *float64) *int64 {
-		if source != nil {
-			value := int64(*source)
-			return &value
-		}
-		return nil
-	}
-
+func buildFinancialData(currentRecord store.Finance, prevYearRecord *store.Finance) FinancialData {
 	setFloat64Ptr := func(source *float64) *float64 {
 		if source != nil {
 			value := math.Round(*source*1000.0) / 1000.0
@@ -269,31 +185,31 @@ func buildFinancialData(currentRecord, prevYearRecord map[string]dynamoTypes.Att
 	financialData := FinancialData{}
 
 	// Current period data
-	financialData.CurrentAssets = setInt64Ptr(getDynamoDBFloatValue(currentRecord, "current_assets"))
-	financialData.NonCurrentAssets = setInt64Ptr(getDynamoDBFloatValue(currentRecord, "non_current_assets"))
-	financialData.Eps = setFloat64Ptr(getDynamoDBFloatValue(currentRecord, "eps"))
-	financialData.CashAndEquivalents = setInt64Ptr(getDynamoDBFloatValue(currentRecord, "cash_and_equivalents"))
-	financialData.CashFlowFromFinancing = setInt64Ptr(getDynamoDBFloatValue(currentRecord, "cash_flow_from_financing"))
-	financialData.CashFlowFromInvesting = setInt64Ptr(getDynamoDBFloatValue(currentRecord, "cash_flow_from_investing"))
-	financialData.CashFlowFromOperations = setInt64Ptr(getDynamoDBFloatValue(currentRecord, "cash_flow_from_operations"))
-	financialData.Revenue = setInt64Ptr(getDynamoDBFloatValue(currentRecord, "revenue"))
-	financialData.CurrentLiabilities = setInt64Ptr(getDynamoDBFloatValue(currentRecord, "current_liabilities"))
-	financialData.NonCurrentLiabilities = setInt64Ptr(getDynamoDBFloatValue(currentRecord, "non_current_liabilities"))
-	financialData.NetIncome = setInt64Ptr(getDynamoDBFloatValue(currentRecord, "net_income"))
+	financialData.CurrentAssets = currentRecord.CurrentAssets
+	financialData.NonCurrentAssets = currentRecord.NonCurrentAssets
+	financialData.Eps = setFloat64Ptr(currentRecord.EPS)
+	financialData.CashAndEquivalents = currentRecord.Cash
+	financialData.CashFlowFromFinancing = currentRecord.CashFlowFromFinancing
+	financialData.CashFlowFromInvesting = currentRecord.CashFlowFromInvesting
+	financialData.CashFlowFromOperations = currentRecord.CashFlowFromOperations
+	financialData.Revenue = currentRecord.Revenue
+	financialData.CurrentLiabilities = currentRecord.CurrentLiabilities
+	financialData.NonCurrentLiabilities = currentRecord.NonCurrentLiabilities
+	financialData.NetIncome = currentRecord.NetIncome
 
 	// Previous year data
 	if prevYearRecord != nil {
-		financialData.CurrentAssetsPrev = setInt64Ptr(getDynamoDBFloatValue(prevYearRecord, "current_assets"))
-		financialData.NonCurrentAssetsPrev = setInt64Ptr(getDynamoDBFloatValue(prevYearRecord, "non_current_assets"))
-		financialData.EpsPrev = setFloat64Ptr(getDynamoDBFloatValue(prevYearRecord, "eps"))
-		financialData.CashAndEquivalentsPrev = setInt64Ptr(getDynamoDBFloatValue(prevYearRecord, "cash_and_equivalents"))
-		financialData.CashFlowFromFinancingPrev = setInt64Ptr(getDynamoDBFloatValue(prevYearRecord, "cash_flow_from_financing"))
-		financialData.CashFlowFromInvestingPrev = setInt64Ptr(getDynamoDBFloatValue(prevYearRecord, "cash_flow_from_investing"))
-		financialData.CashFlowFromOperationsPrev = setInt64Ptr(getDynamoDBFloatValue(prevYearRecord, "cash_flow_from_operations"))
-		financialData.RevenuePrev = setInt64Ptr(getDynamoDBFloatValue(prevYearRecord, "revenue"))
-		financialData.CurrentLiabilitiesPrev = setInt64Ptr(getDynamoDBFloatValue(prevYearRecord, "current_liabilities"))
-		financialData.NonCurrentLiabilitiesPrev = setInt64Ptr(getDynamoDBFloatValue(prevYearRecord, "non_current_liabilities"))
-		financialData.NetIncomePrev = setInt64Ptr(getDynamoDBFloatValue(prevYearRecord, "net_income"))
+		financialData.CurrentAssetsPrev = prevYearRecord.CurrentAssets
+		financialData.NonCurrentAssetsPrev = prevYearRecord.NonCurrentAssets
+		financialData.EpsPrev = setFloat64Ptr(prevYearRecord.EPS)
+		financialData.CashAndEquivalentsPrev = prevYearRecord.Cash
+		financialData.CashFlowFromFinancingPrev = prevYearRecord.CashFlowFromFinancing
+		financialData.CashFlowFromInvestingPrev = prevYearRecord.CashFlowFromInvesting
+		financialData.CashFlowFromOperationsPrev = prevYearRecord.CashFlowFromOperations
+		financialData.RevenuePrev = prevYearRecord.Revenue
+		financialData.CurrentLiabilitiesPrev = prevYearRecord.CurrentLiabilities
+		financialData.NonCurrentLiabilitiesPrev = prevYearRecord.NonCurrentLiabilities
+		financialData.NetIncomePrev = prevYearRecord.NetIncome
 	}
 
 	return financialData
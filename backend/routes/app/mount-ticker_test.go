@@ -0,0 +1,94 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMountTickerPages(t *testing.T) {
+	st := newMemoryStore(t, 0)
+	putPeriod(t, st, "AAPL", 2023, "Y", 100)
+	putPeriod(t, st, "AAPL", 2024, "Y", 110)
+
+	mount := func(cursor string) Response {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		MountTicker(w, newRequest(t, http.MethodGet, "/mount-ticker?ticker=AAPL&cursor="+cursor, "", testUser), st)
+		if w.Code != http.StatusOK {
+			t.Fatalf("MountTicker() status = %d, want %d", w.Code, http.StatusOK)
+		}
+
+		var res Response
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		return res
+	}
+
+	latest := mount("")
+	if latest.Period != "2024-Y" || latest.Currency != "USD" || latest.Cursor == "" {
+		t.Fatalf("first page = %q %q cursor %q, want 2024-Y USD with a cursor", latest.Period, latest.Currency, latest.Cursor)
+	}
+	if *latest.FinancialData.Revenue != 110 || latest.FinancialData.RevenuePrev == nil || *latest.FinancialData.RevenuePrev != 100 {
+		t.Errorf("first page revenue = %v, prev %v, want 110, 100", latest.FinancialData.Revenue, latest.FinancialData.RevenuePrev)
+	}
+
+	// the ticker metadata comes with the first page only
+	previous := mount(latest.Cursor)
+	if previous.Period != "2023-Y" || previous.Currency != "" || previous.Cursor != "" {
+		t.Errorf("second page = %q %q cursor %q, want 2023-Y without currency nor cursor", previous.Period, previous.Currency, previous.Cursor)
+	}
+	if previous.FinancialData.RevenuePrev != nil {
+		t.Errorf("second page prev revenue = %d, want none", *previous.FinancialData.RevenuePrev)
+	}
+}
+
+func TestMountTickerErrors(t *testing.T) {
+	st := newMemoryStore(t, 0)
+	putPeriod(t, st, "AAPL", 2023, "Y", 100)
+	putPeriod(t, st, "AAPL", 2024, "Y", 110)
+
+	cursor := func(username, sortKey string) string {
+		data, _ := json.Marshal(map[string]map[string]string{
+			"username":     {"S": username},
+			"composite_sk": {"S": sortKey},
+		})
+		return base64.URLEncoding.EncodeToString(data)
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		username string
+		want     int
+	}{
+		{"ticker not stored", "/mount-ticker?ticker=MSFT", testUser, http.StatusNotFound},
+		{"ticker of another user", "/mount-ticker?ticker=AAPL", "other-user", http.StatusNotFound},
+		{"invalid ticker", "/mount-ticker?ticker=a$b", testUser, http.StatusBadRequest},
+		{"cursor of another user", "/mount-ticker?ticker=AAPL&cursor=" + cursor("other-user", "FINANCE#AAPL#7975#01"), testUser, http.StatusBadRequest},
+		{"cursor of another ticker", "/mount-ticker?ticker=AAPL&cursor=" + cursor(testUser, "FINANCE#MSFT#7975#01"), testUser, http.StatusBadRequest},
+		{"cursor past the last period", "/mount-ticker?ticker=AAPL&cursor=" + cursor(testUser, "FINANCE#AAPL#7976#01"), testUser, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			MountTicker(w, newRequest(t, http.MethodGet, tt.target, "", tt.username), st)
+			if w.Code != tt.want {
+				t.Errorf("MountTicker() status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+
+	t.Run("without id token", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		MountTicker(w, httptest.NewRequest(http.MethodGet, "/mount-ticker?ticker=AAPL", nil), st)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("MountTicker() status = %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"nodofinance/store"
+	"nodofinance/utils/jwt"
+	"nodofinance/utils/logger"
+	"nodofinance/utils/sanitize"
+
+	"go.uber.org/zap"
+)
+
+// Res
+type ProvenanceRes struct {
+	Ticker string `json:"ticker"`
+	Period string `json:"period"`
+	// Edited periods keep the sources of the extraction, the values may not match them anymore
+	Edited bool `json:"edited"`
+	// Field -> row of the filing. Empty for periods imported from XBRL or edited before provenance existed
+	Sources map[string]store.Source `json:"sources"`
+	// Version of the prompt templates of the extraction, empty for imported periods
+	PromptVersion string `json:"prompt_version,omitempty"`
+}
+
+// Provenance returns the source label and snippet behind each stored field of a period
+func Provenance(w http.ResponseWriter, r *http.Request, st store.Store) {
+	ctx := r.Context()
+
+	ticker := sanitize.Trim(r.URL.Query().Get("ticker"), "u")
+	fullPeriod := sanitize.Trim(r.URL.Query().Get("period"), "u")
+
+	if ticker == "" || fullPeriod == "" {
+		logger.Log.Error("Ticker or period is empty")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !sanitize.Ticker(ticker) || !sanitize.Period(fullPeriod) {
+		logger.Log.Error("Invalid ticker or period", zap.String("ticker", ticker), zap.String("period", fullPeriod))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	idTokenCookie, errIT := r.Cookie("nodo_id_token")
+	if errIT != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	idClaims, err := jwt.GetTokenClaims(idTokenCookie.Value)
+	if err != nil {
+		logger.Log.Error("Failed to get token claims", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	username, exists := idClaims["cognito:username"].(string)
+	if !exists {
+		logger.Log.Error("Failed to get username from token claims")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	year, period, err := splitPeriod(fullPeriod)
+	if err != nil {
+		logger.Log.Error("Failed to parse period", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	f, err := st.GetFinancePeriod(ctx, username, ticker, year, period)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		logger.Log.Error("Failed to get finance period", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp := ProvenanceRes{
+		Ticker:        ticker,
+		Period:        fullPeriod,
+		Edited:        f.Edited,
+		Sources:       f.Provenance,
+		PromptVersion: f.PromptVersion,
+	}
+	if resp.Sources == nil {
+		resp.Sources = map[string]store.Source{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Log.Error("Error encoding response", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
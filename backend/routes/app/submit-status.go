@@ -0,0 +1,139 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"nodofinance/jobs"
+	"nodofinance/utils/jwt"
+	"nodofinance/utils/logger"
+	"nodofinance/utils/sanitize"
+
+	"go.uber.org/zap"
+)
+
+// SubmitStatus returns the current state of a submission job, with the status code of its failure
+// if it has one, e.g. 503 when the LLM provider is unavailable
+func SubmitStatus(w http.ResponseWriter, r *http.Request, queue *jobs.Queue) {
+	job, ok := getSubmitJob(w, r, queue)
+	if !ok {
+		return
+	}
+
+	snapshot := job.Snapshot()
+
+	w.Header().Set("Content-Type", "application/json")
+	if snapshot.Status == jobs.StatusFailed && snapshot.Code != 0 {
+		w.WriteHeader(snapshot.Code)
+	}
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		logger.Log.Error("Error encoding response", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// SubmitEvents streams the stages of a submission job as server-sent events,
+// replaying the ones already emitted, until the job is done or failed. The stream itself is a 200,
+// the failed event carries the status code of the failure.
+func SubmitEvents(w http.ResponseWriter, r *http.Request, queue *jobs.Queue) {
+	job, ok := getSubmitJob(w, r, queue)
+	if !ok {
+		return
+	}
+
+	// the stream outlives the server WriteTimeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		logger.Log.Error("Failed to clear write deadline", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	writeEvent := func(event jobs.Event) bool {
+		data, err := json.Marshal(event)
+		if err != nil {
+			logger.Log.Error("Failed to marshal job event", zap.Error(err))
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Status, data); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	past, events, unsubscribe := job.Subscribe()
+	defer unsubscribe()
+
+	for _, event := range past {
+		if !writeEvent(event) {
+			return
+		}
+	}
+
+	if events == nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case event, open := <-events:
+			if !open || !writeEvent(event) {
+				return
+			}
+		}
+	}
+}
+
+func getSubmitJob(w http.ResponseWriter, r *http.Request, queue *jobs.Queue) (*jobs.Job, bool) {
+	idTokenCookie, errIT := r.Cookie("nodo_id_token")
+	if errIT != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, false
+	}
+
+	idClaims, err := jwt.GetTokenClaims(idTokenCookie.Value)
+	if err != nil {
+		logger.Log.Error("Failed to get token claims", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, false
+	}
+
+	username, exists := idClaims["cognito:username"].(string)
+	if !exists {
+		logger.Log.Error("Failed to get username from token claims")
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, false
+	}
+
+	jobID := sanitize.Trim(r.URL.Query().Get("id"), "l")
+	if !sanitize.Hex(jobID) {
+		logger.Log.Error("Invalid job id")
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+
+	job, exists := queue.Get(username, jobID)
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+
+	return job, true
+}
@@ -0,0 +1,238 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+
+	"nodofinance/routes/app/submitter"
+	"nodofinance/store"
+	"nodofinance/utils/jwt"
+	"nodofinance/utils/logger"
+	"nodofinance/utils/sanitize"
+	"nodofinance/xbrl"
+
+	"github.com/patrickmn/go-cache"
+	"go.uber.org/zap"
+)
+
+// Req
+type SubmitXBRLReq struct {
+	Ticker string `json:"ticker"`
+	// Period and currency default to the ones the filing reports
+	Period   string `json:"period,omitempty"`
+	Currency string `json:"currency,omitempty"`
+	// XBRL instance or iXBRL HTML
+	Document string `json:"document"`
+}
+
+// Res
+type SubmitXBRLRes struct {
+	Ticker        string                  `json:"ticker"`
+	Period        string                  `json:"period"`
+	Currency      string                  `json:"currency"`
+	FinancialData submitter.Postprocessed `json:"financial_data"`
+}
+
+// SubmitXBRL stores the tagged figures of an XBRL filing. No model is called, so no tokens are billed.
+func SubmitXBRL(w http.ResponseWriter, r *http.Request, st store.Store, dataCache *cache.Cache) {
+	ctx := r.Context()
+
+	idTokenCookie, errIT := r.Cookie("nodo_id_token")
+	if errIT != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	idClaims, err := jwt.GetTokenClaims(idTokenCookie.Value)
+	if err != nil {
+		logger.Log.Error("Failed to get token claims", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	username, exists := idClaims["cognito:username"].(string)
+	if !exists {
+		logger.Log.Error("Failed to get username from token claims")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// 1. Request body
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Log.Error("Failed to read request body", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+
+	var req SubmitXBRLReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		logger.Log.Error("Failed to unmarshal request body", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ticker := sanitize.Trim(req.Ticker, "u")
+	if !sanitize.Ticker(ticker) {
+		logger.Log.Error("Invalid ticker", zap.String("ticker", ticker))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// 2. Filing
+	doc, err := xbrl.Parse([]byte(req.Document))
+	if err != nil {
+		logger.Log.Error("Failed to parse XBRL document", zap.Error(err))
+		http.Error(w, "nodo error: Invalid file. Is it an XBRL or inline XBRL filing?", http.StatusBadRequest)
+		return
+	}
+
+	period, err := doc.DocumentPeriod()
+	if err != nil {
+		logger.Log.Error("Failed to get period of XBRL document", zap.Error(err))
+		http.Error(w, "nodo error: The period of the filing could not be found.", http.StatusBadRequest)
+		return
+	}
+
+	if requested := sanitize.Trim(req.Period, "u"); requested != "" {
+		if !sanitize.Period(requested) {
+			logger.Log.Error("Invalid period", zap.String("period", requested))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		year, periodType, err := splitPeriod(requested)
+		if err != nil {
+			logger.Log.Error("Failed to parse period", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if period.Type != "" && (period.Year != year || period.Type != periodType) {
+			logger.Log.Info("Requested period does not match the filing", zap.String("requested", requested), zap.String("filing", period.String()))
+			http.Error(w, fmt.Sprintf("nodo error: The filing reports %s.", period), http.StatusBadRequest)
+			return
+		}
+
+		period.Year = year
+		period.Type = periodType
+	} else if period.Type == "" {
+		logger.Log.Error("XBRL document without fiscal period")
+		http.Error(w, "nodo error: The period of the filing could not be found.", http.StatusBadRequest)
+		return
+	}
+
+	documentCurrency := doc.Currency()
+	currency := sanitize.Trim(req.Currency, "u")
+	switch {
+	case currency == "" && sanitize.Currency(documentCurrency):
+		currency = documentCurrency
+	case currency == "":
+		currency = "ND"
+	case !sanitize.Currency(currency):
+		logger.Log.Error("Invalid currency", zap.String("currency", currency))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	case currency != "ND" && sanitize.Currency(documentCurrency) && currency != documentCurrency:
+		logger.Log.Info("Requested currency does not match the filing", zap.String("requested", currency), zap.String("filing", documentCurrency))
+		http.Error(w, fmt.Sprintf("nodo error: The filing reports in %s.", documentCurrency), http.StatusBadRequest)
+		return
+	}
+
+	// 3. Consumption limits, tokens are not consumed here
+	limitResult, err := checkConsumptionLimits(ctx, st, username, ticker)
+	if err != nil {
+		logger.Log.Error("Error checking consumption limits", zap.Error(err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	switch limitResult.LimitReached {
+	case LimitTypePeriods:
+		http.Error(w, fmt.Sprintf("Max %d periods per ticker", MAX_PERIODS), http.StatusForbidden)
+		return
+	case LimitTypeTickers:
+		http.Error(w, fmt.Sprintf("Max %d tickers", MAX_TICKERS), http.StatusForbidden)
+		return
+	}
+
+	// 4. Postprocessor, same constraints and fallbacks as the model output
+	postprocessedResult, err := postprocessXBRL(doc.Statements(period, documentCurrency))
+	if err != nil {
+		logger.Log.Error("Failed to postprocess financial data", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if reflect.ValueOf(postprocessedResult).IsZero() {
+		logger.Log.Info("No financial data in XBRL document", zap.String("ticker", ticker), zap.String("period", period.String()))
+		http.Error(w, fmt.Sprintf("nodo error: No financial data found for %s.", period), http.StatusBadRequest)
+		return
+	}
+
+	// 5. DynamoDB
+	err = st.PutFinancePeriod(ctx, username, postprocessedToFinance(ticker, period.Year, period.Type, postprocessedResult), currency, 0)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			logger.Log.Error("User does not exist", zap.String("username", username))
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		logger.Log.Error("Transaction failed", zap.Error(err), zap.String("username", username), zap.String("ticker", ticker), zap.String("period", period.String()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	cacheKey := "tickers_" + username
+	dataCache.Delete(cacheKey)
+
+	// 6. Response
+	resp := SubmitXBRLRes{
+		Ticker:        ticker,
+		Period:        period.String(),
+		Currency:      currency,
+		FinancialData: postprocessedResult,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Log.Error("Error encoding response", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// postprocessXBRL runs the statements of a filing through the postprocessor.
+// Values are in units of currency, so Units is left nil.
+func postprocessXBRL(s xbrl.Statements) (submitter.Postprocessed, error) {
+	balance := &submitter.BalanceSheet{
+		CashAndEquivalents:    s.CashAndEquivalents,
+		CurrentAssets:         s.CurrentAssets,
+		NonCurrentAssets:      s.NonCurrentAssets,
+		TotalAssets:           s.TotalAssets,
+		CurrentLiabilities:    s.CurrentLiabilities,
+		NonCurrentLiabilities: s.NonCurrentLiabilities,
+		TotalLiabilities:      s.TotalLiabilities,
+		Equity:                s.Equity,
+	}
+
+	income := &submitter.IncomeStatement{
+		Revenue:   s.Revenue,
+		NetIncome: s.NetIncome,
+		EPS:       s.EPS,
+	}
+
+	cashFlow := &submitter.CashFlowStatement{
+		CashFlowFromOperations: s.CashFlowFromOperations,
+		CashFlowFromInvesting:  s.CashFlowFromInvesting,
+		CashFlowFromFinancing:  s.CashFlowFromFinancing,
+	}
+
+	return submitter.Postprocessor(balance, income, cashFlow)
+}
@@ -3,6 +3,7 @@ package app
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,59 +11,91 @@ import (
 	"net/http"
 	"os"
 	"reflect"
-	"strconv"
+	"slices"
 	"strings"
 	"time"
 
+	"nodofinance/jobs"
+	"nodofinance/llm"
+	"nodofinance/preprocess"
+	"nodofinance/prompts"
 	"nodofinance/routes/app/submitter"
+	"nodofinance/store"
 	"nodofinance/utils/jwt"
 	"nodofinance/utils/logger"
 	"nodofinance/utils/sanitize"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
-	"github.com/openai/openai-go"
 	"github.com/patrickmn/go-cache"
 	"go.uber.org/zap"
 )
 
-// Req
-type ChunkMetrics struct {
-	FirstUniqueHits  int `json:"first_unique_hits"`
-	SecondUniqueHits int `json:"second_unique_hits"`
-	ThirdUniqueHits  int `json:"third_unique_hits"`
-	FourthUniqueHits int `json:"fourth_unique_hits"`
-	FifthUniqueHits  int `json:"fifth_unique_hits"`
-}
-
-type ChunkResult struct {
-	Chunk      string       `json:"chunk,omitempty"`
-	Cleaned    string       `json:"cleaned,omitempty"`
-	Units      int64        `json:"units,omitempty"`
-	Metrics    ChunkMetrics `json:"metrics"`
-	Indicators []string     `json:"indicators"`
-}
-
-type PreprocessorOutput struct {
-	BalanceResult  ChunkResult `json:"balance_result"`
-	IncomeResult   ChunkResult `json:"income_result"`
-	CashFlowResult ChunkResult `json:"cash_flow_result"`
-	Language       string      `json:"language"`
-}
+// Req, same shapes as the browser preprocessor output
+type (
+	ChunkMetrics       = preprocess.Metrics
+	ChunkResult        = preprocess.ChunkResult
+	PreprocessorOutput = preprocess.Output
+)
 
 type SubmitReq struct {
 	Ticker   string             `json:"ticker"`
 	Period   string             `json:"period"`
 	Currency string             `json:"currency"`
 	Content  PreprocessorOutput `json:"content"`
+	// Raw document text or HTML, preprocessed on the server instead of Content
+	Raw string `json:"raw,omitempty"`
+	// Draft keeps the result as a DRAFT# for the user to review instead of storing it
+	Draft bool `json:"draft,omitempty"`
+	// Mode is empty for the AI extraction, SUBMIT_MODE_QUICK or SUBMIT_MODE_ENSEMBLE
+	Mode string `json:"mode,omitempty"`
+}
+
+// Statements of a failed submission are kept this long for a retry of the same document
+const PARTIAL_RETENTION = time.Hour
+
+const (
+	// SUBMIT_MODE_QUICK extracts with the label rules only, without tokens and with low confidence values
+	SUBMIT_MODE_QUICK = "quick"
+	// SUBMIT_MODE_ENSEMBLE votes the answers of every llm.ENSEMBLE_SUBMITTERS run, billing all of them
+	SUBMIT_MODE_ENSEMBLE = "ensemble"
+)
+
+// Res
+type SubmitRes struct {
+	JobID string `json:"job_id"`
+}
+
+// SubmitResult is the result of a finished submission job, in the job status
+type SubmitResult struct {
+	Ticker string `json:"ticker"`
+	// Requested period first, then the comparatives stored with it
+	Periods []SubmitPeriod `json:"periods"`
+	// Set when the periods wait in a draft until DraftExpiresAt
+	Draft          bool  `json:"draft,omitempty"`
+	DraftExpiresAt int64 `json:"draft_expires_at,omitempty"`
+	// Set when the extraction of an identical submission was reused, no tokens were billed
+	Cached bool `json:"cached,omitempty"`
+	// submitter.EXTRACTOR_LLM, or submitter.EXTRACTOR_RULES in quick mode or when the AI failed on a
+	// draft, every value of a rule based extraction is low confidence
+	Extractor string `json:"extractor"`
+}
+
+type SubmitPeriod struct {
+	submitter.PeriodResult
+	LowConfidence []string `json:"low_confidence"`
+}
+
+func newSubmitPeriod(result submitter.PeriodResult) SubmitPeriod {
+	return SubmitPeriod{PeriodResult: result, LowConfidence: result.Validation.LowConfidence()}
 }
 
 // S3
-func convertChunkMetrics(cm ChunkMetrics) S3MetricsData {
-	return S3MetricsData{
+const S3_OUTPUTS_BUCKET = "financial-docs-outputs"
+
+func convertChunkMetrics(cm ChunkMetrics) submitter.S3MetricsData {
+	return submitter.S3MetricsData{
 		First:  cm.FirstUniqueHits,
 		Second: cm.SecondUniqueHits,
 		Third:  cm.ThirdUniqueHits,
@@ -71,29 +104,7 @@ func convertChunkMetrics(cm ChunkMetrics) S3MetricsData {
 	}
 }
 
-type S3MetricsData struct {
-	First  int `json:"first"`
-	Second int `json:"second"`
-	Third  int `json:"third"`
-	Fourth int `json:"fourth"`
-	Fifth  int `json:"fifth"`
-}
-
-type S3StatementSection struct {
-	Metrics         S3MetricsData `json:"metrics"`
-	RawContent      string        `json:"raw_content"`
-	CleanerPrompt   string        `json:"cleaner_prompt"`
-	SubmitterPrompt string        `json:"submitter_prompt"`
-}
-
-type S3Document struct {
-	Balance     S3StatementSection      `json:"balance"`
-	Income      S3StatementSection      `json:"income"`
-	CashFlow    S3StatementSection      `json:"cash_flow"`
-	FinalResult submitter.Postprocessed `json:"final_result"`
-}
-
-func Submit(w http.ResponseWriter, r *http.Request, d *dynamodb.Client, s *s3.Client, ai openai.Client, dataCache *cache.Cache, devMode bool) {
+func Submit(w http.ResponseWriter, r *http.Request, st store.Store, s *s3.Client, ai llm.Provider, dataCache *cache.Cache, queue *jobs.Queue, devMode bool) {
 	ctx := r.Context()
 
 	idTokenCookie, errIT := r.Cookie("nodo_id_token")
@@ -134,6 +145,32 @@ func Submit(w http.ResponseWriter, r *http.Request, d *dynamodb.Client, s *s3.Cl
 
 	ticker := sanitize.Trim(req.Ticker, "u")
 	period := sanitize.Trim(req.Period, "u")
+
+	if req.Mode != "" && req.Mode != SUBMIT_MODE_QUICK && req.Mode != SUBMIT_MODE_ENSEMBLE {
+		logger.Log.Error("Invalid submit mode", zap.String("mode", req.Mode))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	quick := req.Mode == SUBMIT_MODE_QUICK
+
+	var ensemble []llm.EnsembleMember
+	if req.Mode == SUBMIT_MODE_ENSEMBLE {
+		ensemble = llm.ENSEMBLE_SUBMITTERS
+	}
+
+	// Raw documents are preprocessed here, browser output is checked against its own chunks
+	if req.Raw != "" {
+		raw := req.Raw
+		if preprocess.IsHTML(raw) {
+			raw = preprocess.HTMLToText(raw)
+		}
+		req.Content = preprocess.Preprocess(raw, period)
+	} else if err := verifyPreprocessorOutput(&req.Content, period); err != nil {
+		logger.Log.Error("Preprocessor output does not match its chunks", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	currency := sanitize.Trim(req.Currency, "u")
 	language := sanitize.Trim(req.Content.Language, "u")
 	unitsFromClient := submitter.UnitsFromClient{
@@ -280,14 +317,15 @@ func Submit(w http.ResponseWriter, r *http.Request, d *dynamodb.Client, s *s3.Cl
 	}
 
 	// 2. Consumption limits
-	limitResult, err := checkConsumptionLimits(ctx, d, username, ticker)
+	limitResult, err := checkConsumptionLimits(ctx, st, username, ticker)
 	if err != nil {
 		logger.Log.Error("Error checking consumption limits", zap.Error(err))
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	if !limitResult.Allowed {
+	// quick extractions spend no tokens
+	if !limitResult.Allowed && !(quick && limitResult.LimitReached == LimitTypeTokens) {
 		var limitTypeStr string
 		switch limitResult.LimitReached {
 		case LimitTypePeriods:
@@ -320,132 +358,234 @@ func Submit(w http.ResponseWriter, r *http.Request, d *dynamodb.Client, s *s3.Cl
 		return
 	}
 
-	// 3. Submitter
-	hits := submitter.Hits{
-		Balance:  req.Content.BalanceResult.Metrics.FirstUniqueHits,
-		Income:   req.Content.IncomeResult.Metrics.FirstUniqueHits,
-		CashFlow: req.Content.CashFlowResult.Metrics.FirstUniqueHits,
-	}
-
-	submitterResponse, err := submitter.CallSubmitter(
-		ctx, ai,
-		balanceCleaned, incomeCleaned, cashFlowCleaned,
-		period, language, hits,
-		unitsFromClient)
-	if err != nil {
-		logger.Log.Error("Error calling submitter", zap.Error(err))
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	// the breaker is open, the job would fail, drafts fall back to the rules
+	if !quick && !req.Draft && !llm.Available(ai) {
+		logger.Log.Warn("LLM provider unavailable", zap.String("username", username))
+		http.Error(w, llm.ErrUnavailable.Error(), http.StatusServiceUnavailable)
 		return
 	}
 
-	if submitterResponse.Balance.FinalContent == "" ||
-		submitterResponse.Income.FinalContent == "" ||
-		submitterResponse.CashFlow.FinalContent == "" {
-		logger.Log.Error("Empty response from submitter")
-		w.WriteHeader(http.StatusInternalServerError)
+	if retryAfter, busy := llm.Saturated(ai); !quick && !req.Draft && busy {
+		logger.Log.Warn("LLM calls saturated", zap.String("username", username), zap.Duration("retry_after", retryAfter))
+		writeBusy(w, retryAfter)
 		return
 	}
 
-	balanceData, err := submitter.ParseFinancialData("balance", submitterResponse.Balance.FinalContent)
+	// 3. Job
+	sub := submission{
+		username:        username,
+		ticker:          ticker,
+		period:          period,
+		currency:        currency,
+		language:        language,
+		units:           unitsFromClient,
+		balanceCleaned:  balanceCleaned,
+		incomeCleaned:   incomeCleaned,
+		cashFlowCleaned: cashFlowCleaned,
+		content:         req.Content,
+		draft:           req.Draft,
+		quick:           quick,
+		ensemble:        ensemble,
+	}
+
+	job, err := queue.Enqueue(username, func(ctx context.Context, j *jobs.Job) error {
+		return runSubmission(ctx, st, s, ai, dataCache, devMode, sub)
+	})
 	if err != nil {
-		logger.Log.Error("Failed to parse balance sheet", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	balance, ok := balanceData.(*submitter.BalanceSheet)
-	if !ok {
-		logger.Log.Error("Type assertion failed for balance sheet")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	if unitsFromClient.Balance != 0 {
-		balanceUnitsFloat := float64(unitsFromClient.Balance)
-		balance.Units = &balanceUnitsFloat
-	}
+		if errors.Is(err, jobs.ErrQueueFull) {
+			logger.Log.Warn("Submission queue is full", zap.String("username", username))
+			http.Error(w, "Too many submissions in progress, try again later", http.StatusServiceUnavailable)
+			return
+		}
 
-	incomeData, err := submitter.ParseFinancialData("income", submitterResponse.Income.FinalContent)
-	if err != nil {
-		logger.Log.Error("Failed to parse income statement", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	income, ok := incomeData.(*submitter.IncomeStatement)
-	if !ok {
-		logger.Log.Error("Type assertion failed for income statement")
+		logger.Log.Error("Failed to enqueue submission", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if unitsFromClient.Income != 0 {
-		incomeUnitsFloat := float64(unitsFromClient.Income)
-		income.Units = &incomeUnitsFloat
+
+	// 4. Response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(SubmitRes{JobID: job.ID}); err != nil {
+		logger.Log.Error("Error encoding response", zap.Error(err))
 	}
+}
 
-	cashFlowData, err := submitter.ParseFinancialData("cash_flow", submitterResponse.CashFlow.FinalContent)
-	if err != nil {
-		logger.Log.Error("Failed to parse cash flow statement", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+// submission is a validated submit request, ready to run in a job
+type submission struct {
+	username        string
+	ticker          string
+	period          string
+	currency        string
+	language        string
+	units           submitter.UnitsFromClient
+	balanceCleaned  string
+	incomeCleaned   string
+	cashFlowCleaned string
+	content         PreprocessorOutput
+	draft           bool
+	quick           bool
+	// nil for a single submitter run
+	ensemble []llm.EnsembleMember
+}
+
+var (
+	errSubmitInternal = errors.New("internal server error")
+	errSubmitUser     = errors.New("user does not exist")
+	errSubmitPeriod   = errors.New("invalid period")
+)
+
+func runSubmission(ctx context.Context, st store.Store, s *s3.Client, ai llm.Provider, dataCache *cache.Cache, devMode bool, sub submission) error {
+	// 1. Submitter
+	hits := submitter.Hits{
+		Balance:  sub.content.BalanceResult.Metrics.FirstUniqueHits,
+		Income:   sub.content.IncomeResult.Metrics.FirstUniqueHits,
+		CashFlow: sub.content.CashFlowResult.Metrics.FirstUniqueHits,
 	}
-	cashFlow, ok := cashFlowData.(*submitter.CashFlowStatement)
-	if !ok {
-		logger.Log.Error("Type assertion failed for cash flow statement")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+
+	// resubmitting the same period keeps its version
+	templates := prompts.Assign(sub.username + "#" + sub.ticker + "#" + sub.period)
+
+	var submitterResponse submitter.SubmitterRes
+	var cached bool
+	extractor := submitter.EXTRACTOR_LLM
+	// tokens of a failed AI extraction, billed anyway
+	var spentTokens int64
+
+	if !sub.quick {
+		examples := loadExamples(ctx, st, sub.username, sub.language)
+
+		// the same document uploaded again, with no correction in between, reuses the extraction without LLM calls
+		hash, err := extractionHash(sub, hits, templates, examples)
+		if err != nil {
+			logger.Log.Error("Failed to hash extraction", zap.Error(err))
+			return errSubmitInternal
+		}
+		submitterResponse, cached = getCachedExtraction(ctx, st, hash)
+		if cached {
+			logger.Log.Info("Reusing cached extraction", zap.String("username", sub.username), zap.String("ticker", sub.ticker), zap.String("period", sub.period), zap.String("hash", hash))
+			jobs.Report(ctx, "cached")
+		} else {
+			// statements finished by an earlier attempt of the same user at the same document
+			partialKey := "partial_" + sub.username + "_" + hash
+			var previous submitter.SubmitterRes
+			if cachedPartial, found := dataCache.Get(partialKey); found {
+				previous = cachedPartial.(submitter.SubmitterRes)
+			}
+
+			submitterResponse, err = submitter.CallSubmitter(
+				ctx, ai,
+				sub.balanceCleaned, sub.incomeCleaned, sub.cashFlowCleaned,
+				sub.period, sub.language, hits,
+				sub.units, examples, templates, sub.ensemble, previous)
+			if err != nil {
+				// the statements that finished are billed now, a retry reuses them without billing them again
+				spentTokens = extractionTokens(submitterResponse)
+				var partial *submitter.PartialError
+				if errors.As(err, &partial) {
+					dataCache.Set(partialKey, submitterResponse.WithoutUsage(), PARTIAL_RETENTION)
+				}
+
+				if !sub.draft {
+					logger.Log.Warn("Error calling submitter", zap.Error(err), zap.String("kind", string(llm.Classify(err))),
+						zap.String("username", sub.username), zap.String("ticker", sub.ticker), zap.String("period", sub.period), zap.Int64("tokens", spentTokens))
+					billFailedExtraction(ctx, st, sub.username, spentTokens)
+					return submitterError(err)
+				}
+
+				// a draft is reviewed before it is stored, the rules still give the user something to review
+				logger.Log.Warn("Error calling submitter, falling back to rules", zap.Error(err), zap.String("kind", string(llm.Classify(err))),
+					zap.String("username", sub.username), zap.String("ticker", sub.ticker), zap.String("period", sub.period))
+				extractor = submitter.EXTRACTOR_RULES
+			} else {
+				dataCache.Delete(partialKey)
+				putCachedExtraction(ctx, st, hash, templates.Version, submitterResponse)
+			}
+		}
+	} else {
+		extractor = submitter.EXTRACTOR_RULES
 	}
-	if unitsFromClient.CashFlow != 0 {
-		cashFlowUnitsFloat := float64(unitsFromClient.CashFlow)
-		cashFlow.Units = &cashFlowUnitsFloat
+
+	// rule based extractions use no prompts
+	promptVersion := templates.Version
+	if extractor == submitter.EXTRACTOR_RULES {
+		jobs.Report(ctx, "extracting with rules")
+
+		var err error
+		submitterResponse, err = submitter.ExtractWithRules(
+			sub.balanceCleaned, sub.incomeCleaned, sub.cashFlowCleaned,
+			sub.period, sub.language, hits,
+			sub.units)
+		if err != nil {
+			logger.Log.Error("Error extracting with rules", zap.Error(err))
+			return errSubmitInternal
+		}
+		promptVersion = ""
 	}
 
-	postprocessedResult, err := submitter.Postprocessor(balance, income, cashFlow)
-	if err != nil {
-		logger.Log.Error("Failed to postprocess financial data", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	// Jumps against the previous year already stored
+	for i := range submitterResponse.Periods {
+		compareWithPreviousYear(ctx, st, sub.username, sub.ticker, &submitterResponse.Periods[i])
 	}
 
-	// 4. S3
-	s3Doc := S3Document{}
+	requested := submitterResponse.Periods[0]
+	postprocessedResult := requested.Result
+	comparatives := submitterResponse.Periods[1:]
+
+	// 2. S3
+	jobs.Report(ctx, "uploading")
+	s3Doc := submitter.S3Document{Period: sub.period, Language: sub.language, PromptVersion: promptVersion, Extractor: extractor}
 
-	s3Doc.Balance.Metrics = convertChunkMetrics(req.Content.BalanceResult.Metrics)
-	s3Doc.Balance.RawContent = req.Content.BalanceResult.Chunk
+	s3Doc.Balance.Metrics = convertChunkMetrics(sub.content.BalanceResult.Metrics)
+	s3Doc.Balance.RawContent = sub.content.BalanceResult.Chunk
+	s3Doc.Balance.Units = sub.units.Balance
 	s3Doc.Balance.CleanerPrompt = submitterResponse.Balance.CleanerPrompt
 	s3Doc.Balance.SubmitterPrompt = submitterResponse.Balance.SubmitterPrompt
+	s3Doc.Balance.CleanerContent = sub.balanceCleaned
+	s3Doc.Balance.SubmitterContent = submitterResponse.Balance.CleanerResult
+	s3Doc.Balance.Ensemble = submitterResponse.Balance.Ensemble
 
-	s3Doc.Income.Metrics = convertChunkMetrics(req.Content.IncomeResult.Metrics)
-	s3Doc.Income.RawContent = req.Content.IncomeResult.Chunk
+	s3Doc.Income.Metrics = convertChunkMetrics(sub.content.IncomeResult.Metrics)
+	s3Doc.Income.RawContent = sub.content.IncomeResult.Chunk
+	s3Doc.Income.Units = sub.units.Income
 	s3Doc.Income.CleanerPrompt = submitterResponse.Income.CleanerPrompt
 	s3Doc.Income.SubmitterPrompt = submitterResponse.Income.SubmitterPrompt
+	s3Doc.Income.CleanerContent = sub.incomeCleaned
+	s3Doc.Income.SubmitterContent = submitterResponse.Income.CleanerResult
+	s3Doc.Income.Ensemble = submitterResponse.Income.Ensemble
 
-	s3Doc.CashFlow.Metrics = convertChunkMetrics(req.Content.CashFlowResult.Metrics)
-	s3Doc.CashFlow.RawContent = req.Content.CashFlowResult.Chunk
+	s3Doc.CashFlow.Metrics = convertChunkMetrics(sub.content.CashFlowResult.Metrics)
+	s3Doc.CashFlow.RawContent = sub.content.CashFlowResult.Chunk
+	s3Doc.CashFlow.Units = sub.units.CashFlow
 	s3Doc.CashFlow.CleanerPrompt = submitterResponse.CashFlow.CleanerPrompt
 	s3Doc.CashFlow.SubmitterPrompt = submitterResponse.CashFlow.SubmitterPrompt
+	s3Doc.CashFlow.CleanerContent = sub.cashFlowCleaned
+	s3Doc.CashFlow.SubmitterContent = submitterResponse.CashFlow.CleanerResult
+	s3Doc.CashFlow.Ensemble = submitterResponse.CashFlow.Ensemble
 
 	s3Doc.FinalResult = postprocessedResult
+	s3Doc.Validation = requested.Validation
+	s3Doc.Comparatives = comparatives
 
 	s3JSON, err := json.Marshal(s3Doc)
 	if err != nil {
 		logger.Log.Error("Failed to marshal S3 document", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return errSubmitInternal
 	}
 
 	var compressedData bytes.Buffer
 	gzipWriter := gzip.NewWriter(&compressedData)
 	if _, err := gzipWriter.Write(s3JSON); err != nil {
 		logger.Log.Error("Failed to write to gzip writer", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return errSubmitInternal
 	}
 	if err := gzipWriter.Close(); err != nil {
 		logger.Log.Error("Failed to close gzip writer", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return errSubmitInternal
 	}
 
-	s3BucketName := "financial-docs-outputs"
-	s3FileName := username + "_" + ticker + "_" + period + ".json.gz"
+	s3BucketName := S3_OUTPUTS_BUCKET
+	s3FileName := sub.username + "_" + sub.ticker + "_" + sub.period + ".json.gz"
 
 	v := reflect.ValueOf(postprocessedResult)
 
@@ -468,118 +608,102 @@ func Submit(w http.ResponseWriter, r *http.Request, d *dynamodb.Client, s *s3.Cl
 		ContentType:     aws.String("application/json"),
 		StorageClass:    s3Types.StorageClassStandardIa,
 		Metadata: map[string]string{
-			"language": language,
+			"language":       sub.language,
+			"prompt_version": promptVersion,
+			"extractor":      extractor,
 		},
 	}
 
-	_, err = s.PutObject(ctx, putObjectInput)
-	if err != nil {
-		logger.Log.Error("Failed to upload to S3", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	// 5. DynamoDB
-	year, err := strconv.Atoi(period[:4])
-	if err != nil {
-		logger.Log.Error("Failed to parse year from period", zap.Error(err))
-		w.WriteHeader(http.StatusBadRequest)
-		return
+	// without S3 (MEMORY_STORE) the document is not kept, so its periods are not learned from when edited
+	if s != nil {
+		_, err = s.PutObject(ctx, putObjectInput)
+		if err != nil {
+			logger.Log.Error("Failed to upload to S3", zap.Error(err))
+			return errSubmitInternal
+		}
+	} else {
+		s3FileName = ""
 	}
-	periodType := period[5:]
-	currentTime := time.Now().Unix()
 
-	financeSK, err := buildFinanceSortKey(ticker, year, periodType)
+	// 3. DynamoDB
+	requestedFinance, err := periodResultToFinance(sub.ticker, requested)
 	if err != nil {
-		logger.Log.Error("Failed to build finance sort key", zap.Error(err))
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
+		logger.Log.Error("Failed to parse period", zap.Error(err))
+		return errSubmitPeriod
+	}
+	requestedFinance.SourceDocument = s3FileName
+	requestedFinance.PromptVersion = promptVersion
+
+	// Calculate total tokens, cached extractions made no calls and rule based ones only those that failed
+	totalTokens := spentTokens
+	if !cached && extractor == submitter.EXTRACTOR_LLM {
+		totalTokens = extractionTokens(submitterResponse)
+	}
+
+	comparativeLabels := make([]string, 0, len(comparatives))
+	for _, comparative := range comparatives {
+		comparativeLabels = append(comparativeLabels, comparative.Period)
+	}
+	var result SubmitResult
+	for attempt := 0; ; attempt++ {
+		// The last attempt stores the requested period alone, it is never conditioned
+		storable := map[string]bool{}
+		if attempt < 2 {
+			storable, err = storablePeriods(ctx, st, sub.username, sub.ticker, sub.period, comparativeLabels)
+			if err != nil {
+				logger.Log.Error("Failed to check stored periods", zap.Error(err))
+				return errSubmitInternal
+			}
+		}
 
-	// Calculate total tokens
-	var totalTokens = int64(
-		float64(
-			submitterResponse.Balance.PromptTokensCleaner+submitterResponse.Income.PromptTokensCleaner+submitterResponse.CashFlow.PromptTokensCleaner) +
-			float64(
-				submitterResponse.Balance.PromptTokensSubmitter+submitterResponse.Income.PromptTokensSubmitter+submitterResponse.CashFlow.PromptTokensSubmitter) +
-			float64(
-				submitterResponse.Balance.CompletionTokensCleaner+submitterResponse.Income.CompletionTokensCleaner+submitterResponse.CashFlow.CompletionTokensCleaner)*(OUTPUT_RATE_SMALL/INPUT_RATE_SMALL) +
-			float64(
-				submitterResponse.Balance.CompletionTokensSubmitter+submitterResponse.Income.CompletionTokensSubmitter+submitterResponse.CashFlow.CompletionTokensSubmitter)*(OUTPUT_RATE_SMALL/INPUT_RATE_SMALL),
-	)
-
-	// DynamoDB Transaction
-	transactItems := []dynamoTypes.TransactWriteItem{
-		// 1. FINANCE#{ticker}#{reverse_year}#{period_order}
-		{
-			Put: &dynamoTypes.Put{
-				TableName: aws.String("nodofinance_table"),
-				Item: map[string]dynamoTypes.AttributeValue{
-					"username":                  &dynamoTypes.AttributeValueMemberS{Value: username},
-					"composite_sk":              &dynamoTypes.AttributeValueMemberS{Value: financeSK},
-					"current_assets":            &dynamoTypes.AttributeValueMemberN{Value: formatInt64Ptr(postprocessedResult.CurrentAssets)},
-					"non_current_assets":        &dynamoTypes.AttributeValueMemberN{Value: formatInt64Ptr(postprocessedResult.NonCurrentAssets)},
-					"eps":                       &dynamoTypes.AttributeValueMemberN{Value: formatFloat64Ptr(postprocessedResult.EPS)},
-					"cash_and_equivalents":      &dynamoTypes.AttributeValueMemberN{Value: formatInt64Ptr(postprocessedResult.Cash)},
-					"cash_flow_from_financing":  &dynamoTypes.AttributeValueMemberN{Value: formatInt64Ptr(postprocessedResult.CashFlowFromFinancing)},
-					"cash_flow_from_investing":  &dynamoTypes.AttributeValueMemberN{Value: formatInt64Ptr(postprocessedResult.CashFlowFromInvesting)},
-					"cash_flow_from_operations": &dynamoTypes.AttributeValueMemberN{Value: formatInt64Ptr(postprocessedResult.CashFlowFromOperations)},
-					"revenue":                   &dynamoTypes.AttributeValueMemberN{Value: formatInt64Ptr(postprocessedResult.Revenue)},
-					"current_liabilities":       &dynamoTypes.AttributeValueMemberN{Value: formatInt64Ptr(postprocessedResult.CurrentLiabilities)},
-					"non_current_liabilities":   &dynamoTypes.AttributeValueMemberN{Value: formatInt64Ptr(postprocessedResult.NonCurrentLiabilities)},
-					"net_income":                &dynamoTypes.AttributeValueMemberN{Value: formatInt64Ptr(postprocessedResult.NetIncome)},
-				},
-			},
-		},
-		// 2. TICKER#{ticker}
-		{
-			Put: &dynamoTypes.Put{
-				TableName: aws.String("nodofinance_table"),
-				Item: map[string]dynamoTypes.AttributeValue{
-					"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-					"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: fmt.Sprintf("TICKER#%s", ticker)},
-					"last_update":  &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(currentTime, 10)},
-					"currency":     &dynamoTypes.AttributeValueMemberS{Value: currency},
-					// analysis starts empty, can be updated later
-				},
-			},
-		},
-		// 3. User Token Update
-		{
-			Update: &dynamoTypes.Update{
-				TableName: aws.String("nodofinance_table"),
-				Key: map[string]dynamoTypes.AttributeValue{
-					"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-					"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: "METADATA"},
-				},
-				UpdateExpression: aws.String("ADD ctokens :tokens"),
-				ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
-					":tokens": &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(totalTokens, 10)},
-				},
-				ConditionExpression: aws.String("attribute_exists(username) AND attribute_exists(composite_sk)"),
-			},
-		},
-	}
+		finances := []store.Finance{requestedFinance}
+		validations := []submitter.Validation{requested.Validation}
+		result = SubmitResult{Ticker: sub.ticker, Periods: []SubmitPeriod{newSubmitPeriod(requested)}, Cached: cached, Extractor: extractor}
+		for _, comparative := range comparatives {
+			if !storable[comparative.Period] {
+				continue
+			}
+			f, err := periodResultToFinance(sub.ticker, comparative)
+			if err != nil {
+				continue
+			}
+			f.SourceDocument = s3FileName
+			f.PromptVersion = promptVersion
+			finances = append(finances, f)
+			validations = append(validations, comparative.Validation)
+			result.Periods = append(result.Periods, newSubmitPeriod(comparative))
+		}
 
-	// Execute transaction
-	_, err = d.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
-		TransactItems: transactItems,
-	})
+		if sub.draft {
+			// DRAFT# and token update in one transaction, FINANCE# waits for the approval
+			d := newDraft(sub.ticker, sub.period, sub.currency, finances, validations)
+			err = st.PutDraft(ctx, sub.username, d, totalTokens)
+			result.Draft, result.DraftExpiresAt = true, d.ExpiresAt
+		} else {
+			// FINANCE# of every period, TICKER# and token update in one transaction
+			err = st.PutFinancePeriods(ctx, sub.username, sub.ticker, finances, sub.currency, totalTokens)
+		}
 
+		// A comparative was edited after storablePeriods read it, the next attempt leaves it out
+		if errors.Is(err, store.ErrConditionFailed) && attempt < 2 {
+			logger.Log.Info("Comparative period edited meanwhile, retrying", zap.String("ticker", sub.ticker), zap.String("period", sub.period))
+			continue
+		}
+		break
+	}
 	if err != nil {
-		// Handle condition check failure
-		var conditionCheckFailed *dynamoTypes.TransactionCanceledException
-		if errors.As(err, &conditionCheckFailed) {
-			logger.Log.Error("User does not exist", zap.String("username", username))
-			w.WriteHeader(http.StatusBadRequest)
-			return
+		if errors.Is(err, store.ErrNotFound) {
+			logger.Log.Error("User does not exist", zap.String("username", sub.username))
+			return errSubmitUser
 		}
 
-		logger.Log.Error("Transaction failed", zap.Error(err), zap.String("username", username), zap.String("ticker", ticker), zap.String("period", period))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		logger.Log.Error("Transaction failed", zap.Error(err), zap.String("username", sub.username), zap.String("ticker", sub.ticker), zap.String("period", sub.period))
+		return errSubmitInternal
 	}
 
+	jobs.SetResult(ctx, result)
+	jobs.Report(ctx, "persisted")
+
 	// *
 	// **
 	// ***
@@ -591,13 +715,12 @@ func Submit(w http.ResponseWriter, r *http.Request, d *dynamodb.Client, s *s3.Cl
 		postprocessedResultJSON, err := json.Marshal(postprocessedResult)
 		if err != nil {
 			logger.Log.Error("Failed to marshal postprocessed result", zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			return errSubmitInternal
 		}
 
-		balanceResultJSON, _ := json.Marshal(req.Content.BalanceResult.Metrics)
-		incomeResultJSON, _ := json.Marshal(req.Content.IncomeResult.Metrics)
-		cashFlowResultJSON, _ := json.Marshal(req.Content.CashFlowResult.Metrics)
+		balanceResultJSON, _ := json.Marshal(sub.content.BalanceResult.Metrics)
+		incomeResultJSON, _ := json.Marshal(sub.content.IncomeResult.Metrics)
+		cashFlowResultJSON, _ := json.Marshal(sub.content.CashFlowResult.Metrics)
 
 		contentToWrite := fmt.Sprintf(">>>>>SUBMITTER_RESULT:\n\n>>>Balance:\n\n%s\n\n\n\n>>>Income\n\n%s\n\n\n\n>>>CashFlow\n\n%s\n"+
 			"\n\n\n\n>>>>>FINAL_RESULT:\n%s\n\n\n\n>>>>>HITS:\nbalance:%s\nincome:%s\ncashflow:%s",
@@ -613,64 +736,64 @@ func Submit(w http.ResponseWriter, r *http.Request, d *dynamodb.Client, s *s3.Cl
 		err = os.WriteFile(filePath, []byte(contentToWrite), 0644)
 		if err != nil {
 			fmt.Println("Error writing file:", err)
-			return
+			return nil
 		}
 
 		filePath = "/Users/vitor/desktop/nodofinance/tests/balance___raw.txt"
-		err = os.WriteFile(filePath, []byte(req.Content.BalanceResult.Chunk), 0644)
+		err = os.WriteFile(filePath, []byte(sub.content.BalanceResult.Chunk), 0644)
 		if err != nil {
 			fmt.Println("Error writing file:", err)
-			return
+			return nil
 		}
 		filePath = "/Users/vitor/desktop/nodofinance/tests/balance__cleaner.txt"
 		err = os.WriteFile(filePath, []byte(submitterResponse.Balance.CleanerPrompt), 0644)
 		if err != nil {
 			fmt.Println("Error writing file:", err)
-			return
+			return nil
 		}
 		filePath = "/Users/vitor/desktop/nodofinance/tests/balance_submitter.txt"
 		err = os.WriteFile(filePath, []byte(submitterResponse.Balance.SubmitterPrompt), 0644)
 		if err != nil {
 			fmt.Println("Error writing file:", err)
-			return
+			return nil
 		}
 
 		filePath = "/Users/vitor/desktop/nodofinance/tests/income___raw.txt"
-		err = os.WriteFile(filePath, []byte(req.Content.IncomeResult.Chunk), 0644)
+		err = os.WriteFile(filePath, []byte(sub.content.IncomeResult.Chunk), 0644)
 		if err != nil {
 			fmt.Println("Error writing file:", err)
-			return
+			return nil
 		}
 		filePath = "/Users/vitor/desktop/nodofinance/tests/income__cleaner.txt"
 		err = os.WriteFile(filePath, []byte(submitterResponse.Income.CleanerPrompt), 0644)
 		if err != nil {
 			fmt.Println("Error writing file:", err)
-			return
+			return nil
 		}
 		filePath = "/Users/vitor/desktop/nodofinance/tests/income_submitter.txt"
 		err = os.WriteFile(filePath, []byte(submitterResponse.Income.SubmitterPrompt), 0644)
 		if err != nil {
 			fmt.Println("Error writing file:", err)
-			return
+			return nil
 		}
 
 		filePath = "/Users/vitor/desktop/nodofinance/tests/cashflow___raw.txt"
-		err = os.WriteFile(filePath, []byte(req.Content.CashFlowResult.Chunk), 0644)
+		err = os.WriteFile(filePath, []byte(sub.content.CashFlowResult.Chunk), 0644)
 		if err != nil {
 			fmt.Println("Error writing file:", err)
-			return
+			return nil
 		}
 		filePath = "/Users/vitor/desktop/nodofinance/tests/cashflow__cleaner.txt"
 		err = os.WriteFile(filePath, []byte(submitterResponse.CashFlow.CleanerPrompt), 0644)
 		if err != nil {
 			fmt.Println("Error writing file:", err)
-			return
+			return nil
 		}
 		filePath = "/Users/vitor/desktop/nodofinance/tests/cashflow_submitter.txt"
 		err = os.WriteFile(filePath, []byte(submitterResponse.CashFlow.SubmitterPrompt), 0644)
 		if err != nil {
 			fmt.Println("Error writing file:", err)
-			return
+			return nil
 		}
 	}
 	// ******* <- DEVMODE
@@ -681,9 +804,195 @@ func Submit(w http.ResponseWriter, r *http.Request, d *dynamodb.Client, s *s3.Cl
 	// **
 	// *
 
-	cacheKey := "tickers_" + username
+	cacheKey := "tickers_" + sub.username
 	dataCache.Delete(cacheKey)
 
-	// 6. Response
-	w.WriteHeader(http.StatusOK)
+	return nil
+
+}
+
+// submitterError fails a submission whose AI extraction failed: 429 while the calls are saturated,
+// 503 otherwise. Drafts fall back to the rules instead.
+func submitterError(err error) error {
+	var busyErr *llm.BusyError
+	if errors.As(err, &busyErr) {
+		return &jobs.Error{Code: http.StatusTooManyRequests, Message: "Too many requests in progress, try again later"}
+	}
+	if llm.Classify(err) == llm.KindUnavailable {
+		return &jobs.Error{Code: http.StatusServiceUnavailable, Message: llm.ErrUnavailable.Error()}
+	}
+	return &jobs.Error{Code: http.StatusServiceUnavailable, Message: "The AI extraction failed, try again or submit a draft to review a rule based extraction"}
+}
+
+// billFailedExtraction adds the tokens of the calls of a failed extraction. Failures are only logged,
+// the submission fails anyway.
+func billFailedExtraction(ctx context.Context, st store.Store, username string, tokens int64) {
+	if tokens == 0 {
+		return
+	}
+	if err := st.AddTokens(ctx, username, tokens); err != nil {
+		logger.Log.Error("Failed to bill failed extraction", zap.Error(err), zap.String("username", username), zap.Int64("tokens", tokens))
+	}
+}
+
+// extractionTokens bills the calls of an extraction in small model input tokens.
+// Ensemble runs are billed at the rates of their model, see modelRates.
+func extractionTokens(res submitter.SubmitterRes) int64 {
+	outputRatio := OUTPUT_RATE_SMALL / INPUT_RATE_SMALL
+
+	total := 0.0
+	for _, r := range []submitter.AIresponse{res.Balance, res.Income, res.CashFlow} {
+		total += float64(r.PromptTokensCleaner) + float64(r.CompletionTokensCleaner)*outputRatio
+
+		if len(r.Ensemble) == 0 {
+			total += float64(r.PromptTokensSubmitter) + float64(r.CompletionTokensSubmitter)*outputRatio
+			continue
+		}
+		for _, member := range r.Ensemble {
+			inputRate, outputRate := modelRates(member.Model)
+			total += (float64(member.PromptTokens)*inputRate + float64(member.CompletionTokens)*outputRate) / INPUT_RATE_SMALL
+		}
+	}
+
+	return int64(total)
+}
+
+// storablePeriods returns the comparative periods that can be stored along the requested one:
+// periods edited by the user are kept as they are and new periods are added while MAX_PERIODS allows
+func storablePeriods(ctx context.Context, st store.Store, username, ticker, requested string, comparatives []string) (map[string]bool, error) {
+	storable := make(map[string]bool, len(comparatives))
+	if len(comparatives) == 0 {
+		return storable, nil
+	}
+
+	existing, err := st.ListFinancePeriods(ctx, username, ticker)
+	if err != nil {
+		return nil, err
+	}
+
+	edited := make(map[string]bool, len(existing))
+	for _, f := range existing {
+		edited[fmt.Sprintf("%d-%s", f.Year, f.Period)] = f.Edited
+	}
+
+	freeSlots := int(MAX_PERIODS) - len(existing)
+	if _, exists := edited[requested]; !exists {
+		freeSlots--
+	}
+
+	// the requested period and a draft delete share the transaction
+	maxComparatives := store.MaxTransactionPeriods - 2
+
+	for _, comparative := range comparatives {
+		isEdited, exists := edited[comparative]
+		if isEdited || (!exists && freeSlots <= 0) || len(storable) >= maxComparatives || comparative == requested {
+			continue
+		}
+		if !exists {
+			freeSlots--
+		}
+		storable[comparative] = true
+	}
+
+	return storable, nil
+}
+
+// compareWithPreviousYear adds the year over year checks of a period, if the previous year is stored
+func compareWithPreviousYear(ctx context.Context, st store.Store, username, ticker string, result *submitter.PeriodResult) {
+	year, periodType, err := splitPeriod(result.Period)
+	if err != nil {
+		return
+	}
+
+	previous, err := st.GetFinancePeriod(ctx, username, ticker, year-1, periodType)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			logger.Log.Warn("Failed to get previous year", zap.Error(err), zap.String("ticker", ticker), zap.String("period", result.Period))
+		}
+		return
+	}
+
+	result.Validation.CompareWithPrevious(result.Result, financeToPostprocessed(previous))
+}
+
+func periodResultToFinance(ticker string, result submitter.PeriodResult) (store.Finance, error) {
+	year, periodType, err := splitPeriod(result.Period)
+	if err != nil {
+		return store.Finance{}, err
+	}
+
+	f := postprocessedToFinance(ticker, year, periodType, result.Result)
+	f.LowConfidence = result.Validation.LowConfidence()
+	f.Warnings = result.Validation.Messages()
+	f.Provenance = result.Provenance
+	return f, nil
+}
+
+func financeToPostprocessed(f store.Finance) submitter.Postprocessed {
+	return submitter.Postprocessed{
+		CurrentAssets:          f.CurrentAssets,
+		Cash:                   f.Cash,
+		NonCurrentAssets:       f.NonCurrentAssets,
+		CurrentLiabilities:     f.CurrentLiabilities,
+		NonCurrentLiabilities:  f.NonCurrentLiabilities,
+		CashFlowFromOperations: f.CashFlowFromOperations,
+		CashFlowFromInvesting:  f.CashFlowFromInvesting,
+		CashFlowFromFinancing:  f.CashFlowFromFinancing,
+		EPS:                    f.EPS,
+		Revenue:                f.Revenue,
+		NetIncome:              f.NetIncome,
+	}
+}
+
+func postprocessedToFinance(ticker string, year int, period string, p submitter.Postprocessed) store.Finance {
+	return store.Finance{
+		Ticker:                 ticker,
+		Year:                   year,
+		Period:                 period,
+		CurrentAssets:          p.CurrentAssets,
+		NonCurrentAssets:       p.NonCurrentAssets,
+		Cash:                   p.Cash,
+		CurrentLiabilities:     p.CurrentLiabilities,
+		NonCurrentLiabilities:  p.NonCurrentLiabilities,
+		Revenue:                p.Revenue,
+		NetIncome:              p.NetIncome,
+		EPS:                    p.EPS,
+		CashFlowFromOperations: p.CashFlowFromOperations,
+		CashFlowFromInvesting:  p.CashFlowFromInvesting,
+		CashFlowFromFinancing:  p.CashFlowFromFinancing,
+	}
+}
+
+// verifyPreprocessorOutput recomputes the cleaned text and units of every chunk
+// and rejects metrics or indicators the chunk cannot produce
+func verifyPreprocessorOutput(content *PreprocessorOutput, period string) error {
+	sections := []struct {
+		target string
+		result *ChunkResult
+	}{
+		{"balance", &content.BalanceResult},
+		{"income", &content.IncomeResult},
+		{"cash_flow", &content.CashFlowResult},
+	}
+
+	for _, section := range sections {
+		hits, found := preprocess.UniqueHits(section.result.Chunk, section.target, content.Language)
+		if section.result.Metrics.FirstUniqueHits > hits {
+			return fmt.Errorf("%s: %d unique hits claimed, %d found", section.target, section.result.Metrics.FirstUniqueHits, hits)
+		}
+
+		for _, indicator := range section.result.Indicators {
+			if !slices.Contains(found, indicator) {
+				return fmt.Errorf("%s: indicator %q not found in chunk", section.target, indicator)
+			}
+		}
+
+		cleaned, units := preprocess.CleanChunk(section.target, section.result.Chunk, content.Language, period)
+		section.result.Cleaned = cleaned
+		if units != 0 {
+			section.result.Units = units
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,335 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"nodofinance/jobs"
+	"nodofinance/llm"
+	"nodofinance/routes/app/submitter"
+	"nodofinance/store"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// setRates sets the billing rates for the test
+func setRates(t *testing.T, inputSmall, outputSmall, inputBig, outputBig float64) {
+	t.Helper()
+
+	saved := []float64{INPUT_RATE_SMALL, OUTPUT_RATE_SMALL, INPUT_RATE_BIG, OUTPUT_RATE_BIG}
+	t.Cleanup(func() {
+		INPUT_RATE_SMALL, OUTPUT_RATE_SMALL, INPUT_RATE_BIG, OUTPUT_RATE_BIG = saved[0], saved[1], saved[2], saved[3]
+	})
+	INPUT_RATE_SMALL, OUTPUT_RATE_SMALL, INPUT_RATE_BIG, OUTPUT_RATE_BIG = inputSmall, outputSmall, inputBig, outputBig
+}
+
+func TestExtractionTokens(t *testing.T) {
+	setRates(t, 1, 4, 10, 40)
+
+	member := func(model string) submitter.MemberResult {
+		return submitter.MemberResult{EnsembleMember: llm.EnsembleMember{Model: model}, PromptTokens: 100, CompletionTokens: 10}
+	}
+
+	tests := []struct {
+		name string
+		res  submitter.AIresponse
+		want int64
+	}{
+		{
+			name: "single run at small rates",
+			res: submitter.AIresponse{
+				PromptTokensCleaner: 100, CompletionTokensCleaner: 10,
+				PromptTokensSubmitter: 200, CompletionTokensSubmitter: 20,
+			},
+			want: 100 + 40 + 200 + 80,
+		},
+		{
+			name: "submitter member at small rates",
+			res:  submitter.AIresponse{Ensemble: []submitter.MemberResult{member(llm.MODEL_SUBMITTER)}},
+			want: 100 + 40,
+		},
+		{
+			name: "big analyst member at big rates",
+			res:  submitter.AIresponse{Ensemble: []submitter.MemberResult{member(llm.MODEL_ANALYST_BIG)}},
+			want: 1000 + 400,
+		},
+		{
+			name: "unknown member at the highest rates",
+			res:  submitter.AIresponse{Ensemble: []submitter.MemberResult{member("some-other-model")}},
+			want: 1000 + 400,
+		},
+		{
+			name: "ensemble replaces the submitter tokens, not the cleaner ones",
+			res: submitter.AIresponse{
+				PromptTokensCleaner: 100, CompletionTokensCleaner: 10,
+				PromptTokensSubmitter: 200, CompletionTokensSubmitter: 20,
+				Ensemble: []submitter.MemberResult{member(llm.MODEL_SUBMITTER), member(llm.MODEL_ANALYST_BIG)},
+			},
+			want: 100 + 40 + 100 + 40 + 1000 + 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractionTokens(submitter.SubmitterRes{Balance: tt.res}); got != tt.want {
+				t.Errorf("extractionTokens() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModelRatesUnknownModelIsNeverCheaper(t *testing.T) {
+	// a big model cheaper than the small one, the unknown model takes the highest of each
+	setRates(t, 5, 1, 2, 8)
+
+	input, output := modelRates("some-other-model")
+	if input != 5 || output != 8 {
+		t.Errorf("modelRates() = %g, %g, want 5, 8", input, output)
+	}
+}
+
+// quickSubmitBody is a quick submission of the raw filing in testdata
+func quickSubmitBody(t *testing.T, ticker, period, filing string) string {
+	t.Helper()
+
+	raw, err := os.ReadFile("../../preprocess/testdata/" + filing)
+	if err != nil {
+		t.Fatalf("reading filing: %v", err)
+	}
+
+	body, err := json.Marshal(SubmitReq{Ticker: ticker, Period: period, Currency: "USD", Raw: string(raw), Mode: SUBMIT_MODE_QUICK})
+	if err != nil {
+		t.Fatalf("json.Marshal() = %v", err)
+	}
+	return string(body)
+}
+
+// awaitJob waits for the job of a 202 Submit response to finish
+func awaitJob(t *testing.T, queue *jobs.Queue, w *httptest.ResponseRecorder) jobs.Snapshot {
+	t.Helper()
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Submit() status = %d, want %d: %s", w.Code, http.StatusAccepted, w.Body.String())
+	}
+
+	var res SubmitRes
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	job, found := queue.Get(testUser, res.JobID)
+	if !found {
+		t.Fatalf("job %s not found", res.JobID)
+	}
+
+	_, events, unsubscribe := job.Subscribe()
+	defer unsubscribe()
+	for range events {
+	}
+	return job.Snapshot()
+}
+
+func TestSubmitQuickStoresPeriodsAndEditRecordsCorrection(t *testing.T) {
+	setLimits(t, 1000, 5, 5)
+	st := newMemoryStore(t, 0)
+	s3Client, fake := newS3Client(t)
+	queue := jobs.NewQueue(1, 4, time.Minute, time.Minute)
+	dataCache := cache.New(cache.NoExpiration, 0)
+	ctx := context.Background()
+
+	w := httptest.NewRecorder()
+	Submit(w, newRequest(t, http.MethodPost, "/submit", quickSubmitBody(t, "ACME", "2024-Y", "filing_en.txt"), testUser), st, s3Client, nil, dataCache, queue, false)
+	if snapshot := awaitJob(t, queue, w); snapshot.Status != jobs.StatusDone {
+		t.Fatalf("job status = %s, want %s: %s", snapshot.Status, jobs.StatusDone, snapshot.Error)
+	}
+
+	requested, err := st.GetFinancePeriod(ctx, testUser, "ACME", 2024, "Y")
+	if err != nil {
+		t.Fatalf("GetFinancePeriod() of the requested period = %v", err)
+	}
+	if requested.CurrentAssets == nil || *requested.CurrentAssets != 27_800_000_000 {
+		t.Errorf("requested current assets = %v, want 27800000000", deref(requested.CurrentAssets))
+	}
+	// the income statement has a column more than the balance sheet above it in the chunk
+	if requested.Revenue == nil || *requested.Revenue != 48_200_000_000 {
+		t.Errorf("requested revenue = %v, want 48200000000", deref(requested.Revenue))
+	}
+	if requested.SourceDocument != testUser+"_ACME_2024-Y.json.gz" {
+		t.Errorf("source document = %q, want the S3 key of the submission", requested.SourceDocument)
+	}
+	if _, found := fake.object(requested.SourceDocument); !found {
+		t.Errorf("S3 document %s not uploaded", requested.SourceDocument)
+	}
+
+	comparative, err := st.GetFinancePeriod(ctx, testUser, "ACME", 2023, "Y")
+	if err != nil {
+		t.Fatalf("GetFinancePeriod() of the comparative period = %v", err)
+	}
+	if comparative.CurrentAssets == nil || *comparative.CurrentAssets != 24_510_000_000 {
+		t.Errorf("comparative current assets = %v, want 24510000000", deref(comparative.CurrentAssets))
+	}
+
+	ticker, err := st.GetTicker(ctx, testUser, "ACME")
+	if err != nil || ticker.Currency != "USD" {
+		t.Errorf("GetTicker() = %+v, %v, want USD", ticker, err)
+	}
+
+	// quick submissions spend no tokens
+	user, err := st.GetMetadata(ctx, testUser)
+	if err != nil || *user.CTokens != 0 {
+		t.Errorf("tokens after a quick submission = %v, %v, want 0", user.CTokens, err)
+	}
+
+	// the first edit of an extracted period teaches the submitter, with the statement of the S3 document
+	values := map[string]any{}
+	for field, value := range financeValues(requested) {
+		if value != nil {
+			values[field] = *value
+		}
+	}
+	values["current_assets"] = 27_900_000_000
+
+	w = httptest.NewRecorder()
+	Edit(w, newRequest(t, http.MethodPost, "/edit", editBody(t, "ACME", "2024-Y", values), testUser), st, s3Client)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Edit() status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	corrections, err := st.ListCorrections(ctx, testUser, "EN", "balance", 10)
+	if err != nil {
+		t.Fatalf("ListCorrections() = %v", err)
+	}
+	if len(corrections) != 1 || corrections[0].Period != "2024-Y" || corrections[0].Chunk == "" {
+		t.Fatalf("balance corrections = %+v, want one of 2024-Y with its chunk", corrections)
+	}
+	if fields := corrections[0].Fields; len(fields) != 1 || fields[0].Field != "current_assets" {
+		t.Errorf("corrected fields = %+v, want current_assets only", fields)
+	}
+
+	corrections, err = st.ListCorrections(ctx, testUser, "EN", "income", 10)
+	if err != nil || len(corrections) != 0 {
+		t.Errorf("income corrections = %+v, %v, want none", corrections, err)
+	}
+}
+
+func TestSubmitLimits(t *testing.T) {
+	setLimits(t, 1000, 2, 5)
+	body := quickSubmitBody(t, "ACME", "2024-Y", "filing_en.txt")
+
+	tests := []struct {
+		name       string
+		setup      func(t *testing.T) store.Store
+		mode       string
+		wantStatus int
+		wantJob    jobs.Status
+	}{
+		{
+			name:       "quick with tokens used up",
+			setup:      func(t *testing.T) store.Store { return newMemoryStore(t, 1000) },
+			mode:       SUBMIT_MODE_QUICK,
+			wantStatus: http.StatusAccepted,
+			wantJob:    jobs.StatusDone,
+		},
+		{
+			name:       "AI with tokens used up",
+			setup:      func(t *testing.T) store.Store { return newMemoryStore(t, 1000) },
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "quick with periods used up",
+			setup: func(t *testing.T) store.Store {
+				st := newMemoryStore(t, 0)
+				putPeriod(t, st, "ACME", 2021, "Y", 100)
+				putPeriod(t, st, "ACME", 2022, "Y", 100)
+				return st
+			},
+			mode:       SUBMIT_MODE_QUICK,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			// limits let it through, the transaction finds no user
+			name:       "quick without metadata",
+			setup:      func(t *testing.T) store.Store { return store.NewMemory() },
+			mode:       SUBMIT_MODE_QUICK,
+			wantStatus: http.StatusAccepted,
+			wantJob:    jobs.StatusFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req SubmitReq
+			if err := json.Unmarshal([]byte(body), &req); err != nil {
+				t.Fatalf("json.Unmarshal() = %v", err)
+			}
+			req.Mode = tt.mode
+			modeBody, _ := json.Marshal(req)
+
+			s3Client, _ := newS3Client(t)
+			queue := jobs.NewQueue(1, 4, time.Minute, time.Minute)
+
+			w := httptest.NewRecorder()
+			Submit(w, newRequest(t, http.MethodPost, "/submit", string(modeBody), testUser), tt.setup(t), s3Client, nil, cache.New(cache.NoExpiration, 0), queue, false)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Submit() status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusAccepted {
+				return
+			}
+
+			if snapshot := awaitJob(t, queue, w); snapshot.Status != tt.wantJob {
+				t.Errorf("job status = %s, want %s: %s", snapshot.Status, tt.wantJob, snapshot.Error)
+			}
+		})
+	}
+}
+
+// editingStore edits a period of the user right before the first write, like a concurrent Edit
+type editingStore struct {
+	*store.Memory
+	edit   store.Finance
+	edited bool
+}
+
+func (s *editingStore) PutFinancePeriods(ctx context.Context, username, ticker string, periods []store.Finance, currency string, tokens int64) error {
+	if !s.edited {
+		s.edited = true
+		if err := s.Memory.PutFinancePeriod(ctx, username, s.edit, currency, 0); err != nil {
+			return err
+		}
+	}
+	return s.Memory.PutFinancePeriods(ctx, username, ticker, periods, currency, tokens)
+}
+
+func TestSubmitKeepsComparativeEditedMeanwhile(t *testing.T) {
+	setLimits(t, 1000, 5, 5)
+	currentAssets := int64(1)
+	st := &editingStore{
+		Memory: newMemoryStore(t, 0),
+		edit:   store.Finance{Ticker: "ACME", Year: 2023, Period: "Y", CurrentAssets: &currentAssets, Edited: true},
+	}
+	s3Client, _ := newS3Client(t)
+	queue := jobs.NewQueue(1, 4, time.Minute, time.Minute)
+
+	w := httptest.NewRecorder()
+	Submit(w, newRequest(t, http.MethodPost, "/submit", quickSubmitBody(t, "ACME", "2024-Y", "filing_en.txt"), testUser), st, s3Client, nil, cache.New(cache.NoExpiration, 0), queue, false)
+	if snapshot := awaitJob(t, queue, w); snapshot.Status != jobs.StatusDone {
+		t.Fatalf("job status = %s, want %s: %s", snapshot.Status, jobs.StatusDone, snapshot.Error)
+	}
+
+	ctx := context.Background()
+	if _, err := st.GetFinancePeriod(ctx, testUser, "ACME", 2024, "Y"); err != nil {
+		t.Errorf("GetFinancePeriod() of the requested period = %v", err)
+	}
+	comparative, err := st.GetFinancePeriod(ctx, testUser, "ACME", 2023, "Y")
+	if err != nil {
+		t.Fatalf("GetFinancePeriod() of the comparative period = %v", err)
+	}
+	if !comparative.Edited || *comparative.CurrentAssets != currentAssets {
+		t.Errorf("comparative = edited %t, current assets %v, want the edit kept", comparative.Edited, deref(comparative.CurrentAssets))
+	}
+}
@@ -0,0 +1,42 @@
+package submitter
+
+/*
+	S3Document is the record of a submission in financial-docs-outputs: the statements sent to the
+	prompts, the answers and the final result. Corrections read the chunks back and the eval replays them.
+*/
+
+type S3MetricsData struct {
+	First  int `json:"first"`
+	Second int `json:"second"`
+	Third  int `json:"third"`
+	Fourth int `json:"fourth"`
+	Fifth  int `json:"fifth"`
+}
+
+type S3StatementSection struct {
+	Metrics         S3MetricsData `json:"metrics"`
+	Units           int64         `json:"units,omitempty"`
+	RawContent      string        `json:"raw_content"`
+	CleanerPrompt   string        `json:"cleaner_prompt"`
+	SubmitterPrompt string        `json:"submitter_prompt"`
+	// Texts the prompts were rendered with
+	CleanerContent   string `json:"cleaner_content,omitempty"`
+	SubmitterContent string `json:"submitter_content,omitempty"`
+	// Answers of every run of an ensemble extraction
+	Ensemble []MemberResult `json:"ensemble,omitempty"`
+}
+
+type S3Document struct {
+	Period   string `json:"period,omitempty"`
+	Language string `json:"language,omitempty"`
+	// Version of the prompt templates, see the prompts package
+	PromptVersion string             `json:"prompt_version,omitempty"`
+	Extractor     string             `json:"extractor,omitempty"`
+	Balance       S3StatementSection `json:"balance"`
+	Income        S3StatementSection `json:"income"`
+	CashFlow      S3StatementSection `json:"cash_flow"`
+	FinalResult   Postprocessed      `json:"final_result"`
+	Validation    Validation         `json:"validation"`
+	// Other period columns of the same statements
+	Comparatives []PeriodResult `json:"comparatives,omitempty"`
+}
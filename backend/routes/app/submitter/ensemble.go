@@ -0,0 +1,273 @@
+package submitter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"nodofinance/llm"
+	"nodofinance/utils/logger"
+
+	"go.uber.org/zap"
+)
+
+/*
+	Ensemble extraction: the cleaned text of a statement goes to several submitter runs (models or
+	temperatures, see llm.ENSEMBLE_SUBMITTERS) and their answers are merged field by field. A value most
+	runs agree on wins, otherwise the median. Fields without a unanimous answer are low confidence.
+*/
+
+const (
+	// Values within this relative distance are the same vote
+	ensembleTolerance float64 = 0.005
+	// Confidence factor of the fields the runs disagree on, under LOW_CONFIDENCE
+	ensembleDisagreement float64 = 0.5
+)
+
+// Postprocessed fields computed from statement fields that are not stored themselves
+var ensembleDerived = map[string][]string{
+	"total_assets":      {"non_current_assets"},
+	"total_liabilities": {"non_current_liabilities"},
+	"equity":            {"non_current_liabilities"},
+}
+
+// MemberResult is the answer of one ensemble run, its tokens are billed at the rate of its model
+type MemberResult struct {
+	llm.EnsembleMember
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	Content          string `json:"content,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// callEnsemble runs the submitter prompt with every member. Failed runs are left out of the vote,
+// the statement fails only if all of them do.
+func callEnsemble(ctx context.Context, ai llm.Provider, req llm.Request, schema llm.Schema, ensemble []llm.EnsembleMember) []MemberResult {
+	members := make([]MemberResult, len(ensemble))
+
+	done := make(chan struct{}, len(ensemble))
+	for i, member := range ensemble {
+		go func() {
+			defer func() { done <- struct{}{} }()
+
+			memberReq := req
+			memberReq.Model = member.Model
+			memberReq.Temperature = member.Temperature
+
+			members[i].EnsembleMember = member
+			res, err := ai.ChatJSON(ctx, memberReq, schema)
+			// a failed run still has the usage of the answers it threw away
+			members[i].PromptTokens = res.Usage.PromptTokens
+			members[i].CompletionTokens = res.Usage.CompletionTokens
+			if err != nil {
+				logger.Log.Warn("Ensemble member failed", zap.Error(err), zap.String("model", member.Model), zap.Float64("temperature", member.Temperature))
+				members[i].Error = err.Error()
+				return
+			}
+
+			members[i].Content = res.Content
+		}()
+	}
+	for range ensemble {
+		<-done
+	}
+
+	return members
+}
+
+// mergeAnswers votes the answers of a statement into one, in the submitter format, and returns the
+// fields of each period that were not unanimous. Units given by the request are not voted, like the
+// schema of the runs, they replace the answered ones anyway.
+func mergeAnswers(target, requested string, units int64, members []MemberResult) (string, map[string][]string, error) {
+	var answers []map[string]string
+	for _, member := range members {
+		if member.Error != "" {
+			continue
+		}
+		periods, err := ParsePeriods(member.Content)
+		if err != nil {
+			logger.Log.Warn("Invalid ensemble answer", zap.Error(err), zap.String("model", member.Model))
+			continue
+		}
+		answers = append(answers, periods)
+	}
+	if len(answers) == 0 {
+		return "", nil, fmt.Errorf("every ensemble member failed on %s", target)
+	}
+
+	// periods most answers found, and the requested one if any did
+	counts := map[string]int{}
+	for _, periods := range answers {
+		for period := range periods {
+			counts[period]++
+		}
+	}
+	var labels []string
+	for period, count := range counts {
+		if period != requested && count*2 > len(answers) {
+			labels = append(labels, period)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(labels)))
+	if counts[requested] > 0 {
+		labels = append([]string{requested}, labels...)
+	}
+
+	fields := getRequiredFields(target, units)
+	merged := make([]any, 0, len(labels))
+	disagreements := map[string][]string{}
+
+	for _, period := range labels {
+		var docs []ensembleDoc
+		for _, periods := range answers {
+			content, exists := periods[period]
+			if !exists {
+				continue
+			}
+			var doc ensembleDoc
+			if err := json.Unmarshal([]byte(content), &doc.values); err != nil {
+				return "", nil, fmt.Errorf("parsing ensemble answer of %s: %w", period, err)
+			}
+			if err := json.Unmarshal([]byte(content), &doc); err != nil {
+				return "", nil, fmt.Errorf("parsing ensemble sources of %s: %w", period, err)
+			}
+			docs = append(docs, doc)
+		}
+
+		entry := map[string]any{"period": period}
+		sources := map[string]json.RawMessage{}
+		for _, field := range fields {
+			votes := make([]*float64, len(docs))
+			for i, doc := range docs {
+				votes[i] = doc.value(field)
+			}
+
+			value, winner, unanimous := voteField(votes)
+			entry[field] = value
+			if source, exists := docs[winner].Sources[field]; exists {
+				sources[field] = source
+			}
+			if !unanimous {
+				disagreements[period] = append(disagreements[period], field)
+			}
+		}
+		entry["sources"] = sources
+		merged = append(merged, entry)
+	}
+
+	content, err := json.Marshal(map[string]any{"periods": merged})
+	if err != nil {
+		return "", nil, fmt.Errorf("marshaling %s ensemble: %w", target, err)
+	}
+	return string(content), disagreements, nil
+}
+
+type ensembleDoc struct {
+	values  map[string]json.RawMessage
+	Sources map[string]json.RawMessage `json:"sources"`
+}
+
+func (d ensembleDoc) value(field string) *float64 {
+	raw, exists := d.values[field]
+	if !exists {
+		return nil
+	}
+	var value *float64
+	if err := json.Unmarshal(raw, &value); err != nil || value == nil || math.IsNaN(*value) || math.IsInf(*value, 0) {
+		return nil
+	}
+	return value
+}
+
+// voteField returns the value most votes agree on (null included), else the median of the numbers,
+// with the index of a vote that gave it
+func voteField(votes []*float64) (value *float64, winner int, unanimous bool) {
+	// groups of equal votes, as indexes
+	var groups [][]int
+	for i, vote := range votes {
+		placed := false
+		for g, group := range groups {
+			if sameVote(votes[group[0]], vote) {
+				groups[g] = append(group, i)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			groups = append(groups, []int{i})
+		}
+	}
+
+	largest := groups[0]
+	for _, group := range groups[1:] {
+		if len(group) > len(largest) {
+			largest = group
+		}
+	}
+
+	if len(largest)*2 > len(votes) {
+		return votes[largest[0]], largest[0], len(largest) == len(votes)
+	}
+
+	var numbers []int
+	for i, vote := range votes {
+		if vote != nil {
+			numbers = append(numbers, i)
+		}
+	}
+	if len(numbers) == 0 {
+		return nil, 0, false
+	}
+
+	// lower median, so the value and its source come from the same answer
+	sort.SliceStable(numbers, func(i, j int) bool { return *votes[numbers[i]] < *votes[numbers[j]] })
+	median := numbers[(len(numbers)-1)/2]
+	return votes[median], median, false
+}
+
+func sameVote(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return math.Abs(*a-*b) <= ensembleTolerance*math.Max(math.Abs(*a), math.Abs(*b))
+}
+
+// flagDisagreements lowers the confidence of the fields the ensemble runs disagreed on
+func flagDisagreements(periods []PeriodResult, response SubmitterRes) {
+	statements := map[string]AIresponse{
+		"balance":   response.Balance,
+		"income":    response.Income,
+		"cash_flow": response.CashFlow,
+	}
+
+	for i := range periods {
+		seen := map[string]bool{}
+		var fields []string
+		for target, statement := range statements {
+			for _, field := range statement.Disagreements[periods[i].Period] {
+				affected := []string{field}
+				if derived, exists := ensembleDerived[field]; exists {
+					affected = derived
+				}
+				if field == "units" {
+					affected = StatementFields[target]
+				}
+
+				for _, f := range affected {
+					if !seen[f] {
+						seen[f] = true
+						fields = append(fields, f)
+					}
+				}
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		sort.Strings(fields)
+		periods[i].Validation.penalize(ensembleDisagreement, "ensemble_disagreement", "the extraction runs gave different values, review them", fields...)
+	}
+}
@@ -0,0 +1,234 @@
+package submitter
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"nodofinance/utils/logger"
+
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+func TestVoteField(t *testing.T) {
+	number := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name          string
+		votes         []*float64
+		wantValue     *float64
+		wantWinner    int
+		wantUnanimous bool
+	}{
+		{"unanimous", []*float64{number(100), number(100), number(100)}, number(100), 0, true},
+		{"within tolerance", []*float64{number(1000), number(1004), number(1000)}, number(1000), 0, true},
+		{"majority", []*float64{number(100), number(250), number(250)}, number(250), 1, false},
+		{"majority of nulls", []*float64{nil, number(100), nil}, nil, 0, false},
+		{"no majority, lower median", []*float64{number(300), number(100), number(200)}, number(200), 2, false},
+		{"no majority of two, lower one", []*float64{number(300), number(100)}, number(100), 1, false},
+		{"tie with a null", []*float64{number(100), nil}, number(100), 0, false},
+		{"every vote null", []*float64{nil, nil}, nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, winner, unanimous := voteField(tt.votes)
+			if !reflect.DeepEqual(value, tt.wantValue) || winner != tt.wantWinner || unanimous != tt.wantUnanimous {
+				t.Errorf("voteField() = %v, %d, %t, want %v, %d, %t", deref(value), winner, unanimous, deref(tt.wantValue), tt.wantWinner, tt.wantUnanimous)
+			}
+		})
+	}
+}
+
+// deref is the value of an optional number, or nil, for messages
+func deref(value *float64) any {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
+
+// answer is the content of a run with its entries, one per period
+func answer(t *testing.T, periods ...map[string]any) string {
+	t.Helper()
+
+	content, err := json.Marshal(map[string]any{"periods": periods})
+	if err != nil {
+		t.Fatalf("Marshal() = %v", err)
+	}
+	return string(content)
+}
+
+func TestMergeAnswers(t *testing.T) {
+	income := func(period string, revenue, units any) map[string]any {
+		return map[string]any{
+			"period": period, "revenue": revenue, "net_income": 50, "eps": 1.5, "units": units,
+			"sources": map[string]any{"revenue": map[string]string{"label": "Revenue", "snippet": "Revenue " + period}},
+		}
+	}
+
+	tests := []struct {
+		name              string
+		units             int64
+		members           []MemberResult
+		wantRevenue       map[string]any // period -> merged revenue
+		wantDisagreements map[string][]string
+	}{
+		{
+			name: "values agree",
+			members: []MemberResult{
+				{Content: answer(t, income("2024-Y", 1000, 1e6), income("2023-Y", 900, 1e6))},
+				{Content: answer(t, income("2024-Y", 1000, 1e6), income("2023-Y", 900, 1e6))},
+				{Content: answer(t, income("2024-Y", 1000, 1e6), income("2023-Y", 900, 1e6))},
+			},
+			wantRevenue:       map[string]any{"2024-Y": 1000.0, "2023-Y": 900.0},
+			wantDisagreements: map[string][]string{},
+		},
+		{
+			name: "revenue outvoted",
+			members: []MemberResult{
+				{Content: answer(t, income("2024-Y", 1000, 1e6))},
+				{Content: answer(t, income("2024-Y", 1200, 1e6))},
+				{Content: answer(t, income("2024-Y", 1000, 1e6))},
+			},
+			wantRevenue:       map[string]any{"2024-Y": 1000.0},
+			wantDisagreements: map[string][]string{"2024-Y": {"revenue"}},
+		},
+		{
+			name: "units answered differently",
+			members: []MemberResult{
+				{Content: answer(t, income("2024-Y", 1000, 1e6))},
+				{Content: answer(t, income("2024-Y", 1000, 1e3))},
+			},
+			wantRevenue:       map[string]any{"2024-Y": 1000.0},
+			wantDisagreements: map[string][]string{"2024-Y": {"units"}},
+		},
+		{
+			name:  "units of the request not voted",
+			units: 1000,
+			members: []MemberResult{
+				{Content: answer(t, income("2024-Y", 1000, 1e6))},
+				{Content: answer(t, income("2024-Y", 1000, nil))},
+			},
+			wantRevenue:       map[string]any{"2024-Y": 1000.0},
+			wantDisagreements: map[string][]string{},
+		},
+		{
+			name: "failed and invalid runs left out",
+			members: []MemberResult{
+				{Error: "timeout"},
+				{Content: "not json"},
+				{Content: answer(t, income("2024-Y", 1000, 1e6))},
+			},
+			wantRevenue:       map[string]any{"2024-Y": 1000.0},
+			wantDisagreements: map[string][]string{},
+		},
+		{
+			name: "comparative of a minority left out",
+			members: []MemberResult{
+				{Content: answer(t, income("2024-Y", 1000, 1e6), income("2022-Y", 800, 1e6))},
+				{Content: answer(t, income("2024-Y", 1000, 1e6))},
+				{Content: answer(t, income("2024-Y", 1000, 1e6))},
+			},
+			wantRevenue:       map[string]any{"2024-Y": 1000.0},
+			wantDisagreements: map[string][]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, disagreements, err := mergeAnswers("income", "2024-Y", tt.units, tt.members)
+			if err != nil {
+				t.Fatalf("mergeAnswers() = %v", err)
+			}
+
+			var merged struct {
+				Periods []map[string]any `json:"periods"`
+			}
+			if err := json.Unmarshal([]byte(content), &merged); err != nil {
+				t.Fatalf("decoding merged answer: %v", err)
+			}
+
+			revenue := map[string]any{}
+			for _, entry := range merged.Periods {
+				revenue[entry["period"].(string)] = entry["revenue"]
+			}
+			if !reflect.DeepEqual(revenue, tt.wantRevenue) {
+				t.Errorf("merged revenue = %v, want %v", revenue, tt.wantRevenue)
+			}
+			if first := merged.Periods[0]["period"]; first != "2024-Y" {
+				t.Errorf("first period = %v, want the requested one", first)
+			}
+			if !reflect.DeepEqual(disagreements, tt.wantDisagreements) {
+				t.Errorf("disagreements = %v, want %v", disagreements, tt.wantDisagreements)
+			}
+		})
+	}
+
+	t.Run("every run failed", func(t *testing.T) {
+		if _, _, err := mergeAnswers("income", "2024-Y", 0, []MemberResult{{Error: "timeout"}}); err == nil {
+			t.Error("mergeAnswers() = nil, want an error")
+		}
+	})
+}
+
+func TestFlagDisagreements(t *testing.T) {
+	tests := []struct {
+		name     string
+		response SubmitterRes
+		wantLow  []string
+	}{
+		{
+			name:     "no disagreement",
+			response: SubmitterRes{},
+			wantLow:  []string{},
+		},
+		{
+			name:     "value of a field",
+			response: SubmitterRes{Income: AIresponse{Disagreements: map[string][]string{"2024-Y": {"revenue"}}}},
+			wantLow:  []string{"revenue"},
+		},
+		{
+			name:     "field that is only used to derive a stored one",
+			response: SubmitterRes{Balance: AIresponse{Disagreements: map[string][]string{"2024-Y": {"total_assets", "equity"}}}},
+			wantLow:  []string{"non_current_assets", "non_current_liabilities"},
+		},
+		{
+			name:     "units scale every field of the statement",
+			response: SubmitterRes{CashFlow: AIresponse{Disagreements: map[string][]string{"2024-Y": {"units"}}}},
+			wantLow:  StatementFields["cash_flow"],
+		},
+		{
+			name:     "disagreement of another period",
+			response: SubmitterRes{Income: AIresponse{Disagreements: map[string][]string{"2023-Y": {"revenue"}}}},
+			wantLow:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			confidence := map[string]float64{}
+			for _, fields := range StatementFields {
+				for _, field := range fields {
+					confidence[field] = 1
+				}
+			}
+			periods := []PeriodResult{{Period: "2024-Y", Validation: Validation{Confidence: confidence}}}
+
+			flagDisagreements(periods, tt.response)
+
+			want := append([]string{}, tt.wantLow...)
+			sort.Strings(want)
+			if got := periods[0].Validation.LowConfidence(); !reflect.DeepEqual(got, want) {
+				t.Errorf("low confidence = %v, want %v", got, want)
+			}
+		})
+	}
+}
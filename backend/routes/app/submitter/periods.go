@@ -0,0 +1,179 @@
+package submitter
+
+import (
+	"encoding/json"
+	"fmt"
+	"nodofinance/store"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Comparatives older than this many years before the requested period are ignored
+	maxComparativeYears = 5
+	// Longer snippets are cut, a row is what we are after
+	maxSnippetLength = 300
+)
+
+type PeriodResult struct {
+	Period     string        `json:"period"`
+	Result     Postprocessed `json:"result"`
+	Validation Validation    `json:"validation"`
+	// Provenance maps the fields of the three statements to the row they were read from
+	Provenance map[string]store.Source `json:"provenance"`
+}
+
+// postprocessPeriods builds the requested period, even if a statement misses it, and every
+// comparative period found in the three statements
+func postprocessPeriods(res SubmitterRes, requested string, unitsFromClient UnitsFromClient) ([]PeriodResult, error) {
+	balances, err := ParsePeriods(res.Balance.FinalContent)
+	if err != nil {
+		return nil, fmt.Errorf("parsing balance sheet: %w", err)
+	}
+	incomes, err := ParsePeriods(res.Income.FinalContent)
+	if err != nil {
+		return nil, fmt.Errorf("parsing income statement: %w", err)
+	}
+	cashFlows, err := ParsePeriods(res.CashFlow.FinalContent)
+	if err != nil {
+		return nil, fmt.Errorf("parsing cash flow statement: %w", err)
+	}
+
+	requestedYear, err := strconv.Atoi(requested[:4])
+	if err != nil {
+		return nil, fmt.Errorf("invalid period: %s", requested)
+	}
+
+	comparatives := []string{}
+	for period := range balances {
+		_, inIncome := incomes[period]
+		_, inCashFlow := cashFlows[period]
+		if period == requested || !inIncome || !inCashFlow {
+			continue
+		}
+
+		year, _ := strconv.Atoi(period[:4])
+		if year > requestedYear || year < requestedYear-maxComparativeYears {
+			continue
+		}
+		comparatives = append(comparatives, period)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(comparatives)))
+
+	results := make([]PeriodResult, 0, len(comparatives)+1)
+	for _, period := range append([]string{requested}, comparatives...) {
+		balance, income, cashFlow, err := statementsOf(period, balances, incomes, cashFlows, unitsFromClient)
+		if err != nil {
+			return nil, err
+		}
+
+		postprocessed, err := Postprocessor(balance, income, cashFlow)
+		if err != nil {
+			return nil, err
+		}
+
+		// the requested period is kept even if empty, like a single period submission
+		if period != requested && reflect.ValueOf(postprocessed).IsZero() {
+			continue
+		}
+
+		provenance, err := sourcesOf(period, balances, incomes, cashFlows)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, PeriodResult{
+			Period:     period,
+			Result:     postprocessed,
+			Validation: Validate(balance, income, cashFlow, postprocessed),
+			Provenance: provenance,
+		})
+	}
+
+	return results, nil
+}
+
+func statementsOf(period string, balances, incomes, cashFlows map[string]string, unitsFromClient UnitsFromClient) (*BalanceSheet, *IncomeStatement, *CashFlowStatement, error) {
+	content := func(periods map[string]string) string {
+		if c, exists := periods[period]; exists {
+			return c
+		}
+		return "{}"
+	}
+
+	balanceData, err := ParseFinancialData("balance", content(balances))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("parsing balance sheet of %s: %w", period, err)
+	}
+	balance := balanceData.(*BalanceSheet)
+	if unitsFromClient.Balance != 0 {
+		balanceUnitsFloat := float64(unitsFromClient.Balance)
+		balance.Units = &balanceUnitsFloat
+	}
+
+	incomeData, err := ParseFinancialData("income", content(incomes))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("parsing income statement of %s: %w", period, err)
+	}
+	income := incomeData.(*IncomeStatement)
+	if unitsFromClient.Income != 0 {
+		incomeUnitsFloat := float64(unitsFromClient.Income)
+		income.Units = &incomeUnitsFloat
+	}
+
+	cashFlowData, err := ParseFinancialData("cash_flow", content(cashFlows))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("parsing cash flow statement of %s: %w", period, err)
+	}
+	cashFlow := cashFlowData.(*CashFlowStatement)
+	if unitsFromClient.CashFlow != 0 {
+		cashFlowUnitsFloat := float64(unitsFromClient.CashFlow)
+		cashFlow.Units = &cashFlowUnitsFloat
+	}
+
+	return balance, income, cashFlow, nil
+}
+
+// sourcesOf merges the sources of the period in every statement, skipping empty ones
+func sourcesOf(period string, statements ...map[string]string) (map[string]store.Source, error) {
+	provenance := make(map[string]store.Source)
+
+	for _, periods := range statements {
+		content, exists := periods[period]
+		if !exists {
+			continue
+		}
+
+		var doc struct {
+			Sources map[string]struct {
+				Label   *string `json:"label"`
+				Snippet *string `json:"snippet"`
+			} `json:"sources"`
+		}
+		if err := json.Unmarshal([]byte(content), &doc); err != nil {
+			return nil, fmt.Errorf("parsing sources of %s: %w", period, err)
+		}
+
+		for field, source := range doc.Sources {
+			var label, snippet string
+			if source.Label != nil {
+				label = strings.TrimSpace(*source.Label)
+			}
+			if source.Snippet != nil {
+				snippet = strings.TrimSpace(*source.Snippet)
+			}
+			if label == "" && snippet == "" {
+				continue
+			}
+
+			if runes := []rune(snippet); len(runes) > maxSnippetLength {
+				snippet = string(runes[:maxSnippetLength])
+			}
+			provenance[field] = store.Source{Label: label, Snippet: snippet}
+		}
+	}
+
+	return provenance, nil
+}
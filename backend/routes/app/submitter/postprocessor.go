@@ -21,6 +21,13 @@ type Postprocessed struct {
 	NetIncome              *int64   `json:"net_income"`
 }
 
+// StatementFields groups the Postprocessed fields by the statement they come from
+var StatementFields = map[string][]string{
+	"balance":   {"cash_and_equivalents", "current_assets", "non_current_assets", "current_liabilities", "non_current_liabilities"},
+	"income":    {"revenue", "net_income", "eps"},
+	"cash_flow": {"cash_flow_from_operations", "cash_flow_from_investing", "cash_flow_from_financing"},
+}
+
 const (
 	SAFEMAX int64   = 1e14  // 100 trillion
 	SAFEMIN int64   = -1e14 // -100 trillion
@@ -1,32 +1,48 @@
 package submitter
 
-import "strings"
-
-func getSystemPrompt(executer string, target string) string {
-	if executer == "submitter" {
-		return "You are an expert at structured data extraction. " +
-			"You will be given unstructured text from a financial report and should " +
-			"convert it into the given JSON structure."
-	}
-
-	var sectionName string
-	switch target {
-	case "balance":
-		sectionName = "Balance Sheet"
-	case "income":
-		sectionName = "Income Statement"
-	default:
-		sectionName = "Statement of Cash Flow"
-	}
+import (
+	"nodofinance/prompts"
+	"nodofinance/store"
+	"strings"
+)
+
+// Few-shot examples are cut to keep the submitter prompt small
+const (
+	MAX_EXAMPLES       = 2
+	maxExampleChunkLen = 1500
+)
+
+// Template data, see the prompts package
+type sectionData struct {
+	Target  string
+	Section string
+}
 
-	return "You are a meticulous text organizer. Your job is to extract the " +
-		sectionName + " section from provided text that may include messy or disorganized chunks."
+type cleanerData struct {
+	sectionData
+	// Zero when the preprocessor found no units, the cleaner has to keep them
+	Units   int64
+	Content string
+}
 
+type submitterData struct {
+	// e.g. "the first quarter of 2024", FormattedPeriod ends with a period for years and semesters
+	PeriodName      string
+	Period          string
+	FormattedPeriod string
+	// Only for quarters, points at the three months columns of cumulative statements
+	QuarterGuideline string
+	Examples         []exampleData
+	Text             string
 }
 
-func promptEngineerCleaner(
-	contentToClean, target string, units int64) string {
+type exampleData struct {
+	Period string
+	Chunk  string
+	Fields []store.CorrectedField
+}
 
+func newSectionData(target string) sectionData {
 	var sectionName string
 	switch target {
 	case "balance":
@@ -37,25 +53,28 @@ func promptEngineerCleaner(
 		sectionName = "Statement of Cash Flow"
 	}
 
-	var firstGuideline string
+	return sectionData{Target: target, Section: sectionName}
+}
 
-	if units == 0 {
-		firstGuideline = "* Include units and time periods for the " + sectionName + ".\n"
-	} else {
-		firstGuideline = "* Include time periods for the " + sectionName + ".\n"
+func getSystemPrompt(templates *prompts.Set, executer string, target string) (string, error) {
+	if executer == "submitter" {
+		return templates.Render(prompts.SubmitterSystem, newSectionData(target))
 	}
 
-	prompt := "Guidelines:\n" + firstGuideline +
-		"* Ensure you capture all paragraphs that belong to the " + sectionName + ". Be careful, as the " + sectionName + " might be split into multiple paragraphs.\n" +
-		"* Keep the headers and footers of the " + sectionName + " intact.\n" +
-		"* Place the periods of the " + sectionName + " at the top.\n" +
-		"* Do not format the text.\n\n" +
-		"This is the text:\n" + contentToClean
+	return templates.Render(prompts.CleanerSystem, newSectionData(target))
+}
 
-	return prompt
+func promptEngineerCleaner(templates *prompts.Set,
+	contentToClean, target string, units int64) (string, error) {
+
+	return templates.Render(prompts.Cleaner, cleanerData{
+		sectionData: newSectionData(target),
+		Units:       units,
+		Content:     contentToClean,
+	})
 }
 
-func promptEngineerSubmitter(text, period string) string {
+func promptEngineerSubmitter(templates *prompts.Set, text, period string, examples []store.Correction) (string, error) {
 
 	year := period[:4]
 
@@ -92,16 +111,32 @@ func promptEngineerSubmitter(text, period string) string {
 
 	formattedPeriod := formatPeriod(period)
 
-	prompt := "Extract financial data from " + formattedPeriod +
-		"\nGuidelines:\n" +
-		"* All resulting values should be floats\n" +
-		"* Do not multiply or divide values by units. Use the values as they are.\n" +
-		"* Values in each row align with the years/periods as ordered in the document header or footer.\n" +
-		"* For missing values that can be calculated, perform basic calculations.\n" +
-		"* For values that cannot be found or calculated, use 'null'.\n" +
-		"* Focus on the data from " + formattedPeriod + quarterGuideline +
-		"\nThis is the text:\n" +
-		text
-
-	return prompt
+	return templates.Render(prompts.Submitter, submitterData{
+		PeriodName:       strings.TrimSuffix(formattedPeriod, "."),
+		Period:           period,
+		FormattedPeriod:  formattedPeriod,
+		QuarterGuideline: quarterGuideline,
+		Examples:         promptExamples(examples),
+		Text:             text,
+	})
+}
+
+// promptExamples keeps the past user corrections of the same statement and language the prompt shows
+func promptExamples(examples []store.Correction) []exampleData {
+	var data []exampleData
+
+	for i, example := range examples {
+		if i == MAX_EXAMPLES {
+			break
+		}
+
+		chunk := example.Chunk
+		if runes := []rune(chunk); len(runes) > maxExampleChunkLen {
+			chunk = string(runes[:maxExampleChunkLen]) + "..."
+		}
+
+		data = append(data, exampleData{Period: example.Period, Chunk: chunk, Fields: example.Fields})
+	}
+
+	return data
 }
@@ -0,0 +1,505 @@
+package submitter
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"nodofinance/preprocess"
+)
+
+/*
+	Rule based extraction, without LLM calls. Rows of the cleaned statements are matched against label
+	dictionaries (EN and ES, IFRS and US GAAP wording) and their values assigned to the period columns of
+	the header. The answer has the submitter format, so postprocessing and validation are shared, and
+	every value is flagged as low confidence.
+*/
+
+const (
+	EXTRACTOR_LLM   = "llm"
+	EXTRACTOR_RULES = "rules"
+
+	// Confidence of every value read by the rules, under LOW_CONFIDENCE
+	RULES_CONFIDENCE float64 = 0.5
+	// Longer labels are sentences, not rows
+	maxRuleLabelWords = 10
+)
+
+var (
+	yearToken   = regexp.MustCompile(`^(19|20)\d{2}$`)
+	numberToken = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+)
+
+// fieldRules finds the row of a field: labels are normalized labels, best first. Without an exact
+// match, a label containing every word of one of the groups (as word prefixes) is taken.
+type fieldRules struct {
+	labels []string
+	groups [][]string
+}
+
+var ruleDictionaries = map[string]map[string]fieldRules{
+	"EN": {
+		// balance
+		"cash_and_equivalents": {
+			labels: []string{"cash cash equivalents", "total cash cash equivalents", "cash equivalents", "cash bank balances", "cash"},
+		},
+		"current_assets": {
+			labels: []string{"total current assets", "current assets"},
+		},
+		"non_current_assets": {
+			labels: []string{"total non current assets", "total noncurrent assets", "non current assets", "noncurrent assets", "total long term assets", "long term assets"},
+		},
+		"total_assets": {
+			labels: []string{"total assets", "assets total"},
+		},
+		"current_liabilities": {
+			labels: []string{"total current liabilities", "current liabilities"},
+		},
+		"non_current_liabilities": {
+			labels: []string{"total non current liabilities", "total noncurrent liabilities", "non current liabilities", "noncurrent liabilities", "total long term liabilities", "long term liabilities"},
+		},
+		"total_liabilities": {
+			labels: []string{"total liabilities"},
+		},
+		"equity": {
+			labels: []string{"total equity", "total shareholders equity", "total stockholders equity", "total shareholders funds", "shareholders equity", "stockholders equity", "equity"},
+		},
+		// income
+		"revenue": {
+			labels: []string{"total net sales", "net sales", "total revenues", "total revenue", "total net revenues", "total net revenue", "net revenues", "net revenue", "revenues", "revenue", "revenue contracts customers", "turnover", "sales"},
+		},
+		"net_income": {
+			labels: []string{"net income", "net earnings", "net income loss", "net earnings loss", "consolidated net income", "net profit", "profit year", "profit loss year", "profit period", "profit loss period", "net profit year", "profit attributable owners parent", "profit attributable owners company"},
+		},
+		"eps": {
+			labels: []string{"basic earnings per share", "earnings per share basic", "basic earnings loss per share", "earnings loss per share basic", "basic net income per share", "net income per share basic", "basic eps", "eps basic", "earnings per share"},
+		},
+		// cash flow
+		"cash_flow_from_operations": {
+			labels: []string{"net cash operating activities", "cash operating activities", "net cash flows operating activities", "net cash flow operating activities", "cash flows operating activities"},
+			groups: [][]string{{"cash", "operating", "activit"}},
+		},
+		"cash_flow_from_investing": {
+			labels: []string{"net cash investing activities", "cash investing activities", "net cash flows investing activities", "net cash flow investing activities", "cash flows investing activities"},
+			groups: [][]string{{"cash", "investing", "activit"}},
+		},
+		"cash_flow_from_financing": {
+			labels: []string{"net cash financing activities", "cash financing activities", "net cash flows financing activities", "net cash flow financing activities", "cash flows financing activities"},
+			groups: [][]string{{"cash", "financing", "activit"}},
+		},
+	},
+	"ES": {
+		// balance
+		"cash_and_equivalents": {
+			labels: []string{"efectivo otros activos liquidos equivalentes", "efectivo equivalentes efectivo", "efectivo equivalentes", "tesoreria", "caja bancos", "efectivo"},
+		},
+		"current_assets": {
+			labels: []string{"activo corriente", "total activo corriente", "activos corrientes", "total activos corrientes"},
+		},
+		"non_current_assets": {
+			labels: []string{"activo no corriente", "total activo no corriente", "activos no corrientes", "total activos no corrientes"},
+		},
+		"total_assets": {
+			labels: []string{"total activo", "total activos", "activo total"},
+		},
+		"current_liabilities": {
+			labels: []string{"pasivo corriente", "total pasivo corriente", "pasivos corrientes", "total pasivos corrientes"},
+		},
+		"non_current_liabilities": {
+			labels: []string{"pasivo no corriente", "total pasivo no corriente", "pasivos no corrientes", "total pasivos no corrientes"},
+		},
+		"total_liabilities": {
+			labels: []string{"total pasivo", "total pasivos", "pasivo total"},
+		},
+		"equity": {
+			labels: []string{"patrimonio neto", "total patrimonio neto", "total patrimonio", "patrimonio"},
+		},
+		// income
+		"revenue": {
+			labels: []string{"importe neto cifra negocios", "cifra negocios", "ingresos ordinarios", "ingresos actividades ordinarias", "ventas netas", "ventas", "total ingresos", "ingresos totales", "ingresos explotacion", "ingresos"},
+		},
+		"net_income": {
+			labels: []string{"resultado ejercicio", "resultado consolidado ejercicio", "resultado neto", "beneficio neto", "beneficio ejercicio", "resultado periodo", "resultado consolidado periodo", "beneficio periodo", "resultado atribuido sociedad dominante", "resultado atribuido entidad dominante", "beneficio neto atribuido sociedad dominante"},
+		},
+		"eps": {
+			labels: []string{"beneficio basico accion", "beneficio accion basico", "ganancias accion basicas", "resultado basico accion", "resultado accion basico", "beneficio accion", "ganancias accion"},
+		},
+		// cash flow
+		"cash_flow_from_operations": {
+			labels: []string{"flujos efectivo actividades explotacion", "flujos netos efectivo actividades explotacion", "flujos efectivo actividades operacion", "flujos netos efectivo actividades operacion", "efectivo neto actividades explotacion", "efectivo neto actividades operacion"},
+			groups: [][]string{{"efectivo", "explotacion"}, {"efectivo", "operacion"}},
+		},
+		"cash_flow_from_investing": {
+			labels: []string{"flujos efectivo actividades inversion", "flujos netos efectivo actividades inversion", "efectivo neto actividades inversion"},
+			groups: [][]string{{"efectivo", "inversion"}},
+		},
+		"cash_flow_from_financing": {
+			labels: []string{"flujos efectivo actividades financiacion", "flujos netos efectivo actividades financiacion", "efectivo neto actividades financiacion"},
+			groups: [][]string{{"efectivo", "financiacion"}},
+		},
+	},
+}
+
+// Words left out of normalized labels, they vary between filings of the same row
+var ruleStopWords = map[string]bool{
+	"and": true, "of": true, "the": true, "from": true, "by": true, "in": true, "for": true, "to": true, "with": true,
+	"used": true, "provided": true, "generated": true, "utilized": true, "applied": true, "note": true, "notes": true,
+	"de": true, "del": true, "la": true, "las": true, "los": true, "el": true, "en": true, "al": true, "por": true, "y": true,
+	"procedentes": true, "generados": true, "generado": true, "utilizados": true, "aplicados": true, "nota": true, "notas": true,
+}
+
+type ruleRow struct {
+	label string
+	// normalized label, and the one of the closest row without values above it
+	key     string
+	context string
+	values  []*float64
+	line    string
+	// years of the closest header above the row, its statement may have other columns than the first
+	years []int
+}
+
+type ruleSource struct {
+	Label   string `json:"label"`
+	Snippet string `json:"snippet"`
+}
+
+// ExtractWithRules is CallSubmitter without LLM calls, for a free quick extraction or when the LLM fails on a draft
+func ExtractWithRules(
+	balanceResult string,
+	incomeResult string,
+	cashFlowResult string,
+	period string,
+	language string,
+	hits Hits,
+	unitsFromClient UnitsFromClient,
+) (SubmitterRes, error) {
+
+	response := SubmitterRes{}
+
+	statements := []struct {
+		target   string
+		text     string
+		hits     int
+		response *AIresponse
+	}{
+		{"balance", balanceResult, hits.Balance, &response.Balance},
+		{"income", incomeResult, hits.Income, &response.Income},
+		{"cash_flow", cashFlowResult, hits.CashFlow, &response.CashFlow},
+	}
+
+	for _, statement := range statements {
+		var content string
+		var err error
+		if statement.hits < 17 {
+			content, err = createNullJSONResponse(statement.target, period)
+		} else {
+			content, err = extractStatement(statement.text, statement.target, period, language)
+		}
+		if err != nil {
+			return SubmitterRes{}, err
+		}
+
+		*statement.response = AIresponse{FinalContent: content, CleanerResult: statement.text}
+	}
+
+	periods, err := postprocessPeriods(response, period, unitsFromClient)
+	if err != nil {
+		return SubmitterRes{}, err
+	}
+
+	for i := range periods {
+		periods[i].Validation.flagRuleBased()
+	}
+	response.Periods = periods
+
+	return response, nil
+}
+
+// flagRuleBased lowers every value to RULES_CONFIDENCE, the rules can't tell a right row from a wrong one
+func (v *Validation) flagRuleBased() {
+	fields := make([]string, 0, len(v.Confidence))
+	for field := range v.Confidence {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	v.penalize(RULES_CONFIDENCE, "rule_based", "values were matched by row label without AI, review them", fields...)
+}
+
+// extractStatement answers like the submitter: {"periods": [{"period": ..., fields..., "sources": {...}}]}
+func extractStatement(text, target, period, language string) (string, error) {
+	dictionary, exists := ruleDictionaries[language]
+	if !exists {
+		dictionary = ruleDictionaries["EN"]
+	}
+
+	rows, years := parseRuleRows(text)
+	columns := ruleColumns(rows, years, period)
+
+	fields := getRequiredFields(target, 0)
+	entries := make([]map[string]any, len(columns))
+	sources := make([]map[string]ruleSource, len(columns))
+	for i, column := range columns {
+		entries[i] = map[string]any{"period": column.period}
+		sources[i] = map[string]ruleSource{}
+		for _, field := range fields {
+			entries[i][field] = nil
+		}
+	}
+
+	for _, field := range fields {
+		rules, exists := dictionary[field]
+		if !exists {
+			continue
+		}
+
+		row := matchRow(rows, rules)
+		if row == nil {
+			continue
+		}
+
+		values := alignValues(row.values, len(row.years))
+		for i, column := range columns {
+			index := row.columnIndex(column, years)
+			if index < 0 || index >= len(values) || values[index] == nil {
+				continue
+			}
+			entries[i][field] = *values[index]
+			sources[i][field] = ruleSource{Label: row.label, Snippet: row.line}
+		}
+	}
+
+	periods := make([]any, len(columns))
+	for i := range columns {
+		entries[i]["sources"] = sources[i]
+		periods[i] = entries[i]
+	}
+
+	content, err := json.Marshal(map[string]any{"periods": periods})
+	if err != nil {
+		return "", fmt.Errorf("marshaling %s rules: %w", target, err)
+	}
+	return string(content), nil
+}
+
+// parseRuleRows splits the lines into a label and the values at their end, each row with the years of
+// the header above it, and returns the years of the first header
+func parseRuleRows(text string) ([]ruleRow, []int) {
+	var rows []ruleRow
+	var years, header []int
+	context := ""
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		tokens := strings.Fields(line)
+		if len(tokens) == 0 {
+			continue
+		}
+
+		if lineYears := headerYears(tokens); lineYears != nil {
+			header = lineYears
+			if years == nil {
+				years = lineYears
+			}
+		}
+
+		end := len(tokens)
+		for end > 0 && (numberToken.MatchString(tokens[end-1]) || isDashToken(tokens[end-1])) {
+			end--
+		}
+
+		label := strings.Join(tokens[:end], " ")
+		key := normalizeRuleLabel(label)
+		if key == "" {
+			continue
+		}
+
+		if end == len(tokens) {
+			context = key
+			continue
+		}
+
+		var values []*float64
+		for _, token := range tokens[end:] {
+			if isDashToken(token) {
+				values = append(values, nil)
+				continue
+			}
+			value, err := strconv.ParseFloat(token, 64)
+			if err != nil {
+				values = append(values, nil)
+				continue
+			}
+			values = append(values, &value)
+		}
+
+		rows = append(rows, ruleRow{label: label, key: key, context: context, values: values, line: line, years: header})
+	}
+
+	return rows, years
+}
+
+// headerYears returns the years of a header line: years and no other figures than days. A single year
+// among other words is the date of a title, not a column.
+func headerYears(tokens []string) []int {
+	var years []int
+	words := 0
+	for _, token := range tokens {
+		token = strings.Trim(token, ".,:;()")
+		if yearToken.MatchString(token) {
+			year, _ := strconv.Atoi(token)
+			years = append(years, year)
+			continue
+		}
+		if day, err := strconv.Atoi(token); err == nil && (day < 1 || day > 31) {
+			return nil
+		}
+		if numberToken.MatchString(token) && strings.Contains(token, ".") {
+			return nil
+		}
+		words++
+	}
+
+	if len(years) == 1 && words > 1 {
+		return nil
+	}
+	return years
+}
+
+func isDashToken(token string) bool {
+	return token == "-" || token == "–" || token == "—"
+}
+
+type ruleColumn struct {
+	index  int
+	period string
+}
+
+// ruleColumns labels the value columns. Years get a column each, the first of a year wins (three months
+// come before the cumulative ones). Other periods only get their own column, comparatives of interim
+// statements may be year ends.
+func ruleColumns(rows []ruleRow, years []int, period string) []ruleColumn {
+	requestedYear, _ := strconv.Atoi(period[:4])
+	annual := strings.HasSuffix(period, "-Y")
+
+	requestedIndex := -1
+	for i, year := range years {
+		if year == requestedYear {
+			requestedIndex = i
+			break
+		}
+	}
+
+	if !annual || requestedIndex == -1 {
+		return []ruleColumn{{index: max(requestedIndex, 0), period: period}}
+	}
+
+	columns := []ruleColumn{}
+	seen := map[int]bool{}
+	for i, year := range years {
+		if seen[year] || year > requestedYear {
+			continue
+		}
+		seen[year] = true
+		columns = append(columns, ruleColumn{index: i, period: fmt.Sprintf("%d-Y", year)})
+	}
+
+	// requested period first
+	sort.SliceStable(columns, func(i, j int) bool { return columns[i].period == period && columns[j].period != period })
+	return columns
+}
+
+// columnIndex is the position in the values of the row of the column of the first header, by its year
+func (row ruleRow) columnIndex(column ruleColumn, years []int) int {
+	if row.years == nil || column.index >= len(years) {
+		return column.index
+	}
+	for i, year := range row.years {
+		if year == years[column.index] {
+			return i
+		}
+	}
+	return -1
+}
+
+// alignValues keeps the last values of a row, one per header column. Notes come before them.
+func alignValues(values []*float64, columns int) []*float64 {
+	if columns > 0 && len(values) > columns {
+		return values[len(values)-columns:]
+	}
+	return values
+}
+
+func matchRow(rows []ruleRow, rules fieldRules) *ruleRow {
+	for _, label := range rules.labels {
+		for i := range rows {
+			if rows[i].key == label || rows[i].context+" "+rows[i].key == label {
+				return &rows[i]
+			}
+		}
+	}
+
+	for _, group := range rules.groups {
+		for i := range rows {
+			words := strings.Fields(rows[i].key)
+			if len(words) <= maxRuleLabelWords && containsWords(words, group) {
+				return &rows[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// containsWords tells if every word of group starts a word of words
+func containsWords(words, group []string) bool {
+	for _, prefix := range group {
+		found := false
+		for _, word := range words {
+			if strings.HasPrefix(word, prefix) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeRuleLabel lowercases, strips accents, parentheses, punctuation, stop words and one letter words
+func normalizeRuleLabel(label string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range strings.ToLower(label) {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')':
+			if depth > 0 {
+				depth--
+			}
+		case depth > 0:
+		case unicode.Is(unicode.Mn, r):
+			// combining accents of decomposed text
+		case unicode.IsLetter(r):
+			b.WriteRune(preprocess.StripAccent(r))
+		default:
+			b.WriteRune(' ')
+		}
+	}
+
+	var words []string
+	for _, word := range strings.Fields(b.String()) {
+		if len(word) > 1 && !ruleStopWords[word] {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,152 @@
+package submitter
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The fixtures are the cleaned chunks of preprocess/testdata/filing_en.txt and filing_es.txt, as
+// Preprocess cuts them: each one starts with the end of the statements before it.
+func TestExtractStatement(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		target   string
+		language string
+		want     map[string]map[string]float64 // period -> field -> value
+	}{
+		{
+			fixture: "en_balance", target: "balance", language: "EN",
+			want: map[string]map[string]float64{
+				"2024-Y": {"cash_and_equivalents": 12345, "current_assets": 27800, "non_current_assets": 29500, "total_assets": 57300, "current_liabilities": 10700, "non_current_liabilities": 18000, "total_liabilities": 28700, "equity": 28600},
+				"2023-Y": {"cash_and_equivalents": 10210, "current_assets": 24510, "non_current_assets": 28300, "total_assets": 52810, "current_liabilities": 9870, "non_current_liabilities": 18600, "total_liabilities": 28470, "equity": 24340},
+			},
+		},
+		{
+			// three years under its own header, after the two of the balance sheet
+			fixture: "en_income", target: "income", language: "EN",
+			want: map[string]map[string]float64{
+				"2024-Y": {"revenue": 48200, "net_income": 6710, "eps": 3.36},
+				"2023-Y": {"revenue": 45100, "net_income": 5890, "eps": 2.95},
+			},
+		},
+		{
+			fixture: "en_cash_flow", target: "cash_flow", language: "EN",
+			want: map[string]map[string]float64{
+				"2024-Y": {"cash_flow_from_operations": 9560, "cash_flow_from_investing": -3900, "cash_flow_from_financing": -3500},
+				"2023-Y": {"cash_flow_from_operations": 8500, "cash_flow_from_investing": -3550, "cash_flow_from_financing": -3750},
+			},
+		},
+		{
+			// headed by a title with the year and a Nota column
+			fixture: "es_balance", target: "balance", language: "ES",
+			want: map[string]map[string]float64{
+				"2024-Y": {"cash_and_equivalents": 120000, "current_assets": 212480, "non_current_assets": 845320, "total_assets": 1057800, "current_liabilities": 144000, "non_current_liabilities": 401500, "equity": 512300},
+				"2023-Y": {"cash_and_equivalents": 104000, "current_assets": 188640, "non_current_assets": 790110, "total_assets": 978750, "current_liabilities": 120000, "non_current_liabilities": 389850, "equity": 468900},
+			},
+		},
+		{
+			// the result attributed to the parent in the equity of the balance sheet is not the one of the year
+			fixture: "es_income", target: "income", language: "ES",
+			want: map[string]map[string]float64{
+				"2024-Y": {"revenue": 398700, "net_income": 33300, "eps": 0.22},
+				"2023-Y": {"revenue": 371200, "net_income": 28275, "eps": 0.19},
+			},
+		},
+		{
+			fixture: "es_cash_flow", target: "cash_flow", language: "ES",
+			want: map[string]map[string]float64{
+				"2024-Y": {"cash_flow_from_operations": 65000, "cash_flow_from_investing": -85000, "cash_flow_from_financing": 36000},
+				"2023-Y": {"cash_flow_from_operations": 58900, "cash_flow_from_investing": -77000, "cash_flow_from_financing": 21500},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			text, err := os.ReadFile("testdata/" + tt.fixture + ".txt")
+			if err != nil {
+				t.Fatalf("reading fixture: %v", err)
+			}
+
+			content, err := extractStatement(string(text), tt.target, "2024-Y", tt.language)
+			if err != nil {
+				t.Fatalf("extractStatement() = %v", err)
+			}
+
+			var answer struct {
+				Periods []map[string]any `json:"periods"`
+			}
+			if err := json.Unmarshal([]byte(content), &answer); err != nil {
+				t.Fatalf("decoding answer: %v", err)
+			}
+
+			got := make(map[string]map[string]float64)
+			for _, entry := range answer.Periods {
+				period, _ := entry["period"].(string)
+				got[period] = make(map[string]float64)
+				for field, value := range entry {
+					if number, ok := value.(float64); ok {
+						got[period][field] = number
+					}
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractStatement() = %v, want %v", got, tt.want)
+			}
+			if first, _ := answer.Periods[0]["period"].(string); first != "2024-Y" {
+				t.Errorf("first period = %q, want the requested one", first)
+			}
+		})
+	}
+}
+
+func TestHeaderYears(t *testing.T) {
+	tests := []struct {
+		line string
+		want []int
+	}{
+		{"2024 2023", []int{2024, 2023}},
+		{"Nota 2024 2023", []int{2024, 2023}},
+		{"September 28, 2024 September 30, 2023", []int{2024, 2023}},
+		{"2024", []int{2024}},
+		{"BALANCE DE SITUACIÓN CONSOLIDADO AL DE DICIEMBRE DE 2024", nil},
+		{"December 31", nil},
+		{"Net sales 48200 45100", nil},
+		{"Basic 3.36 2.95", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			if got := headerYears(strings.Fields(tt.line)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("headerYears(%q) = %v, want %v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeRuleLabel(t *testing.T) {
+	tests := []struct {
+		label string
+		want  string
+	}{
+		{"Net cash provided by (used in) operating activities", "net cash operating activities"},
+		{"Total stockholders' equity", "total stockholders equity"},
+		{"FLUJOS DE EFECTIVO DE LAS ACTIVIDADES DE EXPLOTACIÓN", "flujos efectivo actividades explotacion"},
+		{"Importe neto de la cifra de negocios (Nota 21)", "importe neto cifra negocios"},
+		{"Resultado del ejercicio atribuido a la sociedad dominante", "resultado ejercicio atribuido sociedad dominante"},
+		// decomposed accents, as copied from some PDFs
+		{"Beneficio por accio\u0301n ba\u0301sico", "beneficio accion basico"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			if got := normalizeRuleLabel(tt.label); got != tt.want {
+				t.Errorf("normalizeRuleLabel(%q) = %q, want %q", tt.label, got, tt.want)
+			}
+		})
+	}
+}
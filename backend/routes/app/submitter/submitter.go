@@ -4,11 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"nodofinance/jobs"
+	"nodofinance/llm"
+	"nodofinance/prompts"
+	"nodofinance/store"
 	"nodofinance/utils/logger"
+	"nodofinance/utils/sanitize"
 	"reflect"
+	"slices"
+	"sort"
 	"strings"
 
-	"github.com/openai/openai-go"
 	"go.uber.org/zap"
 )
 
@@ -72,7 +78,7 @@ func getRequiredFields(target string, units int64) []string {
 	return fields
 }
 
-// Parse the content into the appropriate struct
+// Parse the content of one period into the appropriate struct
 func ParseFinancialData(target string, content string) (any, error) {
 	var structPtr any
 	switch target {
@@ -94,29 +100,108 @@ func ParseFinancialData(target string, content string) (any, error) {
 	return structPtr, nil
 }
 
+// ParsePeriods splits the submitter answer of a statement into the content of each period column.
+// Labels are normalized and columns with an invalid or repeated label are dropped.
+func ParsePeriods(content string) (map[string]string, error) {
+	var doc struct {
+		Periods []json.RawMessage `json:"periods"`
+	}
+	if err := json.Unmarshal([]byte(content), &doc); err != nil {
+		return nil, err
+	}
+
+	periods := make(map[string]string, len(doc.Periods))
+	for _, raw := range doc.Periods {
+		var label struct {
+			Period string `json:"period"`
+		}
+		if err := json.Unmarshal(raw, &label); err != nil {
+			return nil, err
+		}
+
+		period := sanitize.Trim(label.Period, "u")
+		if !sanitize.Period(period) {
+			continue
+		}
+		if _, exists := periods[period]; exists {
+			continue
+		}
+		periods[period] = string(raw)
+	}
+
+	return periods, nil
+}
+
+// createFinancialDataSchema asks for one object per period column of the statement
 func createFinancialDataSchema(fields []string) any {
-	properties := map[string]any{}
-	required := []string{}
+	properties := map[string]any{
+		"period": map[string]any{
+			"type": "string",
+		},
+	}
+	required := []string{"period"}
 
+	sources := map[string]any{}
 	for _, field := range fields {
 		properties[field] = map[string]any{
 			"type": []string{"number", "null"},
 		}
 		required = append(required, field)
+
+		if field == "units" {
+			continue
+		}
+		sources[field] = map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"label":   map[string]any{"type": []string{"string", "null"}},
+				"snippet": map[string]any{"type": []string{"string", "null"}},
+			},
+			"required":             []string{"label", "snippet"},
+			"additionalProperties": false,
+		}
 	}
 
-	schema := map[string]any{
+	// Row behind each value, for provenance
+	properties["sources"] = map[string]any{
 		"type":                 "object",
-		"properties":           properties,
-		"required":             required,
+		"properties":           sources,
+		"required":             sortedFields(sources),
+		"additionalProperties": false,
+	}
+	required = append(required, "sources")
+
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"periods": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type":                 "object",
+					"properties":           properties,
+					"required":             required,
+					"additionalProperties": false,
+				},
+			},
+		},
+		"required":             []string{"periods"},
 		"additionalProperties": false,
 	}
 
 	return schema
 }
 
-func createNullJSONResponse(target string) (string, error) {
-	doc := make(map[string]any)
+func sortedFields(m map[string]any) []string {
+	fields := make([]string, 0, len(m))
+	for field := range m {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func createNullJSONResponse(target string, period string) (string, error) {
+	doc := map[string]any{"period": period}
 
 	fields := getRequiredFields(target, 0)
 
@@ -124,7 +209,7 @@ func createNullJSONResponse(target string) (string, error) {
 		doc[field] = nil
 	}
 
-	jsonBytes, err := json.Marshal(doc)
+	jsonBytes, err := json.Marshal(map[string]any{"periods": []any{doc}})
 	if err != nil {
 		logger.Log.Error("Failed to marshal JSON", zap.Error(err))
 		return "", err
@@ -139,6 +224,13 @@ type UnitsFromClient struct {
 	CashFlow int64
 }
 
+// Examples are user corrections of each statement, shown to the submitter as few-shot examples
+type Examples struct {
+	Balance  []store.Correction
+	Income   []store.Correction
+	CashFlow []store.Correction
+}
+
 type Hits struct {
 	Balance  int
 	Income   int
@@ -153,17 +245,55 @@ type AIresponse struct {
 	CleanerPrompt             string `json:"cleaner_prompt"`
 	SubmitterPrompt           string `json:"submitter_prompt"`
 	FinalContent              string `json:"final_content"`
+	// Text the submitter read, its prompt layout depends on the template version
+	CleanerResult string `json:"cleaner_result"`
+	// Runs of an ensemble extraction, the submitter tokens above add them up
+	Ensemble []MemberResult `json:"ensemble,omitempty"`
+	// Fields of each period the ensemble runs disagreed on
+	Disagreements map[string][]string `json:"disagreements,omitempty"`
+}
+
+// PartialError is a submission where some statements failed. The SubmitterRes returned with it has
+// the other ones, CallSubmitter reuses them when it gets it back as previous.
+type PartialError struct {
+	Failed []string
+	Err    error
 }
 
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("statements %s failed: %v", strings.Join(e.Failed, ", "), e.Err)
+}
+func (e *PartialError) Unwrap() error { return e.Err }
+
 type SubmitterRes struct {
 	Balance  AIresponse `json:"balance"`
 	Income   AIresponse `json:"income"`
 	CashFlow AIresponse `json:"cash_flow"`
+	// Periods has the requested period first, then the comparatives found in every statement
+	Periods []PeriodResult `json:"periods"`
+}
+
+// WithoutUsage is res with the tokens of every statement at zero, once they are billed
+func (res SubmitterRes) WithoutUsage() SubmitterRes {
+	for _, r := range []*AIresponse{&res.Balance, &res.Income, &res.CashFlow} {
+		r.PromptTokensCleaner, r.CompletionTokensCleaner = 0, 0
+		r.PromptTokensSubmitter, r.CompletionTokensSubmitter = 0, 0
+
+		if r.Ensemble != nil {
+			// the runs are shared with res
+			ensemble := slices.Clone(r.Ensemble)
+			for i := range ensemble {
+				ensemble[i].PromptTokens, ensemble[i].CompletionTokens = 0, 0
+			}
+			r.Ensemble = ensemble
+		}
+	}
+	return res
 }
 
 func CallSubmitter(
 	ctx context.Context,
-	ai openai.Client,
+	ai llm.Provider,
 	balanceResult string,
 	incomeResult string,
 	cashFlowResult string,
@@ -171,6 +301,10 @@ func CallSubmitter(
 	language string,
 	hits Hits,
 	unitsFromClient UnitsFromClient,
+	examples Examples,
+	templates *prompts.Set,
+	ensemble []llm.EnsembleMember,
+	previous SubmitterRes,
 ) (SubmitterRes, error) {
 
 	response := SubmitterRes{}
@@ -185,51 +319,64 @@ func CallSubmitter(
 	activeRoutines := 0
 
 	if hits.Balance < 17 {
-		nullJSON, err := createNullJSONResponse("balance")
+		nullJSON, err := createNullJSONResponse("balance", period)
 		if err != nil {
 			return SubmitterRes{}, err
 		}
-		response.Balance = AIresponse{0, 0, 0, 0, "", "", nullJSON}
+		response.Balance = AIresponse{FinalContent: nullJSON}
+	} else if previous.Balance.FinalContent != "" {
+		response.Balance = previous.Balance
 	} else {
 		activeRoutines++
 		go func() {
-			balanceResp, err := InitiatePipeline(ctx, ai, balanceResult, "balance", period, language, unitsFromClient.Balance)
+			balanceResp, err := InitiatePipeline(ctx, ai, balanceResult, "balance", period, language, unitsFromClient.Balance, examples.Balance, templates, ensemble)
 			results <- result{"balance", balanceResp, err}
 		}()
 	}
 
 	if hits.Income < 17 {
-		nullJSON, err := createNullJSONResponse("income")
+		nullJSON, err := createNullJSONResponse("income", period)
 		if err != nil {
 			return SubmitterRes{}, err
 		}
-		response.Income = AIresponse{0, 0, 0, 0, "", "", nullJSON}
+		response.Income = AIresponse{FinalContent: nullJSON}
+	} else if previous.Income.FinalContent != "" {
+		response.Income = previous.Income
 	} else {
 		activeRoutines++
 		go func() {
-			incomeResp, err := InitiatePipeline(ctx, ai, incomeResult, "income", period, language, unitsFromClient.Income)
+			incomeResp, err := InitiatePipeline(ctx, ai, incomeResult, "income", period, language, unitsFromClient.Income, examples.Income, templates, ensemble)
 			results <- result{"income", incomeResp, err}
 		}()
 	}
 
 	if hits.CashFlow < 17 {
-		nullJSON, err := createNullJSONResponse("cash_flow")
+		nullJSON, err := createNullJSONResponse("cash_flow", period)
 		if err != nil {
 			return SubmitterRes{}, err
 		}
-		response.CashFlow = AIresponse{0, 0, 0, 0, "", "", nullJSON}
+		response.CashFlow = AIresponse{FinalContent: nullJSON}
+	} else if previous.CashFlow.FinalContent != "" {
+		response.CashFlow = previous.CashFlow
 	} else {
 		activeRoutines++
 		go func() {
-			cashFlowResp, err := InitiatePipeline(ctx, ai, cashFlowResult, "cash_flow", period, language, unitsFromClient.CashFlow)
+			cashFlowResp, err := InitiatePipeline(ctx, ai, cashFlowResult, "cash_flow", period, language, unitsFromClient.CashFlow, examples.CashFlow, templates, ensemble)
 			results <- result{"cash_flow", cashFlowResp, err}
 		}()
 	}
 
+	// every statement finishes, the ones that did are kept for the next attempt.
+	// Failed ones keep their usage, without a FinalContent they are not reused.
+	var partial *PartialError
 	for range activeRoutines {
 		res := <-results
 		if res.err != nil {
-			return SubmitterRes{}, res.err
+			if partial == nil {
+				partial = &PartialError{Err: res.err}
+			}
+			partial.Failed = append(partial.Failed, res.target)
+			res.response.FinalContent = ""
 		}
 
 		switch res.target {
@@ -242,6 +389,26 @@ func CallSubmitter(
 		}
 	}
 
+	if partial != nil {
+		sort.Strings(partial.Failed)
+		return response, partial
+	}
+
+	// the statements keep their usage, the calls are billed even if the extraction fails
+	if response.Balance.FinalContent == "" ||
+		response.Income.FinalContent == "" ||
+		response.CashFlow.FinalContent == "" {
+		return response, fmt.Errorf("empty response from submitter")
+	}
+
+	jobs.Report(ctx, "postprocessing")
+	periods, err := postprocessPeriods(response, period, unitsFromClient)
+	if err != nil {
+		return response, err
+	}
+	flagDisagreements(periods, response)
+	response.Periods = periods
+
 	return response, nil
 }
 
@@ -252,88 +419,104 @@ func CallSubmitter(
 // *****
 func InitiatePipeline(
 	ctx context.Context,
-	ai openai.Client,
+	ai llm.Provider,
 	contentToClean string,
 	target string,
 	period string,
 	language string,
 	units int64,
+	examples []store.Correction,
+	templates *prompts.Set,
+	ensemble []llm.EnsembleMember,
 ) (AIresponse, error) {
 
-	cleanerModel := openai.ChatModelGPT4oMini
-	submitterModel := openai.ChatModelGPT4oMini
-
 	response := AIresponse{}
 
-	cleanerPrompt := promptEngineerCleaner(contentToClean, target, units)
-	cleanerSystemContent := getSystemPrompt("cleaner", target)
+	cleanerPrompt, err := promptEngineerCleaner(templates, contentToClean, target, units)
+	if err != nil {
+		return AIresponse{}, err
+	}
+	cleanerSystemContent, err := getSystemPrompt(templates, "cleaner", target)
+	if err != nil {
+		return AIresponse{}, err
+	}
 
-	chatCompletionCleaner, err := ai.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.SystemMessage(cleanerSystemContent),
-			openai.UserMessage(cleanerPrompt),
+	jobs.Report(ctx, "cleaning "+target)
+	cleanerRes, err := ai.Chat(ctx, llm.Request{
+		Model: llm.MODEL_CLEANER,
+		Messages: []llm.Message{
+			llm.SystemMessage(cleanerSystemContent),
+			llm.UserMessage(cleanerPrompt),
 		},
-		Model:       cleanerModel,
-		MaxTokens:   openai.Int(5000),
-		Temperature: openai.Float(0.2),
+		MaxTokens:   5000,
+		Temperature: 0.2,
+		Timeout:     llm.TIMEOUT_CLEANER,
 	})
-
+	// from here on failures return the response, its usage is billed anyway,
+	// a failed call has the usage of the attempts it threw away
+	response.PromptTokensCleaner = cleanerRes.Usage.PromptTokens
+	response.CompletionTokensCleaner = cleanerRes.Usage.CompletionTokens
 	if err != nil {
-		logger.Log.Error("Failed to call OpenAI API", zap.Error(err))
-		return AIresponse{}, err
+		logger.Log.Error("Failed to call cleaner model", zap.Error(err))
+		return response, err
 	}
 
-	cleanerResult := ""
+	cleanerResult := cleanerRes.Content
 
-	if len(chatCompletionCleaner.Choices) > 0 {
-		response.PromptTokensCleaner = int64(chatCompletionCleaner.Usage.PromptTokens)
-		response.CompletionTokensCleaner = int64(chatCompletionCleaner.Usage.CompletionTokens)
-		cleanerResult = chatCompletionCleaner.Choices[0].Message.Content
-	} else {
-		logger.Log.Error("OpenAI API returned no choices", zap.Any("response", chatCompletionCleaner))
-		return AIresponse{}, fmt.Errorf("OpenAI API returned no choices")
+	submitterPrompt, err := promptEngineerSubmitter(templates, cleanerResult, period, examples)
+	if err != nil {
+		return response, err
+	}
+	submitterSystemContent, err := getSystemPrompt(templates, "submitter", target)
+	if err != nil {
+		return response, err
 	}
-
-	submitterPrompt := promptEngineerSubmitter(cleanerResult, period)
-	submitterSystemContent := getSystemPrompt("submitter", target)
 
 	fields := getRequiredFields(target, units)
 	financialSchema := createFinancialDataSchema(fields)
 
-	schemaParam := openai.ResponseFormatJSONSchemaJSONSchemaParam{
-		Name:   "financial_data",
-		Schema: financialSchema,
-		Strict: openai.Bool(true),
+	submitterReq := llm.Request{
+		Model: llm.MODEL_SUBMITTER,
+		Messages: []llm.Message{
+			llm.SystemMessage(submitterSystemContent),
+			llm.UserMessage(submitterPrompt),
+		},
+		MaxTokens:   4000, // one entry per period column, with its sources
+		Temperature: 0.2,
+		Timeout:     llm.TIMEOUT_SUBMITTER,
 	}
+	schema := llm.Schema{Name: "financial_data", Schema: financialSchema}
+
+	response.CleanerPrompt = cleanerPrompt
+	response.SubmitterPrompt = submitterPrompt
+	response.CleanerResult = cleanerResult
+
+	if len(ensemble) > 0 {
+		jobs.Report(ctx, fmt.Sprintf("extracting %s with %d runs", target, len(ensemble)))
+		response.Ensemble = callEnsemble(ctx, ai, submitterReq, schema, ensemble)
+		for _, member := range response.Ensemble {
+			response.PromptTokensSubmitter += member.PromptTokens
+			response.CompletionTokensSubmitter += member.CompletionTokens
+		}
 
-	chatCompletionSubmitter, err := ai.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.SystemMessage(submitterSystemContent),
-			openai.UserMessage(submitterPrompt),
-		},
-		Model:       submitterModel,
-		MaxTokens:   openai.Int(1000),
-		Temperature: openai.Float(0.2),
-		ResponseFormat: openai.ChatCompletionNewParamsResponseFormatUnion{
-			OfJSONSchema: &openai.ResponseFormatJSONSchemaParam{JSONSchema: schemaParam},
-		},
-	})
+		response.FinalContent, response.Disagreements, err = mergeAnswers(target, period, units, response.Ensemble)
+		if err != nil {
+			logger.Log.Error("Failed to merge ensemble answers", zap.Error(err))
+			return response, err
+		}
+		return response, nil
+	}
 
+	jobs.Report(ctx, "extracting "+target)
+	submitterRes, err := ai.ChatJSON(ctx, submitterReq, schema)
+	response.PromptTokensSubmitter = submitterRes.Usage.PromptTokens
+	response.CompletionTokensSubmitter = submitterRes.Usage.CompletionTokens
 	if err != nil {
-		logger.Log.Error("Failed to call OpenAI API", zap.Error(err))
-		return AIresponse{}, err
+		logger.Log.Error("Failed to call submitter model", zap.Error(err))
+		return response, err
 	}
 
-	if len(chatCompletionSubmitter.Choices) > 0 {
-		response.PromptTokensSubmitter = int64(chatCompletionSubmitter.Usage.PromptTokens)
-		response.CompletionTokensSubmitter = int64(chatCompletionSubmitter.Usage.CompletionTokens)
-		response.CleanerPrompt = cleanerPrompt
-		response.SubmitterPrompt = submitterPrompt
-		response.FinalContent = chatCompletionSubmitter.Choices[0].Message.Content
-	} else {
-		logger.Log.Error("OpenAI API returned no choices", zap.Any("response", chatCompletionSubmitter))
-		return AIresponse{}, fmt.Errorf("OpenAI API returned no choices")
-	}
+	response.FinalContent = submitterRes.Content
 
 	return response, nil
 }
@@ -0,0 +1,64 @@
+package submitter
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"nodofinance/llm"
+	"nodofinance/prompts"
+)
+
+func TestCallSubmitterKeepsUsageOfFailedStatement(t *testing.T) {
+	errSubmitter := errors.New("submitter unavailable")
+
+	tests := []struct {
+		name                 string
+		ensemble             []llm.EnsembleMember
+		wantSubmitterPrompts int64
+	}{
+		{
+			name: "submitter call",
+		},
+		{
+			// the invalid answer is billed, the failed run has no usage
+			name:                 "ensemble merge",
+			ensemble:             []llm.EnsembleMember{{Model: "invalid"}, {Model: "failing"}},
+			wantSubmitterPrompts: 50,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ai := llm.NewScripted()
+			ai.Handler = func(req llm.Request, schema *llm.Schema) (llm.Response, error) {
+				if schema == nil {
+					return llm.Response{Content: "cleaned", Usage: llm.Usage{PromptTokens: 100, CompletionTokens: 10}}, nil
+				}
+				if req.Model == "invalid" {
+					return llm.Response{Content: "not json", Usage: llm.Usage{PromptTokens: 50, CompletionTokens: 5}}, nil
+				}
+				return llm.Response{}, errSubmitter
+			}
+
+			// only the balance reaches the models
+			res, err := CallSubmitter(context.Background(), ai, "balance", "", "", "2024-Y", "EN",
+				Hits{Balance: 20}, UnitsFromClient{}, Examples{}, prompts.Assign("test"), tt.ensemble, SubmitterRes{})
+
+			var partial *PartialError
+			if !errors.As(err, &partial) || !reflect.DeepEqual(partial.Failed, []string{"balance"}) {
+				t.Fatalf("CallSubmitter() = %v, want the balance failed", err)
+			}
+			if res.Balance.FinalContent != "" {
+				t.Errorf("failed balance content = %q, want none so a retry runs it again", res.Balance.FinalContent)
+			}
+			if res.Balance.PromptTokensCleaner != 100 || res.Balance.CompletionTokensCleaner != 10 {
+				t.Errorf("cleaner tokens = %d, %d, want 100, 10", res.Balance.PromptTokensCleaner, res.Balance.CompletionTokensCleaner)
+			}
+			if res.Balance.PromptTokensSubmitter != tt.wantSubmitterPrompts {
+				t.Errorf("submitter prompt tokens = %d, want %d", res.Balance.PromptTokensSubmitter, tt.wantSubmitterPrompts)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package submitter
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	// Fields scored below LOW_CONFIDENCE are flagged to the user
+	LOW_CONFIDENCE float64 = 0.6
+
+	balanceTolerance float64 = 0.02 // assets vs liabilities + equity
+	yoyMaxRatio      float64 = 5    // current vs previous period, either way
+	yoyMinMagnitude  float64 = 1e5  // smaller values jump around too much to tell
+)
+
+type Warning struct {
+	Code    string   `json:"code"`
+	Fields  []string `json:"fields,omitempty"`
+	Message string   `json:"message"`
+}
+
+// Validation scores every extracted field from 0 to 1 and explains the penalties
+type Validation struct {
+	Confidence map[string]float64 `json:"confidence"`
+	Warnings   []Warning          `json:"warnings"`
+}
+
+// LowConfidence lists the fields scored below LOW_CONFIDENCE, sorted
+func (v Validation) LowConfidence() []string {
+	fields := []string{}
+	for field, score := range v.Confidence {
+		if score < LOW_CONFIDENCE {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// Messages returns the warning messages, as stored with the period
+func (v Validation) Messages() []string {
+	messages := make([]string, 0, len(v.Warnings))
+	for _, w := range v.Warnings {
+		messages = append(messages, w.Message)
+	}
+	return messages
+}
+
+func (v *Validation) penalize(factor float64, code, message string, fields ...string) {
+	for _, field := range fields {
+		if _, exists := v.Confidence[field]; exists {
+			v.Confidence[field] = math.Round(v.Confidence[field]*factor*100) / 100
+		}
+	}
+	v.Warnings = append(v.Warnings, Warning{Code: code, Fields: fields, Message: message})
+}
+
+// *
+// **
+// ***
+// ****
+// *****
+// Validate checks what Postprocessor did to the raw statements (dropped values, flipped signs,
+// mixed units) and the consistency of the statements with each other
+func Validate(balance *BalanceSheet, income *IncomeStatement, cashFlow *CashFlowStatement, postprocessed Postprocessed) Validation {
+	v := Validation{Confidence: make(map[string]float64), Warnings: []Warning{}}
+
+	values := postprocessedValues(postprocessed)
+	for field, value := range values {
+		if value != nil {
+			v.Confidence[field] = 1
+		}
+	}
+
+	// 1. Values Postprocessor dropped or changed
+	raw := map[string]*float64{
+		"cash_and_equivalents":      balance.CashAndEquivalents,
+		"current_assets":            balance.CurrentAssets,
+		"current_liabilities":       balance.CurrentLiabilities,
+		"revenue":                   income.Revenue,
+		"net_income":                income.NetIncome,
+		"eps":                       income.EPS,
+		"cash_flow_from_operations": cashFlow.CashFlowFromOperations,
+		"cash_flow_from_investing":  cashFlow.CashFlowFromInvesting,
+		"cash_flow_from_financing":  cashFlow.CashFlowFromFinancing,
+	}
+	if balance.TotalAssets == nil {
+		raw["non_current_assets"] = balance.NonCurrentAssets
+	}
+
+	for _, field := range sortedKeys(raw) {
+		value := raw[field]
+		if value == nil {
+			continue
+		}
+
+		if values[field] == nil {
+			v.Confidence[field] = 0
+			v.Warnings = append(v.Warnings, Warning{
+				Code:    "out_of_range",
+				Fields:  []string{field},
+				Message: fmt.Sprintf("%s was dropped, %g is out of range", field, *value),
+			})
+			continue
+		}
+
+		constraint, isInt := Constraints[field].Constraint.(IntegerConstraint)
+		if isInt && !constraint.AllowNegative && *value < 0 {
+			v.penalize(0.5, "sign_flipped", fmt.Sprintf("%s was negative and its sign was flipped", field), field)
+		}
+	}
+
+	// 2. Units
+	balanceUnits := getUnitValue(balance.Units)
+	incomeUnits := getUnitValue(income.Units)
+	cashFlowUnits := getUnitValue(cashFlow.Units)
+
+	if balanceUnits != incomeUnits || balanceUnits != cashFlowUnits {
+		v.penalize(0.7, "unit_mismatch",
+			fmt.Sprintf("statements use different units: balance %g, income %g, cash flow %g", balanceUnits, incomeUnits, cashFlowUnits),
+			"current_assets", "non_current_assets", "cash_and_equivalents", "current_liabilities", "non_current_liabilities",
+			"revenue", "net_income", "cash_flow_from_operations", "cash_flow_from_investing", "cash_flow_from_financing")
+	}
+
+	// 3. Assets = liabilities + equity
+	totalAssets := balance.TotalAssets
+	if totalAssets == nil && balance.CurrentAssets != nil && balance.NonCurrentAssets != nil {
+		sum := *balance.CurrentAssets + *balance.NonCurrentAssets
+		totalAssets = &sum
+	}
+	totalLiabilities := balance.TotalLiabilities
+	if totalLiabilities == nil && balance.CurrentLiabilities != nil && balance.NonCurrentLiabilities != nil {
+		sum := math.Abs(*balance.CurrentLiabilities) + math.Abs(*balance.NonCurrentLiabilities)
+		totalLiabilities = &sum
+	}
+
+	if totalAssets != nil && totalLiabilities != nil && balance.Equity != nil && *totalAssets != 0 {
+		liabilitiesAndEquity := math.Abs(*totalLiabilities) + *balance.Equity
+		if math.Abs(*totalAssets-liabilitiesAndEquity)/math.Abs(*totalAssets) > balanceTolerance {
+			v.penalize(0.6, "balance_mismatch",
+				fmt.Sprintf("assets (%g) do not match liabilities + equity (%g)", *totalAssets, liabilitiesAndEquity),
+				"current_assets", "non_current_assets", "current_liabilities", "non_current_liabilities")
+		}
+	}
+
+	// 4. Net income and EPS signs
+	if postprocessed.NetIncome != nil && postprocessed.EPS != nil &&
+		*postprocessed.NetIncome != 0 && *postprocessed.EPS != 0 &&
+		(*postprocessed.NetIncome < 0) != (*postprocessed.EPS < 0) {
+		v.penalize(0.5, "eps_sign", "net income and EPS have opposite signs", "net_income", "eps")
+	}
+
+	return v
+}
+
+// CompareWithPrevious flags the fields that changed more than yoyMaxRatio times
+// against the same period of the previous year
+func (v *Validation) CompareWithPrevious(current, previous Postprocessed) {
+	currentValues := postprocessedValues(current)
+	previousValues := postprocessedValues(previous)
+
+	for _, field := range sortedKeys(currentValues) {
+		if field == "eps" {
+			continue
+		}
+
+		value, previousValue := currentValues[field], previousValues[field]
+		if value == nil || previousValue == nil {
+			continue
+		}
+
+		magnitude, previousMagnitude := math.Abs(*value), math.Abs(*previousValue)
+		if math.Max(magnitude, previousMagnitude) < yoyMinMagnitude || magnitude == 0 || previousMagnitude == 0 {
+			continue
+		}
+
+		ratio := magnitude / previousMagnitude
+		if ratio > yoyMaxRatio || ratio < 1/yoyMaxRatio {
+			v.penalize(0.6, "yoy_jump",
+				fmt.Sprintf("%s changed %.1fx from the previous year (%g to %g)", field, ratio, *previousValue, *value),
+				field)
+		}
+	}
+}
+
+// *
+// **
+// ***
+// ****
+// ***** HELPERS
+// postprocessedValues maps the json name of every field to its value as float64
+func postprocessedValues(p Postprocessed) map[string]*float64 {
+	values := make(map[string]*float64)
+
+	v := reflect.ValueOf(p)
+	t := v.Type()
+	for i := range t.NumField() {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		field := v.Field(i)
+
+		if field.IsNil() {
+			values[name] = nil
+			continue
+		}
+
+		var value float64
+		switch number := field.Elem().Interface().(type) {
+		case int64:
+			value = float64(number)
+		case float64:
+			value = number
+		}
+		values[name] = &value
+	}
+
+	return values
+}
+
+func sortedKeys(m map[string]*float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
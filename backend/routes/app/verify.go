@@ -0,0 +1,381 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"nodofinance/llm"
+	"nodofinance/store"
+	"nodofinance/utils/logger"
+
+	"go.uber.org/zap"
+)
+
+/*
+	Verification of the figures of an analyst report. Amounts, percentages and ratios are read from the
+	markdown and matched against the finances the report was written from (see PreprocessFinancesFromMaps):
+	their values and ratios, and the changes between periods. Figures of projection sections are not
+	checked, nor years and small counts. A report with more than ANALYST_MAX_ERROR_RATE of its figures
+	unmatched is regenerated up to ANALYST_REGENERATIONS times, then rejected.
+*/
+
+const (
+	// Fewer checked figures are not enough to reject a report
+	VERIFY_MIN_FIGURES = 5
+	// Relative slack on top of the rounding of the figure
+	verifyTolerance = 0.005
+	// Characters of context kept around a mismatch
+	verifyContext = 160
+)
+
+var errAnalysisRejected = errors.New("analysis figures don't match the finances")
+
+const errAnalysisRejectedMessage = "The analysis quoted figures that don't match your data and was discarded, try again"
+
+var (
+	figurePattern = regexp.MustCompile(`(?i)([$€£]\s?)?\(?-?(\d[\d,.]*\d|\d)\)?\s?(%|x\b|×|trillion|billion|bn\b|million|mm\b|thousand|mil millones|millones|millón|miles\b|[kmb]\b)?`)
+	// Sections with forecasts, nothing to match them against
+	projectionHeading = regexp.MustCompile(`(?i)projection|scenario|outlook|perspective|forecast|proyecci|escenario|perspectiva|previsi`)
+)
+
+var figureScales = map[string]float64{
+	"thousand": 1e3, "k": 1e3, "miles": 1e3,
+	"million": 1e6, "mm": 1e6, "m": 1e6, "millones": 1e6, "millón": 1e6,
+	"billion": 1e9, "bn": 1e9, "b": 1e9, "mil millones": 1e9,
+	"trillion": 1e12,
+}
+
+// Fields of the preprocessed finances that are ratios, their changes between periods are in points
+var ratioFields = map[string]bool{
+	"solvency_ratio": true, "debt_ratio": true, "liquidity_ratio": true,
+	"roa": true, "roe": true, "net_margin": true, "working_capital_over_non_current_liabilities": true,
+}
+
+type knownValue struct {
+	label string
+	value float64
+}
+
+type figure struct {
+	text    string
+	context string
+	// value as written, percentages as fractions
+	value float64
+	// half of the last written digit, in the unit of value
+	rounding float64
+	// unscaled amounts may be in thousands or millions
+	scales []float64
+}
+
+// generateAnalysis calls the analyst and verifies the report, regenerating it while too many figures
+// don't match. onRetry, if not nil, is called before each regeneration. Tokens of every attempt are
+// added up, failures return them too so they are billed. A report still over the threshold returns
+// errAnalysisRejected.
+func generateAnalysis(ctx context.Context, ai llm.Provider, req analysisRequest, onDelta llm.OnDelta, onRetry func(store.Verification)) (OpenAIResponse, error) {
+	known, err := knownValues(req.finances)
+	if err != nil {
+		return OpenAIResponse{}, err
+	}
+
+	var promptTokens, completionTokens int64
+	for attempt := 1; ; attempt++ {
+		openAIResponse, err := callOpenAI(ctx, ai, req.templates, req.ticker, req.finances, req.currency, req.rows, onDelta)
+		promptTokens += openAIResponse.PromptTokens
+		completionTokens += openAIResponse.CompletionTokens
+		if err != nil {
+			return OpenAIResponse{PromptTokens: promptTokens, CompletionTokens: completionTokens}, err
+		}
+		openAIResponse.PromptTokens, openAIResponse.CompletionTokens = promptTokens, completionTokens
+
+		verification := verifyReport(openAIResponse.FinalContent, known)
+		verification.Attempts = attempt
+		openAIResponse.Verification = &verification
+
+		if reportAccepted(verification) {
+			return openAIResponse, nil
+		}
+
+		logger.Log.Warn("Analysis figures don't match the finances",
+			zap.String("username", req.username),
+			zap.String("ticker", req.ticker),
+			zap.Int("attempt", attempt),
+			zap.Int("checked", verification.Checked),
+			zap.Int("mismatches", len(verification.Mismatches)))
+
+		if attempt > ANALYST_REGENERATIONS {
+			return openAIResponse, fmt.Errorf("%w: %d of %d figures", errAnalysisRejected, len(verification.Mismatches), verification.Checked)
+		}
+		if onRetry != nil {
+			onRetry(verification)
+		}
+	}
+}
+
+// reportAccepted tells if a report is kept: too few figures to judge it, or few enough unmatched
+func reportAccepted(v store.Verification) bool {
+	return v.Checked < VERIFY_MIN_FIGURES || v.ErrorRate <= ANALYST_MAX_ERROR_RATE
+}
+
+// verifyReport matches the figures of the report against the known values
+func verifyReport(content string, known []knownValue) store.Verification {
+	verification := store.Verification{Mismatches: []store.Mismatch{}}
+
+	for _, f := range reportFigures(content) {
+		verification.Checked++
+		if matchFigure(f, known) {
+			continue
+		}
+
+		mismatch := store.Mismatch{Figure: f.text, Context: f.context}
+		if closest, ok := closestValue(f, known); ok {
+			mismatch.Closest = closest.label
+			mismatch.Expected = &closest.value
+		}
+		verification.Mismatches = append(verification.Mismatches, mismatch)
+	}
+
+	if verification.Checked > 0 {
+		verification.ErrorRate = RoundTo2Decimals(float64(len(verification.Mismatches)) / float64(verification.Checked))
+	}
+	return verification
+}
+
+// reportFigures reads the figures of the lines outside headings and projection sections
+func reportFigures(content string) []figure {
+	var figures []figure
+
+	// projection heading levels, a section ends at a heading of its level or above
+	skipLevel := 0
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "#") {
+			level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+			if skipLevel > 0 && level <= skipLevel {
+				skipLevel = 0
+			}
+			if skipLevel == 0 && projectionHeading.MatchString(trimmed) {
+				skipLevel = level
+			}
+			continue
+		}
+		if skipLevel > 0 {
+			continue
+		}
+
+		for _, loc := range figurePattern.FindAllStringSubmatchIndex(line, -1) {
+			// part of a word or a period label, e.g. Q4 or 2023-Y
+			if loc[0] > 0 && isWordByte(line[loc[0]-1]) {
+				continue
+			}
+			if loc[1] < len(line) && (line[loc[1]] == '-' || isWordByte(line[loc[1]])) {
+				continue
+			}
+
+			currency := loc[2] != -1
+			unit := ""
+			if loc[6] != -1 {
+				unit = strings.ToLower(line[loc[6]:loc[7]])
+			}
+
+			f, ok := parseFigure(line[loc[4]:loc[5]], unit, currency)
+			if !ok {
+				continue
+			}
+			f.text = strings.TrimSpace(line[loc[0]:loc[1]])
+			f.context = figureContext(line, loc[0], loc[1])
+			figures = append(figures, f)
+		}
+	}
+
+	return figures
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// parseFigure reads English and Spanish number formats. Years and small counts are not figures.
+func parseFigure(number, unit string, currency bool) (figure, bool) {
+	decimals := ""
+	digits := number
+
+	lastDot := strings.LastIndex(number, ".")
+	lastComma := strings.LastIndex(number, ",")
+	decimalSep := -1
+	switch {
+	case lastDot != -1 && lastComma != -1:
+		decimalSep = max(lastDot, lastComma)
+	case lastDot != -1 && strings.Count(number, ".") == 1:
+		decimalSep = lastDot
+	case lastComma != -1 && strings.Count(number, ",") == 1 && len(number)-lastComma-1 != 3:
+		decimalSep = lastComma
+	}
+	if decimalSep != -1 {
+		digits, decimals = number[:decimalSep], number[decimalSep+1:]
+	}
+	digits = strings.NewReplacer(",", "", ".", "").Replace(digits)
+
+	value, err := strconv.ParseFloat(digits+"."+decimals, 64)
+	if decimals == "" {
+		value, err = strconv.ParseFloat(digits, 64)
+	}
+	if err != nil {
+		return figure{}, false
+	}
+
+	f := figure{value: value, rounding: 0.5 * math.Pow10(-len(decimals))}
+
+	switch {
+	case unit == "%":
+		f.value /= 100
+		f.rounding /= 100
+	case unit == "x" || unit == "×":
+	case figureScales[unit] > 0:
+		f.value *= figureScales[unit]
+		f.rounding *= figureScales[unit]
+	default:
+		if decimals == "" && !currency && (value < 100 || (value >= 1900 && value <= 2100)) {
+			return figure{}, false
+		}
+		f.scales = []float64{1}
+		if decimals == "" && value >= 1000 {
+			f.scales = append(f.scales, 1e3, 1e6)
+		}
+	}
+
+	if f.scales == nil {
+		f.scales = []float64{1}
+	}
+
+	// 6.710 is 6710 in Spanish, both readings are tried
+	ambiguous := decimalSep == lastDot && lastComma == -1 && len(decimals) == 3
+	if ambiguous && unit != "%" && unit != "x" && unit != "×" {
+		f.scales = append(f.scales, 1e3)
+	}
+	return f, true
+}
+
+func figureContext(line string, start, end int) string {
+	from := max(0, start-verifyContext/2)
+	to := min(len(line), end+verifyContext/2)
+	// whole characters
+	for from > 0 && !isRuneStart(line[from]) {
+		from--
+	}
+	for to < len(line) && !isRuneStart(line[to]) {
+		to++
+	}
+	return strings.TrimSpace(line[from:to])
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+func matchFigure(f figure, known []knownValue) bool {
+	target := math.Abs(f.value)
+	for _, scale := range f.scales {
+		for _, k := range known {
+			expected := math.Abs(k.value)
+			if math.Abs(target*scale-expected) <= f.rounding*scale+verifyTolerance*expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func closestValue(f figure, known []knownValue) (knownValue, bool) {
+	best, bestDistance := knownValue{}, math.Inf(1)
+	target := math.Abs(f.value)
+	for _, scale := range f.scales {
+		for _, k := range known {
+			expected := math.Abs(k.value)
+			if expected == 0 {
+				continue
+			}
+			distance := math.Abs(math.Log(target * scale / expected))
+			if distance < bestDistance {
+				best, bestDistance = k, distance
+			}
+		}
+	}
+	// more than 2x away is not the same number
+	return best, bestDistance < math.Ln2
+}
+
+// knownValues lists the values and ratios of every period of the preprocessed finances, and their
+// changes from the previous period and from the same period of the previous year
+func knownValues(mergedFinances string) ([]knownValue, error) {
+	var periods map[string]map[string]*float64
+	if err := json.Unmarshal([]byte(mergedFinances), &periods); err != nil {
+		return nil, fmt.Errorf("parsing finances: %w", err)
+	}
+
+	keys := make([]string, 0, len(periods))
+	for key := range periods {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return periodBefore(keys[i], keys[j]) })
+
+	var known []knownValue
+	for i, key := range keys {
+		for field, value := range periods[key] {
+			if value != nil {
+				known = append(known, knownValue{label: key + " " + field, value: *value})
+			}
+		}
+
+		var previous []string
+		if i > 0 {
+			previous = append(previous, keys[i-1])
+		}
+		if year, period, err := splitPeriod(key); err == nil {
+			sameLastYear := fmt.Sprintf("%d-%s", year-1, period)
+			if _, exists := periods[sameLastYear]; exists && (i == 0 || keys[i-1] != sameLastYear) {
+				previous = append(previous, sameLastYear)
+			}
+		}
+
+		for _, prev := range previous {
+			for field, value := range periods[key] {
+				before := periods[prev][field]
+				if value == nil || before == nil {
+					continue
+				}
+				label := fmt.Sprintf("%s %s vs %s", field, key, prev)
+				if ratioFields[field] {
+					known = append(known, knownValue{label: label + " (points)", value: *value - *before})
+				}
+				if *before != 0 {
+					known = append(known, knownValue{label: label + " (change)", value: (*value - *before) / math.Abs(*before)})
+				}
+			}
+		}
+	}
+
+	return known, nil
+}
+
+// periodBefore orders "YYYY-P" keys by year, then quarters and halves before the year
+func periodBefore(a, b string) bool {
+	yearA, periodA, errA := splitPeriod(a)
+	yearB, periodB, errB := splitPeriod(b)
+	if errA != nil || errB != nil {
+		return a < b
+	}
+	if yearA != yearB {
+		return yearA < yearB
+	}
+	orderA, _ := store.PeriodOrder(periodA)
+	orderB, _ := store.PeriodOrder(periodB)
+	return orderA > orderB
+}
@@ -0,0 +1,296 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"nodofinance/llm"
+)
+
+func TestParseFigure(t *testing.T) {
+	tests := []struct {
+		name       string
+		number     string
+		unit       string
+		currency   bool
+		wantOK     bool
+		wantValue  float64
+		wantScales []float64
+	}{
+		{"EN thousands and decimals", "1,234.5", "", false, true, 1234.5, []float64{1}},
+		{"ES thousands and decimals", "1.234,5", "", false, true, 1234.5, []float64{1}},
+		{"EN thousands", "12,345", "", false, true, 12345, []float64{1, 1e3, 1e6}},
+		{"ES thousands", "12.345.678", "", false, true, 12345678, []float64{1, 1e3, 1e6}},
+		{"ES decimal comma", "3,25", "", false, true, 3.25, []float64{1}},
+		{"ES thousands or EN decimals", "6.710", "millones", false, true, 6.71e6, []float64{1, 1e3}},
+		{"percentage with three decimals", "1.125", "%", false, true, 0.01125, []float64{1}},
+		{"percentage", "12.5", "%", false, true, 0.125, []float64{1}},
+		{"ES percentage", "12,5", "%", false, true, 0.125, []float64{1}},
+		{"multiple", "1.8", "x", false, true, 1.8, []float64{1}},
+		{"million", "1,234.5", "million", true, true, 1234.5e6, []float64{1}},
+		{"millones", "1.234,5", "millones", true, true, 1234.5e6, []float64{1}},
+		{"mil millones", "2,1", "mil millones", false, true, 2.1e9, []float64{1}},
+		{"bn", "1.2", "bn", true, true, 1.2e9, []float64{1}},
+		{"M", "250", "m", true, true, 250e6, []float64{1}},
+		{"k", "45", "k", false, true, 45e3, []float64{1}},
+		{"year", "2024", "", false, false, 0, nil},
+		{"small count", "42", "", false, false, 0, nil},
+		{"amount of a year's size", "2024", "", true, true, 2024, []float64{1, 1e3, 1e6}},
+		{"small amount", "42", "", true, true, 42, []float64{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, ok := parseFigure(tt.number, tt.unit, tt.currency)
+			if ok != tt.wantOK {
+				t.Fatalf("parseFigure(%q, %q) ok = %t, want %t", tt.number, tt.unit, ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if math.Abs(f.value-tt.wantValue) > 1e-9*math.Abs(tt.wantValue) {
+				t.Errorf("parseFigure(%q, %q) value = %v, want %v", tt.number, tt.unit, f.value, tt.wantValue)
+			}
+			if !reflect.DeepEqual(f.scales, tt.wantScales) {
+				t.Errorf("parseFigure(%q, %q) scales = %v, want %v", tt.number, tt.unit, f.scales, tt.wantScales)
+			}
+		})
+	}
+}
+
+func TestReportFigures(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"EN amount", "Revenue reached $1,234.5 million in the year.", []string{"$1,234.5 million"}},
+		{"ES amount", "La cifra de negocios alcanzó 1.234,5 millones de euros.", []string{"1.234,5 millones"}},
+		{"scale letter", "Cash of €250M and debt of 1.2bn.", []string{"€250M", "1.2bn"}},
+		{"negative in parentheses", "Net income was (350) million.", []string{"(350) million"}},
+		{"percentages", "Net margin of 12.5% against 11,2 %.", []string{"12.5%", "11,2 %"}},
+		{"years and counts", "In 2023 the company opened 12 stores.", nil},
+		{"period labels", "Revenue of 2024-Y beat Q4 and the H1 of 2023-S1.", nil},
+		{"headings", "## 2024 results: 15% growth", nil},
+		{
+			name:    "projection sections",
+			content: "## Outlook\nRevenue could reach $60 billion.\n### Bull case\nUp to $70 billion.\n## Balance\nCash of $12.3 billion.",
+			want:    []string{"$12.3 billion"},
+		},
+		{
+			name:    "ES projection sections",
+			content: "## Proyecciones\nIngresos de 60.000 millones.\n## Riesgos\nDeuda de 1.500 millones.",
+			want:    []string{"1.500 millones"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range reportFigures(tt.content) {
+				got = append(got, f.text)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reportFigures() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKnownValues(t *testing.T) {
+	finances := `{
+		"2023-Q1": {"revenue": 100, "net_margin": 0.10},
+		"2023-Q4": {"revenue": 110, "net_margin": 0.12},
+		"2024-Q1": {"revenue": 121, "net_margin": 0.15, "eps": null}
+	}`
+
+	known, err := knownValues(finances)
+	if err != nil {
+		t.Fatalf("knownValues() = %v", err)
+	}
+	values := make(map[string]float64, len(known))
+	for _, k := range known {
+		values[k.label] = k.value
+	}
+
+	want := map[string]float64{
+		"2024-Q1 revenue": 121,
+		// from the previous period and the same period of the previous year
+		"revenue 2024-Q1 vs 2023-Q4 (change)": 0.1,
+		"revenue 2024-Q1 vs 2023-Q1 (change)": 0.21,
+		// ratios change in points too
+		"net_margin 2024-Q1 vs 2023-Q4 (points)": 0.03,
+		"net_margin 2024-Q1 vs 2023-Q4 (change)": 0.25,
+	}
+	for label, value := range want {
+		got, exists := values[label]
+		if !exists || math.Abs(got-value) > 1e-9 {
+			t.Errorf("known %q = %v (found %t), want %v", label, got, exists, value)
+		}
+	}
+
+	for _, label := range []string{"2024-Q1 eps", "revenue 2024-Q1 vs 2023-Q4 (points)", "revenue 2023-Q1 vs 2022-Q1 (change)"} {
+		if _, exists := values[label]; exists {
+			t.Errorf("known %q, want none", label)
+		}
+	}
+
+	if _, err := knownValues("not json"); err == nil {
+		t.Error("knownValues() of invalid finances = nil, want an error")
+	}
+}
+
+func TestVerifyReport(t *testing.T) {
+	saved := ANALYST_MAX_ERROR_RATE
+	t.Cleanup(func() { ANALYST_MAX_ERROR_RATE = saved })
+	ANALYST_MAX_ERROR_RATE = 0.2
+
+	known, err := knownValues(`{
+		"2023-Y": {"revenue": 45100000000, "net_income": -350000000, "net_margin": 0.118},
+		"2024-Y": {"revenue": 48200000000, "net_income": 6710000000, "net_margin": 0.139}
+	}`)
+	if err != nil {
+		t.Fatalf("knownValues() = %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		content        string
+		wantChecked    int
+		wantMismatches []string
+		wantErrorRate  float64
+		wantAccepted   bool
+	}{
+		{
+			name: "every figure matches",
+			content: "Revenue grew 6.9% to $48.2 billion from $45,100 million.\n" +
+				"Net income of 6.710 millones after a loss of (350) million.\n" +
+				"Net margin of 13.9%, up 2.1 points from 11.8%.\n" +
+				"## Outlook\nRevenue could reach $60 billion.",
+			wantChecked:    7,
+			wantMismatches: nil,
+			wantErrorRate:  0,
+			wantAccepted:   true,
+		},
+		{
+			name: "at the threshold",
+			content: "Revenue of $48.2 billion and $45.1 billion.\n" +
+				"Net income of $6.7 billion, net margin of 13.9%.\n" +
+				"Cash of $9 billion.",
+			wantChecked:    5,
+			wantMismatches: []string{"$9 billion"},
+			wantErrorRate:  0.2,
+			wantAccepted:   true,
+		},
+		{
+			name: "over the threshold",
+			content: "Revenue of $48.2 billion and $45.1 billion.\n" +
+				"Net income of $7.9 billion, net margin of 13.9%.\n" +
+				"Cash of $9 billion.",
+			wantChecked:    5,
+			wantMismatches: []string{"$7.9 billion", "$9 billion"},
+			wantErrorRate:  0.4,
+			wantAccepted:   false,
+		},
+		{
+			name:           "too few figures to judge",
+			content:        "Revenue of $52 billion, net income of $9 billion.",
+			wantChecked:    2,
+			wantMismatches: []string{"$52 billion", "$9 billion"},
+			wantErrorRate:  1,
+			wantAccepted:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := verifyReport(tt.content, known)
+
+			var mismatches []string
+			for _, m := range v.Mismatches {
+				mismatches = append(mismatches, m.Figure)
+			}
+			if v.Checked != tt.wantChecked || !reflect.DeepEqual(mismatches, tt.wantMismatches) {
+				t.Errorf("verifyReport() checked %d, mismatches %q, want %d, %q", v.Checked, mismatches, tt.wantChecked, tt.wantMismatches)
+			}
+			if v.ErrorRate != tt.wantErrorRate {
+				t.Errorf("error rate = %v, want %v", v.ErrorRate, tt.wantErrorRate)
+			}
+			if got := reportAccepted(v); got != tt.wantAccepted {
+				t.Errorf("reportAccepted() = %t, want %t", got, tt.wantAccepted)
+			}
+		})
+	}
+}
+
+func TestAnalystBillsFailedReport(t *testing.T) {
+	setLimits(t, 1_000_000, 5, 5)
+	setRates(t, 1, 2, 4, 8)
+	savedRegenerations := ANALYST_REGENERATIONS
+	t.Cleanup(func() { ANALYST_REGENERATIONS = savedRegenerations })
+	ANALYST_REGENERATIONS = 1
+
+	// no figure of the report is in the finances
+	report := "Revenue reached $123.4 million, up from $98.7 million. Net income was $45.6 million, " +
+		"operating cash flow $67.8 million and debt $89.1 million, with margins of 37.2%."
+	usage := llm.Usage{PromptTokens: 1000, CompletionTokens: 100}
+
+	tests := []struct {
+		name       string
+		analystErr error
+		wantStatus int
+		wantTokens int64
+	}{
+		{
+			name:       "rejected after every regeneration",
+			wantStatus: http.StatusBadGateway,
+			wantTokens: analystTokens(false, 2*usage.PromptTokens, 2*usage.CompletionTokens),
+		},
+		{
+			// the first report is regenerated, the second call fails with the usage of the attempts it threw away
+			name:       "failed regeneration",
+			analystErr: errors.New("provider down"),
+			wantStatus: http.StatusInternalServerError,
+			wantTokens: analystTokens(false, 2*usage.PromptTokens, 2*usage.CompletionTokens),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newMemoryStore(t, 0)
+			putPeriod(t, st, "ACME", 2024, "Y", 100)
+
+			calls := 0
+			ai := llm.NewScripted()
+			ai.Handler = func(req llm.Request, schema *llm.Schema) (llm.Response, error) {
+				if schema != nil {
+					return llm.Response{Content: "{}"}, nil
+				}
+				calls++
+				if calls > 1 && tt.analystErr != nil {
+					return llm.Response{Usage: usage}, tt.analystErr
+				}
+				return llm.Response{Content: report, Usage: usage}, nil
+			}
+
+			w := httptest.NewRecorder()
+			Analyst(w, newRequest(t, http.MethodPost, "/analyst", `{"ticker": "ACME", "currency": "USD"}`, testUser), st, ai)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Analyst() status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			user, err := st.GetMetadata(context.Background(), testUser)
+			if err != nil {
+				t.Fatalf("GetMetadata() = %v", err)
+			}
+			if *user.CTokens != tt.wantTokens {
+				t.Errorf("tokens = %d, want %d", *user.CTokens, tt.wantTokens)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package auth
 import (
 	"context"
 	"fmt"
+	"nodofinance/store"
 	"nodofinance/utils/env"
 	"nodofinance/utils/logger"
 
@@ -42,6 +43,11 @@ func init() {
 }
 
 func validateVar() error {
+	// not served without AWS
+	if store.MEMORY_STORE {
+		return nil
+	}
+
 	var exists bool
 
 	COGNITO_CLIENT_ID, exists = env.Get("COGNITO_CLIENT_ID")
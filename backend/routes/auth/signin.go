@@ -6,21 +6,19 @@ import (
 	"net/http"
 	"time"
 
+	"nodofinance/store"
 	"nodofinance/utils/csrf"
 	"nodofinance/utils/jwt"
 	"nodofinance/utils/logger"
 	"nodofinance/utils/sanitize"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
 	cognitoTypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"go.uber.org/zap"
 )
 
-func SignIn(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider.Client, d *dynamodb.Client) {
+func SignIn(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider.Client, st store.Store) {
 	ctx := r.Context()
 
 	var req EmailPasswordReq
@@ -95,23 +93,14 @@ func SignIn(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider.C
 		isCognitoPremium = true
 	}
 
-	getUserInput := &dynamodb.GetItemInput{
-		TableName: aws.String("nodofinance_table"),
-		Key: map[string]dynamoTypes.AttributeValue{
-			"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-			"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: "METADATA"},
-		},
-		ProjectionExpression: aws.String("expires_date"),
-	}
-
-	result, err := d.GetItem(ctx, getUserInput)
-	if err != nil {
+	user, err := st.GetMetadata(ctx, username)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
 		logger.Log.Error("DynamoDB query failed", zap.Error(err), zap.String("username", username))
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	if result.Item == nil {
+	if errors.Is(err, store.ErrNotFound) {
 		// *
 		// ***
 		// *****
@@ -139,17 +128,6 @@ func SignIn(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider.C
 		// ***
 		// *****
 		// SCENARIO 2: has been or is premium in db
-		var user struct {
-			ExpiresDate *int64 `dynamodbav:"expires_date,omitempty"`
-		}
-
-		err = attributevalue.UnmarshalMap(result.Item, &user)
-		if err != nil {
-			logger.Log.Error("Failed to unmarshal user data", zap.Error(err))
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-
 		if user.ExpiresDate == nil || *user.ExpiresDate == 0 {
 			logger.Log.Warn("User has no expiration date in DB", zap.String("username", username))
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -2,23 +2,22 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
+	"nodofinance/store"
 	"nodofinance/utils/csrf"
 	"nodofinance/utils/jwt"
 	"nodofinance/utils/logger"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
 	cognitoTypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"go.uber.org/zap"
 )
 
-func Token(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider.Client, d *dynamodb.Client) {
+func Token(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider.Client, st store.Store) {
 	ctx := r.Context()
 
 	var idToken string
@@ -143,24 +142,15 @@ func Token(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider.Cl
 			isCognitoPremium = true
 		}
 
-		getUserInput := &dynamodb.GetItemInput{
-			TableName: aws.String("nodofinance_table"),
-			Key: map[string]dynamoTypes.AttributeValue{
-				"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-				"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: "METADATA"},
-			},
-			ProjectionExpression: aws.String("expires_date"),
-		}
-
-		result, err := d.GetItem(ctx, getUserInput)
-		if err != nil {
+		user, err := st.GetMetadata(ctx, username)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
 			logger.Log.Error("DynamoDB query failed", zap.Error(err), zap.String("username", username))
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
 		// Check if item exists
-		if result.Item == nil {
+		if errors.Is(err, store.ErrNotFound) {
 			// *
 			// ***
 			// *****
@@ -189,18 +179,6 @@ func Token(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider.Cl
 			// *****
 			// SCENARIO 2: has been or is premium in db
 
-			// Unmarshal the user data
-			var user struct {
-				ExpiresDate *int64 `dynamodbav:"expires_date,omitempty"`
-			}
-
-			err = attributevalue.UnmarshalMap(result.Item, &user)
-			if err != nil {
-				logger.Log.Error("Failed to unmarshal user data", zap.Error(err))
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				return
-			}
-
 			if user.ExpiresDate == nil || *user.ExpiresDate == 0 {
 				logger.Log.Warn("User has no expiration date in DB", zap.String("username", username))
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -2,6 +2,7 @@ package payments
 
 import (
 	"fmt"
+	"nodofinance/store"
 	"nodofinance/utils/env"
 )
 
@@ -16,6 +17,11 @@ func init() {
 }
 
 func validateVar() error {
+	// not served without AWS
+	if store.MEMORY_STORE {
+		return nil
+	}
+
 	var exists bool
 
 	PRICE_ID, exists = env.Get("STRIPE_PRICE_ID")
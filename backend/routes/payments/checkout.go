@@ -4,22 +4,20 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"nodofinance/store"
 	"nodofinance/utils/jwt"
 	"nodofinance/utils/logger"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/patrickmn/go-cache"
 	"github.com/stripe/stripe-go/v81"
 	"github.com/stripe/stripe-go/v81/checkout/session"
 	"go.uber.org/zap"
 )
 
-func Checkout(w http.ResponseWriter, r *http.Request, d *dynamodb.Client, dataCache *cache.Cache) {
+func Checkout(w http.ResponseWriter, r *http.Request, st store.Store, dataCache *cache.Cache) {
 	ctx := r.Context()
 
 	idTokenCookie, errIT := r.Cookie("nodo_id_token")
@@ -79,37 +77,15 @@ func Checkout(w http.ResponseWriter, r *http.Request, d *dynamodb.Client, dataCa
 		CustomerEmail: stripe.String(email),
 	}
 
-	getUserInput := &dynamodb.GetItemInput{
-		TableName: aws.String("nodofinance_table"),
-		Key: map[string]dynamoTypes.AttributeValue{
-			"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-			"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: "METADATA"},
-		},
-		ProjectionExpression: aws.String("expires_date, stripe_id"),
-	}
-
-	result, err := d.GetItem(ctx, getUserInput)
-	if err != nil {
+	user, err := st.GetMetadata(ctx, username)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
 		logger.Log.Error("Error querying DynamoDB", zap.Error(err))
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
 	// Check if user exists
-	if result.Item != nil {
-		// Unmarshal the user data
-		var user struct {
-			StripeID    string `dynamodbav:"stripe_id,omitempty"`
-			ExpiresDate *int64 `dynamodbav:"expires_date,omitempty"`
-		}
-
-		err = attributevalue.UnmarshalMap(result.Item, &user)
-		if err != nil {
-			logger.Log.Error("Failed to unmarshal user data", zap.Error(err))
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-
+	if err == nil {
 		if user.StripeID == "" || user.ExpiresDate == nil || *user.ExpiresDate == 0 {
 			logger.Log.Warn("User has no expiration date or stripe ID in DB", zap.String("username", username))
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
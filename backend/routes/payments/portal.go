@@ -2,21 +2,19 @@ package payments
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
+	"nodofinance/store"
 	"nodofinance/utils/jwt"
 	"nodofinance/utils/logger"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/stripe/stripe-go/v81"
 	"github.com/stripe/stripe-go/v81/billingportal/session"
 	"go.uber.org/zap"
 )
 
-func Portal(w http.ResponseWriter, r *http.Request, d *dynamodb.Client) {
+func Portal(w http.ResponseWriter, r *http.Request, st store.Store) {
 	ctx := r.Context()
 
 	idTokenCookie, errIT := r.Cookie("nodo_id_token")
@@ -39,36 +37,15 @@ func Portal(w http.ResponseWriter, r *http.Request, d *dynamodb.Client) {
 		return
 	}
 
-	getUserInput := &dynamodb.GetItemInput{
-		TableName: aws.String("nodofinance_table"),
-		Key: map[string]dynamoTypes.AttributeValue{
-			"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-			"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: "METADATA"},
-		},
-		ProjectionExpression: aws.String("stripe_id"),
-	}
-
-	result, err := d.GetItem(ctx, getUserInput)
-	if err != nil {
-		logger.Log.Error("Error querying DynamoDB", zap.Error(err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-
-	if result.Item == nil {
+	user, err := st.GetMetadata(ctx, username)
+	if errors.Is(err, store.ErrNotFound) {
 		logger.Log.Warn("No rows found for user, review potential tampering", zap.String("username", username))
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	// Unmarshal the user data
-	var user struct {
-		StripeID string `dynamodbav:"stripe_id,omitempty"`
-	}
-
-	err = attributevalue.UnmarshalMap(result.Item, &user)
 	if err != nil {
-		logger.Log.Error("Failed to unmarshal user data", zap.Error(err))
+		logger.Log.Error("Error querying DynamoDB", zap.Error(err))
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
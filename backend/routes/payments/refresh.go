@@ -2,9 +2,9 @@ package payments
 
 import (
 	"errors"
-	"fmt"
 	"net/http"
 	"nodofinance/routes/auth"
+	"nodofinance/store"
 	"nodofinance/utils/csrf"
 	"nodofinance/utils/jwt"
 	"nodofinance/utils/logger"
@@ -12,11 +12,8 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/patrickmn/go-cache"
 	"github.com/stripe/stripe-go/v81"
 	"github.com/stripe/stripe-go/v81/customer"
@@ -24,7 +21,7 @@ import (
 	"go.uber.org/zap"
 )
 
-func Refresh(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider.Client, d *dynamodb.Client, dataCache *cache.Cache) {
+func Refresh(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider.Client, st store.Store, dataCache *cache.Cache) {
 	ctx := r.Context()
 
 	token := r.URL.Query().Get("token")
@@ -174,40 +171,18 @@ func Refresh(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider.
 			return
 		}
 
-		var user struct {
-			StripeID    string `dynamodbav:"stripe_id,omitempty"`
-			ExpiresDate *int64 `dynamodbav:"expires_date,omitempty"`
-			CTokens     *int64 `dynamodbav:"ctokens,omitempty"`
-		}
-
 		if !triggerFallback {
-			getUserInput := &dynamodb.GetItemInput{
-				TableName: aws.String("nodofinance_table"),
-				Key: map[string]dynamoTypes.AttributeValue{
-					"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-					"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: "METADATA"},
-				},
-			}
-
-			result, err := d.GetItem(ctx, getUserInput)
-			if err != nil {
-				logger.Log.Error("Error querying DynamoDB, review webhook hit", zap.Error(err), zap.String("username", username))
-				triggerFallback = true
-			} else if result.Item == nil {
+			user, err := st.GetMetadata(ctx, username)
+			if errors.Is(err, store.ErrNotFound) {
 				logger.Log.Info("No rows found for user review webhook hit -> manually updating database", zap.String("username", username))
 
-				putUserInput := &dynamodb.PutItemInput{
-					TableName: aws.String("nodofinance_table"),
-					Item: map[string]dynamoTypes.AttributeValue{
-						"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-						"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: "METADATA"},
-						"stripe_id":    &dynamoTypes.AttributeValueMemberS{Value: customerID},
-						"expires_date": &dynamoTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", currentPeriodEnd)},
-						"ctokens":      &dynamoTypes.AttributeValueMemberN{Value: "0"},
-					},
-				}
-
-				_, err := d.PutItem(ctx, putUserInput)
+				ctokens := int64(0)
+				err := st.PutMetadata(ctx, store.Metadata{
+					Username:    username,
+					StripeID:    customerID,
+					ExpiresDate: &currentPeriodEnd,
+					CTokens:     &ctokens,
+				})
 				if err != nil {
 					logger.Log.Error("Error inserting new user into database", zap.Error(err))
 					triggerFallback = true
@@ -217,52 +192,32 @@ func Refresh(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider.
 						zap.String("email", email),
 						zap.Int64("Expires At", currentPeriodEnd))
 				}
-			} else {
-				// Unmarshal existing user data
-				err = attributevalue.UnmarshalMap(result.Item, &user)
+			} else if err != nil {
+				logger.Log.Error("Error querying DynamoDB, review webhook hit", zap.Error(err), zap.String("username", username))
+				triggerFallback = true
+			} else if user.StripeID == "" || user.ExpiresDate == nil || *user.ExpiresDate == 0 {
+				logger.Log.Error("Stripe ID or expiresTimestamp is not valid", zap.String("username", username))
+				triggerFallback = true
+			} else if user.StripeID != customerID {
+				logger.Log.Error("Webhook failed and Stripe ID mismatch", zap.String("expected", user.StripeID), zap.String("received", customerID),
+					zap.String("username", username), zap.String("email", email))
+				triggerFallback = true
+			} else if *user.ExpiresDate < currentPeriodEnd {
+				err := st.RenewSubscription(ctx, username, customerID, currentPeriodEnd)
 				if err != nil {
-					logger.Log.Error("Failed to unmarshal user data", zap.Error(err))
-					triggerFallback = true
-				} else if user.StripeID == "" || user.ExpiresDate == nil || *user.ExpiresDate == 0 {
-					logger.Log.Error("Stripe ID or expiresTimestamp is not valid", zap.String("username", username))
-					triggerFallback = true
-				} else if user.StripeID != customerID {
-					logger.Log.Error("Webhook failed and Stripe ID mismatch", zap.String("expected", user.StripeID), zap.String("received", customerID),
-						zap.String("username", username), zap.String("email", email))
-					triggerFallback = true
-				} else if *user.ExpiresDate < currentPeriodEnd {
-					updateUserInput := &dynamodb.UpdateItemInput{
-						TableName: aws.String("nodofinance_table"),
-						Key: map[string]dynamoTypes.AttributeValue{
-							"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-							"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: "METADATA"},
-						},
-						UpdateExpression: aws.String("SET expires_date = :expires, ctokens = :ctokens"),
-						ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
-							":expires":           &dynamoTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", currentPeriodEnd)},
-							":ctokens":           &dynamoTypes.AttributeValueMemberN{Value: "0"},
-							":current_stripe_id": &dynamoTypes.AttributeValueMemberS{Value: customerID},
-						},
-						ConditionExpression: aws.String("stripe_id = :current_stripe_id"),
-					}
-
-					_, err := d.UpdateItem(ctx, updateUserInput)
-					if err != nil {
-						// Check if it's a condition check failure (equivalent to rowsAffected == 0)
-						var conditionalCheckFailedErr *dynamoTypes.ConditionalCheckFailedException
-						if errors.As(err, &conditionalCheckFailedErr) {
-							logger.Log.Error("User not found for subscription update", zap.String("username", username), zap.String("email", email))
-							triggerFallback = true
-						} else {
-							logger.Log.Error("Error updating timestamp", zap.Error(err), zap.String("username", username), zap.String("email", email))
-							triggerFallback = true
-						}
+					// Check if it's a condition check failure (equivalent to rowsAffected == 0)
+					if errors.Is(err, store.ErrConditionFailed) {
+						logger.Log.Error("User not found for subscription update", zap.String("username", username), zap.String("email", email))
+						triggerFallback = true
 					} else {
-						logger.Log.Info("Webhook failed. Recurring subscription updated",
-							zap.String("username", username),
-							zap.String("email", email),
-							zap.Int64("expires", currentPeriodEnd))
+						logger.Log.Error("Error updating timestamp", zap.Error(err), zap.String("username", username), zap.String("email", email))
+						triggerFallback = true
 					}
+				} else {
+					logger.Log.Info("Webhook failed. Recurring subscription updated",
+						zap.String("username", username),
+						zap.String("email", email),
+						zap.Int64("expires", currentPeriodEnd))
 				}
 			}
 
@@ -317,36 +272,15 @@ func Refresh(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider.
 	if triggerFallback {
 		logger.Log.Error("Triggering SQL fallback authentication. Stripe API failed", zap.String("username", username), zap.String("email", email))
 
-		getUserInput := &dynamodb.GetItemInput{
-			TableName: aws.String("nodofinance_table"),
-			Key: map[string]dynamoTypes.AttributeValue{
-				"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-				"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: "METADATA"},
-			},
-			ProjectionExpression: aws.String("expires_date"),
-		}
-
-		result, err := d.GetItem(ctx, getUserInput)
-		if err != nil {
-			logger.Log.Error("Error querying DynamoDB during fallback", zap.Error(err), zap.String("username", username), zap.String("email", email))
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		if result.Item == nil {
+		user, err := st.GetMetadata(ctx, username)
+		if errors.Is(err, store.ErrNotFound) {
 			logger.Log.Error("User not found during fallback", zap.String("username", username), zap.String("email", email))
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		// Unmarshal user data
-		var user struct {
-			ExpiresDate *int64 `dynamodbav:"expires_date,omitempty"`
-		}
-
-		err = attributevalue.UnmarshalMap(result.Item, &user)
 		if err != nil {
-			logger.Log.Error("Failed to unmarshal user data during fallback", zap.Error(err))
+			logger.Log.Error("Error querying DynamoDB during fallback", zap.Error(err), zap.String("username", username), zap.String("email", email))
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
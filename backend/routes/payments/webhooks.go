@@ -3,18 +3,14 @@ package payments
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
 
 	"nodofinance/routes/auth"
+	"nodofinance/store"
 	"nodofinance/utils/logger"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/stripe/stripe-go/v81"
 	"github.com/stripe/stripe-go/v81/webhook"
 	"go.uber.org/zap"
@@ -22,7 +18,7 @@ import (
 
 const MaxBodyBytes = int64(65536)
 
-func Webhooks(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider.Client, d *dynamodb.Client) {
+func Webhooks(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider.Client, st store.Store) {
 	ctx := r.Context()
 
 	logger.Log.Info("Stripe Webhook", zap.String("path", r.URL.Path))
@@ -88,40 +84,20 @@ func Webhooks(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider
 			return
 		}
 
-		getUserInput := &dynamodb.GetItemInput{
-			TableName: aws.String("nodofinance_table"),
-			Key: map[string]dynamoTypes.AttributeValue{
-				"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-				"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: "METADATA"},
-			},
-			ProjectionExpression: aws.String("stripe_id"),
-		}
-
-		result, err := d.GetItem(ctx, getUserInput)
-		if err != nil {
-			logger.Log.Error("Error querying DynamoDB in webhook", zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		if result.Item == nil {
+		user, err := st.GetMetadata(ctx, username)
+		if errors.Is(err, store.ErrNotFound) {
 			// *
 			// **
 			// ***
 			// ****
 			// ***** new subscription
-			putUserInput := &dynamodb.PutItemInput{
-				TableName: aws.String("nodofinance_table"),
-				Item: map[string]dynamoTypes.AttributeValue{
-					"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-					"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: "METADATA"},
-					"stripe_id":    &dynamoTypes.AttributeValueMemberS{Value: customerId},
-					"expires_date": &dynamoTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", periodEnd)},
-					"ctokens":      &dynamoTypes.AttributeValueMemberN{Value: "0"},
-				},
-			}
-
-			_, err := d.PutItem(ctx, putUserInput)
+			ctokens := int64(0)
+			err := st.PutMetadata(ctx, store.Metadata{
+				Username:    username,
+				StripeID:    customerId,
+				ExpiresDate: &periodEnd,
+				CTokens:     &ctokens,
+			})
 			if err != nil {
 				logger.Log.Error("Error inserting new user into DynamoDB", zap.Error(err))
 				w.WriteHeader(http.StatusInternalServerError)
@@ -131,25 +107,16 @@ func Webhooks(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider
 			logger.Log.Info("New user subscription created",
 				zap.String("email", customerEmail),
 				zap.Int64("Expires At", periodEnd))
+		} else if err != nil {
+			logger.Log.Error("Error querying DynamoDB in webhook", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		} else {
 			// *
 			// **
 			// ***
 			// ****
 			// ***** renewed subscription
-
-			// Unmarshal existing user data
-			var user struct {
-				StripeID string `dynamodbav:"stripe_id,omitempty"`
-			}
-
-			err = attributevalue.UnmarshalMap(result.Item, &user)
-			if err != nil {
-				logger.Log.Error("Failed to unmarshal user data", zap.Error(err))
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-
 			if user.StripeID == "" {
 				logger.Log.Warn("Stripe ID is not valid", zap.String("username", username))
 				w.WriteHeader(http.StatusUnauthorized)
@@ -162,26 +129,10 @@ func Webhooks(w http.ResponseWriter, r *http.Request, c *cognitoidentityprovider
 				return
 			}
 
-			updateUserInput := &dynamodb.UpdateItemInput{
-				TableName: aws.String("nodofinance_table"),
-				Key: map[string]dynamoTypes.AttributeValue{
-					"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
-					"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: "METADATA"},
-				},
-				UpdateExpression: aws.String("SET expires_date = :expires, ctokens = :ctokens"),
-				ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
-					":expires":           &dynamoTypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", periodEnd)},
-					":ctokens":           &dynamoTypes.AttributeValueMemberN{Value: "0"},
-					":current_stripe_id": &dynamoTypes.AttributeValueMemberS{Value: customerId},
-				},
-				ConditionExpression: aws.String("stripe_id = :current_stripe_id"), // More specific than attribute_exists
-			}
-
-			_, err := d.UpdateItem(ctx, updateUserInput)
+			err := st.RenewSubscription(ctx, username, customerId, periodEnd)
 			if err != nil {
 				// Check if it's a condition check failure (equivalent to rowsAffected == 0)
-				var conditionalCheckFailedErr *dynamoTypes.ConditionalCheckFailedException
-				if errors.As(err, &conditionalCheckFailedErr) {
+				if errors.Is(err, store.ErrConditionFailed) {
 					logger.Log.Error("No user found for subscription update", zap.String("username", username), zap.String("email", customerEmail))
 					w.WriteHeader(http.StatusNotFound)
 					return
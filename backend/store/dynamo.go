@@ -0,0 +1,1104 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var _ Store = (*Dynamo)(nil)
+
+type Dynamo struct {
+	d *dynamodb.Client
+}
+
+func NewDynamo(d *dynamodb.Client) *Dynamo {
+	return &Dynamo{d: d}
+}
+
+func itemKey(username, sortKey string) map[string]dynamoTypes.AttributeValue {
+	return map[string]dynamoTypes.AttributeValue{
+		"username":     &dynamoTypes.AttributeValueMemberS{Value: username},
+		"composite_sk": &dynamoTypes.AttributeValueMemberS{Value: sortKey},
+	}
+}
+
+func getSortKey(item map[string]dynamoTypes.AttributeValue) string {
+	if attr, exists := item["composite_sk"]; exists {
+		if s, ok := attr.(*dynamoTypes.AttributeValueMemberS); ok {
+			return s.Value
+		}
+	}
+	return ""
+}
+
+func (s *Dynamo) queryPrefix(ctx context.Context, username, prefix string, selectCount bool) (*dynamodb.QueryOutput, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(TableName),
+		KeyConditionExpression: aws.String("username = :username AND begins_with(composite_sk, :sk_prefix)"),
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":username":  &dynamoTypes.AttributeValueMemberS{Value: username},
+			":sk_prefix": &dynamoTypes.AttributeValueMemberS{Value: prefix},
+		},
+	}
+	if selectCount {
+		input.Select = dynamoTypes.SelectCount
+	}
+
+	// Pages of 1MB, added up in one output
+	output := &dynamodb.QueryOutput{}
+	for {
+		result, err := s.d.Query(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		output.Items = append(output.Items, result.Items...)
+		output.Count += result.Count
+		output.ScannedCount += result.ScannedCount
+
+		if len(result.LastEvaluatedKey) == 0 {
+			return output, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// *
+// **
+// ***
+// ****
+// ***** METADATA
+func (s *Dynamo) GetMetadata(ctx context.Context, username string) (Metadata, error) {
+	result, err := s.d.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName),
+		Key:       itemKey(username, "METADATA"),
+	})
+	if err != nil {
+		return Metadata{}, fmt.Errorf("getting metadata: %w", err)
+	}
+
+	if result.Item == nil {
+		return Metadata{}, ErrNotFound
+	}
+
+	var m Metadata
+	if err := attributevalue.UnmarshalMap(result.Item, &m); err != nil {
+		return Metadata{}, fmt.Errorf("unmarshaling metadata: %w", err)
+	}
+	m.Username = username
+
+	return m, nil
+}
+
+func (s *Dynamo) PutMetadata(ctx context.Context, m Metadata) error {
+	item, err := attributevalue.MarshalMap(m)
+	if err != nil {
+		return fmt.Errorf("marshaling metadata: %w", err)
+	}
+	item["composite_sk"] = &dynamoTypes.AttributeValueMemberS{Value: "METADATA"}
+
+	_, err = s.d.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(TableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("putting metadata: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Dynamo) RenewSubscription(ctx context.Context, username, stripeID string, expiresDate int64) error {
+	_, err := s.d.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(TableName),
+		Key:              itemKey(username, "METADATA"),
+		UpdateExpression: aws.String("SET expires_date = :expires, ctokens = :ctokens"),
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":expires":           &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(expiresDate, 10)},
+			":ctokens":           &dynamoTypes.AttributeValueMemberN{Value: "0"},
+			":current_stripe_id": &dynamoTypes.AttributeValueMemberS{Value: stripeID},
+		},
+		ConditionExpression: aws.String("stripe_id = :current_stripe_id"),
+	})
+	if err != nil {
+		var conditionCheckFailed *dynamoTypes.ConditionalCheckFailedException
+		if errors.As(err, &conditionCheckFailed) {
+			return ErrConditionFailed
+		}
+		return fmt.Errorf("renewing subscription: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Dynamo) AddTokens(ctx context.Context, username string, tokens int64) error {
+	_, err := s.d.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(TableName),
+		Key:              itemKey(username, "METADATA"),
+		UpdateExpression: aws.String("ADD ctokens :tokens"),
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":tokens": &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(tokens, 10)},
+		},
+		ConditionExpression: aws.String("attribute_exists(username) AND attribute_exists(composite_sk)"),
+	})
+	if err != nil {
+		var conditionCheckFailed *dynamoTypes.ConditionalCheckFailedException
+		if errors.As(err, &conditionCheckFailed) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("adding tokens: %w", err)
+	}
+
+	return nil
+}
+
+// *
+// **
+// ***
+// ****
+// ***** TICKER#
+func (s *Dynamo) GetTicker(ctx context.Context, username, ticker string) (Ticker, error) {
+	result, err := s.d.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName),
+		Key:       itemKey(username, TickerSortKey(ticker)),
+	})
+	if err != nil {
+		return Ticker{}, fmt.Errorf("getting ticker: %w", err)
+	}
+
+	if len(result.Item) == 0 {
+		return Ticker{}, ErrNotFound
+	}
+
+	var t Ticker
+	if err := attributevalue.UnmarshalMap(result.Item, &t); err != nil {
+		return Ticker{}, fmt.Errorf("unmarshaling ticker: %w", err)
+	}
+	t.Ticker = ticker
+
+	return t, nil
+}
+
+func (s *Dynamo) ListTickers(ctx context.Context, username string) ([]Ticker, error) {
+	result, err := s.queryPrefix(ctx, username, "TICKER#", false)
+	if err != nil {
+		return nil, fmt.Errorf("querying tickers: %w", err)
+	}
+
+	tickers := make([]Ticker, 0, len(result.Items))
+	for _, item := range result.Items {
+		var t Ticker
+		if err := attributevalue.UnmarshalMap(item, &t); err != nil {
+			return nil, fmt.Errorf("unmarshaling ticker: %w", err)
+		}
+		t.Ticker = strings.TrimPrefix(getSortKey(item), "TICKER#")
+		tickers = append(tickers, t)
+	}
+
+	return tickers, nil
+}
+
+func (s *Dynamo) CountTickers(ctx context.Context, username string) (int, error) {
+	result, err := s.queryPrefix(ctx, username, "TICKER#", true)
+	if err != nil {
+		return 0, fmt.Errorf("checking tickers count: %w", err)
+	}
+
+	return int(result.Count), nil
+}
+
+// *
+// **
+// ***
+// ****
+// ***** FINANCE#
+func financeFromItem(item map[string]dynamoTypes.AttributeValue) (Finance, error) {
+	var f Finance
+	if err := attributevalue.UnmarshalMap(item, &f); err != nil {
+		return Finance{}, fmt.Errorf("unmarshaling finance: %w", err)
+	}
+
+	ticker, year, period, err := ParseFinanceSortKey(getSortKey(item))
+	if err != nil {
+		return Finance{}, err
+	}
+	f.Ticker, f.Year, f.Period = ticker, year, period
+
+	return f, nil
+}
+
+func financeToItem(username string, f Finance) (map[string]dynamoTypes.AttributeValue, error) {
+	sortKey, err := FinanceSortKey(f.Ticker, f.Year, f.Period)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := attributevalue.MarshalMap(f)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling finance: %w", err)
+	}
+	for key, value := range itemKey(username, sortKey) {
+		item[key] = value
+	}
+
+	return item, nil
+}
+
+func (s *Dynamo) GetFinancePeriod(ctx context.Context, username, ticker string, year int, period string) (Finance, error) {
+	sortKey, err := FinanceSortKey(ticker, year, period)
+	if err != nil {
+		return Finance{}, err
+	}
+
+	result, err := s.d.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName),
+		Key:       itemKey(username, sortKey),
+	})
+	if err != nil {
+		return Finance{}, fmt.Errorf("getting finance period: %w", err)
+	}
+
+	if len(result.Item) == 0 {
+		return Finance{}, ErrNotFound
+	}
+
+	return financeFromItem(result.Item)
+}
+
+func (s *Dynamo) ListFinancePeriods(ctx context.Context, username, ticker string) ([]Finance, error) {
+	result, err := s.queryPrefix(ctx, username, FinancePrefix(ticker), false)
+	if err != nil {
+		return nil, fmt.Errorf("database query failed: %w", err)
+	}
+
+	finances := make([]Finance, 0, len(result.Items))
+	for _, item := range result.Items {
+		f, err := financeFromItem(item)
+		if err != nil {
+			return nil, err
+		}
+		finances = append(finances, f)
+	}
+
+	return finances, nil
+}
+
+func (s *Dynamo) PageFinancePeriods(ctx context.Context, username, ticker, cursor string, limit int) ([]Finance, string, error) {
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String(TableName),
+		KeyConditionExpression: aws.String("username = :username AND begins_with(composite_sk, :sk_prefix)"),
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":username":  &dynamoTypes.AttributeValueMemberS{Value: username},
+			":sk_prefix": &dynamoTypes.AttributeValueMemberS{Value: FinancePrefix(ticker)},
+		},
+		Limit: aws.Int32(int32(limit)),
+	}
+
+	if cursor != "" {
+		cursorSK, err := cursorSortKey(cursor, username, FinancePrefix(ticker))
+		if err != nil {
+			return nil, "", err
+		}
+		queryInput.ExclusiveStartKey = itemKey(username, cursorSK)
+	}
+
+	result, err := s.d.Query(ctx, queryInput)
+	if err != nil {
+		return nil, "", fmt.Errorf("querying financial data: %w", err)
+	}
+
+	finances := make([]Finance, 0, len(result.Items))
+	for _, item := range result.Items {
+		f, err := financeFromItem(item)
+		if err != nil {
+			return nil, "", err
+		}
+		finances = append(finances, f)
+	}
+
+	nextCursor := ""
+	if result.LastEvaluatedKey != nil {
+		nextCursor = encodeCursor(username, getSortKey(result.LastEvaluatedKey))
+		if nextCursor == "" {
+			return nil, "", fmt.Errorf("failed to encode next cursor")
+		}
+	}
+
+	return finances, nextCursor, nil
+}
+
+func (s *Dynamo) CountFinancePeriods(ctx context.Context, username, ticker string) (int, error) {
+	result, err := s.queryPrefix(ctx, username, FinancePrefix(ticker), true)
+	if err != nil {
+		return 0, fmt.Errorf("checking periods count: %w", err)
+	}
+
+	return int(result.Count), nil
+}
+
+func (s *Dynamo) PutFinancePeriod(ctx context.Context, username string, f Finance, currency string, tokens int64) error {
+	return s.PutFinancePeriods(ctx, username, f.Ticker, []Finance{f}, currency, tokens)
+}
+
+func (s *Dynamo) PutFinancePeriods(ctx context.Context, username, ticker string, periods []Finance, currency string, tokens int64) error {
+	if len(periods) == 0 || len(periods) > MaxTransactionPeriods {
+		return fmt.Errorf("invalid number of periods: %d", len(periods))
+	}
+
+	transactItems, err := financeTransactItems(username, ticker, periods, currency, tokens)
+	if err != nil {
+		return err
+	}
+
+	return s.transactFinance(ctx, transactItems, len(periods))
+}
+
+// financeTransactItems puts every period and TICKER#, and bills tokens to an existing user.
+// The comparatives, every period after the first, are not put over a period edited by the user.
+func financeTransactItems(username, ticker string, periods []Finance, currency string, tokens int64) ([]dynamoTypes.TransactWriteItem, error) {
+	transactItems := make([]dynamoTypes.TransactWriteItem, 0, len(periods)+3)
+
+	// 1. FINANCE#{ticker}#{reverse_year}#{period_order}
+	for i, f := range periods {
+		if f.Ticker != ticker {
+			return nil, fmt.Errorf("period of %s in a batch of %s", f.Ticker, ticker)
+		}
+
+		financeItem, err := financeToItem(username, f)
+		if err != nil {
+			return nil, err
+		}
+
+		put := &dynamoTypes.Put{
+			TableName: aws.String(TableName),
+			Item:      financeItem,
+		}
+		if i > 0 {
+			put.ConditionExpression = aws.String("attribute_not_exists(edited) OR edited = :false")
+			put.ExpressionAttributeValues = map[string]dynamoTypes.AttributeValue{
+				":false": &dynamoTypes.AttributeValueMemberBOOL{Value: false},
+			}
+		}
+		transactItems = append(transactItems, dynamoTypes.TransactWriteItem{Put: put})
+	}
+
+	tickerItem := itemKey(username, TickerSortKey(ticker))
+	tickerItem["last_update"] = &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)}
+	tickerItem["currency"] = &dynamoTypes.AttributeValueMemberS{Value: currency}
+	// analysis starts empty, can be updated later
+
+	transactItems = append(transactItems,
+		// 2. TICKER#{ticker}
+		dynamoTypes.TransactWriteItem{
+			Put: &dynamoTypes.Put{
+				TableName: aws.String(TableName),
+				Item:      tickerItem,
+			},
+		},
+		// 3. User Token Update
+		tokensTransactItem(username, tokens),
+	)
+
+	return transactItems, nil
+}
+
+func tokensTransactItem(username string, tokens int64) dynamoTypes.TransactWriteItem {
+	return dynamoTypes.TransactWriteItem{
+		Update: &dynamoTypes.Update{
+			TableName:        aws.String(TableName),
+			Key:              itemKey(username, "METADATA"),
+			UpdateExpression: aws.String("ADD ctokens :tokens"),
+			ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+				":tokens": &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(tokens, 10)},
+			},
+			ConditionExpression: aws.String("attribute_exists(username) AND attribute_exists(composite_sk)"),
+		},
+	}
+}
+
+// transact runs the items in one transaction, a failed condition is ErrNotFound
+func (s *Dynamo) transact(ctx context.Context, transactItems []dynamoTypes.TransactWriteItem) error {
+	return s.transactFinance(ctx, transactItems, 0)
+}
+
+// transactFinance is transact for items starting with periods of financeTransactItems,
+// a failed condition of a comparative is ErrConditionFailed
+func (s *Dynamo) transactFinance(ctx context.Context, transactItems []dynamoTypes.TransactWriteItem, periods int) error {
+	_, err := s.d.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: transactItems,
+	})
+	if err != nil {
+		var transactionCanceled *dynamoTypes.TransactionCanceledException
+		if errors.As(err, &transactionCanceled) && conditionFailed(transactionCanceled.CancellationReasons) {
+			for i, reason := range transactionCanceled.CancellationReasons {
+				if i > 0 && i < periods && aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+					return ErrConditionFailed
+				}
+			}
+			return ErrNotFound
+		}
+		return fmt.Errorf("transaction failed: %w", err)
+	}
+
+	return nil
+}
+
+// conditionFailed tells if a transaction was cancelled only by failed conditions, not by conflicts,
+// throttling or validation
+func conditionFailed(reasons []dynamoTypes.CancellationReason) bool {
+	failed := false
+	for _, reason := range reasons {
+		switch aws.ToString(reason.Code) {
+		case "None", "":
+		case "ConditionalCheckFailed":
+			failed = true
+		default:
+			return false
+		}
+	}
+	return failed
+}
+
+func (s *Dynamo) UpdateFinancePeriod(ctx context.Context, username string, f Finance) error {
+	sortKey, err := FinanceSortKey(f.Ticker, f.Year, f.Period)
+	if err != nil {
+		return err
+	}
+
+	values, err := attributevalue.MarshalMap(f)
+	if err != nil {
+		return fmt.Errorf("marshaling finance: %w", err)
+	}
+
+	var setExpressions []string
+	var removeExpressions []string
+	expressionAttributeValues := make(map[string]dynamoTypes.AttributeValue)
+
+	for _, field := range FinanceFields {
+		if value, exists := values[field]; exists {
+			placeholder := fmt.Sprintf(":val_%s", field)
+			setExpressions = append(setExpressions, fmt.Sprintf("%s = %s", field, placeholder))
+			expressionAttributeValues[placeholder] = value
+		} else {
+			// Remove attribute if nil
+			removeExpressions = append(removeExpressions, field)
+		}
+	}
+
+	setExpressions = append(setExpressions, "edited = :edited")
+	expressionAttributeValues[":edited"] = &dynamoTypes.AttributeValueMemberBOOL{Value: true}
+	removeExpressions = append(removeExpressions, "low_confidence", "warnings")
+
+	// Build proper DynamoDB UpdateExpression syntax
+	var updateParts []string
+	if len(setExpressions) > 0 {
+		updateParts = append(updateParts, "SET "+strings.Join(setExpressions, ", "))
+	}
+	if len(removeExpressions) > 0 {
+		updateParts = append(updateParts, "REMOVE "+strings.Join(removeExpressions, ", "))
+	}
+
+	updateInput := &dynamodb.UpdateItemInput{
+		TableName:           aws.String(TableName),
+		Key:                 itemKey(username, sortKey),
+		UpdateExpression:    aws.String(strings.Join(updateParts, " ")),
+		ConditionExpression: aws.String("attribute_exists(composite_sk) AND attribute_exists(username)"),
+		ReturnValues:        dynamoTypes.ReturnValueNone,
+	}
+
+	// Only add ExpressionAttributeValues if we have any
+	if len(expressionAttributeValues) > 0 {
+		updateInput.ExpressionAttributeValues = expressionAttributeValues
+	}
+
+	_, err = s.d.UpdateItem(ctx, updateInput)
+	if err != nil {
+		var conditionCheckFailed *dynamoTypes.ConditionalCheckFailedException
+		if errors.As(err, &conditionCheckFailed) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("updating finance period: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Dynamo) DeleteFinancePeriodAtomic(ctx context.Context, username, ticker string, year int, period string) error {
+	financeSK, err := FinanceSortKey(ticker, year, period)
+	if err != nil {
+		return err
+	}
+
+	// Step 1: Check how many finance records exist for this ticker
+	result, err := s.d.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(TableName),
+		KeyConditionExpression: aws.String("username = :username AND begins_with(composite_sk, :sk_prefix)"),
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":username":  &dynamoTypes.AttributeValueMemberS{Value: username},
+			":sk_prefix": &dynamoTypes.AttributeValueMemberS{Value: FinancePrefix(ticker)},
+		},
+		Select: dynamoTypes.SelectCount,
+		Limit:  aws.Int32(2), // We only need to know if count is 1 or >1
+	})
+	if err != nil {
+		return fmt.Errorf("checking finance records count: %w", err)
+	}
+
+	// Step 2: Build transaction based on count
+	transactItems := []dynamoTypes.TransactWriteItem{
+		// Always delete the finance record
+		{
+			Delete: &dynamoTypes.Delete{
+				TableName:           aws.String(TableName),
+				Key:                 itemKey(username, financeSK),
+				ConditionExpression: aws.String("attribute_exists(username)"),
+			},
+		},
+	}
+
+	// If this is the last finance record (count == 1), also delete ticker
+	if result.Count == 1 {
+		transactItems = append(transactItems, dynamoTypes.TransactWriteItem{
+			Delete: &dynamoTypes.Delete{
+				TableName: aws.String(TableName),
+				Key:       itemKey(username, TickerSortKey(ticker)),
+				// No condition check for ticker - if it doesn't exist, no problem
+			},
+		})
+	}
+
+	// Step 3: Execute transaction
+	_, err = s.d.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: transactItems,
+	})
+	if err != nil {
+		var conditionalCheckErr *dynamoTypes.TransactionCanceledException
+		if errors.As(err, &conditionalCheckErr) {
+			// Check if the failure was due to finance record not existing
+			for _, reason := range conditionalCheckErr.CancellationReasons {
+				if reason.Code != nil && *reason.Code == "ConditionalCheckFailed" {
+					return ErrNotFound
+				}
+			}
+		}
+		return fmt.Errorf("transaction failed: %w", err)
+	}
+
+	return nil
+}
+
+// *
+// **
+// ***
+// ****
+// ***** ANALYSIS#
+func analysisFromItem(item map[string]dynamoTypes.AttributeValue) (Analysis, error) {
+	var a Analysis
+	if err := attributevalue.UnmarshalMap(item, &a); err != nil {
+		return Analysis{}, fmt.Errorf("unmarshaling analysis: %w", err)
+	}
+
+	// ANALYSIS#{ticker}#{id}
+	parts := strings.Split(getSortKey(item), "#")
+	if len(parts) != 3 {
+		return Analysis{}, fmt.Errorf("invalid analysis sort key format")
+	}
+	a.Ticker, a.ID = parts[1], parts[2]
+
+	return a, nil
+}
+
+func (s *Dynamo) PutAnalysis(ctx context.Context, username string, a Analysis) (Analysis, error) {
+	now := time.Now()
+	a.ID = AnalysisID(now.UnixNano())
+	a.CreatedAt = now.Unix()
+
+	item, err := attributevalue.MarshalMap(a)
+	if err != nil {
+		return Analysis{}, fmt.Errorf("marshaling analysis: %w", err)
+	}
+	for key, value := range itemKey(username, AnalysisSortKey(a.Ticker, a.ID)) {
+		item[key] = value
+	}
+
+	updateExpression := "SET analysis = :analysis REMOVE insights"
+	values := map[string]dynamoTypes.AttributeValue{
+		":analysis": &dynamoTypes.AttributeValueMemberS{Value: a.Content},
+	}
+	if insights, exists := item["insights"]; exists {
+		updateExpression = "SET analysis = :analysis, insights = :insights"
+		values[":insights"] = insights
+	}
+
+	err = s.transact(ctx, []dynamoTypes.TransactWriteItem{
+		{
+			Update: &dynamoTypes.Update{
+				TableName:                 aws.String(TableName),
+				Key:                       itemKey(username, TickerSortKey(a.Ticker)),
+				UpdateExpression:          aws.String(updateExpression),
+				ExpressionAttributeValues: values,
+				ConditionExpression:       aws.String("attribute_exists(username) AND attribute_exists(composite_sk)"),
+			},
+		},
+		{
+			Put: &dynamoTypes.Put{
+				TableName: aws.String(TableName),
+				Item:      item,
+			},
+		},
+		tokensTransactItem(username, a.Tokens),
+	})
+	if err != nil {
+		return Analysis{}, err
+	}
+
+	return a, nil
+}
+
+func (s *Dynamo) GetAnalysis(ctx context.Context, username, ticker, id string) (Analysis, error) {
+	result, err := s.d.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName),
+		Key:       itemKey(username, AnalysisSortKey(ticker, id)),
+	})
+	if err != nil {
+		return Analysis{}, fmt.Errorf("getting analysis: %w", err)
+	}
+
+	if len(result.Item) == 0 {
+		return Analysis{}, ErrNotFound
+	}
+
+	return analysisFromItem(result.Item)
+}
+
+func (s *Dynamo) PageAnalyses(ctx context.Context, username, ticker, cursor string, limit int) ([]Analysis, string, error) {
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String(TableName),
+		KeyConditionExpression: aws.String("username = :username AND begins_with(composite_sk, :sk_prefix)"),
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":username":  &dynamoTypes.AttributeValueMemberS{Value: username},
+			":sk_prefix": &dynamoTypes.AttributeValueMemberS{Value: AnalysisPrefix(ticker)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(int32(limit)),
+	}
+
+	if cursor != "" {
+		cursorSK, err := cursorSortKey(cursor, username, AnalysisPrefix(ticker))
+		if err != nil {
+			return nil, "", err
+		}
+		queryInput.ExclusiveStartKey = itemKey(username, cursorSK)
+	}
+
+	result, err := s.d.Query(ctx, queryInput)
+	if err != nil {
+		return nil, "", fmt.Errorf("querying analyses: %w", err)
+	}
+
+	analyses := make([]Analysis, 0, len(result.Items))
+	for _, item := range result.Items {
+		a, err := analysisFromItem(item)
+		if err != nil {
+			return nil, "", err
+		}
+		analyses = append(analyses, a)
+	}
+
+	nextCursor := ""
+	if result.LastEvaluatedKey != nil {
+		nextCursor = encodeCursor(username, getSortKey(result.LastEvaluatedKey))
+		if nextCursor == "" {
+			return nil, "", fmt.Errorf("failed to encode next cursor")
+		}
+	}
+
+	return analyses, nextCursor, nil
+}
+
+// *
+// **
+// ***
+// ****
+// ***** CHAT#
+func (s *Dynamo) PutChatTurn(ctx context.Context, username, ticker string, question, answer ChatMessage) error {
+	now := time.Now()
+
+	transactItems := make([]dynamoTypes.TransactWriteItem, 0, 3)
+	for i, m := range []ChatMessage{question, answer} {
+		m.CreatedAt = now.Unix()
+		item, err := attributevalue.MarshalMap(m)
+		if err != nil {
+			return fmt.Errorf("marshaling chat message: %w", err)
+		}
+		// the answer sorts right after its question
+		for key, value := range itemKey(username, ChatSortKey(ticker, AnalysisID(now.UnixNano()+int64(i)))) {
+			item[key] = value
+		}
+
+		transactItems = append(transactItems, dynamoTypes.TransactWriteItem{
+			Put: &dynamoTypes.Put{
+				TableName: aws.String(TableName),
+				Item:      item,
+			},
+		})
+	}
+	transactItems = append(transactItems, tokensTransactItem(username, answer.Tokens))
+
+	return s.transact(ctx, transactItems)
+}
+
+func (s *Dynamo) ListChat(ctx context.Context, username, ticker string) ([]ChatMessage, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(TableName),
+		KeyConditionExpression: aws.String("username = :username AND begins_with(composite_sk, :sk_prefix)"),
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":username":  &dynamoTypes.AttributeValueMemberS{Value: username},
+			":sk_prefix": &dynamoTypes.AttributeValueMemberS{Value: ChatPrefix(ticker)},
+		},
+	}
+
+	messages := []ChatMessage{}
+	for {
+		result, err := s.d.Query(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("querying chat: %w", err)
+		}
+
+		for _, item := range result.Items {
+			var m ChatMessage
+			if err := attributevalue.UnmarshalMap(item, &m); err != nil {
+				return nil, fmt.Errorf("unmarshaling chat message: %w", err)
+			}
+			m.Ticker, m.ID = ticker, strings.TrimPrefix(getSortKey(item), ChatPrefix(ticker))
+			messages = append(messages, m)
+		}
+
+		if len(result.LastEvaluatedKey) == 0 {
+			return messages, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+func (s *Dynamo) DeleteChat(ctx context.Context, username, ticker string) (int, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(TableName),
+		KeyConditionExpression: aws.String("username = :username AND begins_with(composite_sk, :sk_prefix)"),
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":username":  &dynamoTypes.AttributeValueMemberS{Value: username},
+			":sk_prefix": &dynamoTypes.AttributeValueMemberS{Value: ChatPrefix(ticker)},
+		},
+		ProjectionExpression: aws.String("composite_sk"),
+	}
+
+	deleted := 0
+	for {
+		result, err := s.d.Query(ctx, input)
+		if err != nil {
+			return deleted, fmt.Errorf("querying chat: %w", err)
+		}
+
+		// BatchWriteItem takes up to 25 requests
+		for start := 0; start < len(result.Items); start += 25 {
+			end := min(start+25, len(result.Items))
+
+			requests := make([]dynamoTypes.WriteRequest, 0, end-start)
+			for _, item := range result.Items[start:end] {
+				requests = append(requests, dynamoTypes.WriteRequest{
+					DeleteRequest: &dynamoTypes.DeleteRequest{Key: itemKey(username, getSortKey(item))},
+				})
+			}
+
+			if err := s.batchWrite(ctx, requests); err != nil {
+				return deleted, fmt.Errorf("deleting chat: %w", err)
+			}
+			deleted += len(requests)
+		}
+
+		if len(result.LastEvaluatedKey) == 0 {
+			return deleted, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// *
+// **
+// ***
+// ****
+// ***** DRAFT#
+func (s *Dynamo) GetDraft(ctx context.Context, username, ticker, period string) (Draft, error) {
+	result, err := s.d.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName),
+		Key:       itemKey(username, DraftSortKey(ticker, period)),
+	})
+	if err != nil {
+		return Draft{}, fmt.Errorf("getting draft: %w", err)
+	}
+
+	if len(result.Item) == 0 {
+		return Draft{}, ErrNotFound
+	}
+
+	var d Draft
+	if err := attributevalue.UnmarshalMap(result.Item, &d); err != nil {
+		return Draft{}, fmt.Errorf("unmarshaling draft: %w", err)
+	}
+
+	// expired items linger until DynamoDB deletes them
+	if d.ExpiresAt <= time.Now().Unix() {
+		return Draft{}, ErrNotFound
+	}
+
+	d.Ticker, d.Period = ticker, period
+	for i := range d.Periods {
+		p := &d.Periods[i]
+		p.Finance.Ticker, p.Finance.Year, p.Finance.Period = ticker, p.Year, p.Period
+	}
+
+	return d, nil
+}
+
+func (s *Dynamo) PutDraft(ctx context.Context, username string, d Draft, tokens int64) error {
+	item, err := attributevalue.MarshalMap(d)
+	if err != nil {
+		return fmt.Errorf("marshaling draft: %w", err)
+	}
+	for key, value := range itemKey(username, DraftSortKey(d.Ticker, d.Period)) {
+		item[key] = value
+	}
+
+	return s.transact(ctx, []dynamoTypes.TransactWriteItem{
+		{
+			Put: &dynamoTypes.Put{
+				TableName: aws.String(TableName),
+				Item:      item,
+			},
+		},
+		tokensTransactItem(username, tokens),
+	})
+}
+
+func (s *Dynamo) PromoteDraft(ctx context.Context, username string, d Draft, periods []Finance) error {
+	// the draft delete takes one of the transaction items
+	if len(periods) == 0 || len(periods) > MaxTransactionPeriods-1 {
+		return fmt.Errorf("invalid number of periods: %d", len(periods))
+	}
+
+	transactItems, err := financeTransactItems(username, d.Ticker, periods, d.Currency, 0)
+	if err != nil {
+		return err
+	}
+
+	transactItems = append(transactItems, dynamoTypes.TransactWriteItem{
+		Delete: &dynamoTypes.Delete{
+			TableName:           aws.String(TableName),
+			Key:                 itemKey(username, DraftSortKey(d.Ticker, d.Period)),
+			ConditionExpression: aws.String("attribute_exists(composite_sk) AND expires_at > :now"),
+			ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+				":now": &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+			},
+		},
+	})
+
+	return s.transactFinance(ctx, transactItems, len(periods))
+}
+
+// *
+// **
+// ***
+// ****
+// ***** CORRECTION#
+func (s *Dynamo) PutCorrection(ctx context.Context, username string, c Correction) error {
+	item, err := attributevalue.MarshalMap(c)
+	if err != nil {
+		return fmt.Errorf("marshaling correction: %w", err)
+	}
+	for key, value := range itemKey(username, CorrectionSortKey(c.Language, c.Target, time.Now().UnixNano())) {
+		item[key] = value
+	}
+
+	_, err = s.d.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(TableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("putting correction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Dynamo) ListCorrections(ctx context.Context, username, language, target string, limit int) ([]Correction, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(TableName),
+		KeyConditionExpression: aws.String("username = :username AND begins_with(composite_sk, :sk_prefix)"),
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":username":  &dynamoTypes.AttributeValueMemberS{Value: username},
+			":sk_prefix": &dynamoTypes.AttributeValueMemberS{Value: CorrectionPrefix(language, target)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(int32(limit)),
+	}
+
+	// a page can end before limit at 1MB
+	corrections := make([]Correction, 0, limit)
+	for {
+		result, err := s.d.Query(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("querying corrections: %w", err)
+		}
+
+		for _, item := range result.Items {
+			var c Correction
+			if err := attributevalue.UnmarshalMap(item, &c); err != nil {
+				return nil, fmt.Errorf("unmarshaling correction: %w", err)
+			}
+			c.Language, c.Target = language, target
+			corrections = append(corrections, c)
+		}
+
+		if len(corrections) >= limit || len(result.LastEvaluatedKey) == 0 {
+			return corrections, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+		input.Limit = aws.Int32(int32(limit - len(corrections)))
+	}
+}
+
+// *
+// **
+// ***
+// ****
+// ***** EXTRACTIONS
+func (s *Dynamo) GetExtraction(ctx context.Context, hash string) (Extraction, error) {
+	result, err := s.d.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName),
+		Key:       itemKey(ExtractionsKey, ExtractionSortKey(hash)),
+	})
+	if err != nil {
+		return Extraction{}, fmt.Errorf("getting extraction: %w", err)
+	}
+
+	if len(result.Item) == 0 {
+		return Extraction{}, ErrNotFound
+	}
+
+	var e Extraction
+	if err := attributevalue.UnmarshalMap(result.Item, &e); err != nil {
+		return Extraction{}, fmt.Errorf("unmarshaling extraction: %w", err)
+	}
+
+	// expired items linger until DynamoDB deletes them
+	if e.ExpiresAt <= time.Now().Unix() {
+		return Extraction{}, ErrNotFound
+	}
+
+	e.Hash = hash
+	return e, nil
+}
+
+func (s *Dynamo) PutExtraction(ctx context.Context, e Extraction) error {
+	item, err := attributevalue.MarshalMap(e)
+	if err != nil {
+		return fmt.Errorf("marshaling extraction: %w", err)
+	}
+	for key, value := range itemKey(ExtractionsKey, ExtractionSortKey(e.Hash)) {
+		item[key] = value
+	}
+
+	_, err = s.d.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(TableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("putting extraction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Dynamo) PurgeExtractions(ctx context.Context, promptVersion string) (int, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(TableName),
+		KeyConditionExpression: aws.String("username = :key AND begins_with(composite_sk, :sk_prefix)"),
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":key":       &dynamoTypes.AttributeValueMemberS{Value: ExtractionsKey},
+			":sk_prefix": &dynamoTypes.AttributeValueMemberS{Value: "EXTRACTION#"},
+		},
+		ProjectionExpression: aws.String("composite_sk"),
+	}
+	if promptVersion != "" {
+		input.FilterExpression = aws.String("prompt_version = :version")
+		input.ExpressionAttributeValues[":version"] = &dynamoTypes.AttributeValueMemberS{Value: promptVersion}
+	}
+
+	deleted := 0
+	for {
+		result, err := s.d.Query(ctx, input)
+		if err != nil {
+			return deleted, fmt.Errorf("querying extractions: %w", err)
+		}
+
+		// BatchWriteItem takes up to 25 requests
+		for start := 0; start < len(result.Items); start += 25 {
+			end := min(start+25, len(result.Items))
+
+			requests := make([]dynamoTypes.WriteRequest, 0, end-start)
+			for _, item := range result.Items[start:end] {
+				requests = append(requests, dynamoTypes.WriteRequest{
+					DeleteRequest: &dynamoTypes.DeleteRequest{Key: itemKey(ExtractionsKey, getSortKey(item))},
+				})
+			}
+
+			if err := s.batchWrite(ctx, requests); err != nil {
+				return deleted, fmt.Errorf("deleting extractions: %w", err)
+			}
+			deleted += len(requests)
+		}
+
+		if len(result.LastEvaluatedKey) == 0 {
+			return deleted, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// batchWrite retries the unprocessed requests of a BatchWriteItem with a growing wait
+func (s *Dynamo) batchWrite(ctx context.Context, requests []dynamoTypes.WriteRequest) error {
+	wait := 100 * time.Millisecond
+
+	for attempt := 0; len(requests) > 0; attempt++ {
+		if attempt == 5 {
+			return fmt.Errorf("%d unprocessed requests", len(requests))
+		}
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+
+		result, err := s.d.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]dynamoTypes.WriteRequest{TableName: requests},
+		})
+		if err != nil {
+			return err
+		}
+		requests = result.UnprocessedItems[TableName]
+	}
+
+	return nil
+}
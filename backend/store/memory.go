@@ -0,0 +1,585 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ Store = (*Memory)(nil)
+
+// Memory mirrors the nodofinance_table semantics (keys, ordering, conditions and
+// transactions) in process, for handler tests and local demos without AWS.
+type Memory struct {
+	mu    sync.RWMutex
+	items map[string]map[string]any // username -> composite_sk -> item
+}
+
+func NewMemory() *Memory {
+	return &Memory{items: make(map[string]map[string]any)}
+}
+
+func (s *Memory) get(username, sortKey string) (any, bool) {
+	item, exists := s.items[username][sortKey]
+	return item, exists
+}
+
+func (s *Memory) put(username, sortKey string, item any) {
+	if s.items[username] == nil {
+		s.items[username] = make(map[string]any)
+	}
+	s.items[username][sortKey] = item
+}
+
+// scan returns the sort keys under prefix in ascending order, like a Query
+func (s *Memory) scan(username, prefix string) []string {
+	var keys []string
+	for sortKey := range s.items[username] {
+		if strings.HasPrefix(sortKey, prefix) {
+			keys = append(keys, sortKey)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// *
+// **
+// ***
+// ****
+// ***** METADATA
+func (s *Memory) GetMetadata(ctx context.Context, username string) (Metadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, exists := s.get(username, "METADATA")
+	if !exists {
+		return Metadata{}, ErrNotFound
+	}
+	return item.(Metadata), nil
+}
+
+func (s *Memory) PutMetadata(ctx context.Context, m Metadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.put(m.Username, "METADATA", m)
+	return nil
+}
+
+func (s *Memory) RenewSubscription(ctx context.Context, username, stripeID string, expiresDate int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, exists := s.get(username, "METADATA")
+	if !exists || item.(Metadata).StripeID != stripeID {
+		return ErrConditionFailed
+	}
+
+	m := item.(Metadata)
+	ctokens := int64(0)
+	m.ExpiresDate = &expiresDate
+	m.CTokens = &ctokens
+	s.put(username, "METADATA", m)
+	return nil
+}
+
+func (s *Memory) AddTokens(ctx context.Context, username string, tokens int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addTokens(username, tokens)
+}
+
+func (s *Memory) addTokens(username string, tokens int64) error {
+	item, exists := s.get(username, "METADATA")
+	if !exists {
+		return ErrNotFound
+	}
+
+	m := item.(Metadata)
+	ctokens := tokens
+	if m.CTokens != nil {
+		ctokens += *m.CTokens
+	}
+	m.CTokens = &ctokens
+	s.put(username, "METADATA", m)
+	return nil
+}
+
+// *
+// **
+// ***
+// ****
+// ***** TICKER#
+func (s *Memory) GetTicker(ctx context.Context, username, ticker string) (Ticker, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, exists := s.get(username, TickerSortKey(ticker))
+	if !exists {
+		return Ticker{}, ErrNotFound
+	}
+	return item.(Ticker), nil
+}
+
+func (s *Memory) ListTickers(ctx context.Context, username string) ([]Ticker, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := s.scan(username, "TICKER#")
+	tickers := make([]Ticker, 0, len(keys))
+	for _, sortKey := range keys {
+		tickers = append(tickers, s.items[username][sortKey].(Ticker))
+	}
+	return tickers, nil
+}
+
+func (s *Memory) CountTickers(ctx context.Context, username string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.scan(username, "TICKER#")), nil
+}
+
+// *
+// **
+// ***
+// ****
+// ***** FINANCE#
+func (s *Memory) GetFinancePeriod(ctx context.Context, username, ticker string, year int, period string) (Finance, error) {
+	sortKey, err := FinanceSortKey(ticker, year, period)
+	if err != nil {
+		return Finance{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, exists := s.get(username, sortKey)
+	if !exists {
+		return Finance{}, ErrNotFound
+	}
+	return item.(Finance), nil
+}
+
+func (s *Memory) ListFinancePeriods(ctx context.Context, username, ticker string) ([]Finance, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := s.scan(username, FinancePrefix(ticker))
+	finances := make([]Finance, 0, len(keys))
+	for _, sortKey := range keys {
+		finances = append(finances, s.items[username][sortKey].(Finance))
+	}
+	return finances, nil
+}
+
+func (s *Memory) PageFinancePeriods(ctx context.Context, username, ticker, cursor string, limit int) ([]Finance, string, error) {
+	startAfter := ""
+	if cursor != "" {
+		cursorSK, err := cursorSortKey(cursor, username, FinancePrefix(ticker))
+		if err != nil {
+			return nil, "", err
+		}
+		startAfter = cursorSK
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var finances []Finance
+	nextCursor := ""
+	for _, sortKey := range s.scan(username, FinancePrefix(ticker)) {
+		if sortKey <= startAfter {
+			continue
+		}
+		if len(finances) == limit {
+			nextCursor = encodeCursor(username, finances[len(finances)-1].sortKey())
+			break
+		}
+		finances = append(finances, s.items[username][sortKey].(Finance))
+	}
+
+	return finances, nextCursor, nil
+}
+
+func (f Finance) sortKey() string {
+	sortKey, _ := FinanceSortKey(f.Ticker, f.Year, f.Period)
+	return sortKey
+}
+
+func (s *Memory) CountFinancePeriods(ctx context.Context, username, ticker string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.scan(username, FinancePrefix(ticker))), nil
+}
+
+func (s *Memory) PutFinancePeriod(ctx context.Context, username string, f Finance, currency string, tokens int64) error {
+	return s.PutFinancePeriods(ctx, username, f.Ticker, []Finance{f}, currency, tokens)
+}
+
+func (s *Memory) PutFinancePeriods(ctx context.Context, username, ticker string, periods []Finance, currency string, tokens int64) error {
+	if len(periods) == 0 || len(periods) > MaxTransactionPeriods {
+		return fmt.Errorf("invalid number of periods: %d", len(periods))
+	}
+
+	sortKeys := make([]string, 0, len(periods))
+	for _, f := range periods {
+		if f.Ticker != ticker {
+			return fmt.Errorf("period of %s in a batch of %s", f.Ticker, ticker)
+		}
+
+		sortKey, err := FinanceSortKey(f.Ticker, f.Year, f.Period)
+		if err != nil {
+			return err
+		}
+		sortKeys = append(sortKeys, sortKey)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the token update is the transaction's condition, check it before any write
+	if _, exists := s.get(username, "METADATA"); !exists {
+		return ErrNotFound
+	}
+
+	if s.editedComparative(username, sortKeys) {
+		return ErrConditionFailed
+	}
+
+	for i, f := range periods {
+		s.put(username, sortKeys[i], f)
+	}
+	s.put(username, TickerSortKey(ticker), Ticker{
+		Ticker:     ticker,
+		LastUpdate: time.Now().Unix(),
+		Currency:   currency,
+	})
+	return s.addTokens(username, tokens)
+}
+
+// editedComparative tells if a period after the first one was edited by the user, the condition of Dynamo
+func (s *Memory) editedComparative(username string, sortKeys []string) bool {
+	for _, sortKey := range sortKeys[1:] {
+		if item, exists := s.get(username, sortKey); exists && item.(Finance).Edited {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Memory) UpdateFinancePeriod(ctx context.Context, username string, f Finance) error {
+	sortKey, err := FinanceSortKey(f.Ticker, f.Year, f.Period)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, exists := s.get(username, sortKey)
+	if !exists {
+		return ErrNotFound
+	}
+
+	f.Edited = true
+	f.LowConfidence, f.Warnings = nil, nil
+	f.Provenance = current.(Finance).Provenance
+	f.SourceDocument = current.(Finance).SourceDocument
+	f.PromptVersion = current.(Finance).PromptVersion
+	s.put(username, sortKey, f)
+	return nil
+}
+
+func (s *Memory) DeleteFinancePeriodAtomic(ctx context.Context, username, ticker string, year int, period string) error {
+	sortKey, err := FinanceSortKey(ticker, year, period)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.get(username, sortKey); !exists {
+		return ErrNotFound
+	}
+
+	delete(s.items[username], sortKey)
+	if len(s.scan(username, FinancePrefix(ticker))) == 0 {
+		delete(s.items[username], TickerSortKey(ticker))
+	}
+	return nil
+}
+
+// *
+// **
+// ***
+// ****
+// ***** ANALYSIS#
+func (s *Memory) PutAnalysis(ctx context.Context, username string, a Analysis) (Analysis, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, exists := s.get(username, TickerSortKey(a.Ticker))
+	if !exists {
+		return Analysis{}, ErrNotFound
+	}
+	if _, exists := s.get(username, "METADATA"); !exists {
+		return Analysis{}, ErrNotFound
+	}
+
+	now := time.Now()
+	a.ID = AnalysisID(now.UnixNano())
+	a.CreatedAt = now.Unix()
+
+	t := item.(Ticker)
+	t.Analysis = a.Content
+	t.Insights = a.Insights
+	s.put(username, TickerSortKey(a.Ticker), t)
+	s.put(username, AnalysisSortKey(a.Ticker, a.ID), a)
+	return a, s.addTokens(username, a.Tokens)
+}
+
+func (s *Memory) GetAnalysis(ctx context.Context, username, ticker, id string) (Analysis, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, exists := s.get(username, AnalysisSortKey(ticker, id))
+	if !exists {
+		return Analysis{}, ErrNotFound
+	}
+	return item.(Analysis), nil
+}
+
+func (s *Memory) PageAnalyses(ctx context.Context, username, ticker, cursor string, limit int) ([]Analysis, string, error) {
+	startAfter := ""
+	if cursor != "" {
+		cursorSK, err := cursorSortKey(cursor, username, AnalysisPrefix(ticker))
+		if err != nil {
+			return nil, "", err
+		}
+		startAfter = cursorSK
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sortKeys := s.scan(username, AnalysisPrefix(ticker))
+
+	var analyses []Analysis
+	nextCursor := ""
+	for i := len(sortKeys) - 1; i >= 0; i-- {
+		if startAfter != "" && sortKeys[i] >= startAfter {
+			continue
+		}
+		if len(analyses) == limit {
+			last := analyses[len(analyses)-1]
+			nextCursor = encodeCursor(username, AnalysisSortKey(last.Ticker, last.ID))
+			break
+		}
+		analyses = append(analyses, s.items[username][sortKeys[i]].(Analysis))
+	}
+
+	return analyses, nextCursor, nil
+}
+
+// *
+// **
+// ***
+// ****
+// ***** CHAT#
+func (s *Memory) PutChatTurn(ctx context.Context, username, ticker string, question, answer ChatMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.get(username, "METADATA"); !exists {
+		return ErrNotFound
+	}
+
+	now := time.Now()
+	for i, m := range []*ChatMessage{&question, &answer} {
+		m.Ticker = ticker
+		m.ID = AnalysisID(now.UnixNano() + int64(i))
+		m.CreatedAt = now.Unix()
+		s.put(username, ChatSortKey(ticker, m.ID), *m)
+	}
+	return s.addTokens(username, answer.Tokens)
+}
+
+func (s *Memory) ListChat(ctx context.Context, username, ticker string) ([]ChatMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := []ChatMessage{}
+	for _, sortKey := range s.scan(username, ChatPrefix(ticker)) {
+		messages = append(messages, s.items[username][sortKey].(ChatMessage))
+	}
+	return messages, nil
+}
+
+func (s *Memory) DeleteChat(ctx context.Context, username, ticker string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sortKeys := s.scan(username, ChatPrefix(ticker))
+	for _, sortKey := range sortKeys {
+		delete(s.items[username], sortKey)
+	}
+	return len(sortKeys), nil
+}
+
+// *
+// **
+// ***
+// ****
+// ***** DRAFT#
+func (s *Memory) getDraft(username, ticker, period string) (Draft, bool) {
+	item, exists := s.get(username, DraftSortKey(ticker, period))
+	if !exists {
+		return Draft{}, false
+	}
+
+	d := item.(Draft)
+	if d.ExpiresAt <= time.Now().Unix() {
+		return Draft{}, false
+	}
+	return d, true
+}
+
+func (s *Memory) GetDraft(ctx context.Context, username, ticker, period string) (Draft, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d, exists := s.getDraft(username, ticker, period)
+	if !exists {
+		return Draft{}, ErrNotFound
+	}
+	return d, nil
+}
+
+func (s *Memory) PutDraft(ctx context.Context, username string, d Draft, tokens int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.get(username, "METADATA"); !exists {
+		return ErrNotFound
+	}
+
+	s.put(username, DraftSortKey(d.Ticker, d.Period), d)
+	return s.addTokens(username, tokens)
+}
+
+func (s *Memory) PromoteDraft(ctx context.Context, username string, d Draft, periods []Finance) error {
+	if len(periods) == 0 || len(periods) > MaxTransactionPeriods-1 {
+		return fmt.Errorf("invalid number of periods: %d", len(periods))
+	}
+
+	sortKeys := make([]string, 0, len(periods))
+	for _, f := range periods {
+		if f.Ticker != d.Ticker {
+			return fmt.Errorf("period of %s in a batch of %s", f.Ticker, d.Ticker)
+		}
+
+		sortKey, err := FinanceSortKey(f.Ticker, f.Year, f.Period)
+		if err != nil {
+			return err
+		}
+		sortKeys = append(sortKeys, sortKey)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.get(username, "METADATA"); !exists {
+		return ErrNotFound
+	}
+	if _, exists := s.getDraft(username, d.Ticker, d.Period); !exists {
+		return ErrNotFound
+	}
+
+	if s.editedComparative(username, sortKeys) {
+		return ErrConditionFailed
+	}
+
+	for i, f := range periods {
+		s.put(username, sortKeys[i], f)
+	}
+	s.put(username, TickerSortKey(d.Ticker), Ticker{
+		Ticker:     d.Ticker,
+		LastUpdate: time.Now().Unix(),
+		Currency:   d.Currency,
+	})
+	delete(s.items[username], DraftSortKey(d.Ticker, d.Period))
+	return nil
+}
+
+// *
+// **
+// ***
+// ****
+// ***** CORRECTION#
+func (s *Memory) PutCorrection(ctx context.Context, username string, c Correction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.put(username, CorrectionSortKey(c.Language, c.Target, time.Now().UnixNano()), c)
+	return nil
+}
+
+func (s *Memory) ListCorrections(ctx context.Context, username, language, target string, limit int) ([]Correction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sortKeys := s.scan(username, CorrectionPrefix(language, target))
+
+	corrections := []Correction{}
+	for i := len(sortKeys) - 1; i >= 0 && len(corrections) < limit; i-- {
+		corrections = append(corrections, s.items[username][sortKeys[i]].(Correction))
+	}
+	return corrections, nil
+}
+
+// *
+// **
+// ***
+// ****
+// ***** EXTRACTIONS
+func (s *Memory) GetExtraction(ctx context.Context, hash string) (Extraction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, exists := s.get(ExtractionsKey, ExtractionSortKey(hash))
+	if !exists || item.(Extraction).ExpiresAt <= time.Now().Unix() {
+		return Extraction{}, ErrNotFound
+	}
+	return item.(Extraction), nil
+}
+
+func (s *Memory) PutExtraction(ctx context.Context, e Extraction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.put(ExtractionsKey, ExtractionSortKey(e.Hash), e)
+	return nil
+}
+
+func (s *Memory) PurgeExtractions(ctx context.Context, promptVersion string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for _, sortKey := range s.scan(ExtractionsKey, "EXTRACTION#") {
+		if promptVersion != "" && s.items[ExtractionsKey][sortKey].(Extraction).PromptVersion != promptVersion {
+			continue
+		}
+		delete(s.items[ExtractionsKey], sortKey)
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// The handler tests run on Memory, its errors have to be the ones of Dynamo
+func TestMemoryErrors(t *testing.T) {
+	ctx := context.Background()
+	revenue := int64(100)
+	period := Finance{Ticker: "AAPL", Year: 2024, Period: "Y", Revenue: &revenue}
+
+	newStore := func(t *testing.T) *Memory {
+		t.Helper()
+
+		st := NewMemory()
+		ctokens := int64(0)
+		if err := st.PutMetadata(ctx, Metadata{Username: "user", StripeID: "cus_1", CTokens: &ctokens}); err != nil {
+			t.Fatalf("PutMetadata() = %v", err)
+		}
+		if err := st.PutFinancePeriod(ctx, "user", period, "USD", 0); err != nil {
+			t.Fatalf("PutFinancePeriod() = %v", err)
+		}
+		return st
+	}
+
+	tests := []struct {
+		name string
+		call func(st *Memory) error
+		want error
+	}{
+		{
+			name: "metadata of an unknown user",
+			call: func(st *Memory) error { _, err := st.GetMetadata(ctx, "nobody"); return err },
+			want: ErrNotFound,
+		},
+		{
+			name: "tokens of an unknown user",
+			call: func(st *Memory) error { return st.AddTokens(ctx, "nobody", 10) },
+			want: ErrNotFound,
+		},
+		{
+			name: "renewal with another stripe id",
+			call: func(st *Memory) error { return st.RenewSubscription(ctx, "user", "cus_2", 0) },
+			want: ErrConditionFailed,
+		},
+		{
+			name: "renewal of an unknown user",
+			call: func(st *Memory) error { return st.RenewSubscription(ctx, "nobody", "cus_1", 0) },
+			want: ErrConditionFailed,
+		},
+		{
+			name: "periods of an unknown user",
+			call: func(st *Memory) error {
+				return st.PutFinancePeriods(ctx, "nobody", "AAPL", []Finance{period}, "USD", 0)
+			},
+			want: ErrNotFound,
+		},
+		{
+			name: "update of a period not stored",
+			call: func(st *Memory) error {
+				return st.UpdateFinancePeriod(ctx, "user", Finance{Ticker: "AAPL", Year: 2023, Period: "Y"})
+			},
+			want: ErrNotFound,
+		},
+		{
+			name: "delete of a period not stored",
+			call: func(st *Memory) error { return st.DeleteFinancePeriodAtomic(ctx, "user", "AAPL", 2023, "Y") },
+			want: ErrNotFound,
+		},
+		{
+			name: "promotion of an expired draft",
+			call: func(st *Memory) error {
+				d := Draft{Ticker: "AAPL", Period: "2023-Y", ExpiresAt: time.Now().Add(-time.Minute).Unix()}
+				if err := st.PutDraft(ctx, "user", d, 0); err != nil {
+					return err
+				}
+				return st.PromoteDraft(ctx, "user", d, []Finance{{Ticker: "AAPL", Year: 2023, Period: "Y"}})
+			},
+			want: ErrNotFound,
+		},
+		{
+			name: "comparative over an edited period",
+			call: func(st *Memory) error {
+				edited := Finance{Ticker: "AAPL", Year: 2023, Period: "Y", Revenue: &revenue, Edited: true}
+				if err := st.PutFinancePeriod(ctx, "user", edited, "USD", 0); err != nil {
+					return err
+				}
+				return st.PutFinancePeriods(ctx, "user", "AAPL", []Finance{period, {Ticker: "AAPL", Year: 2023, Period: "Y"}}, "USD", 0)
+			},
+			want: ErrConditionFailed,
+		},
+		{
+			name: "requested period over an edited one",
+			call: func(st *Memory) error {
+				edited := period
+				edited.Edited = true
+				if err := st.PutFinancePeriod(ctx, "user", edited, "USD", 0); err != nil {
+					return err
+				}
+				return st.PutFinancePeriod(ctx, "user", period, "USD", 0)
+			},
+			want: nil,
+		},
+		{
+			name: "ticker of another user",
+			call: func(st *Memory) error { _, err := st.GetTicker(ctx, "other", "AAPL"); return err },
+			want: ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(newStore(t)); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadMemoryStore(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{value: "true", want: true},
+		{value: "false", want: false},
+		{value: "yes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Cleanup(func() { MEMORY_STORE = false })
+			t.Setenv("MEMORY_STORE", tt.value)
+
+			err := LoadMemoryStore()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadMemoryStore() = %v, want error %t", err, tt.wantErr)
+			}
+			if MEMORY_STORE != tt.want {
+				t.Errorf("MEMORY_STORE = %t, want %t", MEMORY_STORE, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,529 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+	nodofinance_table:
+		- PK: username
+		- SK: composite_sk:
+			* TICKER#{ticker} -> attributes: last_update, currency, analysis, insights
+			* FINANCE#{ticker}#{reverse_year}#{period_order} -> attributes: financial data fields, edited, low_confidence, warnings, provenance
+			* DRAFT#{ticker}#{period} -> attributes: currency, created_at, expires_at (TTL), periods
+			* ANALYSIS#{ticker}#{created_at_nanos} -> attributes: created_at, content, insights, verification, model, prompt_version, tokens, finances_hash
+			* CHAT#{ticker}#{created_at_nanos} -> attributes: created_at, role, content, tokens
+			* CORRECTION#{language}#{target}#{created_at_nanos} -> attributes: created_at, period, chunk, fields
+			* METADATA -> attributes: stripe_id, expires_date, ctokens
+		- PK: EXTRACTIONS, the hash includes the user
+		- SK: EXTRACTION#{content_hash} -> attributes: prompt_version, created_at, expires_at (TTL), result
+*/
+
+const TableName = "nodofinance_table"
+
+// MEMORY_STORE keeps the table in process (Memory) instead of DynamoDB, for local demos.
+// It decides whether AWS is used at all, so it comes from the OS environment (or .env), not SSM
+var MEMORY_STORE = false
+
+// LoadMemoryStore reads MEMORY_STORE, before the environment is loaded from SSM
+func LoadMemoryStore() error {
+	value, exists := os.LookupEnv("MEMORY_STORE")
+	if !exists {
+		return nil
+	}
+
+	switch value {
+	case "true":
+		MEMORY_STORE = true
+	case "false":
+		MEMORY_STORE = false
+	default:
+		return fmt.Errorf("invalid value for MEMORY_STORE: %s, want true or false", value)
+	}
+	return nil
+}
+
+// MaxTransactionPeriods fits a batch of FINANCE# puts, TICKER# and METADATA
+// in one DynamoDB transaction (100 items)
+const MaxTransactionPeriods = 98
+
+var (
+	ErrNotFound        = errors.New("record not found")
+	ErrConditionFailed = errors.New("condition check failed")
+	// ErrInvalidCursor is a page cursor that can't be decoded or belongs to another query
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+type Metadata struct {
+	Username    string `dynamodbav:"username"`
+	StripeID    string `dynamodbav:"stripe_id,omitempty"`
+	ExpiresDate *int64 `dynamodbav:"expires_date,omitempty"`
+	CTokens     *int64 `dynamodbav:"ctokens,omitempty"`
+}
+
+type Ticker struct {
+	Ticker     string `dynamodbav:"-"`
+	LastUpdate int64  `dynamodbav:"last_update"`
+	Currency   string `dynamodbav:"currency,omitempty"`
+	Analysis   string `dynamodbav:"analysis,omitempty"`
+	// Insights is the structured part of the analysis, nil for older ones
+	Insights *Insights `dynamodbav:"insights,omitempty"`
+}
+
+// Insights scores the company from 0 to 10, overall and per pillar
+type Insights struct {
+	Score   float64 `dynamodbav:"score" json:"score"`
+	Pillars Pillars `dynamodbav:"pillars" json:"pillars"`
+	// ProjectedEPS is missing in analyses of two periods or less, they have no projections
+	ProjectedEPS Scenarios `dynamodbav:"projected_eps" json:"projected_eps"`
+	Risks        []Risk    `dynamodbav:"risks,omitempty" json:"risks"`
+}
+
+type Pillars struct {
+	Profitability  float64 `dynamodbav:"profitability" json:"profitability"`
+	Solvency       float64 `dynamodbav:"solvency" json:"solvency"`
+	Liquidity      float64 `dynamodbav:"liquidity" json:"liquidity"`
+	CashGeneration float64 `dynamodbav:"cash_generation" json:"cash_generation"`
+}
+
+type Scenarios struct {
+	Base *float64 `dynamodbav:"base,omitempty" json:"base"`
+	Bull *float64 `dynamodbav:"bull,omitempty" json:"bull"`
+	Bear *float64 `dynamodbav:"bear,omitempty" json:"bear"`
+}
+
+type Risk struct {
+	Category    string `dynamodbav:"category" json:"category"` // operational, financial or structural
+	Description string `dynamodbav:"description" json:"description"`
+}
+
+type Finance struct {
+	Ticker string `dynamodbav:"-"`
+	Year   int    `dynamodbav:"-"`
+	Period string `dynamodbav:"-"`
+
+	CurrentAssets          *int64   `dynamodbav:"current_assets,omitempty"`
+	NonCurrentAssets       *int64   `dynamodbav:"non_current_assets,omitempty"`
+	Cash                   *int64   `dynamodbav:"cash_and_equivalents,omitempty"`
+	CurrentLiabilities     *int64   `dynamodbav:"current_liabilities,omitempty"`
+	NonCurrentLiabilities  *int64   `dynamodbav:"non_current_liabilities,omitempty"`
+	Revenue                *int64   `dynamodbav:"revenue,omitempty"`
+	NetIncome              *int64   `dynamodbav:"net_income,omitempty"`
+	EPS                    *float64 `dynamodbav:"eps,omitempty"`
+	CashFlowFromOperations *int64   `dynamodbav:"cash_flow_from_operations,omitempty"`
+	CashFlowFromInvesting  *int64   `dynamodbav:"cash_flow_from_investing,omitempty"`
+	CashFlowFromFinancing  *int64   `dynamodbav:"cash_flow_from_financing,omitempty"`
+
+	// Edited is set once the user corrects the period, so extractions of other periods leave it alone
+	Edited bool `dynamodbav:"edited,omitempty"`
+	// Validation of the extraction, cleared when the user edits the period
+	LowConfidence []string `dynamodbav:"low_confidence,omitempty"`
+	Warnings      []string `dynamodbav:"warnings,omitempty"`
+	// Provenance maps a field to the text it was extracted from, kept when the user edits the period
+	Provenance map[string]Source `dynamodbav:"provenance,omitempty"`
+	// SourceDocument is the S3 key of the submission the period was extracted from, kept on edits too
+	SourceDocument string `dynamodbav:"source_document,omitempty"`
+	// PromptVersion is the version of the prompt templates that extracted the period, kept on edits too
+	PromptVersion string `dynamodbav:"prompt_version,omitempty"`
+}
+
+// Source is the row of the filing an extracted value was read from
+type Source struct {
+	Label   string `dynamodbav:"label" json:"label"`
+	Snippet string `dynamodbav:"snippet" json:"snippet"`
+}
+
+// Anomaly is a validation warning of a drafted period
+type Anomaly struct {
+	Code    string   `dynamodbav:"code" json:"code"`
+	Fields  []string `dynamodbav:"fields,omitempty" json:"fields,omitempty"`
+	Message string   `dynamodbav:"message" json:"message"`
+}
+
+// Draft is an extraction waiting for the user to approve it into FINANCE# items
+type Draft struct {
+	Ticker string `dynamodbav:"-"`
+	Period string `dynamodbav:"-"` // requested period, YYYY-P
+
+	Currency  string `dynamodbav:"currency"`
+	CreatedAt int64  `dynamodbav:"created_at"`
+	// ExpiresAt is the TTL of the item. DynamoDB deletes expired items late, reads check it too
+	ExpiresAt int64 `dynamodbav:"expires_at"`
+	// Requested period first, then the comparatives
+	Periods []DraftPeriod `dynamodbav:"periods"`
+}
+
+type DraftPeriod struct {
+	Finance   Finance   `dynamodbav:"finance"`
+	Year      int       `dynamodbav:"year"`
+	Period    string    `dynamodbav:"period"`
+	Anomalies []Anomaly `dynamodbav:"anomalies,omitempty"`
+}
+
+// Correction is a user fix of the fields of one extracted statement, reused as a few-shot example
+// in the submissions of the same user only, the chunk is their filing text
+type Correction struct {
+	Language string `dynamodbav:"-"`
+	Target   string `dynamodbav:"-"` // balance, income or cash_flow
+
+	CreatedAt int64  `dynamodbav:"created_at"`
+	Period    string `dynamodbav:"period"`
+	// Chunk is the statement text the model extracted from
+	Chunk  string           `dynamodbav:"chunk"`
+	Fields []CorrectedField `dynamodbav:"fields"`
+}
+
+// Extraction is a cached pipeline result, keyed by a hash of everything the pipeline reads
+type Extraction struct {
+	Hash string `dynamodbav:"-"`
+
+	PromptVersion string `dynamodbav:"prompt_version"`
+	CreatedAt     int64  `dynamodbav:"created_at"`
+	// ExpiresAt is the TTL of the item, reads check it too
+	ExpiresAt int64 `dynamodbav:"expires_at"`
+	// Result is the gzipped JSON of the result, opaque to the store
+	Result []byte `dynamodbav:"result"`
+}
+
+// Analysis is one generated analyst report, every one is kept so older opinions can be compared
+type Analysis struct {
+	Ticker string `dynamodbav:"-"`
+	// ID is the creation time in unix nanoseconds, from the sort key
+	ID string `dynamodbav:"-"`
+
+	CreatedAt int64     `dynamodbav:"created_at"`
+	Content   string    `dynamodbav:"content"`
+	Insights  *Insights `dynamodbav:"insights,omitempty"`
+	// Verification is the check of the figures of the report, nil for older ones
+	Verification *Verification `dynamodbav:"verification,omitempty"`
+	Model        string        `dynamodbav:"model"`
+	// PromptVersion is the version of the prompt templates that wrote the report
+	PromptVersion    string `dynamodbav:"prompt_version"`
+	PromptTokens     int64  `dynamodbav:"prompt_tokens"`
+	CompletionTokens int64  `dynamodbav:"completion_tokens"`
+	// Tokens is what the user was billed, in small model input tokens
+	Tokens int64 `dynamodbav:"tokens"`
+	// FinancesHash is the SHA-256 of the finances the report was written from
+	FinancesHash string `dynamodbav:"finances_hash"`
+}
+
+// Verification matches the figures of an analysis against the finances it was written from
+type Verification struct {
+	Checked    int        `dynamodbav:"checked" json:"checked"`
+	Mismatches []Mismatch `dynamodbav:"mismatches,omitempty" json:"mismatches"`
+	ErrorRate  float64    `dynamodbav:"error_rate" json:"error_rate"`
+	// Attempts counts the reports generated, the last one was kept
+	Attempts int `dynamodbav:"attempts" json:"attempts"`
+}
+
+// Mismatch is a figure of an analysis that no value of the finances explains
+type Mismatch struct {
+	Figure  string `dynamodbav:"figure" json:"figure"`
+	Context string `dynamodbav:"context" json:"context"`
+	// Closest is the period and field of the nearest value, if any is near
+	Closest  string   `dynamodbav:"closest,omitempty" json:"closest,omitempty"`
+	Expected *float64 `dynamodbav:"expected,omitempty" json:"expected,omitempty"`
+}
+
+const (
+	ChatRoleUser      = "user"
+	ChatRoleAssistant = "assistant"
+)
+
+// ChatMessage is one message of the follow-up chat about a ticker
+type ChatMessage struct {
+	Ticker string `dynamodbav:"-"`
+	// ID is the creation time in unix nanoseconds, from the sort key
+	ID string `dynamodbav:"-"`
+
+	CreatedAt int64  `dynamodbav:"created_at"`
+	Role      string `dynamodbav:"role"` // ChatRoleUser or ChatRoleAssistant
+	Content   string `dynamodbav:"content"`
+	// Tokens billed for the turn, on the answers
+	Tokens int64 `dynamodbav:"tokens,omitempty"`
+}
+
+type CorrectedField struct {
+	Field string `dynamodbav:"field"`
+	// Label is the row the wrong value was read from, if known
+	Label  string   `dynamodbav:"label,omitempty"`
+	Before *float64 `dynamodbav:"before"`
+	After  *float64 `dynamodbav:"after"`
+}
+
+// FinanceFields lists the financial attributes of a FINANCE# item
+var FinanceFields = []string{
+	"current_assets",
+	"non_current_assets",
+	"eps",
+	"cash_and_equivalents",
+	"cash_flow_from_financing",
+	"cash_flow_from_investing",
+	"cash_flow_from_operations",
+	"revenue",
+	"current_liabilities",
+	"non_current_liabilities",
+	"net_income",
+}
+
+// Store is the typed access layer over nodofinance_table.
+// Lookups of a missing item return ErrNotFound.
+type Store interface {
+	// METADATA
+	GetMetadata(ctx context.Context, username string) (Metadata, error)
+	PutMetadata(ctx context.Context, m Metadata) error
+	// RenewSubscription sets expires_date and resets ctokens, only if stripe_id matches
+	RenewSubscription(ctx context.Context, username, stripeID string, expiresDate int64) error
+	// AddTokens atomically increments ctokens of an existing user
+	AddTokens(ctx context.Context, username string, tokens int64) error
+
+	// TICKER#
+	GetTicker(ctx context.Context, username, ticker string) (Ticker, error)
+	ListTickers(ctx context.Context, username string) ([]Ticker, error)
+	CountTickers(ctx context.Context, username string) (int, error)
+
+	// FINANCE#
+	GetFinancePeriod(ctx context.Context, username, ticker string, year int, period string) (Finance, error)
+	// ListFinancePeriods returns every period of a ticker, newest first
+	ListFinancePeriods(ctx context.Context, username, ticker string) ([]Finance, error)
+	// PageFinancePeriods returns up to limit periods (newest first) and the cursor of the next page
+	PageFinancePeriods(ctx context.Context, username, ticker, cursor string, limit int) ([]Finance, string, error)
+	CountFinancePeriods(ctx context.Context, username, ticker string) (int, error)
+	// PutFinancePeriod writes the period, upserts TICKER# and bills tokens in one transaction
+	PutFinancePeriod(ctx context.Context, username string, f Finance, currency string, tokens int64) error
+	// PutFinancePeriods is PutFinancePeriod for up to MaxTransactionPeriods periods of one ticker.
+	// The first period is the requested one, the others are comparatives and are not written over
+	// a period the user edited: the transaction fails with ErrConditionFailed instead.
+	PutFinancePeriods(ctx context.Context, username, ticker string, periods []Finance, currency string, tokens int64) error
+	// UpdateFinancePeriod replaces the financial fields of an existing period,
+	// marks it as edited and clears its validation
+	UpdateFinancePeriod(ctx context.Context, username string, f Finance) error
+	// DeleteFinancePeriodAtomic deletes the period and, if it was the last one, its TICKER#
+	DeleteFinancePeriodAtomic(ctx context.Context, username, ticker string, year int, period string) error
+
+	// DRAFT#
+	// GetDraft returns ErrNotFound for expired drafts too
+	GetDraft(ctx context.Context, username, ticker, period string) (Draft, error)
+	// PutDraft writes the draft, replacing any draft of the same period, and bills tokens in one transaction
+	PutDraft(ctx context.Context, username string, d Draft, tokens int64) error
+	// PromoteDraft writes periods like PutFinancePeriods (without tokens) and deletes the draft
+	// in one transaction. Returns ErrNotFound if the draft is gone or expired.
+	// Like PutFinancePeriods, ErrConditionFailed if a comparative was edited by the user.
+	PromoteDraft(ctx context.Context, username string, d Draft, periods []Finance) error
+
+	// ANALYSIS#
+	// PutAnalysis sets the analysis and insights of TICKER#, keeps it as a new ANALYSIS# item and bills
+	// a.Tokens in one transaction. Returns ErrNotFound if the ticker or the user is missing.
+	PutAnalysis(ctx context.Context, username string, a Analysis) (Analysis, error)
+	GetAnalysis(ctx context.Context, username, ticker, id string) (Analysis, error)
+	// PageAnalyses returns up to limit analyses of a ticker (newest first) and the cursor of the next page
+	PageAnalyses(ctx context.Context, username, ticker, cursor string, limit int) ([]Analysis, string, error)
+
+	// CHAT#
+	// PutChatTurn appends a question and its answer to the chat of a ticker and bills answer.Tokens
+	// in one transaction
+	PutChatTurn(ctx context.Context, username, ticker string, question, answer ChatMessage) error
+	// ListChat returns the whole chat of a ticker, oldest first
+	ListChat(ctx context.Context, username, ticker string) ([]ChatMessage, error)
+	// DeleteChat deletes the chat of a ticker and returns how many messages it had
+	DeleteChat(ctx context.Context, username, ticker string) (int, error)
+
+	// CORRECTION#
+	PutCorrection(ctx context.Context, username string, c Correction) error
+	// ListCorrections returns up to limit corrections of the user in a language and statement, newest first
+	ListCorrections(ctx context.Context, username, language, target string, limit int) ([]Correction, error)
+
+	// EXTRACTIONS
+	// GetExtraction returns ErrNotFound for expired extractions too
+	GetExtraction(ctx context.Context, hash string) (Extraction, error)
+	PutExtraction(ctx context.Context, e Extraction) error
+	// PurgeExtractions deletes the cached extractions, only those of promptVersion if not empty,
+	// and returns how many were deleted
+	PurgeExtractions(ctx context.Context, promptVersion string) (int, error)
+}
+
+// *
+// **
+// ***
+// ****
+// ***** KEYS
+func TickerSortKey(ticker string) string {
+	return "TICKER#" + ticker
+}
+
+func DraftSortKey(ticker, period string) string {
+	return fmt.Sprintf("DRAFT#%s#%s", ticker, period)
+}
+
+func AnalysisPrefix(ticker string) string {
+	return fmt.Sprintf("ANALYSIS#%s#", ticker)
+}
+
+func AnalysisSortKey(ticker, id string) string {
+	return AnalysisPrefix(ticker) + id
+}
+
+// AnalysisID is the ID of an analysis created at createdAtNanos, IDs sort like their times
+func AnalysisID(createdAtNanos int64) string {
+	return fmt.Sprintf("%019d", createdAtNanos)
+}
+
+func ChatPrefix(ticker string) string {
+	return fmt.Sprintf("CHAT#%s#", ticker)
+}
+
+func ChatSortKey(ticker, id string) string {
+	return ChatPrefix(ticker) + id
+}
+
+func CorrectionPrefix(language, target string) string {
+	return fmt.Sprintf("CORRECTION#%s#%s#", language, target)
+}
+
+func CorrectionSortKey(language, target string, createdAtNanos int64) string {
+	return fmt.Sprintf("%s%019d", CorrectionPrefix(language, target), createdAtNanos)
+}
+
+const ExtractionsKey = "EXTRACTIONS"
+
+func ExtractionSortKey(hash string) string {
+	return "EXTRACTION#" + hash
+}
+
+func FinancePrefix(ticker string) string {
+	return fmt.Sprintf("FINANCE#%s#", ticker)
+}
+
+func FinanceSortKey(ticker string, year int, period string) (string, error) {
+	reverseYear := 9999 - year
+
+	periodOrder, err := PeriodOrder(period)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("FINANCE#%s#%04d#%02d", ticker, reverseYear, periodOrder), nil
+}
+
+// ParseFinanceSortKey extracts ticker, year and period from a FINANCE sort key
+func ParseFinanceSortKey(sortKey string) (string, int, string, error) {
+	// FINANCE#AAPL#7977#04 -> ticker=AAPL, year=2022, period=Q4
+	parts := strings.Split(sortKey, "#")
+	if len(parts) != 4 {
+		return "", 0, "", fmt.Errorf("invalid finance sort key format")
+	}
+
+	reverseYear, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return "", 0, "", fmt.Errorf("invalid reverse year: %w", err)
+	}
+
+	periodOrder, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return "", 0, "", fmt.Errorf("invalid period order: %w", err)
+	}
+
+	return parts[1], 9999 - reverseYear, PeriodFromOrder(periodOrder), nil
+}
+
+func PeriodOrder(period string) (int, error) {
+	switch period {
+	case "Y":
+		return 1, nil
+	case "S2":
+		return 2, nil
+	case "S1":
+		return 3, nil
+	case "Q4":
+		return 4, nil
+	case "Q3":
+		return 5, nil
+	case "Q2":
+		return 6, nil
+	case "Q1":
+		return 7, nil
+	default:
+		return 0, fmt.Errorf("invalid period: %s", period)
+	}
+}
+
+// Convert period order back to period string
+func PeriodFromOrder(order int) string {
+	switch order {
+	case 1:
+		return "Y"
+	case 2:
+		return "S2"
+	case 3:
+		return "S1"
+	case 4:
+		return "Q4"
+	case 5:
+		return "Q3"
+	case 6:
+		return "Q2"
+	case 7:
+		return "Q1"
+	default:
+		return ""
+	}
+}
+
+// *
+// **
+// ***
+// ****
+// ***** CURSOR
+// Cursors are the base64 JSON of the last evaluated key: {"username":{"S":..},"composite_sk":{"S":..}}
+func encodeCursor(username, sortKey string) string {
+	cursorData := map[string]map[string]string{
+		"username":     {"S": username},
+		"composite_sk": {"S": sortKey},
+	}
+
+	jsonData, err := json.Marshal(cursorData)
+	if err != nil {
+		return ""
+	}
+
+	return base64.URLEncoding.EncodeToString(jsonData)
+}
+
+func parseCursor(cursor string) (string, string, error) {
+	jsonData, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: encoding: %v", ErrInvalidCursor, err)
+	}
+
+	var cursorData map[string]map[string]string
+	if err := json.Unmarshal(jsonData, &cursorData); err != nil {
+		return "", "", fmt.Errorf("%w: format: %v", ErrInvalidCursor, err)
+	}
+
+	username := cursorData["username"]["S"]
+	sortKey := cursorData["composite_sk"]["S"]
+	if username == "" || sortKey == "" {
+		return "", "", fmt.Errorf("%w: missing keys", ErrInvalidCursor)
+	}
+
+	return username, sortKey, nil
+}
+
+// cursorSortKey is the sort key to start after, for a cursor of a query of username under prefix
+func cursorSortKey(cursor, username, prefix string) (string, error) {
+	cursorUsername, sortKey, err := parseCursor(cursor)
+	if err != nil {
+		return "", err
+	}
+	if cursorUsername != username || !strings.HasPrefix(sortKey, prefix) {
+		return "", fmt.Errorf("%w: keys of another query", ErrInvalidCursor)
+	}
+	return sortKey, nil
+}
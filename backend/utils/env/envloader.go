@@ -44,6 +44,22 @@ func LoadEnv(ssmClient *ssm.Client, pathPrefix string) error {
 		}
 	}
 
+	devAliases()
+	return nil
+}
+
+// LoadLocal takes the variables from the OS environment (and .env) instead of SSM,
+// for MEMORY_STORE demos without an AWS account
+func LoadLocal() {
+	for _, variable := range os.Environ() {
+		key, value, _ := strings.Cut(variable, "=")
+		parameters[key] = value
+	}
+	devAliases()
+}
+
+// devAliases points the variables to their test and dev values in DEV_MODE
+func devAliases() {
 	if os.Getenv("DEV_MODE") == "true" {
 		if testVal, exists := parameters["VITE_STRIPE_PK_TEST"]; exists {
 			parameters["VITE_STRIPE_PK"] = testVal
@@ -61,8 +77,6 @@ func LoadEnv(ssmClient *ssm.Client, pathPrefix string) error {
 			parameters["VITE_BASE_URL"] = devVal
 		}
 	}
-
-	return nil
 }
 
 func RegisterValidator(validator func() error) {
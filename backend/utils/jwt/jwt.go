@@ -36,6 +36,9 @@ var ISS_CLAIM string
 
 var validationCache *cache.Cache
 
+// UNSIGNED_TOKENS accepts tokens without checking their signature, for MEMORY_STORE demos without Cognito
+var UNSIGNED_TOKENS = false
+
 func LoadJWKS() error {
 	if validationCache == nil {
 		validationCache = cache.New(2*time.Minute, 5*time.Minute)
@@ -98,6 +101,11 @@ func LoadJWKS() error {
 }
 
 func ValidateToken(tokenString string, needsPremiumValidation bool) (bool, error) {
+	if UNSIGNED_TOKENS {
+		claims, err := GetTokenClaims(tokenString)
+		return err == nil && claims != nil, nil
+	}
+
 	hasher := sha256.New()
 	hasher.Write([]byte(tokenString))
 	cacheKey := hex.EncodeToString(hasher.Sum(nil)) + ":" + fmt.Sprintf("%v", needsPremiumValidation)
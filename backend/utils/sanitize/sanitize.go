@@ -165,6 +165,21 @@ func Timestamp(timestamp string) bool {
 	return true
 }
 
+// AnalysisID is a creation time in unix nanoseconds, zero padded to 19 digits
+func AnalysisID(id string) bool {
+	if len(id) != 19 {
+		return false
+	}
+
+	for _, ch := range id {
+		if !unicode.IsDigit(ch) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func Ticker(ticker string) bool {
 	if len(ticker) == 0 || len(ticker) > 12 {
 		return false
@@ -464,3 +479,18 @@ func Cursor(value string) bool {
 
 	return true
 }
+
+// PromptVersion is the name of a prompt templates directory
+func PromptVersion(version string) bool {
+	if len(version) == 0 || len(version) > 32 {
+		return false
+	}
+
+	for _, ch := range version {
+		if !unicode.IsLetter(ch) && !unicode.IsDigit(ch) && ch != '.' && ch != '-' && ch != '_' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,196 @@
+package xbrl
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+	SEC EDGAR companyfacts (https://data.sec.gov/api/xbrl/companyfacts/CIK##########.json):
+	every fact a company ever filed, grouped by taxonomy, concept and unit.
+	Each value carries the accession number, form, fiscal year and fiscal period of the filing
+	that reported it, so the facts of a filing are rebuilt into a Document of its own.
+*/
+
+// Filing is a 10-K or 10-Q rebuilt from companyfacts
+type Filing struct {
+	Accession string
+	Form      string
+	Filed     string
+	Period    Period
+	Document  *Document
+}
+
+type companyFacts struct {
+	Facts map[string]map[string]companyFactConcept `json:"facts"` // taxonomy -> concept
+}
+
+type companyFactConcept struct {
+	Units map[string][]companyFact `json:"units"` // USD, USD/shares, shares...
+}
+
+type companyFact struct {
+	Start string  `json:"start"`
+	End   string  `json:"end"`
+	Val   float64 `json:"val"`
+	Accn  string  `json:"accn"`
+	FY    int     `json:"fy"`
+	FP    string  `json:"fp"`
+	Form  string  `json:"form"`
+	Filed string  `json:"filed"`
+}
+
+// ParseCompanyFacts returns the annual and quarterly filings of a companyfacts file, newest first.
+// When several filings report the same fiscal period, the last filed one is kept.
+func ParseCompanyFacts(data []byte) ([]Filing, error) {
+	var raw companyFacts
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("reading companyfacts: %w", err)
+	}
+
+	filings := make(map[string]*Filing) // accession -> filing
+
+	for taxonomy, concepts := range raw.Facts {
+		for name, concept := range concepts {
+			for unitID, facts := range concept.Units {
+				for _, fact := range facts {
+					if fact.Form != "10-K" && fact.Form != "10-Q" {
+						continue
+					}
+
+					filing, exists := filings[fact.Accn]
+					if !exists {
+						filing = newFiling(fact)
+						if filing == nil {
+							continue
+						}
+						filings[fact.Accn] = filing
+					}
+
+					filing.add(taxonomy+":"+name, unitID, fact)
+				}
+			}
+		}
+	}
+
+	latest := make(map[string]*Filing) // period -> filing
+	for _, filing := range filings {
+		if err := filing.setEnd(); err != nil {
+			continue
+		}
+
+		key := filing.Period.String()
+		if current, exists := latest[key]; !exists || filing.Filed > current.Filed {
+			latest[key] = filing
+		}
+	}
+
+	result := make([]Filing, 0, len(latest))
+	for _, filing := range latest {
+		result = append(result, *filing)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Period.End.After(result[j].Period.End)
+	})
+
+	if len(result) == 0 {
+		return nil, ErrNoFacts
+	}
+	return result, nil
+}
+
+func newFiling(fact companyFact) *Filing {
+	var periodType string
+	switch fact.FP {
+	case "FY":
+		periodType = "Y"
+	case "Q1", "Q2", "Q3":
+		periodType = fact.FP
+	default:
+		return nil
+	}
+
+	if fact.FY == 0 {
+		return nil
+	}
+
+	return &Filing{
+		Accession: fact.Accn,
+		Form:      fact.Form,
+		Filed:     fact.Filed,
+		Period:    Period{Year: fact.FY, Type: periodType},
+		Document: &Document{
+			Contexts: make(map[string]Context),
+			Units:    make(map[string]Unit),
+		},
+	}
+}
+
+func (f *Filing) add(concept, unitID string, fact companyFact) {
+	contextID := fact.Start + "/" + fact.End
+	if _, exists := f.Document.Contexts[contextID]; !exists {
+		c := Context{ID: contextID, End: parseDate(fact.End)}
+		if fact.Start == "" {
+			c.Instant = c.End
+		} else {
+			c.Start = parseDate(fact.Start)
+		}
+		f.Document.Contexts[contextID] = c
+	}
+
+	if _, exists := f.Document.Units[unitID]; !exists {
+		f.Document.Units[unitID] = companyFactUnit(unitID)
+	}
+
+	f.Document.Facts = append(f.Document.Facts, Fact{
+		Concept: concept,
+		Context: contextID,
+		Unit:    unitID,
+		Value:   fact.Val,
+		Numeric: true,
+	})
+}
+
+// setEnd dates the filing with its latest balance sheet, or its latest fact without one
+func (f *Filing) setEnd() error {
+	for _, fact := range f.Document.Facts {
+		c := f.Document.Contexts[fact.Context]
+		isAssets := fact.Concept == "us-gaap:Assets" || fact.Concept == "ifrs-full:Assets"
+		if isAssets && c.End.After(f.Period.End) {
+			f.Period.End = c.End
+		}
+	}
+
+	if f.Period.End.IsZero() {
+		for _, fact := range f.Document.Facts {
+			if c := f.Document.Contexts[fact.Context]; c.End.After(f.Period.End) {
+				f.Period.End = c.End
+			}
+		}
+	}
+
+	if f.Period.End.IsZero() {
+		return ErrNoPeriod
+	}
+	return nil
+}
+
+// companyFactUnit maps the unit keys of companyfacts (USD, USD/shares, pure) to XBRL measures
+func companyFactUnit(unitID string) Unit {
+	numerator, denominator, divided := strings.Cut(unitID, "/")
+
+	measure := func(name string) string {
+		if len(name) == 3 && strings.ToUpper(name) == name {
+			return "iso4217:" + name
+		}
+		return "xbrli:" + name
+	}
+
+	u := Unit{ID: unitID, Numerator: []string{measure(numerator)}}
+	if divided {
+		u.Denominator = []string{measure(denominator)}
+	}
+	return u
+}
@@ -0,0 +1,320 @@
+package xbrl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Concepts per statement field, in order of preference. US GAAP and IFRS (ESEF) names.
+var (
+	cashConcepts = []string{
+		"us-gaap:CashAndCashEquivalentsAtCarryingValue",
+		"us-gaap:CashCashEquivalentsRestrictedCashAndRestrictedCashEquivalents",
+		"us-gaap:Cash",
+		"ifrs-full:CashAndCashEquivalents",
+	}
+	currentAssetsConcepts = []string{
+		"us-gaap:AssetsCurrent",
+		"ifrs-full:CurrentAssets",
+	}
+	nonCurrentAssetsConcepts = []string{
+		"us-gaap:NoncurrentAssets",
+		"us-gaap:AssetsNoncurrent",
+		"ifrs-full:NoncurrentAssets",
+	}
+	totalAssetsConcepts = []string{
+		"us-gaap:Assets",
+		"ifrs-full:Assets",
+	}
+	currentLiabilitiesConcepts = []string{
+		"us-gaap:LiabilitiesCurrent",
+		"ifrs-full:CurrentLiabilities",
+	}
+	nonCurrentLiabilitiesConcepts = []string{
+		"us-gaap:LiabilitiesNoncurrent",
+		"ifrs-full:NoncurrentLiabilities",
+	}
+	totalLiabilitiesConcepts = []string{
+		"us-gaap:Liabilities",
+		"ifrs-full:Liabilities",
+	}
+	equityConcepts = []string{
+		"us-gaap:StockholdersEquity",
+		"us-gaap:StockholdersEquityIncludingPortionAttributableToNoncontrollingInterest",
+		"ifrs-full:EquityAttributableToOwnersOfParent",
+		"ifrs-full:Equity",
+	}
+
+	revenueConcepts = []string{
+		"us-gaap:Revenues",
+		"us-gaap:RevenueFromContractWithCustomerExcludingAssessedTax",
+		"us-gaap:RevenueFromContractWithCustomerIncludingAssessedTax",
+		"us-gaap:SalesRevenueNet",
+		"ifrs-full:Revenue",
+		"ifrs-full:RevenueFromContractsWithCustomers",
+	}
+	netIncomeConcepts = []string{
+		"us-gaap:NetIncomeLoss",
+		"us-gaap:ProfitLoss",
+		"us-gaap:NetIncomeLossAvailableToCommonStockholdersBasic",
+		"ifrs-full:ProfitLossAttributableToOwnersOfParent",
+		"ifrs-full:ProfitLoss",
+	}
+	epsConcepts = []string{
+		"us-gaap:EarningsPerShareBasic",
+		"us-gaap:EarningsPerShareBasicAndDiluted",
+		"us-gaap:EarningsPerShareDiluted",
+		"ifrs-full:BasicEarningsLossPerShare",
+		"ifrs-full:BasicAndDilutedEarningsLossPerShare",
+		"ifrs-full:DilutedEarningsLossPerShare",
+	}
+
+	operatingConcepts = []string{
+		"us-gaap:NetCashProvidedByUsedInOperatingActivities",
+		"us-gaap:NetCashProvidedByUsedInOperatingActivitiesContinuingOperations",
+		"ifrs-full:CashFlowsFromUsedInOperatingActivities",
+	}
+	investingConcepts = []string{
+		"us-gaap:NetCashProvidedByUsedInInvestingActivities",
+		"us-gaap:NetCashProvidedByUsedInInvestingActivitiesContinuingOperations",
+		"ifrs-full:CashFlowsFromUsedInInvestingActivities",
+	}
+	financingConcepts = []string{
+		"us-gaap:NetCashProvidedByUsedInFinancingActivities",
+		"us-gaap:NetCashProvidedByUsedInFinancingActivitiesContinuingOperations",
+		"ifrs-full:CashFlowsFromUsedInFinancingActivities",
+	}
+)
+
+// Period is a fiscal period of the filing, e.g. 2024-Q3 ending 2024-09-28
+type Period struct {
+	Year int
+	Type string // Y, S1, S2, Q1, Q2, Q3, Q4
+	End  time.Time
+}
+
+func (p Period) String() string {
+	return fmt.Sprintf("%d-%s", p.Year, p.Type)
+}
+
+// days returns the accepted length range of a duration of the period type
+func (p Period) days() (int, int) {
+	switch p.Type {
+	case "Y":
+		return 350, 380
+	case "S1", "S2":
+		return 170, 195
+	default:
+		return 80, 100
+	}
+}
+
+// DocumentPeriod reads the fiscal period the filing reports from its dei cover facts.
+// End falls back to the latest total assets date when dei:DocumentPeriodEndDate is missing.
+func (d *Document) DocumentPeriod() (Period, error) {
+	var p Period
+
+	if year, err := strconv.Atoi(d.text("dei:DocumentFiscalYearFocus")); err == nil {
+		p.Year = year
+	}
+
+	switch strings.ToUpper(d.text("dei:DocumentFiscalPeriodFocus")) {
+	case "FY":
+		p.Type = "Y"
+	case "Q1", "Q2", "Q3", "Q4":
+		p.Type = strings.ToUpper(d.text("dei:DocumentFiscalPeriodFocus"))
+	case "H1":
+		p.Type = "S1"
+	case "H2":
+		p.Type = "S2"
+	}
+
+	// The cover facts are reported in the context of the whole period,
+	// the displayed date can be in any ixt date format
+	for _, f := range d.Facts {
+		if f.Concept == "dei:DocumentPeriodEndDate" {
+			if c, exists := d.Contexts[f.Context]; exists {
+				p.End = c.End
+			}
+			if date := parseDate(f.Text); !date.IsZero() {
+				p.End = date
+			}
+			break
+		}
+	}
+
+	// No cover, take the latest balance sheet date
+	if p.End.IsZero() {
+		for _, f := range d.Facts {
+			if f.Concept != "us-gaap:Assets" && f.Concept != "ifrs-full:Assets" {
+				continue
+			}
+			if c, exists := d.Contexts[f.Context]; exists && !c.Dimensional && c.End.After(p.End) {
+				p.End = c.End
+			}
+		}
+	}
+
+	if p.End.IsZero() {
+		return Period{}, ErrNoPeriod
+	}
+	if p.Year == 0 {
+		p.Year = p.End.Year()
+	}
+
+	return p, nil
+}
+
+// Currency is the most used currency of the monetary facts
+func (d *Document) Currency() string {
+	counts := make(map[string]int)
+	for _, f := range d.Facts {
+		if !f.Numeric {
+			continue
+		}
+		if u, exists := d.Units[f.Unit]; exists && len(u.Denominator) == 0 {
+			if currency := u.Currency(); currency != "" {
+				counts[currency]++
+			}
+		}
+	}
+
+	best := ""
+	for currency, count := range counts {
+		if count > counts[best] || (count == counts[best] && currency < best) {
+			best = currency
+		}
+	}
+	return best
+}
+
+// Statements are the consolidated values of a period, in units of currency. Concepts not reported are nil.
+type Statements struct {
+	// balance
+	CashAndEquivalents    *float64
+	CurrentAssets         *float64
+	NonCurrentAssets      *float64
+	TotalAssets           *float64
+	CurrentLiabilities    *float64
+	NonCurrentLiabilities *float64
+	TotalLiabilities      *float64
+	Equity                *float64
+	// income
+	Revenue   *float64
+	NetIncome *float64
+	EPS       *float64
+	// cash flow
+	CashFlowFromOperations *float64
+	CashFlowFromInvesting  *float64
+	CashFlowFromFinancing  *float64
+}
+
+// Statements reads the consolidated facts of period, in currency or in any currency if it is empty
+func (d *Document) Statements(period Period, currency string) Statements {
+	return Statements{
+		CashAndEquivalents:    d.instant(cashConcepts, period, currency),
+		CurrentAssets:         d.instant(currentAssetsConcepts, period, currency),
+		NonCurrentAssets:      d.instant(nonCurrentAssetsConcepts, period, currency),
+		TotalAssets:           d.instant(totalAssetsConcepts, period, currency),
+		CurrentLiabilities:    d.instant(currentLiabilitiesConcepts, period, currency),
+		NonCurrentLiabilities: d.instant(nonCurrentLiabilitiesConcepts, period, currency),
+		TotalLiabilities:      d.instant(totalLiabilitiesConcepts, period, currency),
+		Equity:                d.instant(equityConcepts, period, currency),
+
+		Revenue:   d.duration(revenueConcepts, period, currency, false),
+		NetIncome: d.duration(netIncomeConcepts, period, currency, false),
+		EPS:       d.duration(epsConcepts, period, currency, false),
+
+		// Interim cash flow statements are usually year to date only
+		CashFlowFromOperations: d.duration(operatingConcepts, period, currency, true),
+		CashFlowFromInvesting:  d.duration(investingConcepts, period, currency, true),
+		CashFlowFromFinancing:  d.duration(financingConcepts, period, currency, true),
+	}
+}
+
+// *
+// **
+// ***
+// ****
+// ***** FACTS
+func (d *Document) text(concept string) string {
+	for _, f := range d.Facts {
+		if f.Concept == concept && f.Text != "" {
+			return f.Text
+		}
+	}
+	return ""
+}
+
+// instant returns the first concept with a consolidated value at the end of the period
+func (d *Document) instant(concepts []string, period Period, currency string) *float64 {
+	for _, concept := range concepts {
+		for _, f := range d.Facts {
+			if f.Concept != concept || !d.sameCurrency(f, currency) {
+				continue
+			}
+
+			c, exists := d.Contexts[f.Context]
+			if !exists || c.Dimensional || !c.IsInstant() || !c.Instant.Equal(period.End) {
+				continue
+			}
+
+			value := f.Value
+			return &value
+		}
+	}
+	return nil
+}
+
+// duration returns the first concept with a consolidated value over the period.
+// With yearToDate, the longest duration ending with the period is taken when none has its length.
+func (d *Document) duration(concepts []string, period Period, currency string, yearToDate bool) *float64 {
+	minDays, maxDays := period.days()
+
+	for _, concept := range concepts {
+		var fallback *Fact
+		fallbackDays := 0
+
+		for i, f := range d.Facts {
+			if f.Concept != concept || !d.sameCurrency(f, currency) {
+				continue
+			}
+
+			c, exists := d.Contexts[f.Context]
+			if !exists || c.Dimensional || c.IsInstant() || !c.End.Equal(period.End) {
+				continue
+			}
+
+			days := c.Days()
+			if days >= minDays && days <= maxDays {
+				value := f.Value
+				return &value
+			}
+
+			if yearToDate && days > fallbackDays && days <= 380 {
+				fallback = &d.Facts[i]
+				fallbackDays = days
+			}
+		}
+
+		if fallback != nil {
+			value := fallback.Value
+			return &value
+		}
+	}
+	return nil
+}
+
+func (d *Document) sameCurrency(f Fact, currency string) bool {
+	if !f.Numeric {
+		return false
+	}
+
+	u, exists := d.Units[f.Unit]
+	if !exists {
+		return false
+	}
+	return currency == "" || u.Currency() == currency
+}
@@ -0,0 +1,191 @@
+package xbrl
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func date(value string) time.Time {
+	return parseDate(value)
+}
+
+func TestDocumentPeriod(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     []byte
+		want    Period
+		wantErr error
+	}{
+		{
+			name: "cover facts, end date in a display format",
+			want: Period{Year: 2024, Type: "Y", End: date("2024-12-31")},
+		},
+		{
+			name: "half year without a fiscal year",
+			doc: []byte(`<xbrl xmlns:dei="http://xbrl.sec.gov/dei/2024">
+				<context id="H1"><entity></entity><period><startDate>2024-01-01</startDate><endDate>2024-06-30</endDate></period></context>
+				<dei:DocumentFiscalPeriodFocus contextRef="H1">H1</dei:DocumentFiscalPeriodFocus>
+				<dei:DocumentPeriodEndDate contextRef="H1">2024-06-30</dei:DocumentPeriodEndDate>
+			</xbrl>`),
+			want: Period{Year: 2024, Type: "S1", End: date("2024-06-30")},
+		},
+		{
+			name: "no cover, latest consolidated total assets",
+			doc: []byte(`<xbrl xmlns:ifrs-full="http://xbrl.ifrs.org/taxonomy/2023-03-23/ifrs-full">
+				<context id="I2023"><entity></entity><period><instant>2023-12-31</instant></period></context>
+				<context id="I2024"><entity></entity><period><instant>2024-12-31</instant></period></context>
+				<context id="I2025_Segment"><entity><segment>Spain</segment></entity><period><instant>2025-06-30</instant></period></context>
+				<unit id="EUR"><measure>iso4217:EUR</measure></unit>
+				<ifrs-full:Assets contextRef="I2023" unitRef="EUR">900</ifrs-full:Assets>
+				<ifrs-full:Assets contextRef="I2024" unitRef="EUR">1000</ifrs-full:Assets>
+				<ifrs-full:Assets contextRef="I2025_Segment" unitRef="EUR">300</ifrs-full:Assets>
+			</xbrl>`),
+			want: Period{Year: 2024, End: date("2024-12-31")},
+		},
+		{
+			name: "no date at all",
+			doc: []byte(`<xbrl xmlns:dei="http://xbrl.sec.gov/dei/2024">
+				<dei:DocumentFiscalPeriodFocus contextRef="missing">FY</dei:DocumentFiscalPeriodFocus>
+			</xbrl>`),
+			wantErr: ErrNoPeriod,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc *Document
+			if tt.doc == nil {
+				doc = parseFixture(t)
+			} else {
+				var err error
+				if doc, err = Parse(tt.doc); err != nil {
+					t.Fatalf("Parse() = %v", err)
+				}
+			}
+
+			got, err := doc.DocumentPeriod()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DocumentPeriod() error = %v, want %v", err, tt.wantErr)
+			}
+			if got.Year != tt.want.Year || got.Type != tt.want.Type || !got.End.Equal(tt.want.End) {
+				t.Errorf("DocumentPeriod() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatements(t *testing.T) {
+	doc := parseFixture(t)
+
+	value := func(v float64) *float64 { return &v }
+	fy2024 := Period{Year: 2024, Type: "Y", End: date("2024-12-31")}
+
+	tests := []struct {
+		name     string
+		period   Period
+		currency string
+		want     Statements
+	}{
+		{
+			// dimensional contexts, instants of durations and durations of other lengths are left out
+			name:     "year in dollars",
+			period:   fy2024,
+			currency: "USD",
+			want: Statements{
+				CashAndEquivalents: value(12_345_000_000),
+				CurrentAssets:      value(27_800_000_000),
+				TotalAssets:        value(57_300_000_000),
+				CurrentLiabilities: value(10_700_000_000),
+				Equity:             value(28_600_000_000),
+				Revenue:            value(48_200_000_000),
+				NetIncome:          value(-1_250_500_000),
+				EPS:                value(-0.63),
+
+				CashFlowFromOperations: value(9_560_000_000),
+				CashFlowFromInvesting:  value(-3_900_000_000),
+				CashFlowFromFinancing:  value(0),
+			},
+		},
+		{
+			name:     "facts in another currency",
+			period:   fy2024,
+			currency: "EUR",
+			want:     Statements{CurrentLiabilities: value(9_870_500_000)},
+		},
+		{
+			name:   "comparative year",
+			period: Period{Year: 2023, Type: "Y", End: date("2023-12-31")},
+			want: Statements{
+				CashAndEquivalents: value(10_210_000_000),
+				TotalAssets:        value(52_810_000_000),
+				Revenue:            value(45_100_000_000),
+			},
+		},
+		{
+			// the quarter has its own revenue, the cash flows are the year to date
+			name:     "fourth quarter",
+			period:   Period{Year: 2024, Type: "Q4", End: date("2024-12-31")},
+			currency: "USD",
+			want: Statements{
+				CashAndEquivalents: value(12_345_000_000),
+				CurrentAssets:      value(27_800_000_000),
+				TotalAssets:        value(57_300_000_000),
+				CurrentLiabilities: value(10_700_000_000),
+				Equity:             value(28_600_000_000),
+				Revenue:            value(12_900_000_000),
+
+				CashFlowFromOperations: value(9_560_000_000),
+				CashFlowFromInvesting:  value(-3_900_000_000),
+				CashFlowFromFinancing:  value(0),
+			},
+		},
+		{
+			name:     "third quarter, nine months of cash flows only",
+			period:   Period{Year: 2024, Type: "Q3", End: date("2024-09-30")},
+			currency: "USD",
+			want:     Statements{CashFlowFromOperations: value(7_100_000_000)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := doc.Statements(tt.period, tt.currency)
+
+			fields := []struct {
+				name      string
+				got, want *float64
+			}{
+				{"CashAndEquivalents", got.CashAndEquivalents, tt.want.CashAndEquivalents},
+				{"CurrentAssets", got.CurrentAssets, tt.want.CurrentAssets},
+				{"NonCurrentAssets", got.NonCurrentAssets, tt.want.NonCurrentAssets},
+				{"TotalAssets", got.TotalAssets, tt.want.TotalAssets},
+				{"CurrentLiabilities", got.CurrentLiabilities, tt.want.CurrentLiabilities},
+				{"NonCurrentLiabilities", got.NonCurrentLiabilities, tt.want.NonCurrentLiabilities},
+				{"TotalLiabilities", got.TotalLiabilities, tt.want.TotalLiabilities},
+				{"Equity", got.Equity, tt.want.Equity},
+				{"Revenue", got.Revenue, tt.want.Revenue},
+				{"NetIncome", got.NetIncome, tt.want.NetIncome},
+				{"EPS", got.EPS, tt.want.EPS},
+				{"CashFlowFromOperations", got.CashFlowFromOperations, tt.want.CashFlowFromOperations},
+				{"CashFlowFromInvesting", got.CashFlowFromInvesting, tt.want.CashFlowFromInvesting},
+				{"CashFlowFromFinancing", got.CashFlowFromFinancing, tt.want.CashFlowFromFinancing},
+			}
+			for _, field := range fields {
+				switch {
+				case field.got == nil && field.want == nil:
+				case field.got == nil || field.want == nil || *field.got != *field.want:
+					t.Errorf("%s = %v, want %v", field.name, deref(field.got), deref(field.want))
+				}
+			}
+		})
+	}
+}
+
+// deref is the value of an optional field, or nil, for messages
+func deref(value *float64) any {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
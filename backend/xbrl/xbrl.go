@@ -0,0 +1,441 @@
+package xbrl
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	Parser for XBRL instance documents and inline XBRL (iXBRL) HTML.
+	Both are read as a list of facts bound to contexts (entity + period) and units.
+	Concept names are normalized to "{taxonomy}:{local name}", e.g. us-gaap:Assets or ifrs-full:Assets,
+	whatever prefix the filing declares.
+*/
+
+var (
+	ErrNoFacts  = errors.New("no XBRL facts found")
+	ErrNoPeriod = errors.New("cannot determine the period of the filing")
+)
+
+type Context struct {
+	ID          string
+	Instant     time.Time
+	Start       time.Time
+	End         time.Time
+	Dimensional bool // has a segment or scenario, i.e. not a consolidated total
+}
+
+func (c Context) IsInstant() bool {
+	return !c.Instant.IsZero()
+}
+
+// Days is the length of a duration context
+func (c Context) Days() int {
+	return int(c.End.Sub(c.Start).Hours()/24 + 0.5)
+}
+
+type Unit struct {
+	ID          string
+	Numerator   []string
+	Denominator []string
+}
+
+// Currency returns the ISO 4217 code of a monetary (or per share) unit, or "" if it has none
+func (u Unit) Currency() string {
+	for _, measure := range u.Numerator {
+		if code, found := strings.CutPrefix(measure, "iso4217:"); found {
+			return strings.ToUpper(code)
+		}
+	}
+	return ""
+}
+
+type Fact struct {
+	Concept string
+	Context string
+	Unit    string
+	Value   float64
+	Text    string // non numeric facts, e.g. dei:DocumentPeriodEndDate
+	Numeric bool
+}
+
+type Document struct {
+	Contexts map[string]Context
+	Units    map[string]Unit
+	Facts    []Fact
+}
+
+// Parse reads an XBRL instance or an iXBRL document
+func Parse(data []byte) (*Document, error) {
+	p := parser{
+		doc: &Document{
+			Contexts: make(map[string]Context),
+			Units:    make(map[string]Unit),
+		},
+		namespaces: make(map[string]string),
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	if err := p.run(decoder); err != nil {
+		return nil, err
+	}
+
+	if len(p.doc.Facts) == 0 {
+		return nil, ErrNoFacts
+	}
+
+	return p.doc, nil
+}
+
+// *
+// **
+// ***
+// ****
+// ***** PARSER
+const inlineNamespace = "http://www.xbrl.org/2013/inlineXBRL"
+
+type parser struct {
+	doc        *Document
+	namespaces map[string]string // prefix -> URI, as declared in the document
+}
+
+type xmlContext struct {
+	ID     string `xml:"id,attr"`
+	Entity struct {
+		Segment *struct{} `xml:"segment"`
+	} `xml:"entity"`
+	Scenario *struct{} `xml:"scenario"`
+	Period   struct {
+		Instant   string `xml:"instant"`
+		StartDate string `xml:"startDate"`
+		EndDate   string `xml:"endDate"`
+	} `xml:"period"`
+}
+
+type xmlUnit struct {
+	ID      string   `xml:"id,attr"`
+	Measure []string `xml:"measure"`
+	Divide  struct {
+		Numerator   []string `xml:"unitNumerator>measure"`
+		Denominator []string `xml:"unitDenominator>measure"`
+	} `xml:"divide"`
+}
+
+func (p *parser) run(decoder *xml.Decoder) error {
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading XBRL: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		for _, attr := range start.Attr {
+			if attr.Name.Space == "xmlns" {
+				p.namespaces[attr.Name.Local] = attr.Value
+			}
+		}
+
+		switch {
+		case start.Name.Local == "context":
+			if err := p.context(decoder, start); err != nil {
+				return err
+			}
+		case start.Name.Local == "unit":
+			if err := p.unit(decoder, start); err != nil {
+				return err
+			}
+		case start.Name.Space == inlineNamespace || isInlinePrefix(start.Name):
+			if err := p.inlineFact(decoder, start); err != nil {
+				return err
+			}
+		case attrValue(start, "contextRef") != "":
+			if err := p.instanceFact(decoder, start); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// isInlinePrefix catches ix: elements of documents that did not declare the namespace
+func isInlinePrefix(name xml.Name) bool {
+	return name.Space == "ix" && (name.Local == "nonFraction" || name.Local == "nonNumeric")
+}
+
+func (p *parser) context(decoder *xml.Decoder, start xml.StartElement) error {
+	var raw xmlContext
+	if err := decoder.DecodeElement(&raw, &start); err != nil {
+		return fmt.Errorf("reading context: %w", err)
+	}
+
+	c := Context{
+		ID:          raw.ID,
+		Dimensional: raw.Entity.Segment != nil || raw.Scenario != nil,
+	}
+
+	if raw.Period.Instant != "" {
+		c.Instant = parseDate(raw.Period.Instant)
+		c.End = c.Instant
+	} else {
+		c.Start = parseDate(raw.Period.StartDate)
+		c.End = parseDate(raw.Period.EndDate)
+	}
+
+	if c.ID != "" && !c.End.IsZero() {
+		p.doc.Contexts[c.ID] = c
+	}
+	return nil
+}
+
+func (p *parser) unit(decoder *xml.Decoder, start xml.StartElement) error {
+	var raw xmlUnit
+	if err := decoder.DecodeElement(&raw, &start); err != nil {
+		return fmt.Errorf("reading unit: %w", err)
+	}
+
+	u := Unit{ID: raw.ID, Numerator: trimAll(raw.Measure)}
+	if len(raw.Divide.Numerator) > 0 {
+		u.Numerator = trimAll(raw.Divide.Numerator)
+		u.Denominator = trimAll(raw.Divide.Denominator)
+	}
+
+	if u.ID != "" {
+		p.doc.Units[u.ID] = u
+	}
+	return nil
+}
+
+// instanceFact reads <us-gaap:Assets contextRef="..." unitRef="..." decimals="-6">123000000</us-gaap:Assets>
+func (p *parser) instanceFact(decoder *xml.Decoder, start xml.StartElement) error {
+	text, err := innerText(decoder)
+	if err != nil {
+		return err
+	}
+
+	f := Fact{
+		Concept: p.concept(start.Name.Space, start.Name.Local),
+		Context: attrValue(start, "contextRef"),
+		Unit:    attrValue(start, "unitRef"),
+		Text:    strings.TrimSpace(text),
+	}
+
+	if f.Unit != "" {
+		if attrValue(start, "nil") == "true" {
+			return nil
+		}
+
+		value, err := strconv.ParseFloat(f.Text, 64)
+		if err != nil {
+			return nil // not a number, skip the fact
+		}
+		f.Value = value
+		f.Numeric = true
+	}
+
+	p.doc.Facts = append(p.doc.Facts, f)
+	return nil
+}
+
+// inlineFact reads <ix:nonFraction name="us-gaap:Assets" contextRef="..." unitRef="..." scale="6" format="ixt:num-dot-decimal">123</ix:nonFraction>
+func (p *parser) inlineFact(decoder *xml.Decoder, start xml.StartElement) error {
+	if start.Name.Local != "nonFraction" && start.Name.Local != "nonNumeric" {
+		return nil
+	}
+
+	prefix, local, found := strings.Cut(attrValue(start, "name"), ":")
+	if !found {
+		return nil
+	}
+
+	namespace, declared := p.namespaces[prefix]
+	if !declared {
+		namespace = prefix
+	}
+	concept := p.concept(namespace, local)
+
+	// Text blocks can wrap other facts, only dei values are read as text
+	if start.Name.Local == "nonNumeric" && !strings.HasPrefix(concept, "dei:") {
+		return nil
+	}
+
+	text, err := innerText(decoder)
+	if err != nil {
+		return err
+	}
+
+	f := Fact{
+		Concept: concept,
+		Context: attrValue(start, "contextRef"),
+		Unit:    attrValue(start, "unitRef"),
+		Text:    strings.TrimSpace(text),
+	}
+
+	if start.Name.Local == "nonFraction" {
+		if attrValue(start, "nil") == "true" {
+			return nil
+		}
+
+		value, ok := parseInlineNumber(f.Text, attrValue(start, "format"))
+		if !ok {
+			return nil
+		}
+
+		if scale := attrValue(start, "scale"); scale != "" {
+			exponent, err := strconv.Atoi(scale)
+			if err != nil {
+				return nil
+			}
+			value *= math.Pow10(exponent)
+		}
+
+		if attrValue(start, "sign") == "-" {
+			value = -value
+		}
+
+		f.Value = value
+		f.Numeric = true
+	}
+
+	p.doc.Facts = append(p.doc.Facts, f)
+	return nil
+}
+
+// concept maps a namespace URI to its usual prefix so that us-gaap and IFRS concepts
+// have the same name in every filing
+func (p *parser) concept(namespace, local string) string {
+	switch {
+	case strings.Contains(namespace, "fasb.org/us-gaap"):
+		return "us-gaap:" + local
+	case strings.Contains(namespace, "ifrs-full"):
+		return "ifrs-full:" + local
+	case strings.Contains(namespace, "xbrl.sec.gov/dei"), strings.Contains(namespace, "/dei/"):
+		return "dei:" + local
+	}
+
+	// namespace is a bare prefix (undeclared) or the URI of a company extension
+	for prefix, uri := range p.namespaces {
+		if uri == namespace {
+			return prefix + ":" + local
+		}
+	}
+	return namespace + ":" + local
+}
+
+// *
+// **
+// ***
+// ****
+// ***** HELPERS
+func attrValue(start xml.StartElement, local string) string {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// innerText consumes the element and returns its text, nested tags included
+func innerText(decoder *xml.Decoder) (string, error) {
+	var sb strings.Builder
+	depth := 1
+
+	for depth > 0 {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("reading fact: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			sb.Write(t)
+		}
+	}
+
+	return sb.String(), nil
+}
+
+func parseDate(value string) time.Time {
+	value = strings.TrimSpace(value)
+	if len(value) > 10 {
+		value = value[:10] // 2024-12-31T00:00:00
+	}
+
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
+
+// parseInlineNumber applies the ixt transformation formats of displayed numbers
+func parseInlineNumber(text, format string) (float64, bool) {
+	if _, name, found := strings.Cut(format, ":"); found {
+		format = name
+	}
+
+	switch format {
+	case "fixed-zero", "zerodash", "fixedzero", "fixed-empty":
+		return 0, true
+	}
+
+	var sb strings.Builder
+	for _, r := range text {
+		switch {
+		case r >= '0' && r <= '9':
+			sb.WriteRune(r)
+		case r == ',' || r == '.':
+			sb.WriteRune(r)
+		}
+	}
+	digits := sb.String()
+
+	switch format {
+	case "num-comma-decimal", "numcommadecimal", "numdotcomma", "numspacecomma":
+		// 1.234,5 -> 1234.5
+		digits = strings.ReplaceAll(digits, ".", "")
+		digits = strings.ReplaceAll(digits, ",", ".")
+	default:
+		// num-dot-decimal, numdotdecimal, numcommadot, numspacedot: 1,234.5 -> 1234.5
+		digits = strings.ReplaceAll(digits, ",", "")
+	}
+
+	if digits == "" {
+		return 0, false
+	}
+
+	value, err := strconv.ParseFloat(digits, 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+func trimAll(values []string) []string {
+	trimmed := make([]string, 0, len(values))
+	for _, value := range values {
+		trimmed = append(trimmed, strings.TrimSpace(value))
+	}
+	return trimmed
+}
@@ -0,0 +1,131 @@
+package xbrl
+
+import (
+	"os"
+	"testing"
+)
+
+// parseFixture parses testdata/inline.htm, an iXBRL 10-K of FY 2024 with comparatives of 2023
+func parseFixture(t *testing.T) *Document {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/inline.htm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+	return doc
+}
+
+func TestParseInline(t *testing.T) {
+	doc := parseFixture(t)
+
+	// the first fact of each concept in the fixture
+	tests := []struct {
+		concept string
+		context string
+		unit    string
+		value   float64
+		text    string
+	}{
+		{concept: "dei:DocumentFiscalPeriodFocus", context: "FY2024", text: "FY"},
+		{concept: "dei:DocumentPeriodEndDate", context: "FY2024", text: "December 31, 2024"},
+		{concept: "us-gaap:CashAndCashEquivalentsAtCarryingValue", context: "I2024", unit: "USD", value: 12_345_000_000},
+		{concept: "us-gaap:LiabilitiesCurrent", context: "I2024", unit: "EUR", value: 9_870_500_000},
+		{concept: "us-gaap:NetIncomeLoss", context: "FY2024", unit: "USD", value: -1_250_500_000},
+		{concept: "us-gaap:EarningsPerShareBasic", context: "FY2024", unit: "USDPerShare", value: -0.63},
+		{concept: "us-gaap:NetCashProvidedByUsedInFinancingActivities", context: "FY2024", unit: "USD", value: 0},
+		{concept: "acme:AdjustedRevenue", context: "FY2024", unit: "USD", value: 50_000_000_000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.concept, func(t *testing.T) {
+			for _, f := range doc.Facts {
+				if f.Concept != tt.concept {
+					continue
+				}
+				if f.Context != tt.context || f.Unit != tt.unit || f.Value != tt.value || f.Numeric != (tt.unit != "") {
+					t.Errorf("fact = %+v, want context %s, unit %s, value %g", f, tt.context, tt.unit, tt.value)
+				}
+				if tt.text != "" && f.Text != tt.text {
+					t.Errorf("fact text = %q, want %q", f.Text, tt.text)
+				}
+				return
+			}
+			t.Errorf("no %s fact", tt.concept)
+		})
+	}
+}
+
+func TestParseContextsAndUnits(t *testing.T) {
+	doc := parseFixture(t)
+
+	tests := []struct {
+		id          string
+		instant     bool
+		days        int
+		dimensional bool
+	}{
+		{id: "FY2024", days: 365},
+		{id: "Q4_2024", days: 91},
+		{id: "YTD_Q3_2024", days: 273},
+		{id: "FY2024_Europe", days: 365, dimensional: true},
+		{id: "I2024", instant: true},
+		{id: "I2024_Europe", instant: true, dimensional: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			c, exists := doc.Contexts[tt.id]
+			if !exists {
+				t.Fatalf("context %s not parsed", tt.id)
+			}
+			if c.IsInstant() != tt.instant || c.Dimensional != tt.dimensional {
+				t.Errorf("context = %+v, want instant %v, dimensional %v", c, tt.instant, tt.dimensional)
+			}
+			if !tt.instant && c.Days() != tt.days {
+				t.Errorf("Days() = %d, want %d", c.Days(), tt.days)
+			}
+		})
+	}
+
+	if currency := doc.Units["EUR"].Currency(); currency != "EUR" {
+		t.Errorf("EUR unit Currency() = %q, want EUR", currency)
+	}
+	if perShare := doc.Units["USDPerShare"]; perShare.Currency() != "USD" || len(perShare.Denominator) != 1 {
+		t.Errorf("per share unit = %+v, want USD over shares", perShare)
+	}
+	if currency := doc.Currency(); currency != "USD" {
+		t.Errorf("Currency() = %q, want the most used, USD", currency)
+	}
+}
+
+func TestParseInlineNumber(t *testing.T) {
+	tests := []struct {
+		text   string
+		format string
+		want   float64
+		ok     bool
+	}{
+		{"1,234.5", "ixt:num-dot-decimal", 1234.5, true},
+		{"1,234.5", "", 1234.5, true},
+		{"1.234,5", "ixt:num-comma-decimal", 1234.5, true},
+		{"1 234,5", "ixt-sec:numspacecomma", 1234.5, true},
+		{"—", "ixt:fixed-zero", 0, true},
+		{"-", "ixt:zerodash", 0, true},
+		{"(1,234)", "ixt:num-dot-decimal", 1234, true},
+		{"n/a", "ixt:num-dot-decimal", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text+" "+tt.format, func(t *testing.T) {
+			got, ok := parseInlineNumber(tt.text, tt.format)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("parseInlineNumber() = %g, %v, want %g, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}